@@ -1,19 +1,33 @@
 package abci
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"database/sql"
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chainpoint/tendermint/abci/example/code"
 
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
 	"github.com/chp-project/chainpoint-core/go-abci-service/ethcontracts"
+	"github.com/chp-project/chainpoint-core/go-abci-service/ethtx"
+	"github.com/chp-project/chainpoint-core/go-abci-service/merkletools"
+	"github.com/chp-project/chainpoint-core/go-abci-service/nodeaudit"
+	"github.com/chp-project/chainpoint-core/go-abci-service/outbox"
 	"github.com/chp-project/chainpoint-core/go-abci-service/postgres"
+	"github.com/chp-project/chainpoint-core/go-abci-service/rewardsig"
+	"github.com/chp-project/chainpoint-core/go-abci-service/schema"
+	"github.com/chp-project/chainpoint-core/go-abci-service/smt"
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+	"github.com/chp-project/chainpoint-core/go-abci-service/workledger"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/go-redis/redis"
 
 	"github.com/chainpoint/tendermint/libs/log"
@@ -31,12 +45,23 @@ import (
 // variables for protocol version and main db state key
 var (
 	stateKey                         = []byte("chainpoint")
+	calMMRPrefix                     = "calmmr:"
+	registryPrefix                   = "registry:"
 	ProtocolVersion version.Protocol = 0x1
-	GossipTxs                        = []string{"TOKEN", "NIST"}
+	GossipTxs                        = []string{"NIST"}
 )
 
 const MINT_EPOCH = 6400
 
+// NODE_MINT_SIGNERS : Cores elected to sign each node mint; NODE_MINT_THRESHOLD of their signatures are needed to mint
+const (
+	NODE_MINT_SIGNERS   = 7
+	NODE_MINT_THRESHOLD = 6
+)
+
+// calPrefetch : maximum number of unacked work.cal messages handled at once
+const calPrefetch = 10
+
 // loadState loads the AnchorState struct from a database instance
 func loadState(db dbm.DB) types.AnchorState {
 	stateBytes := db.Get(stateKey)
@@ -59,6 +84,17 @@ func saveState(Db dbm.DB, state types.AnchorState) {
 	Db.Set(stateKey, stateBytes)
 }
 
+//activated : whether a feature with the given activation height applies to the block being delivered
+func (app *AnchorApplication) activated(activationHeight int64) bool {
+	return activationHeight > 0 && app.state.Height+1 >= activationHeight
+}
+
+//calMMRActivated : whether CAL roots are accumulated in the calendar MMR. The MMR only counts CAL txs verified against
+//registered keys, so it waits for the registry as well as its own activation height
+func (app *AnchorApplication) calMMRActivated() bool {
+	return app.activated(app.state.ChainParams.CalMMRHeight) && app.activated(app.state.ChainParams.RegistryHeight)
+}
+
 //---------------------------------------------------
 
 var _ types2.Application = (*AnchorApplication)(nil)
@@ -67,17 +103,27 @@ var _ types2.Application = (*AnchorApplication)(nil)
 type AnchorApplication struct {
 	types2.BaseApplication
 	ValUpdates           []types2.ValidatorUpdate
-	NodeRewardSignatures []string
-	CoreRewardSignatures []string
+	NodeRewardSignatures *rewardsig.Collector
+	CoreRewardSignatures *rewardsig.Collector
 	Db                   dbm.DB
 	state                types.AnchorState
 	config               types.AnchorConfig
 	logger               log.Logger
 	calendar             *calendar.Calendar
+	calMMR               *merkletools.MerkleMountainRange
+	registry             *smt.SparseMerkleTree
 	aggregator           *aggregator.Aggregator
-	pgClient             *postgres.Postgres
+	outbox               *outbox.Outbox
+	bus                  bus.Bus
+	calMutex             sync.Mutex
+	pgClient             postgres.Store
 	redisClient          *redis.Client
 	ethClient            *ethcontracts.EthClient
+	ethWSClient          *ethclient.Client
+	ethTx                *ethtx.Manager
+	tokenABI             abi.ABI
+	auditor              *nodeaudit.Auditor
+	workLedger           *workledger.Ledger
 	rpc                  *RPC
 	ID                   string
 	JWK                  types.Jwk
@@ -93,7 +139,7 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 	state := loadState(db)
 	state.ChainSynced = false // False until we finish syncing
 
-	// Declare postgres connection
+	// Declare postgres connection and bring its schema up to date
 	var pgClient *postgres.Postgres
 	var err error
 	deadline := time.Now().Add(1 * time.Minute)
@@ -102,21 +148,14 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 		if util.LoggerError(*config.Logger, err) != nil {
 			time.Sleep(5 * time.Second)
 			continue
-		} else {
-			_, err = pgClient.GetNodeCount()
-			if util.LoggerError(*config.Logger, err) != nil {
-				(*config.Logger).Info("table 'staked_nodes' doesn't exist, did API start successfully?")
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			_, err = pgClient.GetCoreCount()
-			if util.LoggerError(*config.Logger, err) != nil {
-				(*config.Logger).Info("table 'staked_cores' doesn't exist, did API start successfully?")
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			break
 		}
+		err = pgClient.Migrate()
+		if util.LoggerError(*config.Logger, err) != nil {
+			(*config.Logger).Info("postgres migrations failed, retrying")
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		break
 	}
 	if err != nil {
 		fmt.Println("Postgres not ready after 1 minute")
@@ -192,6 +231,61 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 			panic(err)
 		}
 	}
+	//Websocket client for registry log subscriptions. Without one, registry events are only polled
+	var ethWSClient *ethclient.Client
+	if config.DoNodeManagement && config.EthConfig.EthereumWSURL != "" {
+		ethWSClient, err = ethclient.Dial(config.EthConfig.EthereumWSURL)
+		if util.LoggerError(*config.Logger, err) != nil {
+			(*config.Logger).Info("ethereum websocket unavailable, polling registry events instead")
+			ethWSClient = nil
+		}
+	}
+
+	//Transaction manager for mint calls, tracking each through to its confirmed receipt
+	var ethTx *ethtx.Manager
+	if config.DoNodeManagement {
+		ethTx, err = newEthTxManager(config.EthConfig, db, *config.Logger)
+		if util.LoggerError(*config.Logger, err) != nil {
+			panic(err)
+		}
+	}
+
+	//Token contract ABI, for encoding the mint calls sent through the transaction manager
+	var tokenABI abi.ABI
+	if config.DoNodeAudit {
+		tokenABI, err = abi.JSON(strings.NewReader(config.EthConfig.TokenContractABI))
+		util.LoggerError(*config.Logger, err)
+	}
+
+	//Durable record of in-flight aggregations and CAL submissions
+	calOutbox := outbox.NewOutbox(db)
+
+	//Declare message bus connection shared by the aggregator, calendar and anchoring.
+	//The Node.js services only speak AMQP, so any other backend leaves them without their queues
+	busURI := config.RabbitmqURI
+	if strings.ToLower(config.MessageBus) == bus.BackendNATS {
+		busURI = config.NatsURI
+	}
+	if backend := strings.ToLower(config.MessageBus); backend != "" && backend != bus.BackendRabbitMQ {
+		(*config.Logger).Error(fmt.Sprintf("MESSAGE_BUS %s is only understood by the Go services; "+
+			"the Node.js API, proof state, proof gen and btc services still need RabbitMQ", config.MessageBus))
+	}
+	var messageBus bus.Bus
+	deadline = time.Now().Add(1 * time.Minute)
+	for !time.Now().After(deadline) {
+		messageBus, err = bus.New(config.MessageBus, busURI)
+		if util.LoggerError(*config.Logger, err) != nil {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		fmt.Println("Message bus not ready after 1 minute")
+		panic(err)
+	}
+	//Stamp and validate work.proofstate messages against their versioned schemas
+	messageBus = schema.NewValidatingBus(messageBus, schema.NewProofStateRegistry())
 
 	//Construct application
 	app := AnchorApplication{
@@ -199,27 +293,46 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 		state:                state,
 		config:               config,
 		logger:               *config.Logger,
-		NodeRewardSignatures: make([]string, 0),
-		CoreRewardSignatures: make([]string, 0),
+		NodeRewardSignatures: rewardsig.NewCollector(),
+		CoreRewardSignatures: rewardsig.NewCollector(),
 		calendar: &calendar.Calendar{
-			RabbitmqURI: config.RabbitmqURI,
-			Logger:      *config.Logger,
+			Bus:    messageBus,
+			Logger: *config.Logger,
 		},
+		calMMR:   merkletools.NewMerkleMountainRange(db, calMMRPrefix, state.CalMMRLeafCount),
+		registry: smt.NewSparseMerkleTree(db, registryPrefix, state.RegistryRoot),
 		aggregator: &aggregator.Aggregator{
-			RabbitmqURI: config.RabbitmqURI,
-			Logger:      *config.Logger,
+			Bus:    messageBus,
+			Logger: *config.Logger,
+			Outbox: calOutbox,
 		},
+		outbox:      calOutbox,
+		bus:         messageBus,
 		pgClient:    pgClient,
 		redisClient: redisClient,
 		ethClient:   ethClient,
+		ethWSClient: ethWSClient,
+		ethTx:       ethTx,
+		tokenABI:    tokenABI,
 		rpc:         NewRPCClient(config.TendermintConfig, *config.Logger),
 		CoreKeys:    map[string]ecdsa.PublicKey{},
 	}
 
+	//Node audit checks, with each node's reports kept in the postgres audits table and reputation chain heads in the app database
+	app.auditor, err = newNodeAuditor(config, &auditReports{app: app}, &repChainHeads{Db: db}, app.rpc.GetBlockHash)
+	if util.LoggerError(*config.Logger, err) != nil {
+		(*config.Logger).Info("invalid AUDIT_CHECKS, using the default audit checks")
+	}
+
+	//Core work is credited per mint epoch as txs are delivered, and scored for Core rewards by the chain's work weights
+	app.workLedger = workledger.NewLedger(db)
+
 	//Initialize and monitor node state
 	if config.DoNodeManagement {
-		go app.PollNodesFromContract()
-		go app.PollCoresFromContract()
+		go app.SyncRegistryFromContract(&nodeRegistry{app: &app})
+		go app.SyncRegistryFromContract(&coreRegistry{app: &app})
+		go app.ethTx.Run(30*time.Second, nil)
+		app.ResumeMintMonitors()
 	}
 
 	//Initialize calendar writing if enabled
@@ -229,11 +342,12 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 
 	//Initialize anchoring to bitcoin if enabled
 	if config.DoAnchor {
-		go app.SyncMonitor()   //make sure we're synced before enabling anchoring
-		go app.ReceiveCalRMQ() // Infinite loop to process btctx and btcmon rabbitMQ messages
+		go app.SyncMonitor()        //make sure we're synced before enabling anchoring
+		go app.ReceiveCalMessages() // Process btctx and btcmon messages in the background
 	}
 
 	go app.KeyMonitor()
+	go app.RegistryKeyMonitor()
 
 	// Load JWK into local mapping from redis
 	app.LoadJWK()
@@ -241,6 +355,28 @@ func NewAnchorApplication(config types.AnchorConfig) *AnchorApplication {
 	return &app
 }
 
+//newEthTxManager : transaction manager sending from the Core's ethereum account
+func newEthTxManager(config types.EthConfig, db dbm.DB, logger log.Logger) (*ethtx.Manager, error) {
+	client, err := ethclient.Dial(config.EthereumURL)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.HexToECDSA(config.EthPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := client.NetworkID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	manager := ethtx.NewManager(client, db, key, chainID, logger)
+	manager.Confirmations = config.Confirmations
+	if config.MaxGasPriceGwei > 0 {
+		manager.MaxGasPrice = new(big.Int).Mul(big.NewInt(config.MaxGasPriceGwei), big.NewInt(1000000000))
+	}
+	return manager, nil
+}
+
 // SetOption : Method for runtime data transfer between other apps and ABCI
 func (app *AnchorApplication) SetOption(req types2.RequestSetOption) (res types2.ResponseSetOption) {
 	if req.Key == "TOKEN" {
@@ -249,8 +385,19 @@ func (app *AnchorApplication) SetOption(req types2.RequestSetOption) (res types2
 	return
 }
 
-// InitChain : Save the validators in the merkle tree
+// InitChain : Save the validators in the merkle tree and the chain params from the genesis app_state
 func (app *AnchorApplication) InitChain(req types2.RequestInitChain) types2.ResponseInitChain {
+	if len(req.AppStateBytes) != 0 {
+		if err := json.Unmarshal(req.AppStateBytes, &app.state.ChainParams); err != nil {
+			panic(fmt.Sprintf("invalid genesis app_state: %s", err.Error()))
+		}
+		if !validAuditWeighting(app.state.ChainParams.AuditWeighting) {
+			panic(fmt.Sprintf("invalid genesis audit_weighting: %s", app.state.ChainParams.AuditWeighting))
+		}
+		if _, _, err := coreRewardRules(app.state.ChainParams); err != nil {
+			panic(fmt.Sprintf("invalid genesis core reward params: %s", err.Error()))
+		}
+	}
 	for _, v := range req.Validators {
 		r := app.updateValidator(v, []cmn.KVPair{})
 		if r.IsErr() {
@@ -310,9 +457,6 @@ func (app *AnchorApplication) Commit() types2.ResponseCommit {
 	// If the chain is synced, run all polling methods
 	if app.state.ChainSynced {
 		go app.NistBeaconMonitor() // update NIST beacon using deterministic leader election
-		if app.config.DoNodeAudit && app.JWKSent {
-			go app.MintMonitor()
-		}
 		if app.config.DoCal {
 			go app.AggregateCalendar()
 		}
@@ -335,8 +479,10 @@ func (app *AnchorApplication) Commit() types2.ResponseCommit {
 	}
 
 	// Finalize new block by calculating appHash and incrementing height
-	appHash := make([]byte, 8)
-	binary.PutVarint(appHash, app.state.Height)
+	app.state.CalMMRLeafCount = app.calMMR.LeafCount
+	app.state.CalMMRRoot = app.calMMR.Root()
+	app.state.RegistryRoot = app.registry.Root
+	appHash := app.calculateAppHash() // light clients read the registry and calendar MMR roots straight from the header
 	app.state.AppHash = appHash
 	app.state.Height++
 	saveState(app.Db, app.state)
@@ -344,9 +490,27 @@ func (app *AnchorApplication) Commit() types2.ResponseCommit {
 	return types2.ResponseCommit{Data: appHash}
 }
 
-// Query : Custom ABCI query method. TODO: implement
+// Query : Custom ABCI query method. Serves calendar MMR and registry proofs, and node audit history
 func (app *AnchorApplication) Query(reqQuery types2.RequestQuery) (resQuery types2.ResponseQuery) {
-	return
+	switch reqQuery.Path {
+	case "/calendar/mmr/root":
+		return app.queryCalMMRRoot(reqQuery)
+	case "/calendar/mmr/inclusion":
+		return app.queryCalMMRInclusion(reqQuery)
+	case "/calendar/mmr/consistency":
+		return app.queryCalMMRConsistency(reqQuery)
+	case "/registry/core_key":
+		return app.queryRegistry(registryCoreKeyPrefix, reqQuery)
+	case "/registry/node_token":
+		return app.queryRegistry(registryNodeTokenPrefix, reqQuery)
+	case "/registry/penalty":
+		return app.queryRegistry(registryPenaltyPrefix, reqQuery)
+	case "/audits/node":
+		return app.queryNodeAudits(reqQuery)
+	case "/audits/stats":
+		return app.queryNodeAuditStats(reqQuery)
+	}
+	return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: fmt.Sprintf("unknown query path %s", reqQuery.Path)}
 }
 
 func (app *AnchorApplication) LogError(err error) error {
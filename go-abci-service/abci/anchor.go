@@ -1,6 +1,8 @@
 package abci
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,40 +10,122 @@ import (
 	"strings"
 	"time"
 
-	"github.com/chp-project/chainpoint-core/go-abci-service/rabbitmq"
+	core_types "github.com/chainpoint/tendermint/rpc/core/types"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
+	"github.com/chp-project/chainpoint-core/go-abci-service/schema"
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
-	"github.com/streadway/amqp"
 
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
 )
 
 // AggregateCalendar : Aggregate submitted hashes into a calendar transaction
 func (app *AnchorApplication) AggregateCalendar() error {
+	app.calMutex.Lock()
+	defer app.calMutex.Unlock()
 	app.logger.Debug("starting scheduled aggregation")
 
+	// Finish any CAL submissions left over from earlier rounds or a restart
+	app.FlushCalOutbox()
+
 	// Get agg objects
 	aggs := app.aggregator.AggregateAndReset()
 	app.logger.Debug(fmt.Sprintf("Aggregated %d roots", len(aggs)))
 
-	// Pass the agg objects to generate a calendar tree
+	// Pass the agg objects to generate a calendar tree, hardened once the chain has reached its activation height
+	app.calendar.HardenedTree = app.activated(app.state.ChainParams.HardenedCalTreeHeight)
 	calAgg := app.calendar.GenerateCalendarTree(aggs)
-	if calAgg.CalRoot != "" {
-		app.logger.Info(fmt.Sprintf("Calendar Root: %s", calAgg.CalRoot))
+	if len(calAgg.Excluded) > 0 {
+		// an aggregation excluded as a duplicate of one in the tree has its hashes anchored already
+		anchoredAggIDs := map[string]bool{}
+		for _, proofData := range calAgg.ProofData {
+			anchoredAggIDs[proofData.AggID] = true
+		}
+		excludedAggs := make([]types.Aggregation, 0)
+		for _, excluded := range calAgg.Excluded {
+			if !anchoredAggIDs[excluded.Aggregation.AggID] {
+				excludedAggs = append(excludedAggs, excluded.Aggregation)
+			}
+		}
+		app.LogError(app.aggregator.RequeueHashes(excludedAggs))
+	}
+	if calAgg.CalRoot == "" {
+		return errors.New("No hashes to aggregate")
+	}
+	app.logger.Info(fmt.Sprintf("Calendar Root: %s", calAgg.CalRoot))
 
-		result, err := app.rpc.BroadcastTx("CAL", calAgg.CalRoot, 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
+	// Track the CAL by its root before broadcasting. Its aggregations stay in the outbox until the CAL tx is committed
+	pendingCal := types.PendingCal{CalRoot: calAgg.CalRoot, CalAgg: calAgg}
+	if err := app.outbox.PutCal(pendingCal); app.LogError(err) != nil {
+		return err
+	}
+	return app.submitCal(pendingCal)
+}
+
+// FlushCalOutbox : Retries every CAL submission whose proof state hasn't been acknowledged yet
+func (app *AnchorApplication) FlushCalOutbox() {
+	pendingCals, err := app.outbox.PendingCals()
+	if app.LogError(err) != nil {
+		return
+	}
+	for _, pendingCal := range pendingCals {
+		app.logger.Info(fmt.Sprintf("Retrying CAL submission for root %s", pendingCal.CalRoot))
+		app.LogError(app.submitCal(pendingCal))
+	}
+}
+
+// submitCal : Broadcasts a pending CAL unless it's already on chain and waits for it to be committed, then queues its proof state.
+// Until the CAL tx is committed, the CAL and its aggregations stay in the outbox, so FlushCalOutbox broadcasts it again.
+// The CAL's own outbox entry is only removed once the proof state message has been published
+func (app *AnchorApplication) submitCal(pendingCal types.PendingCal) error {
+	if pendingCal.TxHash == "" {
+		txHash, err := app.findCalTxByRoot(pendingCal.CalRoot)
 		if app.LogError(err) != nil {
 			return err
 		}
-		app.logger.Debug(fmt.Sprintf("CAL result: %v", result))
-		if result.Code == 0 {
-			var tx types.TxTm
-			tx.Hash = result.Hash.Bytes()
-			tx.Data = result.Data.Bytes()
-			app.calendar.QueueCalStateMessage(tx, calAgg)
-			return nil
+		if txHash == nil {
+			result, err := app.rpc.BroadcastTxCommit("CAL", pendingCal.CalRoot, 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
+			if app.LogError(err) != nil {
+				return err
+			}
+			app.logger.Debug(fmt.Sprintf("CAL result: %v", result))
+			if result.CheckTx.Code != 0 {
+				return fmt.Errorf("CAL tx for root %s rejected with code %d: %s", pendingCal.CalRoot, result.CheckTx.Code, result.CheckTx.Log)
+			}
+			if result.DeliverTx.Code != 0 {
+				return fmt.Errorf("CAL tx for root %s failed with code %d: %s", pendingCal.CalRoot, result.DeliverTx.Code, result.DeliverTx.Log)
+			}
+			txHash = result.Hash.Bytes()
+		}
+		pendingCal.TxHash = hex.EncodeToString(txHash)
+		if err := app.outbox.PutCal(pendingCal); app.LogError(err) != nil {
+			return err
 		}
 	}
-	return errors.New("No hashes to aggregate")
+	// the CAL tx is committed, so its aggregations are anchored
+	for _, proofData := range pendingCal.CalAgg.ProofData {
+		app.outbox.RemoveAggregation(proofData.AggID)
+	}
+	var tx types.TxTm
+	tx.Hash, _ = hex.DecodeString(pendingCal.TxHash)
+	if err := app.calendar.QueueCalStateMessage(tx, pendingCal.CalAgg); err != nil {
+		return err
+	}
+	app.outbox.RemoveCal(pendingCal.CalRoot)
+	return nil
+}
+
+// findCalTxByRoot : Returns the hash of a committed CAL tx with the given root, or nil if there isn't one
+func (app *AnchorApplication) findCalTxByRoot(calRoot string) ([]byte, error) {
+	var hash []byte
+	_, err := app.rpc.SearchTxs(context.Background(), fmt.Sprintf("CALROOT='%s'", calRoot), func(tx *core_types.ResultTx) bool {
+		hash = tx.Hash.Bytes()
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hash, nil
 }
 
 // AnchorBTC : Anchor scans all CAL transactions since last anchor epoch and writes the merkle root to the Calendar and to bitcoin
@@ -127,22 +211,20 @@ func (app *AnchorApplication) ConsumeBtcTxMsg(msgBytes []byte) error {
 	if app.LogError(err) != nil {
 		return err
 	}
-	err = rabbitmq.Publish(app.config.RabbitmqURI, "work.proofstate", "btctx", dataJSON)
-	if err != nil {
-		rabbitmq.LogError(err, "rmq dial failure, is rmq connected?")
+	err = app.bus.Publish(bus.Message{Topic: bus.TopicProofState, Type: schema.TypeBtcTx, Body: dataJSON})
+	if app.LogError(err) != nil {
 		return err
 	}
 	txIDBytes, err := json.Marshal(types.TxID{TxID: btcTxObj.BtcTxID})
-	err = rabbitmq.Publish(app.config.RabbitmqURI, "work.btcmon", "", txIDBytes)
-	if err != nil {
-		rabbitmq.LogError(err, "rmq dial failure, is rmq connected?")
+	err = app.bus.Publish(bus.Message{Topic: bus.TopicBtcMon, Body: txIDBytes})
+	if app.LogError(err) != nil {
 		return err
 	}
 	return nil
 }
 
 // ConsumeBtcMonMsg : consumes a btc mon message and issues a BTC-Confirm transaction along with completing btc proof generation
-func (app *AnchorApplication) ConsumeBtcMonMsg(msg amqp.Delivery) error {
+func (app *AnchorApplication) ConsumeBtcMonMsg(msg bus.Message) error {
 	var anchoringCoreID string
 	var hash []byte
 	var btcMonObj types.BtcMonMsg
@@ -150,15 +232,16 @@ func (app *AnchorApplication) ConsumeBtcMonMsg(msg amqp.Delivery) error {
 	// Get the CoreID that originally published the anchor TX using the btc tx ID we tagged it with
 	queryLine := fmt.Sprintf("BTCTX='%s'", btcMonObj.BtcTxID)
 	app.logger.Info("Anchor confirmation query: " + queryLine)
-	txResult, err := app.rpc.client.TxSearch(queryLine, false, 1, 25)
-	if app.LogError(err) == nil {
-		for _, tx := range txResult.Txs {
-			decoded, err := util.DecodeTx(tx.Tx)
-			if app.LogError(err) != nil {
-				continue
-			}
+	_, err := app.rpc.SearchTxs(context.Background(), queryLine, func(tx *core_types.ResultTx) bool {
+		decoded, err := util.DecodeTx(tx.Tx)
+		if app.LogError(err) == nil {
 			anchoringCoreID = decoded.CoreID
 		}
+		return true
+	})
+	app.LogError(err)
+	if coreID, found := app.btcaCoreID(btcMonObj.BtcTxID); len(anchoringCoreID) == 0 && found {
+		anchoringCoreID = coreID
 	}
 	if len(anchoringCoreID) == 0 {
 		app.logger.Error(fmt.Sprintf("Anchor: Cannot retrieve BTCTX-tagged transaction for btc tx: %s", btcMonObj.BtcTxID))
@@ -198,74 +281,51 @@ func (app *AnchorApplication) ConsumeBtcMonMsg(msg amqp.Delivery) error {
 	}
 	stateObjBytes, err := json.Marshal(btccStateObj)
 
-	err = rabbitmq.Publish(app.config.RabbitmqURI, "work.proofstate", "btcmon", stateObjBytes)
-	if err != nil {
-		rabbitmq.LogError(err, "rmq dial failure, is rmq connected?")
+	err = app.bus.Publish(bus.Message{Topic: bus.TopicProofState, Type: schema.TypeBtcMon, Body: stateObjBytes})
+	if app.LogError(err) != nil {
 		return err
 	}
-	msg.Ack(false)
 	return nil
 }
 
-func (app *AnchorApplication) processMessage(msg amqp.Delivery) error {
+// processMessage : handles a work.cal message from the btc tx and monitor services
+func (app *AnchorApplication) processMessage(msg bus.Message) bus.Result {
 	switch msg.Type {
 	case "btctx":
 		time.Sleep(30 * time.Second)
 		var btcTxObj types.BtcTxMsg
 		err := json.Unmarshal(msg.Body, &btcTxObj)
 		if app.LogError(err) != nil {
-			return err
+			return bus.DeadLetter
 		}
 		btcMonBytes, err := json.Marshal(btcTxObj)
 		if app.LogError(err) != nil {
-			return err
+			return bus.DeadLetter
 		}
 		_, err = app.rpc.BroadcastTx("BTC-A", string(btcMonBytes), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
 		if app.LogError(err) != nil {
-			return err
+			return bus.Retry
 		}
-		msg.Ack(false)
-		break
+		return bus.Ack
 	case "btcmon":
-		err := app.ConsumeBtcMonMsg(msg)
-		app.LogError(err)
-		break
-	case "reward":
-		break
+		var btcMonObj types.BtcMonMsg
+		if app.LogError(json.Unmarshal(msg.Body, &btcMonObj)) != nil {
+			return bus.DeadLetter
+		}
+		if app.LogError(app.ConsumeBtcMonMsg(msg)) != nil {
+			return bus.Retry
+		}
+		return bus.Ack
 	default:
-		msg.Ack(false)
+		return bus.Ack
 	}
-	return nil
 }
 
-// ReceiveCalRMQ : Continually consume the calendar work queue and
+// ReceiveCalMessages : Subscribe to the calendar work topic and
 // process any resulting messages from the tx and monitor services
-func (app *AnchorApplication) ReceiveCalRMQ() error {
-	var session rabbitmq.Session
-	var err error
-	endConsume := false
-	for {
-		session, err = rabbitmq.ConnectAndConsume(app.config.RabbitmqURI, "work.cal")
-		if err != nil {
-			rabbitmq.LogError(err, "failed to dial for work.cal queue")
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		for {
-			select {
-			case err = <-session.Notify:
-				if endConsume {
-					return err
-				}
-				time.Sleep(5 * time.Second)
-				break //reconnect
-			case msg := <-session.Msgs:
-				if len(msg.Body) > 0 {
-					go app.processMessage(msg)
-				}
-			}
-		}
-	}
+func (app *AnchorApplication) ReceiveCalMessages() error {
+	_, err := app.bus.Subscribe(bus.TopicCal, calPrefetch, app.processMessage)
+	return app.LogError(err)
 }
 
 // resetAnchor ensures that anchoring will begin again in the next block
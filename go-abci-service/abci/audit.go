@@ -0,0 +1,463 @@
+package abci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/ethsync"
+	"github.com/chp-project/chainpoint-core/go-abci-service/nodeaudit"
+	"github.com/chp-project/chainpoint-core/go-abci-service/sampling"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+)
+
+// NODE_AUDITORS Cores independently audit NODE_AUDIT_SAMPLE nodes each round; a node passes once NODE_AUDIT_QUORUM of them agree
+const (
+	NODE_AUDITORS     = 3
+	NODE_AUDIT_QUORUM = 2
+	NODE_AUDIT_SAMPLE = 5
+)
+
+//NODE_AUDIT_QUERY_LIMIT : the most stored audits returned by one /audits/node query
+const NODE_AUDIT_QUERY_LIMIT = 100
+
+//rewardPassesPrefix : keys the audit passes each committed node REWARD-EPOCH record rewarded, by epoch
+const rewardPassesPrefix = "rewardpasses:"
+
+//rewardPasses : the number of passing audits each node address was rewarded for in an epoch, and the height the record was committed at
+type rewardPasses struct {
+	Height int64          `json:"height"`
+	Passes map[string]int `json:"passes"`
+}
+
+//auditAssignment : the Cores and nodes assigned to one audit round
+type auditAssignment struct {
+	Round    int64
+	Seed     string
+	Auditors []string
+	Nodes    []types.Node
+}
+
+//isAuditor : whether a Core is one of the round's auditors
+func (a auditAssignment) isAuditor(coreID string) bool {
+	return util.Contains(a.Auditors, coreID)
+}
+
+//isAssigned : whether a node is one of the round's audited nodes
+func (a auditAssignment) isAssigned(ethAddr string) bool {
+	for _, node := range a.Nodes {
+		if common.HexToAddress(node.EthAddr) == common.HexToAddress(ethAddr) {
+			return true
+		}
+	}
+	return false
+}
+
+//Node audit weightings: how likely each staked node is to be drawn for a round. Fixed for the chain by the genesis
+//audit_weighting chain param, where an empty weighting is uniform
+const (
+	NODE_AUDIT_WEIGHT_UNIFORM   = "uniform"
+	NODE_AUDIT_WEIGHT_STAKE_AGE = "stake_age"
+	NODE_AUDIT_WEIGHT_HISTORY   = "audit_history"
+	NODE_AUDIT_MAX_WEIGHT       = 10
+)
+
+//assignAudit : deterministically picks a round's auditors from the staked Cores and draws its nodes from the staked nodes
+//in proportion to weight, seeded by the round's block hash, so that every Core derives the same assignment
+func assignAudit(round int64, seed string, cores []types.Core, nodes []types.Node, weight func(types.Node) uint64) auditAssignment {
+	assignment := auditAssignment{Round: round, Seed: seed, Auditors: []string{}, Nodes: []types.Node{}}
+	coreIDs := make([]string, 0, len(cores))
+	for _, core := range cores {
+		if core.CoreId.Valid && core.CoreId.String != "" {
+			coreIDs = append(coreIDs, core.CoreId.String)
+		}
+	}
+	coreSeed := sha256.Sum256([]byte("auditors|" + seed))
+	coreIDs = sampling.Shuffle(coreSeed[:], coreIDs)
+	assignment.Auditors = coreIDs[:int(math.Min(NODE_AUDITORS, float64(len(coreIDs))))]
+
+	byAddr := make(map[string]types.Node)
+	candidates := make([]sampling.Candidate, 0, len(nodes))
+	for _, node := range nodes {
+		key := strings.ToLower(common.HexToAddress(node.EthAddr).Hex())
+		if _, exists := byAddr[key]; exists {
+			continue
+		}
+		byAddr[key] = node
+		candidates = append(candidates, sampling.Candidate{Key: key, Weight: weight(node)})
+	}
+	nodeSeed := sha256.Sum256([]byte("nodes|" + seed))
+	for _, candidate := range sampling.Sample(nodeSeed[:], candidates, NODE_AUDIT_SAMPLE) {
+		assignment.Nodes = append(assignment.Nodes, byAddr[candidate.Key])
+	}
+	return assignment
+}
+
+//uniformAuditWeight : gives every node the same chance of being audited
+func uniformAuditWeight(node types.Node) uint64 {
+	return 1
+}
+
+//stakeAgeAuditWeight : favors nodes that had been staked for longer as of stakingBlock, by one step per mint epoch
+func stakeAgeAuditWeight(stakingBlock int64) func(types.Node) uint64 {
+	return func(node types.Node) uint64 {
+		if !node.BlockNumber.Valid || node.BlockNumber.Int64 >= stakingBlock {
+			return 1
+		}
+		return uint64(math.Min(NODE_AUDIT_MAX_WEIGHT, float64(1+(stakingBlock-node.BlockNumber.Int64)/MINT_EPOCH)))
+	}
+}
+
+//historyAuditWeight : favors nodes by how many passing audits qualified them in the previous epoch's reward
+func historyAuditWeight(passes map[common.Address]int) func(types.Node) uint64 {
+	return func(node types.Node) uint64 {
+		return uint64(math.Min(NODE_AUDIT_MAX_WEIGHT, float64(1+passes[common.HexToAddress(node.EthAddr)])))
+	}
+}
+
+//auditStakingBlock : the ethereum block whose staking state an epoch's audits are drawn from. Before the first mint, it's
+//the block fixed in the genesis chain params; chains without one can't assign audits until then
+func (app *AnchorApplication) auditStakingBlock(epoch int64) (int64, error) {
+	if epoch != 0 {
+		return epoch, nil
+	}
+	if app.state.ChainParams.AuditStakingBlock != 0 {
+		return app.state.ChainParams.AuditStakingBlock, nil
+	}
+	return 0, errors.New("chain has no audit_staking_block, so audits can't be assigned before the first node mint")
+}
+
+//registrySyncedTo : errors unless this Core has ingested the Node and Core registries through an ethereum block. Staked sets
+//read before then would be missing events other Cores have, so their audit assignments would differ
+func (app *AnchorApplication) registrySyncedTo(block int64) error {
+	for _, kind := range []string{types.StakingKindNode, types.StakingKindCore} {
+		last, found, err := app.pgClient.GetIngestionCursor(ethsync.CursorName(kind))
+		if err != nil {
+			return err
+		}
+		if !found || last < block {
+			return fmt.Errorf("%s registry hasn't been synced to block %d yet", kind, block)
+		}
+	}
+	return nil
+}
+
+//GetPriorAuditPasses : the number of passing audits each node was rewarded for in the previous epoch, as kept from its
+//committed REWARD-EPOCH record. A record committed at or after the round's height isn't used, and without one every node
+//weighs the same
+func (app *AnchorApplication) GetPriorAuditPasses(round int64) (map[common.Address]int, error) {
+	passes := make(map[common.Address]int)
+	value := app.Db.Get([]byte(rewardPassesPrefix + strconv.FormatInt(app.state.PrevNodeMintedAtBlock, 10)))
+	if value == nil {
+		return passes, nil
+	}
+	var record rewardPasses
+	if err := json.Unmarshal(value, &record); app.LogError(err) != nil {
+		return passes, err
+	}
+	if record.Height >= round {
+		return passes, nil
+	}
+	for address, count := range record.Passes {
+		passes[common.HexToAddress(address)] = count
+	}
+	return passes, nil
+}
+
+//putRewardPasses : keeps the number of passing audits each node was rewarded for in a committed node REWARD-EPOCH record,
+//with the height it was committed at. Only an epoch's first record is kept
+func (app *AnchorApplication) putRewardPasses(record types.RewardEpoch) {
+	key := []byte(rewardPassesPrefix + strconv.FormatInt(record.Epoch, 10))
+	if record.Kind != types.StakingKindNode || app.Db.Get(key) != nil {
+		return
+	}
+	passes := rewardPasses{Height: app.state.Height + 1, Passes: make(map[string]int)}
+	for _, candidate := range record.Candidates {
+		passes.Passes[common.HexToAddress(candidate.EthAddr).Hex()] = len(candidate.Evidence)
+	}
+	passesJSON, err := json.Marshal(passes)
+	if app.LogError(err) != nil {
+		return
+	}
+	app.Db.Set(key, passesJSON)
+}
+
+//validAuditWeighting : whether a chain's audit_weighting is one every Core knows how to apply
+func validAuditWeighting(weighting string) bool {
+	switch weighting {
+	case "", NODE_AUDIT_WEIGHT_UNIFORM, NODE_AUDIT_WEIGHT_STAKE_AGE, NODE_AUDIT_WEIGHT_HISTORY:
+		return true
+	}
+	return false
+}
+
+//nodeAuditWeight : the chain's node audit weighting, as of an epoch's staking block and a round's height
+func (app *AnchorApplication) nodeAuditWeight(round int64, stakingBlock int64) (func(types.Node) uint64, error) {
+	switch app.state.ChainParams.AuditWeighting {
+	case NODE_AUDIT_WEIGHT_STAKE_AGE:
+		return stakeAgeAuditWeight(stakingBlock), nil
+	case NODE_AUDIT_WEIGHT_HISTORY:
+		passes, err := app.GetPriorAuditPasses(round)
+		if err != nil {
+			return nil, err
+		}
+		return historyAuditWeight(passes), nil
+	default:
+		return uniformAuditWeight, nil
+	}
+}
+
+//GetAuditAssignment : derives the assignment for the audit round at a Tendermint height within an epoch. It fails unless
+//the epoch's staking block is fixed by committed state and this Core's registry sync has reached it
+func (app *AnchorApplication) GetAuditAssignment(round int64, epoch int64) (auditAssignment, error) {
+	seed, err := app.rpc.GetBlockHash(round)
+	if app.LogError(err) != nil {
+		return auditAssignment{}, err
+	}
+	stakingBlock, err := app.auditStakingBlock(epoch)
+	if app.LogError(err) != nil {
+		return auditAssignment{}, err
+	}
+	if err := app.registrySyncedTo(stakingBlock); app.LogError(err) != nil {
+		return auditAssignment{}, err
+	}
+	cores, err := app.pgClient.GetStakedCoresAtBlock(stakingBlock)
+	if app.LogError(err) != nil {
+		return auditAssignment{}, err
+	}
+	nodes, err := app.pgClient.GetStakedNodesAtBlock(stakingBlock)
+	if app.LogError(err) != nil {
+		return auditAssignment{}, err
+	}
+	weight, err := app.nodeAuditWeight(round, stakingBlock)
+	if err != nil {
+		return auditAssignment{}, err
+	}
+	return assignAudit(round, seed, cores, nodes, weight), nil
+}
+
+//auditRoundTimeout : how long a round's audits may run. Rounds start every ANCHOR_INTERVAL blocks
+func (app *AnchorApplication) auditRoundTimeout() time.Duration {
+	return time.Duration(app.config.AnchorInterval) * NODE_AUDIT_BLOCK_TIME
+}
+
+//AuditNodes : Audits the current round's nodes if this Core is one of its auditors, and submits the results in a NODE-AUDIT tx
+func (app *AnchorApplication) AuditNodes() error {
+	audit := types.NodeAudit{Round: app.state.Height, Epoch: app.state.LastNodeMintedAtBlock}
+	assignment, err := app.GetAuditAssignment(audit.Round, audit.Epoch)
+	if err != nil {
+		return err
+	}
+	if len(assignment.Auditors) > 0 {
+		app.state.LastAuditCoreID = assignment.Auditors[0]
+	}
+	if !assignment.isAuditor(app.ID) {
+		app.logger.Info(fmt.Sprintf("Not assigned to node audit round %d", audit.Round))
+		return nil
+	}
+	if len(assignment.Nodes) == 0 {
+		return app.LogError(errors.New("no staked nodes to audit"))
+	}
+	nonce, err := auditNonce()
+	if app.LogError(err) != nil {
+		return err
+	}
+	audit.Results = make([]types.NodeAuditResult, len(assignment.Nodes))
+	//the round's audits have to finish before the next round starts
+	ctx, cancel := context.WithTimeout(context.Background(), app.auditRoundTimeout())
+	defer cancel()
+	var wg sync.WaitGroup
+	for i, node := range assignment.Nodes {
+		wg.Add(1)
+		go func(i int, node types.Node) {
+			defer wg.Done()
+			app.logger.Info(fmt.Sprintf("node audit IP %s", node.PublicIP.String))
+			result := types.NodeAuditResult{EthAddr: node.EthAddr, PublicIP: node.PublicIP.String, Passed: true}
+			report, err := app.AuditNode(ctx, assignment, nonce, node)
+			result.Flags = report.Flags
+			if err != nil {
+				app.logger.Debug(fmt.Sprintf("node audit of node IP %s unsuccessful: %s", node.PublicIP.String, err.Error()))
+				result.Passed = false
+				result.Reason = err.Error()
+			} else {
+				app.logger.Info(fmt.Sprintf("Node IP %s passed node audit", node.PublicIP.String))
+			}
+			audit.Results[i] = result
+		}(i, node)
+	}
+	wg.Wait()
+	if app.state.NodeMintPending {
+		app.logger.Info("Minting in progress, not submitting node audit")
+		return nil
+	}
+	auditJSON, err := json.Marshal(audit)
+	if app.LogError(err) != nil {
+		return err
+	}
+	res, err := app.rpc.BroadcastTx("NODE-AUDIT", string(auditJSON), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
+	if app.LogError(err) != nil {
+		return err
+	}
+	if res.Code != 0 {
+		return util.LoggerError(app.logger, errors.New("problem submitting node audit results"))
+	}
+	app.logger.Info(fmt.Sprintf("node audit round %d complete, NODE-AUDIT tx issued", audit.Round))
+	return nil
+}
+
+//nodeAuditRecord : this Core's audit report, with the score and timing of each check, as kept in the audits table
+func nodeAuditRecord(coreID string, report nodeaudit.Report) types.NodeAuditRecord {
+	record := types.NodeAuditRecord{
+		EthAddr:   report.EthAddr,
+		PublicIP:  report.PublicIP,
+		CoreID:    coreID,
+		Round:     report.Round,
+		Passed:    report.Passed,
+		Reachable: report.Reachable,
+		Score:     report.Score,
+		Error:     report.Reason,
+		Flags:     report.Flags,
+		Checks:    make([]types.NodeAuditCheck, 0),
+		AuditedAt: report.Time,
+	}
+	for _, result := range report.Results {
+		record.Checks = append(record.Checks, types.NodeAuditCheck{
+			Check:  result.Check,
+			Score:  result.Score,
+			Passed: result.Passed,
+			Detail: result.Detail,
+			Millis: result.Millis,
+		})
+		record.Millis += result.Millis
+	}
+	return record
+}
+
+//nodeAuditReport : a report rebuilt from this Core's record of it in the audits table
+func nodeAuditReport(record types.NodeAuditRecord) nodeaudit.Report {
+	report := nodeaudit.Report{
+		EthAddr:   record.EthAddr,
+		PublicIP:  record.PublicIP,
+		Round:     record.Round,
+		Time:      record.AuditedAt,
+		Results:   make([]nodeaudit.Result, 0, len(record.Checks)),
+		Score:     record.Score,
+		Passed:    record.Passed,
+		Reachable: record.Reachable,
+		Flags:     record.Flags,
+		Reason:    record.Error,
+	}
+	for _, check := range record.Checks {
+		report.Results = append(report.Results, nodeaudit.Result{
+			Check:  check.Check,
+			Score:  check.Score,
+			Passed: check.Passed,
+			Detail: check.Detail,
+			Millis: check.Millis,
+		})
+	}
+	return report
+}
+
+//auditReports : keeps this Core's audit reports in the audits table beside other Cores' outcomes, which is the only place
+//reports are kept. A node's history for its next audit is read back from this Core's own rows there
+type auditReports struct {
+	app *AnchorApplication
+}
+
+//Put : records a report. An audit's outcome doesn't depend on it being recorded, so failures are only logged
+func (r *auditReports) Put(report nodeaudit.Report) error {
+	_, err := r.app.pgClient.RecordNodeAudit(nodeAuditRecord(r.app.ID, report))
+	r.app.LogError(err)
+	return nil
+}
+
+//History : this Core's most recent reports for a node, oldest first
+func (r *auditReports) History(node types.Node) ([]nodeaudit.Report, error) {
+	records, err := r.app.pgClient.GetCoreNodeAudits(r.app.ID, node.EthAddr, node.PublicIP.String, nodeaudit.DefaultHistoryLength)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]nodeaudit.Report, len(records))
+	for i, record := range records {
+		history[len(records)-1-i] = nodeAuditReport(record)
+	}
+	return history, nil
+}
+
+//recordNodeAudits : keeps another Core's NODE-AUDIT results in the audits table. Only their outcomes are known
+func (app *AnchorApplication) recordNodeAudits(tx types.Tx, audit types.NodeAudit) {
+	for _, result := range audit.Results {
+		_, err := app.pgClient.RecordNodeAudit(types.NodeAuditRecord{
+			EthAddr:   result.EthAddr,
+			PublicIP:  result.PublicIP,
+			CoreID:    tx.CoreID,
+			Round:     audit.Round,
+			Passed:    result.Passed,
+			Error:     result.Reason,
+			Flags:     result.Flags,
+			Checks:    make([]types.NodeAuditCheck, 0),
+			AuditedAt: tx.Time,
+		})
+		app.LogError(err)
+	}
+}
+
+//auditSubmission : a NODE-AUDIT tx as submitted by one Core
+type auditSubmission struct {
+	Audit  types.NodeAudit
+	CoreID string
+	TxHash string
+	Time   int64
+}
+
+//tallyNodeAudits : the nodes that a quorum of each round's assigned auditors passed, with the NODE-AUDIT txs that agreed.
+//Submissions from Cores that weren't assigned to the round, results for nodes that weren't, and repeat submissions are ignored
+func tallyNodeAudits(submissions []auditSubmission, assignments map[int64]auditAssignment, quorum int) map[common.Address][]types.RewardEvidence {
+	passes := make(map[int64]map[common.Address][]types.RewardEvidence)
+	submitted := make(map[string]bool)
+	for _, submission := range submissions {
+		assignment, ok := assignments[submission.Audit.Round]
+		key := fmt.Sprintf("%d|%s", submission.Audit.Round, submission.CoreID)
+		if !ok || !assignment.isAuditor(submission.CoreID) || submitted[key] {
+			continue
+		}
+		submitted[key] = true
+		if passes[assignment.Round] == nil {
+			passes[assignment.Round] = make(map[common.Address][]types.RewardEvidence)
+		}
+		passed := make(map[common.Address]bool)
+		for _, result := range submission.Audit.Results {
+			address := common.HexToAddress(result.EthAddr)
+			if !result.Passed || passed[address] || !assignment.isAssigned(result.EthAddr) {
+				continue
+			}
+			passed[address] = true
+			passes[assignment.Round][address] = append(passes[assignment.Round][address], types.RewardEvidence{
+				TxType: "NODE-AUDIT",
+				TxHash: submission.TxHash,
+				Time:   submission.Time,
+				CoreID: submission.CoreID,
+			})
+		}
+	}
+	evidence := make(map[common.Address][]types.RewardEvidence)
+	for _, round := range passes {
+		for address, agreeing := range round {
+			if len(agreeing) >= quorum {
+				evidence[address] = append(evidence[address], agreeing...)
+			}
+		}
+	}
+	return evidence
+}
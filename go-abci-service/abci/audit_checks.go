@@ -0,0 +1,310 @@
+package abci
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/nodeaudit"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+//Node audit check names, as used to configure them in AUDIT_CHECKS
+const (
+	AUDIT_CHECK_REPUTATION   = "reputation"
+	AUDIT_CHECK_HASH_LATENCY = "hash_latency"
+	AUDIT_CHECK_PROOF        = "proof"
+	AUDIT_CHECK_VERSION      = "version"
+	AUDIT_CHECK_TLS          = "tls"
+	AUDIT_CHECK_UPTIME       = "uptime"
+)
+
+//Timing for the node audit checks. A node's Cal proof is polled for until NODE_AUDIT_PROOF_TIMEOUT after its hash was submitted,
+//or until the audit round's time is up if that's sooner. Rounds last ANCHOR_INTERVAL blocks of about NODE_AUDIT_BLOCK_TIME each
+const (
+	NODE_AUDIT_FAST_SUBMIT   = 2 * time.Second
+	NODE_AUDIT_PROOF_POLL    = 15 * time.Second
+	NODE_AUDIT_PROOF_TIMEOUT = 4 * time.Minute
+	NODE_AUDIT_DIAL_TIMEOUT  = 10 * time.Second
+	NODE_AUDIT_BLOCK_TIME    = 1 * time.Minute
+)
+
+//defaultAuditConfig : a node must present a valid reputation chain, accept a hash and return its Cal proof, as it had to
+//before audit checks were configurable. Version and uptime only count towards the score, and TLS isn't checked
+var defaultAuditConfig = nodeaudit.Config{
+	Checks: map[string]nodeaudit.CheckConfig{
+		AUDIT_CHECK_REPUTATION:   {Weight: 3, Threshold: 1},
+		AUDIT_CHECK_HASH_LATENCY: {Weight: 2, Threshold: 0.1},
+		AUDIT_CHECK_PROOF:        {Weight: 3, Threshold: 1},
+		AUDIT_CHECK_VERSION:      {Weight: 1, Threshold: 0},
+		AUDIT_CHECK_TLS:          {Weight: 0, Threshold: 1},
+		AUDIT_CHECK_UPTIME:       {Weight: 1, Threshold: 0},
+	},
+}
+
+//newNodeAuditor : registers the node audit checks, configured from AUDIT_CHECKS and AUDIT_PASS_SCORE, keeping reports in
+//store and reputation chain heads in heads. Reputation chains are anchored to calendar blocks looked up with calBlockHash
+func newNodeAuditor(config types.AnchorConfig, store nodeaudit.Store, heads *repChainHeads, calBlockHash func(int64) (string, error)) (*nodeaudit.Auditor, error) {
+	registry := nodeaudit.NewRegistry(
+		reputationCheck{Heads: heads, CalBlockHash: calBlockHash},
+		hashLatencyCheck{Fast: NODE_AUDIT_FAST_SUBMIT},
+		proofCheck{Interval: NODE_AUDIT_PROOF_POLL, Timeout: NODE_AUDIT_PROOF_TIMEOUT},
+		versionCheck{MinVersion: config.AuditMinVersion},
+		tlsCheck{Timeout: NODE_AUDIT_DIAL_TIMEOUT},
+		uptimeCheck{},
+	)
+	defaults := defaultAuditConfig
+	defaults.PassScore = config.AuditPassScore
+	auditConfig, err := nodeaudit.ParseConfig(config.AuditChecks, defaults)
+	return nodeaudit.NewAuditor(registry, auditConfig, store), err
+}
+
+//auditNonce : a random nonce for one audit round, known only to the auditing Core
+func auditNonce() (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+//auditHash : the hash a Core submits to a node in an audit round. It's unique to the Core, round and node, so a node can't
+//answer with a proof it already holds, and mixes in the round's block hash and the Core's private nonce for the round, so
+//a node can't compute it ahead of the audit
+func auditHash(coreID string, round int64, seed string, nonce string, node types.Node) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%s|%s|%s", coreID, round, seed, nonce, node.EthAddr, node.PublicIP.String)))
+	return hex.EncodeToString(hash[:])
+}
+
+//reputationCheck : the node serves a fully valid reputation chain, signed by its address and anchored to the calendar,
+//that extends the one it served at its last audit. A node whose chain conflicts with an earlier one is flagged
+type reputationCheck struct {
+	Heads        *repChainHeads
+	CalBlockHash func(int64) (string, error)
+}
+
+func (c reputationCheck) Name() string {
+	return AUDIT_CHECK_REPUTATION
+}
+
+func (c reputationCheck) Run(ctx context.Context, target *nodeaudit.Target) (float64, string) {
+	repChain, err := GetNodeRecentReputation(target.Node)
+	if err != nil {
+		return 0, err.Error()
+	}
+	target.Reachable = true
+	head, err := c.Heads.Get(target.Node)
+	if err != nil {
+		return 0, err.Error()
+	}
+	newHead, err := VerifyRepChain(target.Node, repChain, head, c.CalBlockHash)
+	if _, forked := err.(RepChainForkError); forked {
+		target.Flags = append(target.Flags, AUDIT_FLAG_FORKED_REP_CHAIN)
+	}
+	if err != nil {
+		return 0, err.Error()
+	}
+	if err := c.Heads.Put(target.Node, newHead); err != nil {
+		return 0, err.Error()
+	}
+	return 1, fmt.Sprintf("reputation chain verified through item %d", newHead.ID)
+}
+
+//hashLatencyCheck : the node accepts the audit hash, scoring fully if it responds within Fast and proportionally less
+//the longer it takes
+type hashLatencyCheck struct {
+	Fast time.Duration
+}
+
+func (c hashLatencyCheck) Name() string {
+	return AUDIT_CHECK_HASH_LATENCY
+}
+
+func (c hashLatencyCheck) Run(ctx context.Context, target *nodeaudit.Target) (float64, string) {
+	start := time.Now()
+	nodeResp, err := SendNodeHash(target.Node, target.Hash)
+	latency := time.Since(start)
+	if err != nil {
+		return 0, err.Error()
+	}
+	target.Reachable = true
+	if len(nodeResp.Hashes) == 0 {
+		return 0, "node accepted no hashes"
+	}
+	target.HashID = nodeResp.Hashes[0].HashIDNode
+	target.SubmittedAt = start
+	if latency <= c.Fast {
+		return 1, fmt.Sprintf("hash accepted in %s", latency)
+	}
+	return float64(c.Fast) / float64(latency), fmt.Sprintf("hash accepted in %s", latency)
+}
+
+//proofCheck : the node returns a Cal-anchored proof for the audit hash, polled for until Timeout after it was submitted
+type proofCheck struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+func (c proofCheck) Name() string {
+	return AUDIT_CHECK_PROOF
+}
+
+func (c proofCheck) Run(ctx context.Context, target *nodeaudit.Target) (float64, string) {
+	if target.HashID == "" {
+		return 0, "no hash was submitted to the node"
+	}
+	ctx, cancel := context.WithDeadline(ctx, target.SubmittedAt.Add(c.Timeout))
+	defer cancel()
+	err := nodeaudit.Poll(ctx, c.Interval, func() (bool, error) {
+		err := RetrieveNodeCalProof(target.Node, target.HashID)
+		return err == nil, err
+	})
+	if err != nil {
+		return 0, err.Error()
+	}
+	return 1, fmt.Sprintf("cal anchored after %s", time.Since(target.SubmittedAt).Round(time.Second))
+}
+
+//versionCheck : the node reports a software version no older than MinVersion in its /config. Nodes whose /config has no
+//version aren't checked
+type versionCheck struct {
+	MinVersion string
+}
+
+func (c versionCheck) Name() string {
+	return AUDIT_CHECK_VERSION
+}
+
+func (c versionCheck) Run(ctx context.Context, target *nodeaudit.Target) (float64, string) {
+	version, err := GetNodeVersion(target.Node)
+	if err != nil {
+		return 0, err.Error()
+	}
+	if version == "" {
+		return 1, "node reports no version, so it wasn't checked"
+	}
+	if c.MinVersion != "" && compareVersions(version, c.MinVersion) < 0 {
+		return 0, fmt.Sprintf("version %s is older than %s", version, c.MinVersion)
+	}
+	return 1, fmt.Sprintf("version %s", version)
+}
+
+//tlsCheck : the node completes a TLS handshake on port 443 with a certificate that's currently valid. Nodes are only
+//known by IP, so the certificate's name isn't verified
+type tlsCheck struct {
+	Timeout time.Duration
+}
+
+func (c tlsCheck) Name() string {
+	return AUDIT_CHECK_TLS
+}
+
+func (c tlsCheck) Run(ctx context.Context, target *nodeaudit.Target) (float64, string) {
+	if net.ParseIP(target.Node.PublicIP.String) == nil {
+		return 0, "cannot parse node IP"
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: c.Timeout}, "tcp", net.JoinHostPort(target.Node.PublicIP.String, "443"),
+		&tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return 0, "no certificate presented"
+	}
+	now := time.Now()
+	if now.Before(certs[0].NotBefore) || now.After(certs[0].NotAfter) {
+		return 0, fmt.Sprintf("certificate valid from %s to %s", certs[0].NotBefore, certs[0].NotAfter)
+	}
+	return 1, fmt.Sprintf("certificate valid until %s", certs[0].NotAfter)
+}
+
+//uptimeCheck : the share of this Core's recent audits of the node, including this one, in which the node responded
+type uptimeCheck struct{}
+
+func (c uptimeCheck) Name() string {
+	return AUDIT_CHECK_UPTIME
+}
+
+func (c uptimeCheck) Run(ctx context.Context, target *nodeaudit.Target) (float64, string) {
+	reachable := 0
+	if target.Reachable {
+		reachable++
+	}
+	for _, report := range target.History {
+		if report.Reachable {
+			reachable++
+		}
+	}
+	audits := len(target.History) + 1
+	return float64(reachable) / float64(audits), fmt.Sprintf("reachable in %d of the last %d audits", reachable, audits)
+}
+
+//GetNodeVersion : get the software version a node reports at node_ip/config, which is empty if it reports none
+func GetNodeVersion(node types.Node) (string, error) {
+	if net.ParseIP(node.PublicIP.String) == nil {
+		return "", errors.New("cannot parse node IP")
+	}
+	resp, err := nodeClient.Get(fmt.Sprintf("http://%s/config", node.PublicIP.String))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return parseNodeVersion(contents)
+}
+
+//parseNodeVersion : the version field of a node's /config, if any
+func parseNodeVersion(contents []byte) (string, error) {
+	var nodeConfig struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(contents, &nodeConfig); err != nil {
+		return "", err
+	}
+	return nodeConfig.Version, nil
+}
+
+//compareVersions : compares dotted numeric versions such as v1.5.2, ignoring any pre-release suffix
+func compareVersions(a string, b string) int {
+	parse := func(version string) []int {
+		version = strings.SplitN(strings.TrimPrefix(strings.TrimSpace(version), "v"), "-", 2)[0]
+		parts := make([]int, 0)
+		for _, part := range strings.Split(version, ".") {
+			n, _ := strconv.Atoi(part)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	pa, pb := parse(a), parse(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package abci
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	dbm "github.com/chainpoint/tendermint/libs/db"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/nodeaudit"
+	"github.com/chp-project/chainpoint-core/go-abci-service/postgres"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+func auditFixtures() ([]types.Core, []types.Node) {
+	cores := make([]types.Core, 0)
+	for i := 0; i < 6; i++ {
+		cores = append(cores, types.Core{CoreId: sql.NullString{String: fmt.Sprintf("core%d", i), Valid: true}})
+	}
+	nodes := make([]types.Node, 0)
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, types.Node{EthAddr: fmt.Sprintf("0x%040d", i), PublicIP: sql.NullString{String: fmt.Sprintf("10.0.0.%d", i), Valid: true}})
+	}
+	return cores, nodes
+}
+
+func TestAssignAuditIsDeterministic(t *testing.T) {
+	assert := assert.New(t)
+	cores, nodes := auditFixtures()
+	seed := "3719ADA3EEE198F3A7A33616EA60ED6D72D94D31A2B2422FA12E2BCDDCABD4D4"
+	assignment := assignAudit(100, seed, cores, nodes, uniformAuditWeight)
+	assert.Len(assignment.Auditors, NODE_AUDITORS)
+	assert.Len(assignment.Nodes, NODE_AUDIT_SAMPLE)
+
+	// staking rows arrive in whatever order postgres returns them
+	reversedCores := make([]types.Core, len(cores))
+	for i, core := range cores {
+		reversedCores[len(cores)-1-i] = core
+	}
+	reversedNodes := make([]types.Node, len(nodes))
+	for i, node := range nodes {
+		reversedNodes[len(nodes)-1-i] = node
+	}
+	again := assignAudit(100, seed, reversedCores, reversedNodes, uniformAuditWeight)
+	assert.Equal(assignment.Auditors, again.Auditors)
+	assert.Equal(assignment.Nodes, again.Nodes)
+}
+
+func TestAuditWeightings(t *testing.T) {
+	assert := assert.New(t)
+	veteran := types.Node{EthAddr: "0x1", BlockNumber: sql.NullInt64{Int64: 100, Valid: true}}
+	newcomer := types.Node{EthAddr: "0x2", BlockNumber: sql.NullInt64{Int64: 3 * MINT_EPOCH, Valid: true}}
+	stakeAge := stakeAgeAuditWeight(3*MINT_EPOCH + 10)
+	assert.Equal(uint64(3), stakeAge(veteran))
+	assert.Equal(uint64(1), stakeAge(newcomer))
+	assert.Equal(uint64(1), stakeAgeAuditWeight(100)(veteran), "a node has no stake age in the block it staked")
+	assert.Equal(uint64(NODE_AUDIT_MAX_WEIGHT), stakeAgeAuditWeight(1000*MINT_EPOCH)(veteran))
+
+	history := historyAuditWeight(map[common.Address]int{common.HexToAddress("0x1"): 4})
+	assert.Equal(uint64(5), history(veteran))
+	assert.Equal(uint64(1), history(newcomer))
+}
+
+func TestTallyNodeAuditsRequiresQuorum(t *testing.T) {
+	assert := assert.New(t)
+	cores, nodes := auditFixtures()
+	assignment := assignAudit(100, "3719ADA3EEE198F3A7A33616EA60ED6D72D94D31A2B2422FA12E2BCDDCABD4D4", cores, nodes, uniformAuditWeight)
+	agreed, disputed := assignment.Nodes[0], assignment.Nodes[1]
+	result := func(node types.Node, passed bool) types.NodeAuditResult {
+		return types.NodeAuditResult{EthAddr: node.EthAddr, PublicIP: node.PublicIP.String, Passed: passed}
+	}
+	outsider := "core-not-assigned"
+	for _, core := range cores {
+		if !assignment.isAuditor(core.CoreId.String) {
+			outsider = core.CoreId.String
+			break
+		}
+	}
+	unassigned := types.Node{}
+	for _, node := range nodes {
+		if !assignment.isAssigned(node.EthAddr) {
+			unassigned = node
+			break
+		}
+	}
+	submissions := []auditSubmission{
+		{CoreID: assignment.Auditors[0], TxHash: "A", Audit: types.NodeAudit{Round: 100, Results: []types.NodeAuditResult{result(agreed, true), result(disputed, true), result(unassigned, true)}}},
+		{CoreID: assignment.Auditors[0], TxHash: "A2", Audit: types.NodeAudit{Round: 100, Results: []types.NodeAuditResult{result(disputed, true)}}},
+		{CoreID: assignment.Auditors[1], TxHash: "B", Audit: types.NodeAudit{Round: 100, Results: []types.NodeAuditResult{result(agreed, true), result(disputed, false), result(unassigned, true)}}},
+		{CoreID: outsider, TxHash: "C", Audit: types.NodeAudit{Round: 100, Results: []types.NodeAuditResult{result(disputed, true), result(unassigned, true)}}},
+	}
+	evidence := tallyNodeAudits(submissions, map[int64]auditAssignment{100: assignment}, NODE_AUDIT_QUORUM)
+	assert.Len(evidence, 1, "only the node a quorum of assigned auditors passed qualifies")
+	assert.Len(evidence[common.HexToAddress(agreed.EthAddr)], 2)
+}
+
+func TestAuditReportsKeepHistoryInAuditsTable(t *testing.T) {
+	assert := assert.New(t)
+	store := postgres.NewMemoryStore()
+	reports := &auditReports{app: &AnchorApplication{ID: "core1", pgClient: store}}
+	node := types.Node{EthAddr: "0xAB00000000000000000000000000000000000001", PublicIP: sql.NullString{String: "10.0.0.1", Valid: true}}
+	for round := int64(1); round <= 2; round++ {
+		assert.NoError(reports.Put(nodeaudit.Report{EthAddr: node.EthAddr, PublicIP: node.PublicIP.String, Round: round, Time: round,
+			Reachable: round == 2, Results: []nodeaudit.Result{{Check: AUDIT_CHECK_UPTIME, Score: 0.5, Passed: true, Millis: 3}}}))
+	}
+	store.RecordNodeAudit(types.NodeAuditRecord{EthAddr: node.EthAddr, CoreID: "core2", Round: 3, AuditedAt: 3})
+
+	history, err := reports.History(node)
+	assert.NoError(err)
+	assert.Len(history, 2, "only this Core's own reports make up a node's history")
+	assert.Equal(int64(1), history[0].Round, "oldest first")
+	assert.True(history[1].Reachable)
+	assert.Equal(AUDIT_CHECK_UPTIME, history[1].Results[0].Check)
+	audits, _ := store.GetNodeAudits(node.EthAddr, 10)
+	assert.Len(audits, 3, "reports are kept once, in the audits table")
+}
+
+func TestAuditChecksTolerateNodes(t *testing.T) {
+	assert := assert.New(t)
+	version, err := parseNodeVersion([]byte(`{"version":"v1.2.0"}`))
+	assert.NoError(err)
+	assert.Equal("v1.2.0", version)
+	version, err = parseNodeVersion([]byte(`{"chainpoint_core_base_uri":"http://10.0.0.1"}`))
+	assert.NoError(err, "a /config without a version isn't an error")
+	assert.Equal("", version)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	score, _ := proofCheck{Interval: 10 * time.Millisecond, Timeout: NODE_AUDIT_PROOF_TIMEOUT}.Run(ctx, &nodeaudit.Target{HashID: "hash", SubmittedAt: start})
+	assert.Equal(float64(0), score)
+	assert.True(time.Since(start) < time.Second, "the proof poll ends with the audit round")
+}
+
+func TestPriorAuditPassesComeFromCommittedRecords(t *testing.T) {
+	assert := assert.New(t)
+	app := &AnchorApplication{Db: dbm.NewMemDB()}
+	app.state.Height = 99
+	app.state.PrevNodeMintedAtBlock = 6400
+	passes, err := app.GetPriorAuditPasses(200)
+	assert.NoError(err)
+	assert.Empty(passes, "without a committed record every node weighs the same")
+
+	node := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	app.putRewardPasses(newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{
+		node: {{TxType: "NODE-AUDIT", TxHash: "AA", CoreID: "core1"}, {TxType: "NODE-AUDIT", TxHash: "BB", CoreID: "core2"}},
+	}))
+	passes, _ = app.GetPriorAuditPasses(100)
+	assert.Empty(passes, "a record committed at the round's height isn't used")
+	passes, _ = app.GetPriorAuditPasses(101)
+	assert.Equal(2, passes[node])
+
+	app.putRewardPasses(newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{
+		node: {{TxType: "NODE-AUDIT", TxHash: "CC", CoreID: "core1"}},
+	}))
+	passes, _ = app.GetPriorAuditPasses(101)
+	assert.Equal(2, passes[node], "only an epoch's first record is kept")
+}
+
+func TestAuditHashIsUnpredictable(t *testing.T) {
+	assert := assert.New(t)
+	_, nodes := auditFixtures()
+	nonce, err := auditNonce()
+	assert.NoError(err)
+	otherNonce, _ := auditNonce()
+	assert.NotEqual(nonce, otherNonce)
+	hash := auditHash("core0", 10, "seed", nonce, nodes[0])
+	assert.Equal(hash, auditHash("core0", 10, "seed", nonce, nodes[0]))
+	assert.NotEqual(hash, auditHash("core0", 10, "seed", otherNonce, nodes[0]), "the Core's nonce changes the hash")
+	assert.NotEqual(hash, auditHash("core0", 10, "other seed", nonce, nodes[0]), "the round's block hash changes the hash")
+	assert.NotEqual(hash, auditHash("core0", 10, "seed", nonce, nodes[1]))
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, compareVersions("v1.5.2", "1.5.2"))
+	assert.Equal(-1, compareVersions("1.5.2", "1.10.0"))
+	assert.Equal(1, compareVersions("2.0", "1.9.9-beta"))
+	assert.Equal(-1, compareVersions("1.5", "1.5.1"))
+}
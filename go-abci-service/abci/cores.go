@@ -1,51 +1,55 @@
 package abci
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
 	"math/big"
-	"sort"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/chp-project/chainpoint-core/go-abci-service/ethcontracts"
+	"github.com/chp-project/chainpoint-core/go-abci-service/ethsync"
 
 	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 
+	"github.com/chp-project/chainpoint-core/go-abci-service/rewardsig"
+	"github.com/chp-project/chainpoint-core/go-abci-service/smt"
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+	"github.com/chp-project/chainpoint-core/go-abci-service/workledger"
 )
 
-//MintCoreReward : mint rewards for cores
-func (app *AnchorApplication) MintCoreReward(sig []string, rewardCandidates []common.Address, rewardHash []byte) error {
+//MintCoreReward : mint rewards for a core reward epoch, committing its REWARD-EPOCH record once the mint is confirmed
+func (app *AnchorApplication) MintCoreReward(sigs [][]byte, record types.RewardEpoch, rewardHash []byte) error {
 	app.logger.Info("CoreMint: Elected Leader for Minting")
-	app.logger.Info(fmt.Sprintf("CoreMint: %v\nReward Candidates: %v\nReward Hash: %x\n", sig, rewardCandidates, rewardHash))
-	sigBytes := make([][]byte, 126)
-	for i, _ := range sigBytes {
-		sigBytes[i] = make([]byte, 0)
-	}
-	for i, sigStr := range sig {
-		var decodedSig []byte
-		decodedSig, err := hex.DecodeString(sigStr)
-		if app.LogError(err) != nil {
-			app.logger.Info("CoreMint: sig hex decoding failed")
-			continue
-		}
-		sigBytes[i] = decodedSig
+	rewardCandidates := rewardEpochAddresses(record)
+	app.logger.Info(fmt.Sprintf("CoreMint: %d signatures\nReward Candidates: %v\nReward Hash: %x\n", len(sigs), rewardCandidates, rewardHash))
+	encodedSigs, err := rewardsig.Encode(sigs, app.config.EthConfig.CoreMintSigSlots)
+	if app.LogError(err) != nil {
+		app.logger.Info("CoreMint: signatures don't fit the mint call")
+		return err
+	}
+	data, err := packMintCall(app.tokenABI, MINT_CORES_METHOD, rewardCandidates, rewardHash, encodedSigs)
+	if app.LogError(err) != nil {
+		app.logger.Info("CoreMint: encoding smart contract call failed")
+		return err
 	}
-	app.logger.Info(fmt.Sprintf("CoreMint: Sig Bytes: %v", sigBytes))
-	var sigFixedBytes [126][]byte
-	copy(sigFixedBytes[:], sigBytes[:126])
-	app.logger.Info(fmt.Sprintf("CoreMint: Sig Bytes: %v", sigFixedBytes))
-	err := app.ethClient.MintCores(rewardCandidates, rewardHash, sigFixedBytes)
+	id := fmt.Sprintf("CORE-MINT:%d", app.state.LastCoreMintedAtBlock)
+	if err := app.putMintRewardEpoch(id, record); app.LogError(err) != nil {
+		return err
+	}
+	tx, err := app.ethTx.Send(id, common.HexToAddress(app.config.EthConfig.TokenContractAddr), data, 0)
 	if app.LogError(err) != nil {
 		app.logger.Info("CoreMint: invoking smart contract failed")
 		return err
 	}
-	app.logger.Info("CoreMint process complete")
+	app.logger.Info(fmt.Sprintf("CoreMint tx %s sent, awaiting confirmation", tx.Hash))
+	go app.MintMonitor(tx.ID)
 
 	return nil
 }
@@ -64,13 +68,13 @@ func (app *AnchorApplication) StartCoreMintProcess() error {
 //SetCoreMintPendingState : create a deferable method to set mint state
 func (app *AnchorApplication) SetCoreMintPendingState(val bool) {
 	app.state.CoreMintPending = val
-	app.CoreRewardSignatures = make([]string, 0)
+	if !val {
+		app.CoreRewardSignatures.Clear()
+	}
 }
 
 //CollectRewardNodes : collate and sign reward node list
 func (app *AnchorApplication) SignCoreRewards() error {
-	var candidates []common.Address
-	var rewardHash []byte
 	currentEthBlock, err := app.ethClient.HighestBlock()
 	if app.LogError(err) != nil {
 		app.logger.Error("CoreMint Error: problem retrieving highest block for core minting")
@@ -80,19 +84,24 @@ func (app *AnchorApplication) SignCoreRewards() error {
 		app.logger.Info("CoreMint: Too soon for core minting")
 		return errors.New("CoreMint: Too soon for minting")
 	}
-	candidates, rewardHash, err = app.GetCoreRewardCandidates()
+	record, err := app.GetCoreRewardCandidates()
 	if app.LogError(err) != nil {
 		app.logger.Info("CoreMint Error: Error retrieving core reward candidates")
 		return err
 	}
+	rewardHash, err := hex.DecodeString(record.RewardHash)
+	if app.LogError(err) != nil {
+		return err
+	}
 	rewardHash = signHash(rewardHash)
 	app.logger.Info(fmt.Sprintf("CoreMint: reward hash: %x", rewardHash))
+	app.CoreRewardSignatures.Reset(rewardHash)
 	signature, err := ethcontracts.SignMsg(rewardHash, app.ethClient.EthPrivateKey)
-	signature[64] += 27
 	if app.LogError(err) != nil {
 		app.logger.Info("CoreMint Error: Problem with signing message for minting")
 		return err
 	}
+	signature[64] += 27
 	_, err = app.rpc.BroadcastTx("CORE-SIGN", hex.EncodeToString(signature), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
 	if err != nil {
 		app.logger.Info("CoreMint Error: Error issuing SIGN tx")
@@ -102,15 +111,10 @@ func (app *AnchorApplication) SignCoreRewards() error {
 		peers := app.GetPeers()
 		thresholdLenPeers := int(math.Ceil(float64(len(peers)) * 0.66))
 
-		// wait for 6 SIGN tx
-		deadline := time.Now().Add(4 * time.Minute)
-		for len(app.CoreRewardSignatures) < thresholdLenPeers && !time.Now().After(deadline) {
-			time.Sleep(10 * time.Second)
-		}
-		// Mint if 2/3+ SIGN txs are received
-		if len(app.CoreRewardSignatures) >= thresholdLenPeers {
+		// Mint once verified SIGN txs are received from 2/3+ of Cores
+		if app.CoreRewardSignatures.Wait(thresholdLenPeers, 4*time.Minute) {
 			app.logger.Info("CoreMint: Enough SIGN TXs received, calling mint")
-			err := app.MintCoreReward(app.CoreRewardSignatures, candidates, rewardHash)
+			err := app.MintCoreReward(app.CoreRewardSignatures.Signatures(), record, rewardHash)
 			if len(ids) == 1 {
 				app.state.LastMintCoreID = ids[0]
 			}
@@ -125,116 +129,164 @@ func (app *AnchorApplication) SignCoreRewards() error {
 	return nil
 }
 
-//GetNodeRewardCandidates : scans for and collates the reward candidates in the current epoch
-func (app *AnchorApplication) GetCoreRewardCandidates() ([]common.Address, []byte, error) {
-	txResult, err := app.rpc.client.TxSearch(fmt.Sprintf("CORERC=%d", app.state.LastCoreMintedAtBlock), false, 1, 25)
-	app.logger.Info(fmt.Sprintf("CoreMint for CORERC txResults: %#v", txResult))
+//GetCoreRewardCandidates : scores the work each Core was credited with in the current epoch and splits the epoch's
+//reward shares between the rewardable Cores that did any
+func (app *AnchorApplication) GetCoreRewardCandidates() (types.RewardEpoch, error) {
+	epoch := app.state.LastCoreMintedAtBlock
+	weights, shares, err := coreRewardRules(app.state.ChainParams)
 	if app.LogError(err) != nil {
-		return []common.Address{}, []byte{}, err
+		return types.RewardEpoch{}, err
 	}
-	coreArray := make([]common.Address, 0)
-	for _, tx := range txResult.Txs {
-		decoded, err := util.DecodeVerifyTx(tx.Tx, app.CoreKeys)
-		if app.LogError(err) != nil {
-			continue
-		}
-		meta := strings.Split(decoded.Meta, "|")
-		if len(meta) == 0 {
-			app.logger.Info(fmt.Sprintf("CoreMint: No CoreID attributable to tx %#v", decoded))
+	work, err := app.coreRewardWork(app.committedRegistry(), epoch, app.rewardableCore)
+	if err != nil {
+		return types.RewardEpoch{}, err
+	}
+	candidates := make([]types.RewardCandidate, 0, len(work))
+	for address, addressWork := range work {
+		candidates = append(candidates, types.RewardCandidate{EthAddr: address.Hex(), Work: addressWork, Score: weights.Score(addressWork)})
+	}
+	record := scoreRewardEpoch(types.StakingKindCore, epoch, candidates, shares)
+	if len(record.Candidates) == 0 {
+		return types.RewardEpoch{}, errors.New("CoreMint: No Core work from the last epoch has been found")
+	}
+	app.logger.Info(fmt.Sprintf("CoreMint: input core addresses: %#v", rewardEpochAddresses(record)))
+	return record, nil
+}
+
+//coreRewardWork : a Core mint epoch's credited work, summed by the address each Core bound to its key in a registry.
+//Cores without a bound address, or that eligible rejects, are left out
+func (app *AnchorApplication) coreRewardWork(registry *smt.SparseMerkleTree, epoch int64, eligible func(types.CoreWork, common.Address) bool) (map[common.Address]map[string]int64, error) {
+	ledger, err := app.workLedger.Epoch(epoch)
+	if app.LogError(err) != nil {
+		return nil, err
+	}
+	work := make(map[common.Address]map[string]int64)
+	for _, coreWork := range ledger {
+		address, bound, err := registryCoreAddress(registry, coreWork.CoreID)
+		if app.LogError(err) != nil || !bound || !eligible(coreWork, address) {
 			continue
 		}
-		core, err := app.pgClient.GetCoreByID(meta[0])
-		app.logger.Info(fmt.Sprintf("CoreMint for core %#v", core))
-		if app.LogError(err) != nil {
-			continue
+		if work[address] == nil {
+			work[address] = make(map[string]int64)
 		}
-		coreArray = append(coreArray, common.HexToAddress(core.EthAddr))
-	}
-	if len(coreArray) == 0 {
-		return []common.Address{}, []byte{}, errors.New("CoreMint: No CORE-RC tx from the last epoch have been found")
-	}
-	addresses := util.UniquifyAddresses(coreArray)
-	sort.Slice(addresses[:], func(i, j int) bool {
-		return addresses[i].Hex() > addresses[j].Hex()
-	})
-	app.logger.Info(fmt.Sprintf("CoreMint: input core addresses: %#v", addresses))
-	rewardHash := ethcontracts.AddressesToHash(addresses)
-	return addresses, rewardHash, nil
-}
-
-//PollCoresFromContract : load all past node staking events and update events
-func (app *AnchorApplication) PollCoresFromContract() {
-	highestBlock := big.NewInt(0)
-	first := true
-	for {
-		app.logger.Info(fmt.Sprintf("Polling for Core Registry events after block %d", highestBlock.Int64()))
-		if first {
-			first = false
-		} else {
-			time.Sleep(30 * time.Second)
+		for kind, count := range coreWork.Work {
+			work[address][kind] += count
 		}
+	}
+	return work, nil
+}
 
-		//Consume all past node events from this contract and import them into the local postgres instance
-		coresStaked, err := app.ethClient.GetPastCoresStakedEvents(*highestBlock)
-		if app.LogError(err) != nil {
-			app.logger.Info("error in finding past staked nodes")
-			continue
-		}
-		for _, core := range coresStaked {
-			newCore := types.Core{
-				EthAddr:     core.Sender.Hex(),
-				PublicIP:    sql.NullString{String: util.Int2Ip(core.CoreIp).String(), Valid: true},
-				CoreId:      sql.NullString{String: hex.EncodeToString(core.CoreId), Valid: true},
-				BlockNumber: sql.NullInt64{Int64: int64(core.Raw.BlockNumber), Valid: true},
-			}
-			inserted, err := app.pgClient.CoreUpsert(newCore)
-			if app.LogError(err) != nil {
-				continue
-			}
-			app.logger.Info(fmt.Sprintf("Inserted for %#v: %t", newCore, inserted))
-		}
+//rewardableCore : whether a Core's work counts toward the next Core mint: it mustn't have been penalized when it was first
+//credited, and the address it bound in the registry must be the one it staked with
+func (app *AnchorApplication) rewardableCore(coreWork types.CoreWork, address common.Address) bool {
+	if penalty, penalized, err := corePenalty(app.committedRegistry(), coreWork.CoreID); app.LogError(err) == nil && penalized && coreWork.FirstHeight < penalty.Until {
+		app.logger.Info(fmt.Sprintf("CoreMint: excluding Core %s, penalized for %s until block %d", coreWork.CoreID, penalty.Offense, penalty.Until))
+		return false
+	}
+	core, err := app.pgClient.GetCoreByID(coreWork.CoreID)
+	if app.LogError(err) != nil {
+		return false
+	}
+	if core.EthAddr == "" || common.HexToAddress(core.EthAddr) != address {
+		app.logger.Info(fmt.Sprintf("CoreMint: Core %s isn't staked with its registered address %s", coreWork.CoreID, address.Hex()))
+		return false
+	}
+	return true
+}
 
-		//Consume all updated events and reconcile them with the previous states
-		coresStakedUpdated, err := app.ethClient.GetPastCoresStakeUpdatedEvents(*highestBlock)
-		if app.LogError(err) != nil {
-			continue
-		}
-		for _, core := range coresStakedUpdated {
-			newCore := types.Core{
-				EthAddr:     core.Sender.Hex(),
-				PublicIP:    sql.NullString{String: util.Int2Ip(core.CoreIp).String(), Valid: true},
-				CoreId:      sql.NullString{String: hex.EncodeToString(core.CoreId), Valid: true},
-				BlockNumber: sql.NullInt64{Int64: int64(core.Raw.BlockNumber), Valid: true},
-			}
-			inserted, err := app.pgClient.CoreUpsert(newCore)
-			if app.LogError(err) != nil {
-				continue
-			}
-			app.logger.Info(fmt.Sprintf("Updated for %#v: %t", newCore, inserted))
-		}
+//creditCoreWork : credits the Core that issued a delivered tx with its work in the current Core mint epoch, once per tx hash.
+//Work is only credited once the registry is active, so every Core verifies the issuer against the same committed keys
+func (app *AnchorApplication) creditCoreWork(rawTx []byte, tx types.Tx, kind string) {
+	if tx.CoreID == "" || !app.activated(app.state.ChainParams.RegistryHeight) {
+		return
+	}
+	txHash := sha256.Sum256(rawTx)
+	_, err := app.workLedger.AddOnce(app.state.LastCoreMintedAtBlock, tx.CoreID, kind, hex.EncodeToString(txHash[:]), app.state.Height)
+	app.LogError(err)
+}
 
-		//Consume unstake events and delete nodes where the blockNumber of this event is higher than the last stake or update
-		coresUnstaked, err := app.ethClient.GetPastCoresUnstakeEvents(*highestBlock)
-		if app.LogError(err) != nil {
-			continue
+//creditCoreAudit : credits the Core that issued a NODE-AUDIT tx once per audit round, for rounds that have been reached
+func (app *AnchorApplication) creditCoreAudit(tx types.Tx, audit types.NodeAudit) {
+	if tx.CoreID == "" || audit.Round > app.state.Height || !app.activated(app.state.ChainParams.RegistryHeight) {
+		return
+	}
+	_, err := app.workLedger.AddOnce(app.state.LastCoreMintedAtBlock, tx.CoreID, workledger.WorkAudit, strconv.FormatInt(audit.Round, 10), app.state.Height)
+	app.LogError(err)
+}
+
+//coreRegistry : ethsync adapter for Cores in the registry contract and the staked_cores table
+type coreRegistry struct {
+	app *AnchorApplication
+}
+
+//Kind : Cores are recorded as "core" in the staking ledger
+func (r *coreRegistry) Kind() string {
+	return types.StakingKindCore
+}
+
+//FetchRange : CoreStaked, CoreStakeUpdated and CoreUnStaked events emitted in blocks start through end.
+//The registry client reads from start to the chain head, so events past end are dropped here and read again with a later range
+func (r *coreRegistry) FetchRange(start int64, end int64) ([]ethsync.Event, error) {
+	startBlock := *big.NewInt(start)
+	events := make([]ethsync.Event, 0)
+	staked, err := r.app.ethClient.GetPastCoresStakedEvents(startBlock)
+	if r.app.LogError(err) != nil {
+		r.app.logger.Info("error in finding past staked cores")
+		return nil, err
+	}
+	for _, core := range staked {
+		if int64(core.Raw.BlockNumber) <= end {
+			events = append(events, coreEvent(types.StakingEventStaked, core.Sender, core.CoreIp, core.CoreId, core.Raw))
 		}
-		for _, core := range coresUnstaked {
-			newCore := types.Core{
-				EthAddr:     core.Sender.Hex(),
-				PublicIP:    sql.NullString{String: util.Int2Ip(core.CoreIp).String(), Valid: true},
-				CoreId:      sql.NullString{String: hex.EncodeToString(core.CoreId), Valid: true},
-				BlockNumber: sql.NullInt64{Int64: int64(core.Raw.BlockNumber), Valid: true},
-			}
-			deleted, err := app.pgClient.CoreDelete(newCore)
-			if app.LogError(err) != nil {
-				continue
-			}
-			app.logger.Info(fmt.Sprintf("Deleted for %#v: %t", newCore, deleted))
+	}
+	updated, err := r.app.ethClient.GetPastCoresStakeUpdatedEvents(startBlock)
+	if r.app.LogError(err) != nil {
+		return nil, err
+	}
+	for _, core := range updated {
+		if int64(core.Raw.BlockNumber) <= end {
+			events = append(events, coreEvent(types.StakingEventUpdated, core.Sender, core.CoreIp, core.CoreId, core.Raw))
 		}
-
-		highestBlock, err = app.ethClient.HighestBlock()
-		if app.LogError(err) != nil {
-			continue
+	}
+	unstaked, err := r.app.ethClient.GetPastCoresUnstakeEvents(startBlock)
+	if r.app.LogError(err) != nil {
+		return nil, err
+	}
+	for _, core := range unstaked {
+		if int64(core.Raw.BlockNumber) <= end {
+			events = append(events, coreEvent(types.StakingEventUnstaked, core.Sender, core.CoreIp, core.CoreId, core.Raw))
 		}
 	}
+	return events, nil
+}
+
+//Upsert : insert or update the core in staked_cores
+func (r *coreRegistry) Upsert(ev ethsync.Event) (bool, error) {
+	return r.app.pgClient.CoreUpsert(stakedCore(ev))
+}
+
+//Delete : remove the core from staked_cores if the event is more recent than its stake or update
+func (r *coreRegistry) Delete(ev ethsync.Event) (bool, error) {
+	return r.app.pgClient.CoreDelete(stakedCore(ev))
+}
+
+//coreEvent : normalize a core registry event. A Core's Tendermint ID is carried as the event ID
+func coreEvent(eventType string, sender common.Address, coreIP uint32, coreID []byte, raw ethtypes.Log) ethsync.Event {
+	return ethsync.Event{
+		Type:     eventType,
+		EthAddr:  sender.Hex(),
+		PublicIP: util.Int2Ip(coreIP).String(),
+		ID:       hex.EncodeToString(coreID),
+		Log:      raw,
+	}
+}
+
+//stakedCore : the staked_cores row described by a core registry event
+func stakedCore(ev ethsync.Event) types.Core {
+	return types.Core{
+		EthAddr:     ev.EthAddr,
+		PublicIP:    sql.NullString{String: ev.PublicIP, Valid: true},
+		CoreId:      sql.NullString{String: ev.ID, Valid: true},
+		BlockNumber: sql.NullInt64{Int64: int64(ev.Log.BlockNumber), Valid: true},
+	}
 }
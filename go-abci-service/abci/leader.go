@@ -23,7 +23,7 @@ func (app *AnchorApplication) ElectLeader(numLeaders int) (isLeader bool, leader
 	}
 	blockHash := status.SyncInfo.LatestBlockHash.String()
 	app.logger.Info(fmt.Sprintf("Blockhash Seed: %s", blockHash))
-	return determineLeader(numLeaders, status, netInfo, blockHash)
+	return determineLeader(numLeaders, status, netInfo, blockHash, app.penalizedCores(app.state.Height))
 }
 
 // ElectValidator : elect a slice of validators as a leader and return whether we're the leader
@@ -38,12 +38,12 @@ func (app *AnchorApplication) ElectValidator(numLeaders int) (isLeader bool, lea
 	}
 	blockHash := status.SyncInfo.LatestBlockHash.String()
 	app.logger.Info(fmt.Sprintf("Blockhash Seed: %s", blockHash))
-	return determineValidatorLeader(numLeaders, status, validators, blockHash, app.config.FilePV.GetAddress().String())
+	return determineValidatorLeader(numLeaders, status, validators, blockHash, app.config.FilePV.GetAddress().String(), app.penalizedValidators(app.state.Height))
 }
 
-func determineValidatorLeader(numLeaders int, status core_types.ResultStatus, validators core_types.ResultValidators, seed string, address string) (isLeader bool, leaderIDs []string) {
+func determineValidatorLeader(numLeaders int, status core_types.ResultStatus, validators core_types.ResultValidators, seed string, address string, excluded []string) (isLeader bool, leaderIDs []string) {
 	leaders := make([]types.Validator, 0)
-	validatorList := GetSortedValidatorList(validators)
+	validatorList := excludeValidators(GetSortedValidatorList(validators), excluded)
 	validatorLength := len(validatorList)
 	index := util.GetSeededRandInt([]byte(seed), validatorLength)    //seed the first time
 	if err := util.RotateLeft(validatorList[:], index); err != nil { //get a wrapped-around slice of numLeader leaders
@@ -118,11 +118,12 @@ func GetSortedPeerList(status core_types.ResultStatus, netInfo core_types.Result
 	return nodeArray
 }
 
-// determineLeader accepts current node status and a peer array, then finds a leader based on the latest blockhash
-func determineLeader(numLeaders int, status core_types.ResultStatus, netInfo core_types.ResultNetInfo, seed string) (isLeader bool, leaderIDs []string) {
+// determineLeader accepts current node status and a peer array, then finds a leader based on the latest blockhash.
+// Penalized Cores in excluded can't be elected
+func determineLeader(numLeaders int, status core_types.ResultStatus, netInfo core_types.ResultNetInfo, seed string, excluded []string) (isLeader bool, leaderIDs []string) {
 	currentNodeID := status.NodeInfo.ID()
 	if len(netInfo.Peers) > 0 {
-		nodeArray := GetSortedPeerList(status, netInfo)
+		nodeArray := excludePeers(GetSortedPeerList(status, netInfo), excluded)
 		index := util.GetSeededRandInt([]byte(seed), len(nodeArray)) //seed the first time
 		if err := util.RotateLeft(nodeArray[:], index); err != nil { //get a wrapped-around slice of numLeader leaders
 			util.LogError(err)
@@ -146,3 +147,31 @@ func determineLeader(numLeaders int, status core_types.ResultStatus, netInfo cor
 	}
 	return true, []string{string(currentNodeID)}
 }
+
+// excludePeers : removes penalized Cores from a sorted peer list, unless every peer is penalized
+func excludePeers(peers []core_types.Peer, excluded []string) []core_types.Peer {
+	kept := make([]core_types.Peer, 0, len(peers))
+	for _, peer := range peers {
+		if !util.Contains(excluded, string(peer.NodeInfo.ID())) {
+			kept = append(kept, peer)
+		}
+	}
+	if len(kept) == 0 {
+		return peers
+	}
+	return kept
+}
+
+// excludeValidators : removes penalized Cores' validators from a sorted validator list, unless every validator is penalized
+func excludeValidators(validators []types.Validator, excluded []string) []types.Validator {
+	kept := make([]types.Validator, 0, len(validators))
+	for _, validator := range validators {
+		if !util.Contains(excluded, validator.Address.String()) {
+			kept = append(kept, validator)
+		}
+	}
+	if len(kept) == 0 {
+		return validators
+	}
+	return kept
+}
@@ -60,7 +60,7 @@ func TestLeaderElectionLeader(t *testing.T) {
 			},
 		},
 	}
-	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed)
+	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed, nil)
 	// We should be leader
 	if !amILeader || LeaderIDs[0] != "b" {
 		t.Errorf("Expected amILeader=true and LeaderID=b, got amILeader=%t and LeaderID=%s instead\n", amILeader, LeaderIDs[0])
@@ -106,7 +106,7 @@ func TestLeaderElectionNotLeader(t *testing.T) {
 			},
 		},
 	}
-	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed)
+	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed, nil)
 	// We should not be leader
 	if amILeader || LeaderIDs[0] != "b" {
 		t.Errorf("Expected amILeader=false and LeaderID=b, got amILeader=%t and LeaderID=%s instead\n", amILeader, LeaderIDs[0])
@@ -127,7 +127,7 @@ func TestLeaderElectionSingleCore(t *testing.T) {
 	netInfo := core_types.ResultNetInfo{
 		Peers: []core_types.Peer{},
 	}
-	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed)
+	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed, nil)
 	// We're the only node so we should be leader
 	if !amILeader || LeaderIDs[0] != "c" {
 		t.Errorf("Expected amILeader=false and LeaderID=c, got amILeader=%t and LeaderID=%s instead\n", amILeader, LeaderIDs[0])
@@ -173,9 +173,48 @@ func TestLeaderElectionCatchingUp(t *testing.T) {
 			},
 		},
 	}
-	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed)
+	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed, nil)
 	// We're catching up so we shouldn't be leader
 	if amILeader || LeaderIDs[0] != "b" {
 		t.Errorf("Expected amILeader=false and LeaderID=b, got amILeader=%t and LeaderID=%s instead\n", amILeader, LeaderIDs[0])
 	}
 }
+
+func TestLeaderElectionPenalized(t *testing.T) {
+	seed := "3719ADA3EEE198F3A7A33616EA60ED6D72D94D31A2B2422FA12E2BCDDCABD4D4"
+	status := core_types.ResultStatus{
+		NodeInfo: p2p.DefaultNodeInfo{
+			ID_: "b",
+		},
+		SyncInfo: core_types.SyncInfo{
+			CatchingUp: false,
+		},
+		ValidatorInfo: core_types.ValidatorInfo{},
+	}
+	netInfo := core_types.ResultNetInfo{
+		Peers: []core_types.Peer{
+			core_types.Peer{
+				NodeInfo: p2p.DefaultNodeInfo{
+					ID_: "a",
+				},
+				RemoteIP: "127.0.0.1",
+			},
+			core_types.Peer{
+				NodeInfo: p2p.DefaultNodeInfo{
+					ID_: "c",
+				},
+				RemoteIP: "127.0.0.1",
+			},
+		},
+	}
+	amILeader, LeaderIDs := determineLeader(1, status, netInfo, seed, []string{"b"})
+	// We'd be leader, but we're penalized
+	if amILeader || LeaderIDs[0] == "b" {
+		t.Errorf("Expected amILeader=false and LeaderID other than b, got amILeader=%t and LeaderID=%s instead\n", amILeader, LeaderIDs[0])
+	}
+	amILeader, LeaderIDs = determineLeader(1, status, netInfo, seed, []string{"a", "b", "c"})
+	// If every Core is penalized, none are excluded
+	if len(LeaderIDs) != 1 {
+		t.Errorf("Expected a leader when every Core is penalized, got %v\n", LeaderIDs)
+	}
+}
@@ -0,0 +1,311 @@
+package abci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/smt"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+)
+
+//Offenses a MISBEHAVIOR tx can prove. Failing leader duties and sending invalidly signed txs aren't among them: a missed
+//anchor leaves no signed tx to carry as evidence, and anyone can put another Core's ID on a tx with a bad signature
+const (
+	MISBEHAVIOR_CONFLICTING_TXS      = "conflicting_txs"
+	MISBEHAVIOR_FALSE_BTCC           = "false_btcc"
+	MISBEHAVIOR_INVALID_REWARD_EPOCH = "invalid_reward_epoch"
+)
+
+//CORE_PENALTY_BLOCKS : how many Tendermint blocks each proven offense excludes a Core for, about two days
+const CORE_PENALTY_BLOCKS = 2880
+
+//app database key prefix for the txs conflicting txs are detected from
+const signedTxPrefix = "signedtx:"
+
+//conflictKey : a Core may sign only one tx of some types per key: one NODE-AUDIT per round and one BTC-C per Bitcoin tx.
+//REWARD-EPOCHs are excluded, since a mint that failed on Ethereum may be retried with different candidates. Returns false
+//for txs of any other type
+func conflictKey(tx types.Tx) (string, bool) {
+	switch tx.TxType {
+	case "NODE-AUDIT":
+		var audit types.NodeAudit
+		if json.Unmarshal([]byte(tx.Data), &audit) != nil {
+			return "", false
+		}
+		return strconv.FormatInt(audit.Round, 10), true
+	case "BTC-C":
+		meta := strings.Split(tx.Meta, "|")
+		if len(meta) < 2 {
+			return "", false
+		}
+		return meta[1], true
+	}
+	return "", false
+}
+
+//logUnverifiedTx : logs a tx dropped because it failed verification, naming the Core it claims to come from. The Core
+//isn't penalized, since a bad signature doesn't prove that Core sent it
+func (app *AnchorApplication) logUnverifiedTx(rawTx []byte, err error) {
+	if tx, decodeErr := util.DecodeTx(rawTx); decodeErr == nil && tx.CoreID != "" {
+		app.logger.Error(fmt.Sprintf("Dropping %s tx naming Core %s, which failed verification: %s", tx.TxType, tx.CoreID, err.Error()))
+		return
+	}
+	app.LogError(err)
+}
+
+//VerifyMisbehavior : checks that evidence proves its offense: each tx must be signed by the key the accused Core committed
+//to the registry, and they must either conflict, be a BTC-C crediting a different Core than the BTC-A for its Bitcoin tx,
+//or be an inconsistent REWARD-EPOCH. btcaCoreID looks up the Core whose committed BTC-A announced a Bitcoin tx
+func VerifyMisbehavior(evidence types.Misbehavior, registry *smt.SparseMerkleTree, btcaCoreID func(string) (string, bool)) error {
+	txs := make([]types.Tx, len(evidence.Txs))
+	for i, rawTx := range evidence.Txs {
+		tx, err := verifyRegisteredTx(registry, []byte(rawTx))
+		if err != nil {
+			return err
+		}
+		if tx.TxType == "JWK" {
+			return fmt.Errorf("evidence tx %d is a JWK, which isn't signed by a registered key", i)
+		}
+		if tx.CoreID != evidence.CoreID {
+			return fmt.Errorf("evidence tx %d was signed by Core %s, not %s", i, tx.CoreID, evidence.CoreID)
+		}
+		txs[i] = tx
+	}
+	switch evidence.Offense {
+	case MISBEHAVIOR_CONFLICTING_TXS:
+		if len(txs) != 2 {
+			return errors.New("conflicting txs evidence must hold two txs")
+		}
+		key, ok := conflictKey(txs[0])
+		if !ok {
+			return fmt.Errorf("%s txs can't conflict", txs[0].TxType)
+		}
+		if otherKey, _ := conflictKey(txs[1]); txs[1].TxType != txs[0].TxType || otherKey != key {
+			return errors.New("evidence txs aren't for the same round or Bitcoin tx")
+		}
+		if !conflicting(txs[0], txs[1]) {
+			return errors.New("evidence txs don't conflict")
+		}
+	case MISBEHAVIOR_FALSE_BTCC:
+		if len(txs) != 1 || txs[0].TxType != "BTC-C" {
+			return errors.New("false BTC-C evidence must hold one BTC-C tx")
+		}
+		if !creditsWrongCore(txs[0], btcaCoreID) {
+			return errors.New("BTC-C matches its BTC-A")
+		}
+	case MISBEHAVIOR_INVALID_REWARD_EPOCH:
+		if len(txs) != 1 || txs[0].TxType != "REWARD-EPOCH" {
+			return errors.New("invalid reward epoch evidence must hold one REWARD-EPOCH tx")
+		}
+		if !invalidRewardEpoch(txs[0]) {
+			return errors.New("reward epoch is valid")
+		}
+	default:
+		return fmt.Errorf("unknown offense %s", evidence.Offense)
+	}
+	return nil
+}
+
+//conflicting : whether two txs sharing a conflict key say different things. Txs that differ only in time are rebroadcasts
+func conflicting(a types.Tx, b types.Tx) bool {
+	return a.Data != b.Data || a.Meta != b.Meta
+}
+
+//creditsWrongCore : whether a BTC-C credits its anchor to a Core other than the one whose committed BTC-A announced its
+//Bitcoin tx. BTC-Cs for Bitcoin txs with no known BTC-A aren't judged
+func creditsWrongCore(tx types.Tx, btcaCoreID func(string) (string, bool)) bool {
+	meta := strings.Split(tx.Meta, "|")
+	if len(meta) < 2 {
+		return false
+	}
+	coreID, found := btcaCoreID(meta[1])
+	return found && coreID != meta[0]
+}
+
+//invalidRewardEpoch : whether a REWARD-EPOCH tx holds a record that isn't internally consistent
+func invalidRewardEpoch(tx types.Tx) bool {
+	var record types.RewardEpoch
+	return json.Unmarshal([]byte(tx.Data), &record) != nil || VerifyRewardEpoch(record) != nil
+}
+
+//misbehaviorHash : identifies evidence regardless of the order its txs were presented in, so it's only penalized once
+func misbehaviorHash(evidence types.Misbehavior) string {
+	txs := append([]string{}, evidence.Txs...)
+	sort.Strings(txs)
+	hash := sha256.Sum256([]byte(evidence.Offense + "|" + evidence.CoreID + "|" + strings.Join(txs, "|")))
+	return hex.EncodeToString(hash[:])
+}
+
+//applyPenalty : extends a Core's penalty by CORE_PENALTY_BLOCKS for evidence committed at height, starting a new penalty
+//period if the last has expired. Returns false if the evidence was already penalized
+func applyPenalty(penalty types.CorePenalty, evidence types.Misbehavior, height int64) (types.CorePenalty, bool) {
+	evidenceHash := misbehaviorHash(evidence)
+	if util.Contains(penalty.Evidence, evidenceHash) {
+		return penalty, false
+	}
+	if penalty.Until <= height {
+		penalty.Height, penalty.Until = height, height
+	}
+	penalty.CoreID = evidence.CoreID
+	penalty.Offense = evidence.Offense
+	penalty.Until += CORE_PENALTY_BLOCKS
+	penalty.Evidence = append(penalty.Evidence, evidenceHash)
+	return penalty, true
+}
+
+//penalizedAt : whether a penalty excludes its Core from leader election at a height
+func penalizedAt(penalty types.CorePenalty, height int64) bool {
+	return penalty.Height <= height && height < penalty.Until
+}
+
+//corePenalty : a Core's entry in a registry's penalty ledger, if it has one
+func corePenalty(registry *smt.SparseMerkleTree, coreID string) (types.CorePenalty, bool, error) {
+	value, err := registry.Get([]byte(registryPenaltyPrefix + coreID))
+	if err != nil || value == nil {
+		return types.CorePenalty{}, false, err
+	}
+	var penalty types.CorePenalty
+	if err := json.Unmarshal(value, &penalty); err != nil {
+		return types.CorePenalty{}, false, err
+	}
+	return penalty, true, nil
+}
+
+//penalizedCoreIDs : every Core with an entry in a registry's penalty ledger, sorted
+func penalizedCoreIDs(registry *smt.SparseMerkleTree) ([]string, error) {
+	coreIDs := make([]string, 0)
+	value, err := registry.Get([]byte(registryPenaltyIndexKey))
+	if err != nil || value == nil {
+		return coreIDs, err
+	}
+	err = json.Unmarshal(value, &coreIDs)
+	return coreIDs, err
+}
+
+//penalizeCore : verifies a MISBEHAVIOR tx's evidence and records the penalty in the registry, so it's part of the app hash
+func (app *AnchorApplication) penalizeCore(evidence types.Misbehavior) error {
+	if !app.activated(app.state.ChainParams.RegistryHeight) {
+		return errors.New("misbehavior can't be penalized until the registry is active")
+	}
+	if err := VerifyMisbehavior(evidence, app.registry, registryBtcaCoreID(app.registry)); err != nil {
+		return err
+	}
+	penalty, found, err := corePenalty(app.registry, evidence.CoreID)
+	if err != nil {
+		return err
+	}
+	penalty, applied := applyPenalty(penalty, evidence, app.state.Height)
+	if !applied {
+		return nil
+	}
+	penaltyJSON, err := json.Marshal(penalty)
+	if err != nil {
+		return err
+	}
+	if _, err := app.registry.Update([]byte(registryPenaltyPrefix+evidence.CoreID), penaltyJSON); err != nil {
+		return err
+	}
+	if !found {
+		coreIDs, err := penalizedCoreIDs(app.registry)
+		if err != nil {
+			return err
+		}
+		coreIDs = append(coreIDs, evidence.CoreID)
+		sort.Strings(coreIDs)
+		indexJSON, err := json.Marshal(coreIDs)
+		if err != nil {
+			return err
+		}
+		if _, err := app.registry.Update([]byte(registryPenaltyIndexKey), indexJSON); err != nil {
+			return err
+		}
+	}
+	app.logger.Error(fmt.Sprintf("Core %s penalized for %s until block %d", evidence.CoreID, evidence.Offense, penalty.Until))
+	return nil
+}
+
+//penalizedCores : the Cores whose committed penalty excludes them from leader election at height
+func (app *AnchorApplication) penalizedCores(height int64) []string {
+	penalized := make([]string, 0)
+	registry := app.committedRegistry()
+	coreIDs, err := penalizedCoreIDs(registry)
+	if app.LogError(err) != nil {
+		return penalized
+	}
+	for _, coreID := range coreIDs {
+		penalty, found, err := corePenalty(registry, coreID)
+		if app.LogError(err) == nil && found && penalizedAt(penalty, height) {
+			penalized = append(penalized, coreID)
+		}
+	}
+	return penalized
+}
+
+//penalizedValidators : the validator addresses of penalized Cores, for those that registered one with their JWK
+func (app *AnchorApplication) penalizedValidators(height int64) []string {
+	validators := make([]string, 0)
+	registry := app.committedRegistry()
+	for _, coreID := range app.penalizedCores(height) {
+		address, err := registry.Get([]byte(registryCoreValidatorPrefix + coreID))
+		if app.LogError(err) == nil && address != nil {
+			validators = append(validators, string(address))
+		}
+	}
+	return validators
+}
+
+//btcaCoreID : the Core whose committed BTC-A announced a Bitcoin tx
+func (app *AnchorApplication) btcaCoreID(btcTxID string) (string, bool) {
+	return registryBtcaCoreID(app.committedRegistry())(btcTxID)
+}
+
+//detectMisbehavior : checks a delivered tx against earlier txs from its Core and reports any offense it proves
+func (app *AnchorApplication) detectMisbehavior(rawTx []byte, tx types.Tx) {
+	evidence := make([]types.Misbehavior, 0)
+	if key, ok := conflictKey(tx); ok {
+		dbKey := []byte(fmt.Sprintf("%s%s|%s|%s", signedTxPrefix, tx.CoreID, tx.TxType, key))
+		if firstRawTx := app.Db.Get(dbKey); firstRawTx == nil {
+			app.Db.Set(dbKey, rawTx)
+		} else if firstTx, err := util.DecodeTx(firstRawTx); app.LogError(err) == nil && conflicting(firstTx, tx) {
+			evidence = append(evidence, types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: tx.CoreID, Txs: []string{string(firstRawTx), string(rawTx)}})
+		}
+	}
+	if tx.TxType == "BTC-C" && creditsWrongCore(tx, registryBtcaCoreID(app.registry)) {
+		evidence = append(evidence, types.Misbehavior{Offense: MISBEHAVIOR_FALSE_BTCC, CoreID: tx.CoreID, Txs: []string{string(rawTx)}})
+	}
+	if tx.TxType == "REWARD-EPOCH" && invalidRewardEpoch(tx) {
+		evidence = append(evidence, types.Misbehavior{Offense: MISBEHAVIOR_INVALID_REWARD_EPOCH, CoreID: tx.CoreID, Txs: []string{string(rawTx)}})
+	}
+	if !app.state.ChainSynced || tx.CoreID == app.ID {
+		return
+	}
+	for _, e := range evidence {
+		go app.ReportMisbehavior(e)
+	}
+}
+
+//ReportMisbehavior : commits evidence of a Core's misbehavior to chain in a MISBEHAVIOR tx, unless it's already been penalized
+func (app *AnchorApplication) ReportMisbehavior(evidence types.Misbehavior) error {
+	penalty, _, err := corePenalty(app.committedRegistry(), evidence.CoreID)
+	if app.LogError(err) != nil {
+		return err
+	}
+	if util.Contains(penalty.Evidence, misbehaviorHash(evidence)) {
+		return nil
+	}
+	app.logger.Error(fmt.Sprintf("Core %s misbehaved: %s", evidence.CoreID, evidence.Offense))
+	evidenceJSON, err := json.Marshal(evidence)
+	if app.LogError(err) != nil {
+		return err
+	}
+	_, err = app.rpc.BroadcastTx("MISBEHAVIOR", string(evidenceJSON), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
+	return app.LogError(err)
+}
@@ -0,0 +1,133 @@
+package abci
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chainpoint/tendermint/crypto/ed25519"
+	dbm "github.com/chainpoint/tendermint/libs/db"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/smt"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+)
+
+func TestVerifyMisbehavior(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	registry := smt.NewSparseMerkleTree(dbm.NewMemDB(), registryPrefix, nil)
+	registry.Update([]byte(registryCoreKeyPrefix+"A"), elliptic.Marshal(elliptic.P256(), key.X, key.Y))
+	registry.Update([]byte(registryCoreKeyPrefix+"B"), elliptic.Marshal(elliptic.P256(), otherKey.X, otherKey.Y))
+	btcaCoreID := func(btcTxID string) (string, bool) {
+		return "B", btcTxID == "btctx1"
+	}
+	signed := func(tx types.Tx, key *ecdsa.PrivateKey) string {
+		return util.EncodeTxWithKey(tx, key)
+	}
+	audit := func(round int64, passed bool) string {
+		auditJSON, _ := json.Marshal(types.NodeAudit{Round: round, Results: []types.NodeAuditResult{{EthAddr: "0x1", Passed: passed}}})
+		return string(auditJSON)
+	}
+
+	passed := signed(types.Tx{TxType: "NODE-AUDIT", Data: audit(7, true), Time: 1, CoreID: "A"}, key)
+	failed := signed(types.Tx{TxType: "NODE-AUDIT", Data: audit(7, false), Time: 2, CoreID: "A"}, key)
+	evidence := types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: "A", Txs: []string{passed, failed}}
+	assert.NoError(VerifyMisbehavior(evidence, registry, btcaCoreID))
+
+	rebroadcast := signed(types.Tx{TxType: "NODE-AUDIT", Data: audit(7, true), Time: 3, CoreID: "A"}, key)
+	assert.Error(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: "A", Txs: []string{passed, rebroadcast}}, registry, btcaCoreID),
+		"rebroadcasting a tx isn't misbehavior")
+	nextRound := signed(types.Tx{TxType: "NODE-AUDIT", Data: audit(8, false), Time: 3, CoreID: "A"}, key)
+	assert.Error(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: "A", Txs: []string{passed, nextRound}}, registry, btcaCoreID))
+	forged := signed(types.Tx{TxType: "NODE-AUDIT", Data: audit(7, false), Time: 2, CoreID: "A"}, otherKey)
+	assert.Error(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: "A", Txs: []string{passed, forged}}, registry, btcaCoreID),
+		"evidence must be signed by the accused Core")
+	assert.Error(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: "B", Txs: []string{passed, failed}}, registry, btcaCoreID))
+	unregistered := signed(types.Tx{TxType: "NODE-AUDIT", Data: audit(7, true), Time: 1, CoreID: "C"}, key)
+	assert.Error(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: "C", Txs: []string{unregistered, unregistered}}, registry, btcaCoreID),
+		"evidence must be signed by a key committed to the registry")
+
+	stolen := signed(types.Tx{TxType: "BTC-C", Data: "root", Time: 1, CoreID: "A", Meta: "A|btctx1"}, key)
+	assert.NoError(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_FALSE_BTCC, CoreID: "A", Txs: []string{stolen}}, registry, btcaCoreID))
+	honest := signed(types.Tx{TxType: "BTC-C", Data: "root", Time: 1, CoreID: "A", Meta: "B|btctx1"}, key)
+	assert.Error(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_FALSE_BTCC, CoreID: "A", Txs: []string{honest}}, registry, btcaCoreID))
+	unknown := signed(types.Tx{TxType: "BTC-C", Data: "root", Time: 1, CoreID: "A", Meta: "A|btctx2"}, key)
+	assert.Error(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_FALSE_BTCC, CoreID: "A", Txs: []string{unknown}}, registry, btcaCoreID),
+		"BTC-Cs without a known BTC-A aren't judged")
+
+	badEpoch, _ := json.Marshal(types.RewardEpoch{Kind: types.StakingKindCore, Epoch: 1, RewardHash: "00"})
+	rewardEpoch := signed(types.Tx{TxType: "REWARD-EPOCH", Data: string(badEpoch), Time: 1, CoreID: "A"}, key)
+	assert.NoError(VerifyMisbehavior(types.Misbehavior{Offense: MISBEHAVIOR_INVALID_REWARD_EPOCH, CoreID: "A", Txs: []string{rewardEpoch}}, registry, btcaCoreID))
+}
+
+func TestApplyPenalty(t *testing.T) {
+	assert := assert.New(t)
+	first := types.Misbehavior{Offense: MISBEHAVIOR_FALSE_BTCC, CoreID: "A", Txs: []string{"tx1"}}
+	penalty, applied := applyPenalty(types.CorePenalty{}, first, 100)
+	assert.True(applied)
+	assert.Equal(int64(100), penalty.Height)
+	assert.Equal(int64(100+CORE_PENALTY_BLOCKS), penalty.Until)
+	assert.True(penalizedAt(penalty, 100))
+	assert.False(penalizedAt(penalty, 100+CORE_PENALTY_BLOCKS))
+
+	_, applied = applyPenalty(penalty, first, 200)
+	assert.False(applied, "the same evidence is only penalized once")
+
+	reordered := types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: "A", Txs: []string{"tx3", "tx2"}}
+	penalty, applied = applyPenalty(penalty, types.Misbehavior{Offense: MISBEHAVIOR_CONFLICTING_TXS, CoreID: "A", Txs: []string{"tx2", "tx3"}}, 200)
+	assert.True(applied)
+	assert.Equal(int64(100), penalty.Height, "an offense during a penalty extends it")
+	assert.Equal(int64(100+2*CORE_PENALTY_BLOCKS), penalty.Until)
+	_, applied = applyPenalty(penalty, reordered, 300)
+	assert.False(applied, "evidence is identified regardless of tx order")
+
+	later := types.Misbehavior{Offense: MISBEHAVIOR_FALSE_BTCC, CoreID: "A", Txs: []string{"tx4"}}
+	penalty, _ = applyPenalty(penalty, later, 10000)
+	assert.Equal(int64(10000), penalty.Height, "an offense after a penalty expires starts a new one")
+	assert.Equal(int64(10000+CORE_PENALTY_BLOCKS), penalty.Until)
+	assert.False(penalizedAt(penalty, 9999))
+}
+
+func TestValidatorBinding(t *testing.T) {
+	assert := assert.New(t)
+	privKey := ed25519.GenPrivKey()
+	binding, err := signValidatorBinding(privKey, "A", "jwk")
+	assert.NoError(err)
+	address, err := verifyValidatorBinding(types.Tx{TxType: "JWK", Data: "jwk", CoreID: "A", Meta: binding})
+	assert.NoError(err)
+	assert.Equal(privKey.PubKey().Address().String(), address)
+
+	_, err = verifyValidatorBinding(types.Tx{TxType: "JWK", Data: "jwk", CoreID: "B", Meta: binding})
+	assert.Error(err, "a binding can't be replayed for another Core")
+	_, err = verifyValidatorBinding(types.Tx{TxType: "JWK", Data: "other", CoreID: "A", Meta: binding})
+	assert.Error(err, "a binding can't be replayed for another key")
+	_, err = verifyValidatorBinding(types.Tx{TxType: "JWK", Data: "jwk", CoreID: "A", Meta: address})
+	assert.Error(err, "an unsigned validator address isn't accepted")
+
+	_, bound, err := verifyAddressBinding(types.Tx{TxType: "JWK", Data: "jwk", CoreID: "A", Meta: binding})
+	assert.NoError(err)
+	assert.False(bound, "a binding may leave out the Ethereum address")
+	ethKey, _ := ethcrypto.GenerateKey()
+	addressBinding, err := signAddressBinding(ethKey, "A", "jwk")
+	assert.NoError(err)
+	meta := binding + "|" + addressBinding
+	_, err = verifyValidatorBinding(types.Tx{TxType: "JWK", Data: "jwk", CoreID: "A", Meta: meta})
+	assert.NoError(err)
+	ethAddress, bound, err := verifyAddressBinding(types.Tx{TxType: "JWK", Data: "jwk", CoreID: "A", Meta: meta})
+	assert.NoError(err)
+	assert.True(bound)
+	assert.Equal(ethcrypto.PubkeyToAddress(ethKey.PublicKey), ethAddress)
+	_, _, err = verifyAddressBinding(types.Tx{TxType: "JWK", Data: "jwk", CoreID: "B", Meta: meta})
+	assert.Error(err, "an address binding can't be replayed for another Core")
+	otherKey, _ := ethcrypto.GenerateKey()
+	claimed := binding + "|" + ethcrypto.PubkeyToAddress(otherKey.PublicKey).Hex() + "|" + strings.Split(addressBinding, "|")[1]
+	_, _, err = verifyAddressBinding(types.Tx{TxType: "JWK", Data: "jwk", CoreID: "A", Meta: claimed})
+	assert.Error(err, "a Core can't bind an address it doesn't hold the key for")
+}
@@ -7,10 +7,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	beacon "github.com/chainpoint/go-nist-beacon"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 
+	"github.com/chp-project/chainpoint-core/go-abci-service/ethtx"
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
 )
 
@@ -53,12 +57,7 @@ func (app *AnchorApplication) KeyMonitor() {
 			continue
 		}
 		app.JWK = apiStatus.Jwk
-		jwkJson, err := json.Marshal(apiStatus.Jwk)
-		if app.LogError(err) != nil {
-			continue
-		}
-		_, err = app.rpc.BroadcastTxCommit("JWK", string(jwkJson), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
-		if app.LogError(err) != nil {
+		if app.LogError(app.broadcastJWK()) != nil {
 			continue
 		} else {
 			return
@@ -67,6 +66,66 @@ func (app *AnchorApplication) KeyMonitor() {
 	panic(errors.New("Cannot broadcast Core public key"))
 }
 
+//broadcastJWK : commits our public key to the chain, signed with its private key
+func (app *AnchorApplication) broadcastJWK() error {
+	jwkJson, err := json.Marshal(app.JWK)
+	if err != nil {
+		return err
+	}
+	// register our validator alongside our key, so we can be excluded from validator elections if penalized
+	validatorBinding, err := signValidatorBinding(app.config.FilePV.Key.PrivKey, app.ID, string(jwkJson))
+	if err != nil {
+		return err
+	}
+	// and the Ethereum account we stake with, so our work can be checked against Core reward records
+	if ethKey, err := crypto.HexToECDSA(app.config.EthConfig.EthPrivateKey); err == nil {
+		addressBinding, err := signAddressBinding(ethKey, app.ID, string(jwkJson))
+		if err != nil {
+			return err
+		}
+		validatorBinding += "|" + addressBinding
+	}
+	_, err = app.rpc.BroadcastTxCommitWithMeta("JWK", string(jwkJson), 2, time.Now().Unix(), app.ID, validatorBinding, &app.config.ECPrivateKey)
+	return err
+}
+
+//RegistryKeyMonitor : makes sure our key and Ethereum address are committed to the registry once it's active. JWKs delivered
+//before the registry's activation height, or without an address binding, weren't fully registered, so Cores that sent them re-broadcast theirs
+func (app *AnchorApplication) RegistryKeyMonitor() {
+	for {
+		time.Sleep(1 * time.Minute)
+		if !app.state.ChainSynced || app.ID == "" || app.JWK.Kid == "" || !app.activated(app.state.ChainParams.RegistryHeight) {
+			continue
+		}
+		pubKey, err := registryCoreKey(app.committedRegistry(), app.ID)
+		if app.LogError(err) != nil {
+			continue
+		}
+		if pubKey != nil {
+			if pubKey.X.Cmp(app.config.ECPrivateKey.X) != 0 || pubKey.Y.Cmp(app.config.ECPrivateKey.Y) != 0 {
+				app.logger.Error(fmt.Sprintf("Registry holds a different key for our Core ID %s", app.ID))
+				return
+			}
+			if app.addressRegistered() {
+				return
+			}
+		}
+		app.logger.Info("Registry: re-broadcasting our JWK")
+		app.LogError(app.broadcastJWK())
+	}
+}
+
+//addressRegistered : whether the registry binds our Core to the Ethereum account we stake with. Cores without an Ethereum
+//key have nothing to bind
+func (app *AnchorApplication) addressRegistered() bool {
+	ethKey, err := crypto.HexToECDSA(app.config.EthConfig.EthPrivateKey)
+	if err != nil {
+		return true
+	}
+	address, bound, err := registryCoreAddress(app.committedRegistry(), app.ID)
+	return app.LogError(err) != nil || (bound && address == crypto.PubkeyToAddress(ethKey.PublicKey))
+}
+
 // NistBeaconMonitor : elects a leader to poll and gossip NIST. Called every minute by ABCI.commit
 func (app *AnchorApplication) NistBeaconMonitor() {
 	time.Sleep(15 * time.Second) //sleep after commit for a few seconds
@@ -84,32 +143,53 @@ func (app *AnchorApplication) NistBeaconMonitor() {
 	}
 }
 
-//MintMonitor : efficiently monitor for new minting and gossip that block to other cores
-func (app *AnchorApplication) MintMonitor() {
-	if leader, _ := app.ElectValidator(1); leader && app.state.ChainSynced {
-		lastNodeMintedAt, err := app.ethClient.GetNodeLastMintedAt()
-		if app.LogError(err) != nil {
-			app.logger.Error("Unable to obtain new NodeLastMintedAt value")
-			return
-		}
-		if lastNodeMintedAt.Int64() != 0 && lastNodeMintedAt.Int64() >= app.state.LastNodeMintedAtBlock+MINT_EPOCH {
-			app.logger.Info("Mint success, sending Node MINT tx")
-			_, err = app.rpc.BroadcastTx("NODE-MINT", strconv.FormatInt(lastNodeMintedAt.Int64(), 10), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey) // elect a leader to send a NIST tx
-			if err != nil {
-				app.logger.Debug("Failed to gossip Node MINT for LastNodeMintedAtBlock gossip")
-			}
-		}
-		lastCoreMintedAt, err := app.ethClient.GetCoreLastMintedAt()
-		if app.LogError(err) != nil {
-			app.logger.Error("Unable to obtain new CoreLastMintedAt value")
-			return
+//MintMonitor : waits for a mint call's receipt to be confirmed, then commits the mint's REWARD-EPOCH record and gossips the
+//block its mint event was emitted in, which the token contract records as the last minted-at block, to other cores.
+//The tx type to gossip, NODE-MINT or CORE-MINT, prefixes the mint's ethtx ID
+func (app *AnchorApplication) MintMonitor(id string) {
+	tx := <-app.ethTx.Await(id)
+	txType := strings.SplitN(id, ":", 2)[0]
+	record, recorded := app.takeMintRewardEpoch(id)
+	if tx.Status != ethtx.StatusConfirmed {
+		app.logger.Error(fmt.Sprintf("Mint failure: %s %s %s", id, tx.Hash, tx.Error))
+		return
+	}
+	if !recorded {
+		app.logger.Error(fmt.Sprintf("Mint: no REWARD-EPOCH record kept for %s", id))
+	} else if err := app.BroadcastRewardEpoch(record); err != nil {
+		app.logger.Error(fmt.Sprintf("Mint: Error issuing REWARD-EPOCH tx for %s", id))
+	}
+	lastMintedAt, found := mintEventBlock(tx, common.HexToAddress(app.config.EthConfig.TokenContractAddr))
+	if !found {
+		app.logger.Error(fmt.Sprintf("Mint failure: %s %s emitted no token contract event to read its block from", id, tx.MinedHash))
+		return
+	}
+	app.logger.Info(fmt.Sprintf("Mint success, sending %s tx for block %d", txType, lastMintedAt))
+	_, err := app.rpc.BroadcastTx(txType, strconv.FormatInt(lastMintedAt, 10), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
+	if err != nil {
+		app.logger.Debug(fmt.Sprintf("Failed to gossip %s for block %d", txType, lastMintedAt))
+	}
+}
+
+//mintEventBlock : the block a mint's receipt says the token contract emitted its events in
+func mintEventBlock(tx ethtx.Tx, contract common.Address) (int64, bool) {
+	for _, event := range tx.Events {
+		if common.HexToAddress(event.Address) == contract {
+			return event.BlockNumber, true
 		}
-		if lastCoreMintedAt.Int64() != 0 && lastCoreMintedAt.Int64() >= app.state.LastCoreMintedAtBlock+MINT_EPOCH {
-			app.logger.Info("Mint success, sending Core MINT tx")
-			_, err = app.rpc.BroadcastTx("CORE-MINT", strconv.FormatInt(lastCoreMintedAt.Int64(), 10), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey) // elect a leader to send a NIST tx
-			if err != nil {
-				app.logger.Debug("Failed to gossip Core MINT for LastNodeMintedAtBlock gossip")
-			}
+	}
+	return 0, false
+}
+
+//ResumeMintMonitors : monitors mint calls still awaiting confirmation from before a restart
+func (app *AnchorApplication) ResumeMintMonitors() {
+	txs, err := app.ethTx.Pending()
+	if app.LogError(err) != nil {
+		return
+	}
+	for _, tx := range txs {
+		if strings.HasPrefix(tx.ID, "NODE-MINT:") || strings.HasPrefix(tx.ID, "CORE-MINT:") {
+			go app.MintMonitor(tx.ID)
 		}
 	}
 }
@@ -2,6 +2,7 @@ package abci
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/sha256"
@@ -16,20 +17,23 @@ import (
 	"math/big"
 	"net"
 	"net/http"
-	"sort"
 	"strings"
-	"sync"
 	"time"
 
+	core_types "github.com/chainpoint/tendermint/rpc/core/types"
 	"github.com/go-redis/redis"
 
 	"github.com/chp-project/chainpoint-core/go-abci-service/ethcontracts"
+	"github.com/chp-project/chainpoint-core/go-abci-service/ethsync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 
 	"github.com/ethereum/go-ethereum/crypto"
 
+	"github.com/chp-project/chainpoint-core/go-abci-service/nodeaudit"
+	"github.com/chp-project/chainpoint-core/go-abci-service/rewardsig"
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
 )
@@ -111,33 +115,37 @@ func (app *AnchorApplication) SaveJWK(tx types.Tx) error {
 	return nil
 }
 
-//MintNodeReward : mint rewards for nodes
-func (app *AnchorApplication) MintNodeReward(sig []string, rewardCandidates []common.Address, rewardHash []byte) error {
+//MintNodeReward : mint rewards for a node reward epoch, committing its REWARD-EPOCH record once the mint is confirmed
+func (app *AnchorApplication) MintNodeReward(sigs [][]byte, record types.RewardEpoch, rewardHash []byte) error {
 	leader, ids := app.ElectValidator(1)
 	if len(ids) == 1 {
 		app.state.LastMintCoreID = ids[0]
 	}
 	if leader {
 		app.logger.Info("Mint: Elected Leader for Minting")
-		if len(sig) > 6 {
-			sig = sig[0:6]
-		}
-		app.logger.Info(fmt.Sprintf("Mint Signatures: %v\nReward Candidates: %v\nReward Hash: %x\n", sig, rewardCandidates, rewardHash))
-		sigBytes := make([][]byte, len(sig))
-		for i, sigStr := range sig {
-			decodedSig, err := hex.DecodeString(sigStr)
-			if app.LogError(err) != nil {
-				app.logger.Info("Mint Error: mint hex decoding failed")
-				continue
-			}
-			sigBytes[i] = decodedSig
+		rewardCandidates := rewardEpochAddresses(record)
+		app.logger.Info(fmt.Sprintf("Mint Signatures: %d\nReward Candidates: %v\nReward Hash: %x\n", len(sigs), rewardCandidates, rewardHash))
+		encodedSigs, err := rewardsig.Encode(sigs, app.config.EthConfig.NodeMintSigSlots)
+		if app.LogError(err) != nil {
+			app.logger.Info("Mint Error: signatures don't fit the mint call")
+			return err
+		}
+		data, err := packMintCall(app.tokenABI, MINT_NODES_METHOD, rewardCandidates, rewardHash, encodedSigs)
+		if app.LogError(err) != nil {
+			app.logger.Info("Mint Error: encoding smart contract call failed")
+			return err
+		}
+		id := fmt.Sprintf("NODE-MINT:%d", app.state.LastNodeMintedAtBlock)
+		if err := app.putMintRewardEpoch(id, record); app.LogError(err) != nil {
+			return err
 		}
-		err := app.ethClient.MintNodes(rewardCandidates, rewardHash, sigBytes)
+		tx, err := app.ethTx.Send(id, common.HexToAddress(app.config.EthConfig.TokenContractAddr), data, 0)
 		if app.LogError(err) != nil {
 			app.logger.Info("Mint Error: invoking smart contract failed")
 			return err
 		}
-		app.logger.Info("Mint process complete")
+		app.logger.Info(fmt.Sprintf("Mint tx %s sent, awaiting confirmation", tx.Hash))
+		go app.MintMonitor(tx.ID)
 	}
 	return nil
 }
@@ -153,264 +161,198 @@ func (app *AnchorApplication) StartNodeMintProcess() error {
 	return nil
 }
 
-//SetNodeMintPendingState : create a deferable method to set mint state
+//SetNodeMintPendingState : create a deferable method to set mint state. Signatures are dropped once minting ends;
+//ones arriving beforehand are held until the reward hash is known
 func (app *AnchorApplication) SetNodeMintPendingState(val bool) {
 	app.state.NodeMintPending = val
-	app.NodeRewardSignatures = make([]string, 0)
+	if !val {
+		app.NodeRewardSignatures.Clear()
+	}
 }
 
-//CollectRewardNodes : collate and sign reward node list
+//SignNodeRewards : collate the reward node list, sign it if elected, and mint once enough verified signatures are collected.
+//Every Core computes the reward hash so that it can verify the signatures it receives
 func (app *AnchorApplication) SignNodeRewards() error {
-	var candidates []common.Address
-	var rewardHash []byte
+	currentEthBlock, err := app.ethClient.HighestBlock()
+	if app.LogError(err) != nil {
+		app.logger.Error("Mint Error: problem retrieving highest block")
+		return err
+	}
+	if currentEthBlock.Int64()-app.state.LastNodeMintedAtBlock < MINT_EPOCH {
+		app.logger.Info("Mint: Too soon for minting")
+		return errors.New("Too soon for minting")
+	}
+	record, err := app.GetNodeRewardCandidates()
+	if app.LogError(err) != nil {
+		app.logger.Info("Mint Error: Error retrieving node reward candidates")
+		return err
+	}
+	app.logger.Info(fmt.Sprintf("Mint: raw SHA3 hash: %s", record.RewardHash))
+	rewardHash, err := hex.DecodeString(record.RewardHash)
+	if app.LogError(err) != nil {
+		return err
+	}
+	rewardHash = signHash(rewardHash)
+	app.logger.Info(fmt.Sprintf("Mint: with prefix: %x", rewardHash))
+	app.NodeRewardSignatures.Reset(rewardHash)
 
-	//Lock the minting process
-	if leader, leaders := app.ElectValidator(7); leader {
+	if leader, leaders := app.ElectValidator(NODE_MINT_SIGNERS); leader {
 		app.logger.Info(fmt.Sprintf("Elected Leaders for Mint Signing: %v", leaders))
-		currentEthBlock, err := app.ethClient.HighestBlock()
-		if app.LogError(err) != nil {
-			app.logger.Error("Mint Error: problem retrieving highest block")
-			return err
-		}
-		if currentEthBlock.Int64()-app.state.LastNodeMintedAtBlock < MINT_EPOCH {
-			app.logger.Info("Mint: Too soon for minting")
-			return errors.New("Too soon for minting")
-		}
-		candidates, rewardHash, err = app.GetNodeRewardCandidates()
-		if app.LogError(err) != nil {
-			app.logger.Info("Mint Error: Error retrieving node reward candidates")
-			return err
-		}
-		app.logger.Info(fmt.Sprintf("Mint: raw SHA3 hash: %x", rewardHash))
-		rewardHash = signHash(rewardHash)
-		app.logger.Info(fmt.Sprintf("Mint: with prefix: %x", rewardHash))
 		signature, err := ethcontracts.SignMsg(rewardHash, app.ethClient.EthPrivateKey)
-		signature[64] += 27
 		if app.LogError(err) != nil {
 			app.logger.Info("Mint Error: Problem with signing message for minting")
 			return err
 		}
+		signature[64] += 27
 		_, err = app.rpc.BroadcastTx("NODE-SIGN", hex.EncodeToString(signature), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
 		if err != nil {
 			app.logger.Info("Mint Error: Error issuing SIGN tx")
 			return err
 		}
 	}
-	// wait for 6 SIGN tx
-	deadline := time.Now().Add(4 * time.Minute)
-	for len(app.NodeRewardSignatures) < 6 && !time.Now().After(deadline) {
-		time.Sleep(10 * time.Second)
-	}
-	// Mint if 6+ SIGN txs are received
-	if len(app.NodeRewardSignatures) >= 6 {
-		app.logger.Info("Mint: Enough SIGN TXs received, calling mint")
-		err := app.MintNodeReward(app.NodeRewardSignatures, candidates, rewardHash)
-		if app.LogError(err) != nil {
-			return err
-		}
-	} else {
+	// wait for enough verified SIGN txs, then mint with every one collected
+	if !app.NodeRewardSignatures.Wait(NODE_MINT_THRESHOLD, 4*time.Minute) {
 		app.logger.Info("Mint: Not enough SIGN TXs")
 		return errors.New("Mint: Not enough SIGN TXs")
 	}
-	return nil
+	app.logger.Info("Mint: Enough SIGN TXs received, calling mint")
+	return app.LogError(app.MintNodeReward(app.NodeRewardSignatures.Signatures(), record, rewardHash))
 }
 
-//GetNodeRewardCandidates : scans for and collates the reward candidates in the current epoch
-func (app *AnchorApplication) GetNodeRewardCandidates() ([]common.Address, []byte, error) {
-	txResult, err := app.rpc.client.TxSearch(fmt.Sprintf("NODERC=%d", app.state.LastNodeMintedAtBlock), false, 1, 25)
-	if app.LogError(err) != nil {
-		return []common.Address{}, []byte{}, err
-	}
-	nodeArray := make([]common.Address, 0)
-	for _, tx := range txResult.Txs {
-		decoded, err := util.DecodeTx(tx.Tx)
-		if err != nil {
-			continue
-		}
-		var nodes []types.NodeJSON
-		if err := json.Unmarshal([]byte(decoded.Data), &nodes); err != nil {
-			return []common.Address{}, []byte{}, err
-		}
-		for _, nodeJSON := range nodes {
-			app.logger.Info(fmt.Sprintf("Mint: Decoded NODE-RC: %#v", nodeJSON))
-			nodeArray = append(nodeArray, common.HexToAddress(nodeJSON.EthAddr))
-		}
+//GetNodeRewardCandidates : collates the current epoch's reward candidates from its NODE-AUDIT txs. A node qualifies once a quorum
+//of a round's assigned auditors passed it, and the agreeing NODE-AUDIT txs are its evidence
+func (app *AnchorApplication) GetNodeRewardCandidates() (types.RewardEpoch, error) {
+	epoch := app.state.LastNodeMintedAtBlock
+	evidence, err := app.nodeRewardEvidence(epoch)
+	if err != nil {
+		return types.RewardEpoch{}, err
 	}
-	if len(nodeArray) == 0 {
-		return []common.Address{}, []byte{}, errors.New("No NODE-RC tx from the last epoch have been found")
+	if len(evidence) == 0 {
+		return types.RewardEpoch{}, errors.New("No node from the last epoch has passed a quorum of audits")
 	}
-	addresses := util.UniquifyAddresses(nodeArray)
-	sort.Slice(addresses[:], func(i, j int) bool {
-		return addresses[i].Hex() > addresses[j].Hex()
-	})
-	app.logger.Info(fmt.Sprintf("Mint: input node addresses: %#v", addresses))
-	rewardHash := ethcontracts.AddressesToHash(addresses)
-	return addresses, rewardHash, nil
+	record := newRewardEpoch(types.StakingKindNode, epoch, evidence)
+	app.logger.Info(fmt.Sprintf("Mint: input node addresses: %#v", rewardEpochAddresses(record)))
+	return record, nil
 }
 
-//AuditNodes : Audit nodes for reputation chain and hash submission validity. Submits reward tx with info if successful
-func (app *AnchorApplication) AuditNodes() error {
-	leader, ids := app.ElectValidator(1)
-	if len(ids) == 1 {
-		app.state.LastAuditCoreID = ids[0]
-	}
-	if leader {
-		rewardCandidates := make([]types.NodeJSON, 0)
-		deadline := time.Now().Add(9 * time.Minute)
-		var wg sync.WaitGroup
-		var mux sync.Mutex
-		for len(rewardCandidates) < 3 && !time.Now().After(deadline) {
-			nodes, err := app.pgClient.GetRandomNodes()
-			if app.LogError(err) != nil {
-				return err
-			}
-			for _, nodeCandidate := range nodes {
-				wg.Add(1)
-				go func(node types.Node) {
-					defer wg.Done()
-					app.logger.Info(fmt.Sprintf("node audit IP %s", node.PublicIP.String))
-					if err := app.AuditNode(node); err != nil {
-						app.logger.Debug(fmt.Sprintf("node audit of node IP %s unsuccessful: %s", node.PublicIP.String, err.Error()))
-						return
-					}
-					app.logger.Info(fmt.Sprintf("Node IP %s passed node audit", node.PublicIP.String))
-					nodeJSON := types.NodeJSON{
-						EthAddr:  node.EthAddr,
-						PublicIP: node.PublicIP.String,
-					}
-					mux.Lock()
-					rewardCandidates = append(rewardCandidates, nodeJSON)
-					mux.Unlock()
-				}(nodeCandidate)
-			}
-			wg.Wait()
-		}
-		if time.Now().After(deadline) {
-			app.logger.Info("node audit collation deadline passed")
-		}
-		if len(rewardCandidates) > 3 {
-			rewardCandidates = rewardCandidates[0:3]
-		}
-		if len(rewardCandidates) == 0 {
-			err := errors.New("Unspecified reward candidate collation failure for node audit")
-			app.LogError(err)
-			return err
-		}
-		if app.state.NodeMintPending {
-			app.logger.Info("Minting in progress, not auditing")
-			return nil
-		}
-		rcJSON, err := json.Marshal(rewardCandidates)
+//nodeRewardEvidence : tallies a node mint epoch's committed NODE-AUDIT txs into the nodes that qualified and their evidence
+func (app *AnchorApplication) nodeRewardEvidence(epoch int64) (map[common.Address][]types.RewardEvidence, error) {
+	submissions := make([]auditSubmission, 0)
+	assignments := make(map[int64]auditAssignment)
+	_, err := app.rpc.SearchTxs(context.Background(), fmt.Sprintf("NODEAUDIT=%d", epoch), func(tx *core_types.ResultTx) bool {
+		decoded, err := app.verifyCommittedTx(tx.Tx)
 		if app.LogError(err) != nil {
-			return err
+			return true
 		}
-		res, err := app.rpc.BroadcastTx("NODE-RC", string(rcJSON), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
-		if app.LogError(err) != nil {
-			return err
+		var audit types.NodeAudit
+		if app.LogError(json.Unmarshal([]byte(decoded.Data), &audit)) != nil || audit.Epoch != epoch {
+			return true
 		}
-		if res.Code == 0 {
-			app.logger.Info("node audit success, NODE-RC tx issued")
-			return nil
+		if _, exists := assignments[audit.Round]; !exists {
+			assignment, err := app.GetAuditAssignment(audit.Round, epoch)
+			if err != nil {
+				return true
+			}
+			assignments[audit.Round] = assignment
 		}
-		err = util.LoggerError(app.logger, errors.New("problem validating and submitting nodes for rewards after node audit process"))
-		return err
+		submissions = append(submissions, auditSubmission{Audit: audit, CoreID: decoded.CoreID, TxHash: tx.Hash.String(), Time: decoded.Time})
+		return true
+	})
+	if app.LogError(err) != nil { // includes ErrTxSearchCapped: a partial tally wouldn't match the other Cores'
+		return nil, err
 	}
-	app.logger.Info("Not leader for node audits")
-	return nil
+	return tallyNodeAudits(submissions, assignments, NODE_AUDIT_QUORUM), nil
 }
 
-//AuditNode : Used by the audit leader and all confirming cores to validate node performance
-func (app *AnchorApplication) AuditNode(node types.Node) error {
-	err := ValidateNodeRecentReputation(node)
+//AuditNode : Runs the registered audit checks against a node for an audit round, submitting a hash derived from nonce.
+//The auditor records the report in the audits table
+func (app *AnchorApplication) AuditNode(ctx context.Context, assignment auditAssignment, nonce string, node types.Node) (nodeaudit.Report, error) {
+	target := nodeaudit.Target{Node: node, Hash: auditHash(app.ID, assignment.Round, assignment.Seed, nonce, node)}
+	report, err := app.auditor.Audit(ctx, assignment.Round, &target)
 	if app.LogError(err) != nil {
-		app.logger.Info("unable to validate node audit reputation")
-		return err
+		return report, err
 	}
-	nodeResp, err := SendNodeHash(node)
-	if app.LogError(err) != nil && len(nodeResp.Hashes) == 0 {
-		app.logger.Info("node audit hash post failed")
-		return err
+	if util.Contains(report.Flags, AUDIT_FLAG_FORKED_REP_CHAIN) {
+		app.logger.Error(fmt.Sprintf("Node %s (%s) presented a forked reputation chain: %s", node.EthAddr, node.PublicIP.String, report.Reason))
 	}
-	time.Sleep(180 * time.Second)
-	err = RetrieveNodeCalProof(node, nodeResp)
-	if err != nil {
-		app.logger.Info("retrieving cal proof from node audit failed")
-		return err
+	if !report.Passed {
+		return report, errors.New(report.Reason)
 	}
-	return nil
+	return report, nil
 }
 
-//PollNodesFromContract : load all past node staking events and update events
-func (app *AnchorApplication) PollNodesFromContract() {
-	highestBlock := big.NewInt(0)
-	first := true
-	for {
-		app.logger.Info(fmt.Sprintf("Polling for Node Registry events after block %d", highestBlock.Int64()))
-		if first {
-			first = false
-		} else {
-			time.Sleep(30 * time.Second)
-		}
+//nodeRegistry : ethsync adapter for Nodes in the registry contract and the staked_nodes table
+type nodeRegistry struct {
+	app *AnchorApplication
+}
 
-		//Consume all past node events from this contract and import them into the local postgres instance
-		nodesStaked, err := app.ethClient.GetPastNodesStakedEvents(*highestBlock)
-		if app.LogError(err) != nil {
-			app.logger.Info("error in finding past staked nodes")
-			continue
-		}
-		for _, node := range nodesStaked {
-			newNode := types.Node{
-				EthAddr:     node.Sender.Hex(),
-				PublicIP:    sql.NullString{String: util.Int2Ip(node.NodeIp).String(), Valid: true},
-				BlockNumber: sql.NullInt64{Int64: int64(node.Raw.BlockNumber), Valid: true},
-			}
-			inserted, err := app.pgClient.NodeUpsert(newNode)
-			if app.LogError(err) != nil {
-				continue
-			}
-			app.logger.Info(fmt.Sprintf("Inserted for %#v: %t", newNode, inserted))
-		}
+//Kind : Nodes are recorded as "node" in the staking ledger
+func (r *nodeRegistry) Kind() string {
+	return types.StakingKindNode
+}
 
-		//Consume all updated events and reconcile them with the previous states
-		nodesStakedUpdated, err := app.ethClient.GetPastNodesStakeUpdatedEvents(*highestBlock)
-		if app.LogError(err) != nil {
-			continue
-		}
-		for _, node := range nodesStakedUpdated {
-			newNode := types.Node{
-				EthAddr:     node.Sender.Hex(),
-				PublicIP:    sql.NullString{String: util.Int2Ip(node.NodeIp).String(), Valid: true},
-				BlockNumber: sql.NullInt64{Int64: int64(node.Raw.BlockNumber), Valid: true},
-			}
-			inserted, err := app.pgClient.NodeUpsert(newNode)
-			if app.LogError(err) != nil {
-				continue
-			}
-			app.logger.Info(fmt.Sprintf("Updated for %#v: %t", newNode, inserted))
+//FetchRange : NodeStaked, NodeStakeUpdated and NodeUnStaked events emitted in blocks start through end.
+//The registry client reads from start to the chain head, so events past end are dropped here and read again with a later range
+func (r *nodeRegistry) FetchRange(start int64, end int64) ([]ethsync.Event, error) {
+	startBlock := *big.NewInt(start)
+	events := make([]ethsync.Event, 0)
+	staked, err := r.app.ethClient.GetPastNodesStakedEvents(startBlock)
+	if r.app.LogError(err) != nil {
+		r.app.logger.Info("error in finding past staked nodes")
+		return nil, err
+	}
+	for _, node := range staked {
+		if int64(node.Raw.BlockNumber) <= end {
+			events = append(events, nodeEvent(types.StakingEventStaked, node.Sender, node.NodeIp, node.Raw))
 		}
-
-		//Consume unstake events and delete nodes where the blockNumber of this event is higher than the last stake or update
-		nodesUnstaked, err := app.ethClient.GetPastNodesUnstakeEvents(*highestBlock)
-		if app.LogError(err) != nil {
-			continue
+	}
+	updated, err := r.app.ethClient.GetPastNodesStakeUpdatedEvents(startBlock)
+	if r.app.LogError(err) != nil {
+		return nil, err
+	}
+	for _, node := range updated {
+		if int64(node.Raw.BlockNumber) <= end {
+			events = append(events, nodeEvent(types.StakingEventUpdated, node.Sender, node.NodeIp, node.Raw))
 		}
-		for _, node := range nodesUnstaked {
-			newNode := types.Node{
-				EthAddr:     node.Sender.Hex(),
-				PublicIP:    sql.NullString{String: util.Int2Ip(node.NodeIp).String(), Valid: true},
-				BlockNumber: sql.NullInt64{Int64: int64(node.Raw.BlockNumber), Valid: true},
-			}
-			deleted, err := app.pgClient.NodeDelete(newNode)
-			if app.LogError(err) != nil {
-				continue
-			}
-			app.logger.Info(fmt.Sprintf("Deleted for %#v: %t", newNode, deleted))
+	}
+	unstaked, err := r.app.ethClient.GetPastNodesUnstakeEvents(startBlock)
+	if r.app.LogError(err) != nil {
+		return nil, err
+	}
+	for _, node := range unstaked {
+		if int64(node.Raw.BlockNumber) <= end {
+			events = append(events, nodeEvent(types.StakingEventUnstaked, node.Sender, node.NodeIp, node.Raw))
 		}
+	}
+	return events, nil
+}
 
-		highestBlock, err = app.ethClient.HighestBlock()
-		if app.LogError(err) != nil {
-			continue
-		}
+//Upsert : insert or update the node in staked_nodes
+func (r *nodeRegistry) Upsert(ev ethsync.Event) (bool, error) {
+	return r.app.pgClient.NodeUpsert(stakedNode(ev))
+}
+
+//Delete : remove the node from staked_nodes if the event is more recent than its stake or update
+func (r *nodeRegistry) Delete(ev ethsync.Event) (bool, error) {
+	return r.app.pgClient.NodeDelete(stakedNode(ev))
+}
+
+//nodeEvent : normalize a node registry event
+func nodeEvent(eventType string, sender common.Address, nodeIP uint32, raw ethtypes.Log) ethsync.Event {
+	return ethsync.Event{
+		Type:     eventType,
+		EthAddr:  sender.Hex(),
+		PublicIP: util.Int2Ip(nodeIP).String(),
+		Log:      raw,
+	}
+}
+
+//stakedNode : the staked_nodes row described by a node registry event
+func stakedNode(ev ethsync.Event) types.Node {
+	return types.Node{
+		EthAddr:     ev.EthAddr,
+		PublicIP:    sql.NullString{String: ev.PublicIP, Valid: true},
+		BlockNumber: sql.NullInt64{Int64: int64(ev.Log.BlockNumber), Valid: true},
 	}
 }
 
@@ -424,12 +366,15 @@ func ValidateNodeRecentReputation(node types.Node) error {
 	return err
 }
 
+//nodeClient : HTTP client for requests to nodes, so that an unresponsive node can't stall an audit
+var nodeClient = &http.Client{Timeout: 30 * time.Second}
+
 //SendNodeHash : Post a hash to a node
-func SendNodeHash(node types.Node) (types.NodeHashResponse, error) {
+func SendNodeHash(node types.Node, hash string) (types.NodeHashResponse, error) {
 	if net.ParseIP(node.PublicIP.String) != nil {
 		HashURI := fmt.Sprintf("http://%s/hashes", node.PublicIP.String)
 		nodeHash := types.NodeHash{
-			Hashes: []string{"c3ab8ff13720e8ad97dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"},
+			Hashes: []string{hash},
 		}
 		hashJSON, err := json.Marshal(nodeHash)
 		if err != nil {
@@ -439,14 +384,14 @@ func SendNodeHash(node types.Node) (types.NodeHashResponse, error) {
 		if err != nil {
 			return types.NodeHashResponse{}, err
 		}
-		client := http.Client{}
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Accept", "application/json")
 		var nodeResponse types.NodeHashResponse
-		resp, err := client.Do(req)
+		resp, err := nodeClient.Do(req)
 		if err != nil {
 			return types.NodeHashResponse{}, err
 		}
+		defer resp.Body.Close()
 		//fmt.Printf("node proof Response: %#v\n", nodeResponse)
 		if resp.StatusCode == http.StatusOK {
 			contents, err := ioutil.ReadAll(resp.Body)
@@ -464,13 +409,14 @@ func SendNodeHash(node types.Node) (types.NodeHashResponse, error) {
 }
 
 //RetrieveNodeCalProof : get back a node cal proof to validate Node health
-func RetrieveNodeCalProof(node types.Node, hashID types.NodeHashResponse) error {
-	if net.ParseIP(node.PublicIP.String) != nil && len(hashID.Hashes) > 0 {
-		calProofURI := fmt.Sprintf("http://%s/proofs/%s", node.PublicIP.String, hashID.Hashes[0].HashIDNode)
-		resp, err := http.Get(calProofURI)
+func RetrieveNodeCalProof(node types.Node, hashIDNode string) error {
+	if net.ParseIP(node.PublicIP.String) != nil && hashIDNode != "" {
+		calProofURI := fmt.Sprintf("http://%s/proofs/%s", node.PublicIP.String, hashIDNode)
+		resp, err := nodeClient.Get(calProofURI)
 		if err != nil {
 			return err
 		}
+		defer resp.Body.Close()
 		contents, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
@@ -514,11 +460,12 @@ func ValidateRepChain(node types.Node, repChain types.RepChain) error {
 func GetNodeRecentReputation(node types.Node) (types.RepChain, error) {
 	if net.ParseIP(node.PublicIP.String) != nil {
 		RecentRepURI := fmt.Sprintf("http://%s/reputation/recent", node.PublicIP.String)
-		resp, err := http.Get(RecentRepURI)
+		resp, err := nodeClient.Get(RecentRepURI)
 		var repChain types.RepChain
 		if err != nil {
 			return types.RepChain{}, err
 		}
+		defer resp.Body.Close()
 		contents, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return types.RepChain{}, err
@@ -534,6 +481,18 @@ func GetNodeRecentReputation(node types.Node) (types.RepChain, error) {
 
 //ValidateRepChainItemHash : Validate hash of chain item
 func ValidateRepChainItemHash(chainItem types.RepChainItem) (string, error) {
+	hashStr, err := repChainItemHash(chainItem)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(chainItem.RepItemHash, hashStr) {
+		return "", errors.New(fmt.Sprintf("Hash mismatch between local record %s and repItem %s\n", hashStr, chainItem.RepItemHash))
+	}
+	return hashStr, nil
+}
+
+//repChainItemHash : computes the hash a reputation chain item should carry
+func repChainItemHash(chainItem types.RepChainItem) (string, error) {
 	buf := new(bytes.Buffer)
 	bid := make([]byte, 4)
 	bbh := make([]byte, 4)
@@ -567,11 +526,7 @@ func ValidateRepChainItemHash(chainItem types.RepChainItem) (string, error) {
 	buf.Write(hashIDNodeNoHyphensBytes)
 
 	hash := sha256.Sum256(buf.Bytes())
-	hashStr := hex.EncodeToString(hash[:])
-	if !strings.Contains(chainItem.RepItemHash, hashStr) {
-		return "", errors.New(fmt.Sprintf("Hash mismatch between local record %s and repItem %s\n", hashStr, chainItem.RepItemHash))
-	}
-	return hashStr, nil
+	return hex.EncodeToString(hash[:]), nil
 }
 
 //ValidateRepChainItemSig : validates the signature from a node's reputation chain item
@@ -0,0 +1,148 @@
+package abci
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chainpoint/tendermint/abci/example/code"
+	types2 "github.com/chainpoint/tendermint/abci/types"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/merkletools"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// queryCalMMRRoot : returns the committed calendar MMR root and leaf count
+func (app *AnchorApplication) queryCalMMRRoot(reqQuery types2.RequestQuery) types2.ResponseQuery {
+	root := types.CalMMRInclusion{
+		LeafCount: app.state.CalMMRLeafCount,
+		Root:      hex.EncodeToString(app.state.CalMMRRoot),
+	}
+	return app.queryResponse(root)
+}
+
+// queryCalMMRInclusion : returns a proof that CAL leaf_index is in the calendar MMR of leaf_count leaves (defaults to committed)
+func (app *AnchorApplication) queryCalMMRInclusion(reqQuery types2.RequestQuery) types2.ResponseQuery {
+	var query types.CalMMRQuery
+	if err := json.Unmarshal(reqQuery.Data, &query); app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeEncodingError, Log: err.Error()}
+	}
+	if query.LeafCount == 0 {
+		query.LeafCount = app.state.CalMMRLeafCount
+	}
+	if query.LeafCount > app.state.CalMMRLeafCount {
+		err := fmt.Errorf("leaf count %d exceeds committed MMR size %d", query.LeafCount, app.state.CalMMRLeafCount)
+		return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: err.Error()}
+	}
+	proof, err := app.calMMR.GetInclusionProof(query.LeafIndex, query.LeafCount)
+	if app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: err.Error()}
+	}
+	root, _ := app.calMMR.RootAt(query.LeafCount)
+	inclusion := types.CalMMRInclusion{
+		LeafIndex: query.LeafIndex,
+		LeafCount: query.LeafCount,
+		Root:      hex.EncodeToString(root),
+		Proof:     append([]types.ProofLineItem{types.ProofLineItem{Op: merkletools.HardenedLeafOp}}, mmrProofLineItems(proof)...),
+	}
+	return app.queryResponse(inclusion)
+}
+
+// queryCalMMRConsistency : returns a proof that the calendar MMR of old_leaf_count leaves is a prefix of leaf_count leaves (defaults to committed)
+func (app *AnchorApplication) queryCalMMRConsistency(reqQuery types2.RequestQuery) types2.ResponseQuery {
+	var query types.CalMMRQuery
+	if err := json.Unmarshal(reqQuery.Data, &query); app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeEncodingError, Log: err.Error()}
+	}
+	if query.LeafCount == 0 {
+		query.LeafCount = app.state.CalMMRLeafCount
+	}
+	if query.LeafCount > app.state.CalMMRLeafCount {
+		err := fmt.Errorf("leaf count %d exceeds committed MMR size %d", query.LeafCount, app.state.CalMMRLeafCount)
+		return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: err.Error()}
+	}
+	proof, err := app.calMMR.GetConsistencyProof(query.OldLeafCount, query.LeafCount)
+	if app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: err.Error()}
+	}
+	oldRoot, _ := app.calMMR.RootAt(query.OldLeafCount)
+	newRoot, _ := app.calMMR.RootAt(query.LeafCount)
+	consistency := types.CalMMRConsistency{
+		OldLeafCount: proof.OldLeafCount,
+		NewLeafCount: proof.NewLeafCount,
+		OldRoot:      hex.EncodeToString(oldRoot),
+		NewRoot:      hex.EncodeToString(newRoot),
+		OldPeaks:     make([]string, 0),
+		NewPeaks:     make([]string, 0),
+		Paths:        make([][]types.ProofLineItem, 0),
+	}
+	for _, peak := range proof.OldPeaks {
+		consistency.OldPeaks = append(consistency.OldPeaks, hex.EncodeToString(peak))
+	}
+	for _, peak := range proof.NewPeaks {
+		consistency.NewPeaks = append(consistency.NewPeaks, hex.EncodeToString(peak))
+	}
+	for _, path := range proof.Paths {
+		consistency.Paths = append(consistency.Paths, mmrProofLineItems(path))
+	}
+	return app.queryResponse(consistency)
+}
+
+// queryNodeAudits : returns a node's most recent audits by any Core, up to limit (defaults to NODE_AUDIT_QUERY_LIMIT)
+func (app *AnchorApplication) queryNodeAudits(reqQuery types2.RequestQuery) types2.ResponseQuery {
+	var query types.NodeAuditQuery
+	if err := json.Unmarshal(reqQuery.Data, &query); app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeEncodingError, Log: err.Error()}
+	}
+	if query.EthAddr == "" {
+		return types2.ResponseQuery{Code: code.CodeTypeEncodingError, Log: "eth_address is required"}
+	}
+	if query.Limit <= 0 || query.Limit > NODE_AUDIT_QUERY_LIMIT {
+		query.Limit = NODE_AUDIT_QUERY_LIMIT
+	}
+	audits, err := app.pgClient.GetNodeAudits(query.EthAddr, query.Limit)
+	if app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: err.Error()}
+	}
+	return app.queryResponse(audits)
+}
+
+// queryNodeAuditStats : returns audit and per-check pass rates since a unix time, for one node or for every node if eth_address is empty
+func (app *AnchorApplication) queryNodeAuditStats(reqQuery types2.RequestQuery) types2.ResponseQuery {
+	var query types.NodeAuditQuery
+	if err := json.Unmarshal(reqQuery.Data, &query); app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeEncodingError, Log: err.Error()}
+	}
+	stats, err := app.pgClient.GetNodeAuditStats(query.EthAddr, query.Since)
+	if app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: err.Error()}
+	}
+	return app.queryResponse(stats)
+}
+
+// queryResponse : wraps a JSON-serializable value into a successful query response at the current height
+func (app *AnchorApplication) queryResponse(value interface{}) types2.ResponseQuery {
+	valueJSON, err := json.Marshal(value)
+	if app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeEncodingError, Log: err.Error()}
+	}
+	return types2.ResponseQuery{
+		Code:   code.CodeTypeOK,
+		Value:  valueJSON,
+		Height: app.state.Height,
+	}
+}
+
+// mmrProofLineItems : converts MMR proof steps into Chainpoint proof steps using the hardened node op
+func mmrProofLineItems(proof []merkletools.ProofStep) []types.ProofLineItem {
+	lineItems := make([]types.ProofLineItem, 0)
+	for _, p := range proof {
+		if p.Left {
+			lineItems = append(lineItems, types.ProofLineItem{Left: hex.EncodeToString(p.Value)})
+		} else {
+			lineItems = append(lineItems, types.ProofLineItem{Right: hex.EncodeToString(p.Value)})
+		}
+		lineItems = append(lineItems, types.ProofLineItem{Op: merkletools.HardenedNodeOp})
+	}
+	return lineItems
+}
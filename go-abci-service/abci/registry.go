@@ -0,0 +1,312 @@
+package abci
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chainpoint/tendermint/abci/example/code"
+	types2 "github.com/chainpoint/tendermint/abci/types"
+	"github.com/chainpoint/tendermint/crypto"
+	"github.com/chainpoint/tendermint/crypto/ed25519"
+	"github.com/chainpoint/tendermint/libs/common"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/smt"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+)
+
+// registry key namespaces, mirroring the redis key layout, plus each Core's validator address, Ethereum address and penalty
+// ledger entry, the Core that anchored each BTC-A's Bitcoin tx, and the list of Cores with a penalty ledger entry
+const (
+	registryCoreKeyPrefix       = "CoreID:"
+	registryNodeTokenPrefix     = "NodeToken:"
+	registryCoreValidatorPrefix = "CoreValidator:"
+	registryCoreAddressPrefix   = "CoreAddress:"
+	registryPenaltyPrefix       = "Penalty:"
+	registryBtcaCorePrefix      = "BtcA:"
+	registryPenaltyIndexKey     = "Penalties"
+)
+
+// decodeTx : decodes a tx for delivery. Once the registry is active, every tx is verified against the keys committed
+// to it, so every Core accepts the same txs. Before then, txs are verified against this Core's key cache once it has synced
+func (app *AnchorApplication) decodeTx(rawTx []byte) (types.Tx, error) {
+	if app.activated(app.state.ChainParams.RegistryHeight) {
+		return verifyRegisteredTx(app.registry, rawTx)
+	}
+	if app.state.ChainSynced {
+		return util.DecodeVerifyTx(rawTx, app.CoreKeys)
+	}
+	return util.DecodeTx(rawTx)
+}
+
+// verifyCommittedTx : decodes a tx read back from the chain. Once the registry is active, it's verified against the keys
+// committed to the registry, so every Core accepts the same txs; before then, against the Core keys known locally
+func (app *AnchorApplication) verifyCommittedTx(rawTx []byte) (types.Tx, error) {
+	if app.activated(app.state.ChainParams.RegistryHeight) {
+		return verifyRegisteredTx(app.committedRegistry(), rawTx)
+	}
+	return util.DecodeVerifyTx(rawTx, app.CoreKeys)
+}
+
+// verifyRegisteredTx : decodes a tx and checks its signature against the key its Core committed to the registry.
+// A JWK is checked against the key it carries instead, proving its sender holds the key it registers
+func verifyRegisteredTx(registry *smt.SparseMerkleTree, rawTx []byte) (types.Tx, error) {
+	tx, err := util.DecodeTx(rawTx)
+	if err != nil {
+		return types.Tx{}, err
+	}
+	var pubKey *ecdsa.PublicKey
+	if tx.TxType == "JWK" {
+		pubKey, err = util.DecodePubKey(tx)
+	} else {
+		pubKey, err = registryCoreKey(registry, tx.CoreID)
+		if err == nil && pubKey == nil {
+			err = fmt.Errorf("Core %s has no key in the registry", tx.CoreID)
+		}
+	}
+	if err != nil {
+		return types.Tx{}, err
+	}
+	if err := util.VerifyTxSig(tx, pubKey); err != nil {
+		return types.Tx{}, err
+	}
+	return tx, nil
+}
+
+// registryCoreKey : returns the public key a Core committed to the registry, or nil if it hasn't registered one
+func registryCoreKey(registry *smt.SparseMerkleTree, coreID string) (*ecdsa.PublicKey, error) {
+	pubKeyBytes, err := registry.Get([]byte(registryCoreKeyPrefix + coreID))
+	if err != nil || pubKeyBytes == nil {
+		return nil, err
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), pubKeyBytes)
+	if x == nil {
+		return nil, fmt.Errorf("registered key for Core %s is not a P-256 point", coreID)
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+// committedRegistry : the registry as of the last committed block, for reads made outside of block execution
+func (app *AnchorApplication) committedRegistry() *smt.SparseMerkleTree {
+	return app.registry.At(app.state.RegistryRoot)
+}
+
+// registerCoreKey : commits the key a JWK carries for its Core, along with the validator address in its meta. A Core's
+// first registered key is kept, and a JWK carrying a different key for an already registered Core is refused
+func (app *AnchorApplication) registerCoreKey(tx types.Tx) error {
+	pubKey, err := util.DecodePubKey(tx)
+	if err != nil {
+		return err
+	}
+	if pubKey.Curve != elliptic.P256() {
+		return errors.New("JWK key must be on the P-256 curve")
+	}
+	pubKeyBytes := elliptic.Marshal(pubKey.Curve, pubKey.X, pubKey.Y)
+	registered, err := app.registry.Get([]byte(registryCoreKeyPrefix + tx.CoreID))
+	if err != nil {
+		return err
+	}
+	if registered == nil {
+		if err := app.setRegistryCoreKey(tx.CoreID, pubKeyBytes); err != nil {
+			return err
+		}
+	} else if !bytes.Equal(registered, pubKeyBytes) {
+		return fmt.Errorf("Core %s has already registered a different key", tx.CoreID)
+	}
+	if tx.Meta != "" {
+		address, err := verifyValidatorBinding(tx)
+		if err != nil {
+			return err
+		}
+		if err := app.setRegistryCoreValidator(tx.CoreID, address); err != nil {
+			return err
+		}
+		ethAddress, bound, err := verifyAddressBinding(tx)
+		if err != nil || !bound {
+			return err
+		}
+		return app.setRegistryCoreAddress(tx.CoreID, ethAddress)
+	}
+	return nil
+}
+
+// validatorBindingMessage : what a Core's Tendermint validator and Ethereum account sign to bind themselves to the Core's JWK
+func validatorBindingMessage(coreID string, jwkData string) []byte {
+	return []byte("JWK|" + coreID + "|" + jwkData)
+}
+
+// signValidatorBinding : JWK tx meta of the form <hex validator pubkey>|<hex signature>, binding our validator to our JWK
+func signValidatorBinding(privKey crypto.PrivKey, coreID string, jwkData string) (string, error) {
+	pubKey, ok := privKey.PubKey().(ed25519.PubKeyEd25519)
+	if !ok {
+		return "", errors.New("validator key isn't ed25519")
+	}
+	sig, err := privKey.Sign(validatorBindingMessage(coreID, jwkData))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(pubKey[:]) + "|" + hex.EncodeToString(sig), nil
+}
+
+// verifyValidatorBinding : checks a JWK tx's meta was signed by the validator it names, and returns that validator's address
+func verifyValidatorBinding(tx types.Tx) (string, error) {
+	meta := strings.Split(tx.Meta, "|")
+	if len(meta) != 2 && len(meta) != 4 {
+		return "", errors.New("JWK validator binding isn't of the form pubkey|signature, optionally followed by |address|signature")
+	}
+	pubKeyBytes, err := hex.DecodeString(meta[0])
+	if err != nil || len(pubKeyBytes) != ed25519.PubKeyEd25519Size {
+		return "", errors.New("JWK validator binding has an invalid ed25519 public key")
+	}
+	sig, err := hex.DecodeString(meta[1])
+	if err != nil {
+		return "", err
+	}
+	var pubKey ed25519.PubKeyEd25519
+	copy(pubKey[:], pubKeyBytes)
+	if !pubKey.VerifyBytes(validatorBindingMessage(tx.CoreID, tx.Data), sig) {
+		return "", fmt.Errorf("JWK validator binding for Core %s isn't signed by its validator", tx.CoreID)
+	}
+	return pubKey.Address().String(), nil
+}
+
+// signAddressBinding : JWK tx meta fields of the form <address>|<hex signature>, appended to the validator binding to bind
+// the Ethereum account our Core stakes and is rewarded with to our JWK
+func signAddressBinding(key *ecdsa.PrivateKey, coreID string, jwkData string) (string, error) {
+	sig, err := ethcrypto.Sign(ethcrypto.Keccak256(validatorBindingMessage(coreID, jwkData)), key)
+	if err != nil {
+		return "", err
+	}
+	return ethcrypto.PubkeyToAddress(key.PublicKey).Hex() + "|" + hex.EncodeToString(sig), nil
+}
+
+// verifyAddressBinding : checks the Ethereum address a JWK tx's meta binds, if any, signed the binding, and returns it.
+// bound is false for JWK txs that only bind a validator
+func verifyAddressBinding(tx types.Tx) (address ethcommon.Address, bound bool, err error) {
+	meta := strings.Split(tx.Meta, "|")
+	if len(meta) != 4 {
+		return ethcommon.Address{}, false, nil
+	}
+	sig, err := hex.DecodeString(meta[3])
+	if err != nil {
+		return ethcommon.Address{}, false, err
+	}
+	pubKey, err := ethcrypto.SigToPub(ethcrypto.Keccak256(validatorBindingMessage(tx.CoreID, tx.Data)), sig)
+	if err != nil {
+		return ethcommon.Address{}, false, err
+	}
+	address = ethcrypto.PubkeyToAddress(*pubKey)
+	if !ethcommon.IsHexAddress(meta[2]) || address != ethcommon.HexToAddress(meta[2]) {
+		return ethcommon.Address{}, false, fmt.Errorf("JWK address binding for Core %s isn't signed by %s", tx.CoreID, meta[2])
+	}
+	return address, true, nil
+}
+
+// registryCoreAddress : the Ethereum address a Core bound to its key in a registry, if any
+func registryCoreAddress(registry *smt.SparseMerkleTree, coreID string) (ethcommon.Address, bool, error) {
+	address, err := registry.Get([]byte(registryCoreAddressPrefix + coreID))
+	if err != nil || address == nil {
+		return ethcommon.Address{}, false, err
+	}
+	return ethcommon.HexToAddress(string(address)), true, nil
+}
+
+// setRegistryCoreKey : commits a Core's marshalled public key to the registry
+func (app *AnchorApplication) setRegistryCoreKey(coreID string, pubKeyBytes []byte) error {
+	_, err := app.registry.Update([]byte(registryCoreKeyPrefix+coreID), pubKeyBytes)
+	return util.LoggerError(app.logger, err)
+}
+
+// setRegistryCoreValidator : commits the Tendermint validator address a Core registered with its JWK
+func (app *AnchorApplication) setRegistryCoreValidator(coreID string, address string) error {
+	_, err := app.registry.Update([]byte(registryCoreValidatorPrefix+coreID), []byte(address))
+	return util.LoggerError(app.logger, err)
+}
+
+// setRegistryCoreAddress : commits the Ethereum address a Core bound with its JWK
+func (app *AnchorApplication) setRegistryCoreAddress(coreID string, address ethcommon.Address) error {
+	_, err := app.registry.Update([]byte(registryCoreAddressPrefix+coreID), []byte(address.Hex()))
+	return util.LoggerError(app.logger, err)
+}
+
+// setRegistryBtcaCore : commits the Core whose BTC-A announced a Bitcoin tx, so its BTC-Cs can be checked against it
+func (app *AnchorApplication) setRegistryBtcaCore(btcTxID string, coreID string) error {
+	_, err := app.registry.Update([]byte(registryBtcaCorePrefix+btcTxID), []byte(coreID))
+	return util.LoggerError(app.logger, err)
+}
+
+// registryBtcaCoreID : looks up the Core whose committed BTC-A announced a Bitcoin tx
+func registryBtcaCoreID(registry *smt.SparseMerkleTree) func(string) (string, bool) {
+	return func(btcTxID string) (string, bool) {
+		coreID, err := registry.Get([]byte(registryBtcaCorePrefix + btcTxID))
+		if util.LogError(err) != nil || coreID == nil {
+			return "", false
+		}
+		return string(coreID), true
+	}
+}
+
+// updateRegistryToken : commits a TOKEN tx of the form <node_ip>|<token_hash> to the registry. An empty hash revokes the node's token
+func (app *AnchorApplication) updateRegistryToken(tx types.Tx, tags []common.KVPair) ([]common.KVPair, error) {
+	payload := strings.Split(tx.Data, "|")
+	if len(payload) != 2 || payload[0] == "" {
+		return tags, util.LoggerError(app.logger, errors.New("TOKEN tx is malformed"))
+	}
+	_, err := app.registry.Update([]byte(registryNodeTokenPrefix+payload[0]), []byte(payload[1]))
+	if util.LoggerError(app.logger, err) != nil {
+		return tags, err
+	}
+	return append(tags, common.KVPair{Key: []byte("NODETOKEN"), Value: []byte(payload[0])}), nil
+}
+
+// queryRegistry : returns a membership or non-membership proof for a registry key against the last committed registry root,
+// the root in the latest app hash
+func (app *AnchorApplication) queryRegistry(prefix string, reqQuery types2.RequestQuery) types2.ResponseQuery {
+	if len(reqQuery.Data) == 0 {
+		return types2.ResponseQuery{Code: code.CodeTypeEncodingError, Log: "query data must contain a Core ID or node IP"}
+	}
+	registry := app.committedRegistry()
+	key := []byte(prefix + string(reqQuery.Data))
+	value, err := registry.Get(key)
+	if app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: err.Error()}
+	}
+	proof, err := registry.Prove(key)
+	if app.LogError(err) != nil {
+		return types2.ResponseQuery{Code: code.CodeTypeUnknownError, Log: err.Error()}
+	}
+	registryProof := types.RegistryProof{
+		Key:      string(key),
+		Value:    hex.EncodeToString(value),
+		Present:  value != nil,
+		Root:     hex.EncodeToString(registry.Root),
+		Bitmap:   hex.EncodeToString(proof.Bitmap),
+		Siblings: make([]string, 0),
+	}
+	for _, sibling := range proof.Siblings {
+		registryProof.Siblings = append(registryProof.Siblings, hex.EncodeToString(sibling))
+	}
+	return app.queryResponse(registryProof)
+}
+
+// calculateAppHash : height || registry root || calendar MMR root. The registry root is always 32 bytes, so the MMR root stays a suffix.
+// Each root is only included once its feature is active
+func (app *AnchorApplication) calculateAppHash() []byte {
+	appHash := make([]byte, 8)
+	binary.PutVarint(appHash, app.state.Height)
+	if app.activated(app.state.ChainParams.RegistryHeight) {
+		appHash = append(appHash, app.state.RegistryRoot...)
+	}
+	if app.calMMRActivated() {
+		appHash = append(appHash, app.state.CalMMRRoot...)
+	}
+	return appHash
+}
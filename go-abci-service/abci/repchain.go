@@ -0,0 +1,135 @@
+package abci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	dbm "github.com/chainpoint/tendermint/libs/db"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+//repChainHeadPrefix : key prefix for each node's last verified reputation chain item within the app database
+const repChainHeadPrefix = "repchain:"
+
+//AUDIT_FLAG_FORKED_REP_CHAIN : flags an audit report whose node presented a reputation chain conflicting with an earlier one
+const AUDIT_FLAG_FORKED_REP_CHAIN = "forked_rep_chain"
+
+//RepChainHead : the newest reputation chain item verified for a node
+type RepChainHead struct {
+	ID             uint32 `json:"id"`
+	RepItemHash    string `json:"repItemHash"`
+	CalBlockHeight uint32 `json:"calBlockHeight"`
+	VerifiedAt     int64  `json:"verifiedAt"`
+}
+
+//RepChainForkError : a node's reputation chain conflicts with the head verified at an earlier audit
+type RepChainForkError struct {
+	Head   RepChainHead
+	Reason string
+}
+
+func (e RepChainForkError) Error() string {
+	return fmt.Sprintf("reputation chain forked from verified item %d: %s", e.Head.ID, e.Reason)
+}
+
+//VerifyRepChain : fully validates a node's reputation chain: each item's hash and signature, that item IDs are contiguous
+//and each item links to the one before it, that each newly seen item anchors to the calendar block it names, and that the
+//chain extends head, the item verified at the node's last audit, if any, by including or directly following it. Returns
+//the chain's new head
+func VerifyRepChain(node types.Node, repChain types.RepChain, head *RepChainHead, calBlockHash func(int64) (string, error)) (RepChainHead, error) {
+	if len(repChain) == 0 {
+		return RepChainHead{}, errors.New("reputation chain is empty")
+	}
+	chain := append(types.RepChain{}, repChain...)
+	sort.Slice(chain, func(i, j int) bool {
+		return chain[i].ID < chain[j].ID
+	})
+	if err := ValidateRepChain(node, chain); err != nil {
+		return RepChainHead{}, err
+	}
+	for i := 1; i < len(chain); i++ {
+		if chain[i].ID != chain[i-1].ID+1 {
+			return RepChainHead{}, fmt.Errorf("reputation chain skips from item %d to %d", chain[i-1].ID, chain[i].ID)
+		}
+		if !strings.EqualFold(chain[i].PrevRepItemHash, chain[i-1].RepItemHash) {
+			return RepChainHead{}, fmt.Errorf("reputation item %d doesn't link to item %d", chain[i].ID, chain[i-1].ID)
+		}
+		if chain[i].CalBlockHeight < chain[i-1].CalBlockHeight {
+			return RepChainHead{}, fmt.Errorf("reputation item %d anchors to an earlier calendar block than item %d", chain[i].ID, chain[i-1].ID)
+		}
+	}
+	first, last := chain[0], chain[len(chain)-1]
+	if head != nil {
+		switch {
+		case last.ID < head.ID:
+			return RepChainHead{}, RepChainForkError{Head: *head, Reason: fmt.Sprintf("chain now ends at item %d", last.ID)}
+		case first.ID <= head.ID:
+			if item := chain[head.ID-first.ID]; !strings.EqualFold(item.RepItemHash, head.RepItemHash) {
+				return RepChainHead{}, RepChainForkError{Head: *head, Reason: fmt.Sprintf("item %d now hashes to %s", item.ID, item.RepItemHash)}
+			}
+		case first.ID == head.ID+1:
+			if !strings.EqualFold(first.PrevRepItemHash, head.RepItemHash) {
+				return RepChainHead{}, RepChainForkError{Head: *head, Reason: fmt.Sprintf("item %d doesn't link to it", first.ID)}
+			}
+		default:
+			return RepChainHead{}, fmt.Errorf("reputation chain starts at item %d, so it can't be linked to verified item %d", first.ID, head.ID)
+		}
+	}
+	for _, item := range chain {
+		if head != nil && item.ID <= head.ID {
+			continue
+		}
+		blockHash, err := calBlockHash(int64(item.CalBlockHeight))
+		if err != nil {
+			return RepChainHead{}, err
+		}
+		if !strings.EqualFold(blockHash, item.CalBlockHash) {
+			return RepChainHead{}, fmt.Errorf("reputation item %d names calendar block %d with hash %s, but it hashes to %s",
+				item.ID, item.CalBlockHeight, item.CalBlockHash, blockHash)
+		}
+	}
+	return RepChainHead{ID: last.ID, RepItemHash: last.RepItemHash, CalBlockHeight: last.CalBlockHeight, VerifiedAt: time.Now().Unix()}, nil
+}
+
+//repChainHeads : each node's last verified reputation chain head, kept in the app database
+type repChainHeads struct {
+	Db dbm.DB
+}
+
+//Get : a node's last verified head, or nil if its chain hasn't been verified yet
+func (h *repChainHeads) Get(node types.Node) (*RepChainHead, error) {
+	headJSON := h.Db.Get(repChainHeadKey(node))
+	if headJSON == nil {
+		return nil, nil
+	}
+	var head RepChainHead
+	if err := json.Unmarshal(headJSON, &head); err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+//Put : records a node's newly verified head
+func (h *repChainHeads) Put(node types.Node, head RepChainHead) error {
+	headJSON, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	h.Db.SetSync(repChainHeadKey(node), headJSON)
+	return nil
+}
+
+//repChainHeadKey : heads are keyed by node address, or by IP for private network nodes, which have none
+func repChainHeadKey(node types.Node) []byte {
+	address := common.HexToAddress(node.EthAddr)
+	if address == (common.Address{}) {
+		return []byte(repChainHeadPrefix + node.PublicIP.String)
+	}
+	return []byte(repChainHeadPrefix + strings.ToLower(address.Hex()))
+}
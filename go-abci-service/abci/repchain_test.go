@@ -0,0 +1,91 @@
+package abci
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+//calBlockHashes : a stand-in calendar whose block at each height hashes to a value derived from the height
+func calBlockHashes(height int64) (string, error) {
+	return fmt.Sprintf("%064x", height*7), nil
+}
+
+//signedRepChain : n reputation items signed by key, continuing from prevHash
+func signedRepChain(t *testing.T, key *ecdsa.PrivateKey, firstID uint32, n int, prevHash string) types.RepChain {
+	chain := types.RepChain{}
+	for i := 0; i < n; i++ {
+		id := firstID + uint32(i)
+		calHash, _ := calBlockHashes(int64(id * 10))
+		item := types.RepChainItem{
+			ID:              id,
+			CalBlockHeight:  id * 10,
+			CalBlockHash:    calHash,
+			PrevRepItemHash: prevHash,
+			HashIDNode:      fmt.Sprintf("3de6d66e-4bd8-11e9-8646-%012x", id),
+		}
+		hash, err := repChainItemHash(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item.RepItemHash = hash
+		hashBytes, _ := hex.DecodeString(hash)
+		sig, err := crypto.Sign(signHash(hashBytes), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig[64] += 27
+		item.Signature = hexutil.Encode(sig)
+		chain = append(chain, item)
+		prevHash = hash
+	}
+	return chain
+}
+
+func TestVerifyRepChain(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := crypto.GenerateKey()
+	node := types.Node{EthAddr: crypto.PubkeyToAddress(key.PublicKey).Hex()}
+	genesis := fmt.Sprintf("%064x", 0)
+	chain := signedRepChain(t, key, 1, 10, genesis)
+
+	head, err := VerifyRepChain(node, types.RepChain{chain[2], chain[0], chain[1]}, nil, calBlockHashes)
+	assert.NoError(err)
+	assert.Equal(uint32(3), head.ID)
+
+	extended, err := VerifyRepChain(node, chain[2:6], &head, calBlockHashes)
+	assert.NoError(err, "a chain overlapping the verified head extends it")
+	assert.Equal(uint32(6), extended.ID)
+	_, err = VerifyRepChain(node, chain[6:], &extended, calBlockHashes)
+	assert.NoError(err, "a chain starting right after the verified head extends it")
+	_, err = VerifyRepChain(node, chain[5:], &head, calBlockHashes)
+	assert.Error(err, "a chain starting past the verified head can't be shown to extend it")
+
+	_, err = VerifyRepChain(node, types.RepChain{chain[0], chain[2]}, nil, calBlockHashes)
+	assert.Error(err, "IDs must be contiguous")
+
+	forged := signedRepChain(t, key, 7, 2, chain[4].RepItemHash)
+	_, err = VerifyRepChain(node, forged, &extended, calBlockHashes)
+	_, forked := err.(RepChainForkError)
+	assert.True(forked, "a chain that doesn't link to the verified head has forked")
+	_, err = VerifyRepChain(node, chain[:4], &extended, calBlockHashes)
+	_, forked = err.(RepChainForkError)
+	assert.True(forked, "a chain that ends before the verified head has been rolled back")
+	rewritten := signedRepChain(t, key, 5, 3, fmt.Sprintf("%064x", 1))
+	_, err = VerifyRepChain(node, rewritten, &extended, calBlockHashes)
+	_, forked = err.(RepChainForkError)
+	assert.True(forked, "a chain that rewrites the verified head has forked")
+
+	misanchored := signedRepChain(t, key, 11, 1, chain[9].RepItemHash)
+	_, err = VerifyRepChain(node, misanchored, nil, func(height int64) (string, error) {
+		return fmt.Sprintf("%064x", height), nil
+	})
+	assert.Error(err, "items must name real calendar blocks")
+}
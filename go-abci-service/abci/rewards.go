@@ -0,0 +1,304 @@
+package abci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/ethcontracts"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+	"github.com/chp-project/chainpoint-core/go-abci-service/workledger"
+)
+
+//mintRewardEpochPrefix : keys the REWARD-EPOCH records of mints awaiting confirmation
+const mintRewardEpochPrefix = "mintepoch:"
+
+//committedAuditPrefix : keys the outcome of each NODE-AUDIT tx delivered once the registry is active, by tx hash
+const committedAuditPrefix = "nodeaudit:"
+
+//DEFAULT_CORE_REWARD_SHARES : how many shares a Core mint splits between Cores on chains whose genesis doesn't set core_reward_shares
+const DEFAULT_CORE_REWARD_SHARES = 20
+
+//committedAudit : the outcome of a committed NODE-AUDIT tx: the Core that issued it, its epoch and round, and the nodes it passed
+type committedAudit struct {
+	CoreID string   `json:"core_id"`
+	Epoch  int64    `json:"epoch"`
+	Round  int64    `json:"round"`
+	Passed []string `json:"passed"`
+}
+
+//newRewardEpoch : builds a mint epoch's REWARD-EPOCH record from each candidate's evidence, ordering candidates as their
+//addresses are hashed for the mint contracts
+func newRewardEpoch(kind string, epoch int64, evidence map[common.Address][]types.RewardEvidence) types.RewardEpoch {
+	candidates := make([]types.RewardCandidate, 0, len(evidence))
+	for address, candidateEvidence := range evidence {
+		sort.SliceStable(candidateEvidence, func(i, j int) bool {
+			if candidateEvidence[i].Time != candidateEvidence[j].Time {
+				return candidateEvidence[i].Time < candidateEvidence[j].Time
+			}
+			return candidateEvidence[i].TxHash < candidateEvidence[j].TxHash
+		})
+		candidates = append(candidates, types.RewardCandidate{EthAddr: address.Hex(), Evidence: candidateEvidence})
+	}
+	return newScoredRewardEpoch(kind, epoch, candidates)
+}
+
+//newScoredRewardEpoch : builds a mint epoch's REWARD-EPOCH record from candidates that already carry their evidence or
+//work, ordering them as their addresses are hashed for the mint contracts
+func newScoredRewardEpoch(kind string, epoch int64, candidates []types.RewardCandidate) types.RewardEpoch {
+	sort.Slice(candidates[:], func(i, j int) bool {
+		return common.HexToAddress(candidates[i].EthAddr).Hex() > common.HexToAddress(candidates[j].EthAddr).Hex()
+	})
+	record := types.RewardEpoch{
+		Kind:       kind,
+		Epoch:      epoch,
+		Candidates: candidates,
+	}
+	record.RewardHash = hex.EncodeToString(ethcontracts.AddressesToHash(rewardEpochAddresses(record)))
+	return record
+}
+
+//scoreRewardEpoch : builds a mint epoch's REWARD-EPOCH record from scored candidates, splitting totalShares between them
+//by score. Candidates that scored nothing are left out
+func scoreRewardEpoch(kind string, epoch int64, candidates []types.RewardCandidate, totalShares int) types.RewardEpoch {
+	sort.Slice(candidates[:], func(i, j int) bool {
+		return common.HexToAddress(candidates[i].EthAddr).Hex() > common.HexToAddress(candidates[j].EthAddr).Hex()
+	})
+	scores := make([]int64, len(candidates))
+	for i, candidate := range candidates {
+		scores[i] = candidate.Score
+	}
+	scored := make([]types.RewardCandidate, 0, len(candidates))
+	for i, shares := range workledger.Shares(scores, totalShares) {
+		if shares == 0 {
+			continue
+		}
+		candidates[i].Shares = shares
+		scored = append(scored, candidates[i])
+	}
+	return newScoredRewardEpoch(kind, epoch, scored)
+}
+
+//rewardEpochAddresses : a REWARD-EPOCH record's candidate addresses, in hashing order. A candidate awarded several shares
+//appears once per share, so the mint contracts split rewards by share
+func rewardEpochAddresses(record types.RewardEpoch) []common.Address {
+	addresses := make([]common.Address, 0, len(record.Candidates))
+	for _, candidate := range record.Candidates {
+		address := common.HexToAddress(candidate.EthAddr)
+		addresses = append(addresses, address)
+		for i := 1; i < candidate.Shares; i++ {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+//Token contract functions that mint node and core rewards
+const (
+	MINT_NODES_METHOD = "mint"
+	MINT_CORES_METHOD = "mintCores"
+)
+
+//packMintCall : ABI-encodes a call to one of the token contract's mint functions, taking signatures as arranged by rewardsig.Encode
+func packMintCall(tokenABI abi.ABI, method string, candidates []common.Address, rewardHash []byte, sigs interface{}) ([]byte, error) {
+	if len(rewardHash) != 32 {
+		return nil, fmt.Errorf("reward hash is %d bytes, expected 32", len(rewardHash))
+	}
+	var hash [32]byte
+	copy(hash[:], rewardHash)
+	return tokenABI.Pack(method, candidates, hash, sigs)
+}
+
+//VerifyRewardEpoch : checks that a REWARD-EPOCH record is internally consistent: every candidate has evidence or work,
+//candidates appear once in hashing order, and hashing their addresses, repeated by share, reproduces the reward hash
+func VerifyRewardEpoch(record types.RewardEpoch) error {
+	if record.Kind != types.StakingKindNode && record.Kind != types.StakingKindCore {
+		return fmt.Errorf("unknown reward epoch kind %s", record.Kind)
+	}
+	if len(record.Candidates) == 0 {
+		return errors.New("reward epoch has no candidates")
+	}
+	for i, candidate := range record.Candidates {
+		if len(candidate.Evidence) == 0 && len(candidate.Work) == 0 {
+			return fmt.Errorf("reward candidate %s has no evidence", candidate.EthAddr)
+		}
+		if i > 0 && common.HexToAddress(record.Candidates[i-1].EthAddr).Hex() <= common.HexToAddress(candidate.EthAddr).Hex() {
+			return fmt.Errorf("reward candidate %s is out of order", candidate.EthAddr)
+		}
+	}
+	if hex.EncodeToString(ethcontracts.AddressesToHash(rewardEpochAddresses(record))) != record.RewardHash {
+		return errors.New("reward hash doesn't match the reward epoch's candidates")
+	}
+	return nil
+}
+
+//coreRewardRules : the Core work weights and reward shares fixed by a chain's genesis params, with the defaults for any it leaves unset
+func coreRewardRules(params types.ChainParams) (workledger.Weights, int, error) {
+	weights, err := workledger.ParseWeights(params.CoreWorkWeights, workledger.DefaultWeights())
+	if err != nil {
+		return nil, 0, err
+	}
+	shares := params.CoreRewardShares
+	if shares == 0 {
+		shares = DEFAULT_CORE_REWARD_SHARES
+	}
+	if shares < 0 {
+		return nil, 0, fmt.Errorf("core reward shares must be positive, not %d", shares)
+	}
+	return weights, shares, nil
+}
+
+//nodeRewardsBacked : checks a node REWARD-EPOCH record against the NODE-AUDIT txs committed for its epoch. Every candidate's
+//evidence must be committed audits from the epoch, issued by the Cores it names, that passed the candidate, and in each round
+//it cites, a quorum of distinct Cores must have passed it. Whether those Cores were the round's assigned auditors depends
+//on Ethereum staking state, so it's left to the Cores that sign the mint
+func nodeRewardsBacked(record types.RewardEpoch, committed func(string) (committedAudit, bool), quorum int) error {
+	for _, candidate := range record.Candidates {
+		address := common.HexToAddress(candidate.EthAddr).Hex()
+		rounds := make(map[int64]map[string]bool)
+		for _, item := range candidate.Evidence {
+			audit, ok := committed(item.TxHash)
+			if !ok || audit.Epoch != record.Epoch || audit.CoreID != item.CoreID || !util.Contains(audit.Passed, address) {
+				return fmt.Errorf("reward candidate %s cites evidence %s that isn't a committed passing audit from epoch %d", candidate.EthAddr, item.TxHash, record.Epoch)
+			}
+			if rounds[audit.Round] == nil {
+				rounds[audit.Round] = make(map[string]bool)
+			}
+			rounds[audit.Round][audit.CoreID] = true
+		}
+		for round, cores := range rounds {
+			if len(cores) < quorum {
+				return fmt.Errorf("reward candidate %s wasn't passed by a quorum of Cores in round %d", candidate.EthAddr, round)
+			}
+		}
+	}
+	return nil
+}
+
+//coreRewardsBacked : checks a Core REWARD-EPOCH record against the work credited in its epoch. Work keeps being credited
+//until the mint confirms, so no candidate may claim more of a kind than its address was credited with, and rescoring the
+//claimed work must reproduce the record's shares
+func coreRewardsBacked(record types.RewardEpoch, work map[common.Address]map[string]int64, weights workledger.Weights, totalShares int) error {
+	candidates := make([]types.RewardCandidate, 0, len(record.Candidates))
+	for _, candidate := range record.Candidates {
+		credited := work[common.HexToAddress(candidate.EthAddr)]
+		for kind, count := range candidate.Work {
+			if count > credited[kind] {
+				return fmt.Errorf("reward candidate %s claims %d %s work, but was credited with %d", candidate.EthAddr, count, kind, credited[kind])
+			}
+		}
+		candidates = append(candidates, types.RewardCandidate{EthAddr: candidate.EthAddr, Work: candidate.Work, Score: weights.Score(candidate.Work)})
+	}
+	if scoreRewardEpoch(record.Kind, record.Epoch, candidates, totalShares).RewardHash != record.RewardHash {
+		return errors.New("reward shares don't match the candidates' work")
+	}
+	return nil
+}
+
+//verifyRewardEvidence : checks a delivered REWARD-EPOCH record against committed state only: the NODE-AUDIT txs kept as
+//they were delivered, or the work ledger, the Core addresses bound in the registry and the chain's reward params. Eligibility
+//that depends on Ethereum staking state or penalties applied since the mint isn't rechecked here
+func (app *AnchorApplication) verifyRewardEvidence(record types.RewardEpoch) error {
+	if record.Kind == types.StakingKindCore {
+		weights, shares, err := coreRewardRules(app.state.ChainParams)
+		if err != nil {
+			return err
+		}
+		work, err := app.coreRewardWork(app.registry, record.Epoch, func(types.CoreWork, common.Address) bool { return true })
+		if err != nil {
+			return err
+		}
+		return coreRewardsBacked(record, work, weights, shares)
+	}
+	return nodeRewardsBacked(record, app.getCommittedAudit, NODE_AUDIT_QUORUM)
+}
+
+//putCommittedAudit : keeps the outcome of a NODE-AUDIT tx delivered once the registry is active, under the hash Tendermint
+//indexes it by, for rounds that have been reached
+func (app *AnchorApplication) putCommittedAudit(rawTx []byte, tx types.Tx, audit types.NodeAudit) {
+	if tx.CoreID == "" || audit.Round > app.state.Height || !app.activated(app.state.ChainParams.RegistryHeight) {
+		return
+	}
+	record := committedAudit{CoreID: tx.CoreID, Epoch: audit.Epoch, Round: audit.Round, Passed: []string{}}
+	for _, result := range audit.Results {
+		if result.Passed {
+			record.Passed = append(record.Passed, common.HexToAddress(result.EthAddr).Hex())
+		}
+	}
+	recordJSON, err := json.Marshal(record)
+	if app.LogError(err) != nil {
+		return
+	}
+	txHash := sha256.Sum256(rawTx)
+	app.Db.Set([]byte(committedAuditPrefix+hex.EncodeToString(txHash[:])), recordJSON)
+}
+
+//getCommittedAudit : the outcome of the committed NODE-AUDIT tx with a hash, as cited by REWARD-EPOCH evidence
+func (app *AnchorApplication) getCommittedAudit(txHash string) (committedAudit, bool) {
+	recordJSON := app.Db.Get([]byte(committedAuditPrefix + strings.ToLower(txHash)))
+	if recordJSON == nil {
+		return committedAudit{}, false
+	}
+	var record committedAudit
+	if app.LogError(json.Unmarshal(recordJSON, &record)) != nil {
+		return committedAudit{}, false
+	}
+	return record, true
+}
+
+//putMintRewardEpoch : keeps a mint's REWARD-EPOCH record under the mint's ethtx ID until the mint is confirmed. A record
+//already kept for a resent mint is left as is, since it's what that mint was sent with
+func (app *AnchorApplication) putMintRewardEpoch(id string, record types.RewardEpoch) error {
+	key := []byte(mintRewardEpochPrefix + id)
+	if app.Db.Get(key) != nil {
+		return nil
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	app.Db.SetSync(key, recordJSON)
+	return nil
+}
+
+//takeMintRewardEpoch : removes and returns the REWARD-EPOCH record kept for a mint
+func (app *AnchorApplication) takeMintRewardEpoch(id string) (types.RewardEpoch, bool) {
+	key := []byte(mintRewardEpochPrefix + id)
+	recordJSON := app.Db.Get(key)
+	if recordJSON == nil {
+		return types.RewardEpoch{}, false
+	}
+	app.Db.Delete(key)
+	var record types.RewardEpoch
+	if app.LogError(json.Unmarshal(recordJSON, &record)) != nil {
+		return types.RewardEpoch{}, false
+	}
+	return record, true
+}
+
+//BroadcastRewardEpoch : commits a mint's REWARD-EPOCH record to chain, tagged with its kind and epoch
+func (app *AnchorApplication) BroadcastRewardEpoch(record types.RewardEpoch) error {
+	recordJSON, err := json.Marshal(record)
+	if app.LogError(err) != nil {
+		return err
+	}
+	_, err = app.rpc.BroadcastTx("REWARD-EPOCH", string(recordJSON), 2, time.Now().Unix(), app.ID, &app.config.ECPrivateKey)
+	return app.LogError(err)
+}
+
+//rewardEpochTag : the tag key REWARD-EPOCH txs of a kind are indexed under
+func rewardEpochTag(kind string) string {
+	if kind == types.StakingKindCore {
+		return "COREEPOCH"
+	}
+	return "NODEEPOCH"
+}
@@ -0,0 +1,182 @@
+package abci
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/rewardsig"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/chp-project/chainpoint-core/go-abci-service/workledger"
+)
+
+func TestRewardEpochIsReproducible(t *testing.T) {
+	assert := assert.New(t)
+	evidence := map[common.Address][]types.RewardEvidence{
+		common.HexToAddress("0x1111111111111111111111111111111111111111"): {{TxType: "NODE-RC", TxHash: "AA", Time: 1, CoreID: "core1"}},
+		common.HexToAddress("0x3333333333333333333333333333333333333333"): {{TxType: "NODE-RC", TxHash: "BB", Time: 2, CoreID: "core2"}},
+		common.HexToAddress("0x2222222222222222222222222222222222222222"): {{TxType: "NODE-RC", TxHash: "CC", Time: 3, CoreID: "core1"}},
+	}
+	record := newRewardEpoch(types.StakingKindNode, 6400, evidence)
+	assert.Equal("0x3333333333333333333333333333333333333333", record.Candidates[0].EthAddr, "candidates should be in hashing order")
+	assert.Equal("0x1111111111111111111111111111111111111111", record.Candidates[2].EthAddr)
+	assert.NoError(VerifyRewardEpoch(record))
+
+	tampered := record
+	tampered.Candidates = append([]types.RewardCandidate{}, record.Candidates[:2]...)
+	assert.Error(VerifyRewardEpoch(tampered), "dropping a candidate should change the reward hash")
+
+	reordered := record
+	reordered.Candidates = []types.RewardCandidate{record.Candidates[1], record.Candidates[0], record.Candidates[2]}
+	assert.Error(VerifyRewardEpoch(reordered))
+
+	unjustified := newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{
+		common.HexToAddress("0x1111111111111111111111111111111111111111"): {},
+	})
+	assert.Error(VerifyRewardEpoch(unjustified), "every candidate needs evidence")
+}
+
+func TestScoredRewardEpochHashesShares(t *testing.T) {
+	assert := assert.New(t)
+	candidates := []types.RewardCandidate{
+		{EthAddr: "0x1111111111111111111111111111111111111111", Work: map[string]int64{"cal": 30}, Score: 30, Shares: 3},
+		{EthAddr: "0x2222222222222222222222222222222222222222", Work: map[string]int64{"btc_a": 1}, Score: 10, Shares: 1},
+	}
+	record := newScoredRewardEpoch(types.StakingKindCore, 6400, candidates)
+	assert.Equal("0x2222222222222222222222222222222222222222", record.Candidates[0].EthAddr)
+	assert.Len(rewardEpochAddresses(record), 4, "a candidate is hashed once per share")
+	assert.NoError(VerifyRewardEpoch(record))
+
+	inflated := record
+	inflated.Candidates = []types.RewardCandidate{record.Candidates[0], record.Candidates[1]}
+	inflated.Candidates[0].Shares = 2
+	assert.Error(VerifyRewardEpoch(inflated), "changing a candidate's shares should change the reward hash")
+
+	idle := newScoredRewardEpoch(types.StakingKindCore, 6400, []types.RewardCandidate{{EthAddr: "0x1111111111111111111111111111111111111111", Shares: 1}})
+	assert.Error(VerifyRewardEpoch(idle), "every candidate needs evidence or work")
+}
+
+func TestScoreRewardEpochSplitsSharesByScore(t *testing.T) {
+	assert := assert.New(t)
+	record := scoreRewardEpoch(types.StakingKindCore, 6400, []types.RewardCandidate{
+		{EthAddr: "0x1111111111111111111111111111111111111111", Work: map[string]int64{"cal": 31}, Score: 31},
+		{EthAddr: "0x2222222222222222222222222222222222222222", Work: map[string]int64{"nist": 9}, Score: 9},
+		{EthAddr: "0x3333333333333333333333333333333333333333", Work: map[string]int64{"audit": 2}, Score: 0},
+	}, 20)
+	assert.Len(record.Candidates, 2, "candidates that scored nothing aren't rewarded")
+	assert.Equal(5, record.Candidates[0].Shares)
+	assert.Equal(15, record.Candidates[1].Shares)
+	assert.Len(rewardEpochAddresses(record), 20)
+	assert.NoError(VerifyRewardEpoch(record))
+}
+
+func TestNodeRewardsBackedByCommittedAudits(t *testing.T) {
+	assert := assert.New(t)
+	node1 := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	node2 := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	audits := map[string]committedAudit{
+		"aa": {CoreID: "core1", Epoch: 6400, Round: 10, Passed: []string{node1.Hex(), node2.Hex()}},
+		"bb": {CoreID: "core2", Epoch: 6400, Round: 10, Passed: []string{node1.Hex()}},
+		"cc": {CoreID: "core3", Epoch: 6300, Round: 10, Passed: []string{node2.Hex()}},
+	}
+	committed := func(txHash string) (committedAudit, bool) {
+		audit, ok := audits[strings.ToLower(txHash)]
+		return audit, ok
+	}
+	quorum := []types.RewardEvidence{{TxType: "NODE-AUDIT", TxHash: "AA", Time: 1, CoreID: "core1"}, {TxType: "NODE-AUDIT", TxHash: "BB", Time: 2, CoreID: "core2"}}
+	record := newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{node1: quorum})
+	assert.NoError(nodeRewardsBacked(record, committed, 2))
+
+	forged := newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{
+		node1: {quorum[0], {TxType: "NODE-AUDIT", TxHash: "DD", Time: 2, CoreID: "core2"}},
+	})
+	assert.Error(nodeRewardsBacked(forged, committed, 2), "evidence must be a committed audit")
+
+	misattributed := newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{
+		node1: {quorum[0], {TxType: "NODE-AUDIT", TxHash: "BB", Time: 2, CoreID: "core3"}},
+	})
+	assert.Error(nodeRewardsBacked(misattributed, committed, 2), "evidence must name the Core that issued the audit")
+
+	failed := newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{node2: quorum})
+	assert.Error(nodeRewardsBacked(failed, committed, 2), "evidence for one node can't qualify another")
+
+	otherEpoch := newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{
+		node2: {quorum[0], {TxType: "NODE-AUDIT", TxHash: "CC", Time: 3, CoreID: "core3"}},
+	})
+	assert.Error(nodeRewardsBacked(otherEpoch, committed, 2), "audits from another epoch don't count")
+
+	noQuorum := newRewardEpoch(types.StakingKindNode, 6400, map[common.Address][]types.RewardEvidence{node2: quorum[:1]})
+	assert.Error(nodeRewardsBacked(noQuorum, committed, 2), "a single Core's pass isn't a quorum")
+}
+
+func TestCoreRewardRules(t *testing.T) {
+	assert := assert.New(t)
+	weights, shares, err := coreRewardRules(types.ChainParams{})
+	assert.NoError(err)
+	assert.Equal(workledger.DefaultWeights(), weights)
+	assert.Equal(DEFAULT_CORE_REWARD_SHARES, shares, "chains without reward params use the defaults")
+
+	weights, shares, err = coreRewardRules(types.ChainParams{CoreWorkWeights: "cal=2", CoreRewardShares: 30})
+	assert.NoError(err)
+	assert.Equal(int64(2), weights[workledger.WorkCal])
+	assert.Equal(30, shares)
+
+	_, _, err = coreRewardRules(types.ChainParams{CoreWorkWeights: "cal"})
+	assert.Error(err)
+	_, _, err = coreRewardRules(types.ChainParams{CoreRewardShares: -1})
+	assert.Error(err)
+}
+
+func TestCoreRewardsBackedByCreditedWork(t *testing.T) {
+	assert := assert.New(t)
+	weights := workledger.DefaultWeights()
+	core1 := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	core2 := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	credited := map[common.Address]map[string]int64{
+		core1: {workledger.WorkCal: 40},
+		core2: {workledger.WorkBtcA: 1, workledger.WorkCal: 5},
+	}
+	record := scoreRewardEpoch(types.StakingKindCore, 6400, []types.RewardCandidate{
+		{EthAddr: core1.Hex(), Work: map[string]int64{workledger.WorkCal: 30}, Score: 30},
+		{EthAddr: core2.Hex(), Work: map[string]int64{workledger.WorkBtcA: 1}, Score: 20},
+	}, 10)
+	assert.NoError(coreRewardsBacked(record, credited, weights, 10), "work credited after the mint shouldn't invalidate it")
+
+	inflated := scoreRewardEpoch(types.StakingKindCore, 6400, []types.RewardCandidate{
+		{EthAddr: core1.Hex(), Work: map[string]int64{workledger.WorkCal: 50}, Score: 50},
+	}, 10)
+	assert.Error(coreRewardsBacked(inflated, credited, weights, 10), "a candidate can't claim more work than it was credited")
+
+	overweighted := scoreRewardEpoch(types.StakingKindCore, 6400, []types.RewardCandidate{
+		{EthAddr: core1.Hex(), Work: map[string]int64{workledger.WorkCal: 30}, Score: 90},
+		{EthAddr: core2.Hex(), Work: map[string]int64{workledger.WorkBtcA: 1}, Score: 20},
+	}, 10)
+	assert.Error(coreRewardsBacked(overweighted, credited, weights, 10), "shares must follow the committed weights")
+}
+
+func TestPackMintCall(t *testing.T) {
+	assert := assert.New(t)
+	tokenABI, err := abi.JSON(strings.NewReader(`[
+		{"type":"function","name":"mint","inputs":[{"name":"_nodes","type":"address[]"},{"name":"_hash","type":"bytes32"},{"name":"_sigs","type":"bytes[]"}],"outputs":[]},
+		{"type":"function","name":"mintCores","inputs":[{"name":"_cores","type":"address[]"},{"name":"_hash","type":"bytes32"},{"name":"_sigs","type":"bytes[2]"}],"outputs":[]}
+	]`))
+	assert.NoError(err)
+	candidates := []common.Address{common.HexToAddress("0x1111111111111111111111111111111111111111")}
+	rewardHash := make([]byte, 32)
+
+	data, err := packMintCall(tokenABI, MINT_NODES_METHOD, candidates, rewardHash, [][]byte{{0x01}})
+	assert.NoError(err)
+	assert.Equal(tokenABI.Methods[MINT_NODES_METHOD].Id(), data[:4])
+	sigs, _ := rewardsig.Encode([][]byte{{0x01}}, 2)
+	data, err = packMintCall(tokenABI, MINT_CORES_METHOD, candidates, rewardHash, sigs)
+	assert.NoError(err)
+	assert.Equal(tokenABI.Methods[MINT_CORES_METHOD].Id(), data[:4])
+
+	_, err = packMintCall(tokenABI, MINT_NODES_METHOD, candidates, rewardHash[:31], [][]byte{{0x01}})
+	assert.Error(err)
+	_, err = packMintCall(abi.ABI{}, MINT_NODES_METHOD, candidates, rewardHash, [][]byte{{0x01}})
+	assert.Error(err, "mint calls can't be packed without the token contract's ABI")
+}
@@ -1,10 +1,12 @@
 package abci
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/chainpoint/tendermint/libs/log"
 	core_types "github.com/chainpoint/tendermint/rpc/core/types"
@@ -14,6 +16,21 @@ import (
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
 )
 
+//TX_SEARCH_PAGE_SIZE : txs fetched per TxSearch page, the most Tendermint returns
+const TX_SEARCH_PAGE_SIZE = 100
+
+//TX_SEARCH_CONCURRENCY : how many TxSearch pages are fetched at once
+const TX_SEARCH_CONCURRENCY = 4
+
+//TX_SEARCH_MAX_RESULTS : safety cap on the txs one search iterates, so a runaway query can't tie up the Tendermint RPC
+const TX_SEARCH_MAX_RESULTS = 10000
+
+//ErrTxSearchCapped : returned once a search has iterated TX_SEARCH_MAX_RESULTS txs of a query that matched more
+var ErrTxSearchCapped = errors.New("tx search matched more txs than the safety cap, later txs were skipped")
+
+//txSearchFunc : Tendermint's TxSearch RPC
+type txSearchFunc func(query string, prove bool, page int, perPage int) (*core_types.ResultTxSearch, error)
+
 // RPC : hold abstract http client for mocking purposes
 type RPC struct {
 	client *client.HTTP
@@ -66,7 +83,12 @@ func (rpc *RPC) BroadcastMsg(tx types.Tx) (core_types.ResultBroadcastMsg, error)
 
 // BroadcastTxCommit : Synchronously broadcasts a transaction to the local Tendermint node THIS IS BLOCKING
 func (rpc *RPC) BroadcastTxCommit(txType string, data string, version int64, time int64, stackID string, privateKey *ecdsa.PrivateKey) (core_types.ResultBroadcastTxCommit, error) {
-	tx := types.Tx{TxType: txType, Data: data, Version: version, Time: time, CoreID: stackID}
+	return rpc.BroadcastTxCommitWithMeta(txType, data, version, time, stackID, "", privateKey)
+}
+
+// BroadcastTxCommitWithMeta : Synchronously broadcasts a transaction with metadata to the local Tendermint node THIS IS BLOCKING
+func (rpc *RPC) BroadcastTxCommitWithMeta(txType string, data string, version int64, time int64, stackID string, meta string, privateKey *ecdsa.PrivateKey) (core_types.ResultBroadcastTxCommit, error) {
+	tx := types.Tx{TxType: txType, Data: data, Version: version, Time: time, CoreID: stackID, Meta: meta}
 	result, err := rpc.client.BroadcastTxCommit([]byte(util.EncodeTxWithKey(tx, privateKey)))
 	if rpc.LogError(err) != nil {
 		return core_types.ResultBroadcastTxCommit{}, err
@@ -100,11 +122,95 @@ func (rpc *RPC) GetNetInfo() (core_types.ResultNetInfo, error) {
 
 //GetTxByInt : Retrieves a tx by its unique integer ID (txInt)
 func (rpc *RPC) GetTxByInt(txInt int64) (core_types.ResultTxSearch, error) {
-	txResult, err := rpc.client.TxSearch(fmt.Sprintf("TxInt=%d", txInt), false, 1, 1)
+	txResult := core_types.ResultTxSearch{Txs: []*core_types.ResultTx{}}
+	total, err := rpc.SearchTxs(context.Background(), fmt.Sprintf("TxInt=%d", txInt), func(tx *core_types.ResultTx) bool {
+		txResult.Txs = append(txResult.Txs, tx)
+		return true
+	})
 	if rpc.LogError(err) != nil {
 		return core_types.ResultTxSearch{}, err
 	}
-	return *txResult, err
+	txResult.TotalCount = total
+	return txResult, nil
+}
+
+//SearchTxs : calls fn with every tx matching a TxSearch query, in result order, until fn returns false or ctx is done.
+//Returns how many txs the query matched, and ErrTxSearchCapped if only the first TX_SEARCH_MAX_RESULTS were iterated
+func (rpc *RPC) SearchTxs(ctx context.Context, query string, fn func(*core_types.ResultTx) bool) (int, error) {
+	total, err := searchTxs(ctx, rpc.client.TxSearch, query, TX_SEARCH_PAGE_SIZE, TX_SEARCH_CONCURRENCY, TX_SEARCH_MAX_RESULTS, fn)
+	if err == ErrTxSearchCapped {
+		rpc.logger.Error(fmt.Sprintf("TxSearch: query %s matched %d txs, only the first %d were read", query, total, TX_SEARCH_MAX_RESULTS))
+	}
+	return total, err
+}
+
+//searchTxs : pages through a query's results, learning the total from the first page and then fetching up to concurrency
+//pages at a time. Pages are handed to fn in order, skipping any tx already seen on an earlier page in case results shifted
+//between requests. A request in flight isn't interrupted when ctx is done, but no further pages are requested
+func searchTxs(ctx context.Context, search txSearchFunc, query string, perPage int, concurrency int, maxResults int, fn func(*core_types.ResultTx) bool) (int, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	first, err := search(query, false, 1, perPage)
+	if err != nil {
+		return 0, err
+	}
+	total := first.TotalCount
+	limit := total
+	if limit > maxResults {
+		limit = maxResults
+	}
+	pages := (limit + perPage - 1) / perPage
+	seen := make(map[string]bool)
+	delivered, stopped := 0, false
+	deliver := func(txs []*core_types.ResultTx) {
+		for _, tx := range txs {
+			if stopped || delivered >= limit {
+				return
+			}
+			key := fmt.Sprintf("%d/%d", tx.Height, tx.Index)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			delivered++
+			stopped = !fn(tx)
+		}
+	}
+	deliver(first.Txs)
+	for page := 2; page <= pages && !stopped && delivered < limit; page += concurrency {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		batch := pages - page + 1
+		if batch > concurrency {
+			batch = concurrency
+		}
+		results := make([]*core_types.ResultTxSearch, batch)
+		errs := make([]error, batch)
+		var wg sync.WaitGroup
+		for i := 0; i < batch; i++ {
+			wg.Add(1)
+			go func(i int, page int) {
+				defer wg.Done()
+				results[i], errs[i] = search(query, false, page, perPage)
+			}(i, page+i)
+		}
+		wg.Wait()
+		for i := 0; i < batch; i++ {
+			if errs[i] != nil {
+				return total, errs[i]
+			}
+			deliver(results[i].Txs)
+		}
+	}
+	if !stopped && total > maxResults {
+		return total, ErrTxSearchCapped
+	}
+	return total, nil
 }
 
 // GetAbciInfo retrieves custom ABCI status struct detailing the state of our application
@@ -126,3 +232,12 @@ func (rpc *RPC) GetValidators(height int64) (core_types.ResultValidators, error)
 	}
 	return *resp, nil
 }
+
+//GetBlockHash : retrieves the hash of the block at a particular height
+func (rpc *RPC) GetBlockHash(height int64) (string, error) {
+	resp, err := rpc.client.Block(&height)
+	if err != nil {
+		return "", err
+	}
+	return resp.BlockMeta.BlockID.Hash.String(), nil
+}
@@ -0,0 +1,108 @@
+package abci
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	core_types "github.com/chainpoint/tendermint/rpc/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//fakeTxSearch : serves count txs a page at a time, recording the pages requested and how many were in flight at once
+type fakeTxSearch struct {
+	count       int
+	failPage    int
+	mux         sync.Mutex
+	pages       []int
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *fakeTxSearch) search(query string, prove bool, page int, perPage int) (*core_types.ResultTxSearch, error) {
+	f.mux.Lock()
+	f.pages = append(f.pages, page)
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mux.Unlock()
+	time.Sleep(time.Millisecond)
+	defer func() {
+		f.mux.Lock()
+		f.inFlight--
+		f.mux.Unlock()
+	}()
+	if page == f.failPage {
+		return nil, errors.New("rpc failure")
+	}
+	result := &core_types.ResultTxSearch{TotalCount: f.count, Txs: []*core_types.ResultTx{}}
+	for i := (page - 1) * perPage; i < page*perPage && i < f.count; i++ {
+		result.Txs = append(result.Txs, &core_types.ResultTx{Height: int64(i)})
+	}
+	return result, nil
+}
+
+func TestSearchTxsPagesThroughEveryResult(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeTxSearch{count: 950}
+	heights := make([]int64, 0)
+	total, err := searchTxs(context.Background(), fake.search, "NODEAUDIT=1", 100, 3, 10000, func(tx *core_types.ResultTx) bool {
+		heights = append(heights, tx.Height)
+		return true
+	})
+	assert.NoError(err)
+	assert.Equal(950, total)
+	assert.Len(heights, 950)
+	for i, height := range heights {
+		if !assert.Equal(int64(i), height, "txs should be delivered in result order") {
+			break
+		}
+	}
+	assert.Len(fake.pages, 10)
+	assert.True(fake.maxInFlight <= 3, "no more than concurrency pages should be fetched at once")
+}
+
+func TestSearchTxsStopsEarly(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeTxSearch{count: 950}
+	seen := 0
+	_, err := searchTxs(context.Background(), fake.search, "BTCTX='a'", 100, 3, 10000, func(tx *core_types.ResultTx) bool {
+		seen++
+		return false
+	})
+	assert.NoError(err)
+	assert.Equal(1, seen)
+	assert.Equal([]int{1}, fake.pages, "no more pages are fetched once fn stops the search")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fake = &fakeTxSearch{count: 950}
+	_, err = searchTxs(ctx, fake.search, "NODEAUDIT=1", 100, 3, 10000, func(tx *core_types.ResultTx) bool {
+		cancel()
+		return true
+	})
+	assert.Equal(context.Canceled, err)
+	assert.Equal([]int{1}, fake.pages)
+}
+
+func TestSearchTxsReportsCapAndErrors(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeTxSearch{count: 950}
+	seen := 0
+	total, err := searchTxs(context.Background(), fake.search, "NODEAUDIT=1", 100, 3, 250, func(tx *core_types.ResultTx) bool {
+		seen++
+		return true
+	})
+	assert.Equal(ErrTxSearchCapped, err)
+	assert.Equal(950, total)
+	assert.Equal(250, seen)
+	assert.Len(fake.pages, 3)
+
+	fake = &fakeTxSearch{count: 950, failPage: 4}
+	_, err = searchTxs(context.Background(), fake.search, "NODEAUDIT=1", 100, 3, 10000, func(tx *core_types.ResultTx) bool {
+		return true
+	})
+	assert.Error(err)
+}
@@ -0,0 +1,45 @@
+package abci
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/ethsync"
+)
+
+// REGISTRY_RECHECK_DEPTH : blocks behind the ingestion cursor whose registry events are checked against the chain before each poll
+const REGISTRY_RECHECK_DEPTH = 10000
+
+//SyncRegistryFromContract : keep a registry entity's staking ledger and current-state table in sync with the registry contract.
+//Node and Core adapters live beside the rest of their entity's logic; any new registry entity only needs its own adapter
+func (app *AnchorApplication) SyncRegistryFromContract(adapter ethsync.Adapter) {
+	engine := ethsync.Engine{
+		Adapter:       adapter,
+		Ledger:        app.pgClient,
+		HighestBlock:  app.ethClient.HighestBlock,
+		BlockHash:     app.ethBlockHash,
+		StartBlock:    app.config.EthConfig.RegistryStartBlock,
+		Confirmations: app.config.EthConfig.Confirmations,
+		RecheckDepth:  REGISTRY_RECHECK_DEPTH,
+		PageSize:      app.config.EthConfig.LogPageSize,
+		PollInterval:  30 * time.Second,
+		Logger:        app.logger,
+	}
+	if app.ethWSClient != nil {
+		engine.Logs = app.ethWSClient
+		engine.Contract = common.HexToAddress(app.config.EthConfig.RegistryContractAddr)
+	}
+	engine.Run(nil)
+}
+
+//ethBlockHash : hash of the canonical ethereum block at a height
+func (app *AnchorApplication) ethBlockHash(block int64) (common.Hash, error) {
+	header, err := app.ethTx.Backend.HeaderByNumber(context.Background(), big.NewInt(block))
+	if app.LogError(err) != nil {
+		return common.Hash{}, err
+	}
+	return header.Hash(), nil
+}
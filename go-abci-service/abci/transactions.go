@@ -1,13 +1,17 @@
 package abci
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/chp-project/chainpoint-core/go-abci-service/rewardsig"
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	ethcommon "github.com/ethereum/go-ethereum/common"
 
 	types2 "github.com/chainpoint/tendermint/abci/types"
 
@@ -15,6 +19,7 @@ import (
 	"github.com/chainpoint/tendermint/libs/common"
 	core_types "github.com/chainpoint/tendermint/rpc/core/types"
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+	"github.com/chp-project/chainpoint-core/go-abci-service/workledger"
 )
 
 // incrementTxInt: Helper method to increment transaction integer
@@ -26,7 +31,9 @@ func (app *AnchorApplication) incrementTxInt(tags []common.KVPair) []common.KVPa
 func (app *AnchorApplication) validateGossip(rawTx []byte) types2.ResponseCheckTx {
 	var tx types.Tx
 	var err error
-	if app.state.ChainSynced {
+	if app.activated(app.state.ChainParams.RegistryHeight) {
+		tx, err = verifyRegisteredTx(app.committedRegistry(), rawTx)
+	} else if app.state.ChainSynced {
 		tx, err = util.DecodeVerifyTx(rawTx, app.CoreKeys)
 	} else {
 		tx, err = util.DecodeTx(rawTx)
@@ -54,17 +61,13 @@ func (app *AnchorApplication) validateGossip(rawTx []byte) types2.ResponseCheckT
 
 // updateStateFromTx: Updates state based on type of transaction received. Used by DeliverTx
 func (app *AnchorApplication) updateStateFromTx(rawTx []byte, gossip bool) types2.ResponseDeliverTx {
-	var tx types.Tx
-	var err error
 	var resp types2.ResponseDeliverTx
 	tags := []common.KVPair{}
-	if app.state.ChainSynced {
-		tx, err = util.DecodeVerifyTx(rawTx, app.CoreKeys)
-	} else {
-		tx, err = util.DecodeTx(rawTx)
-	}
+	tx, err := app.decodeTx(rawTx)
 	app.logger.Info(fmt.Sprintf("Received Tx: %s, Gossip: %t", tx.TxType, gossip))
-	app.LogError(err)
+	if err != nil {
+		app.logUnverifiedTx(rawTx, err)
+	}
 	switch string(tx.TxType) {
 	/*	case "VAL":
 		tags = app.incrementTxInt(tags)
@@ -75,6 +78,13 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte, gossip bool) types
 	case "CAL":
 		tags = app.incrementTxInt(tags)
 		app.state.LatestCalTxInt = app.state.TxInt
+		tags = append(tags, common.KVPair{Key: []byte("CALROOT"), Value: []byte(tx.Data)})
+		if !gossip {
+			if app.calMMRActivated() {
+				tags = app.appendCalMMR(tx, tags)
+			}
+			app.creditCoreWork(rawTx, tx, workledger.WorkCal)
+		}
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		break
 	case "BTC-A":
@@ -88,6 +98,12 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte, gossip bool) types
 			app.logger.Info(fmt.Sprintf("BTC-A Anchor Data: %s", tx.Data))
 		}
 		app.state.LatestBtcaTx = rawTx
+		if !gossip {
+			app.creditCoreWork(rawTx, tx, workledger.WorkBtcA)
+			if app.activated(app.state.ChainParams.RegistryHeight) {
+				app.setRegistryBtcaCore(btca.BtcTxID, tx.CoreID) // lets BTC-Cs be checked against the Core that anchored
+			}
+		}
 		app.state.LatestBtcaHeight = app.state.Height + 1
 		tags = app.incrementTxInt(tags)
 		app.state.LatestBtcaTxInt = app.state.TxInt
@@ -105,10 +121,17 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte, gossip bool) types
 			app.state.LastAnchorCoreID = meta[0]
 			tags = append(tags, common.KVPair{Key: []byte("CORERC"), Value: util.Int64ToByte(app.state.LastCoreMintedAtBlock)})
 		}
+		if !gossip {
+			app.detectMisbehavior(rawTx, tx)
+			app.creditCoreWork(rawTx, tx, workledger.WorkBtcC)
+		}
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		break
 	case "NIST":
 		app.state.LatestNistRecord = tx.Data
+		if !gossip {
+			app.creditCoreWork(rawTx, tx, workledger.WorkNist)
+		}
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		if app.config.DoCal {
 			app.aggregator.LatestNist = app.state.LatestNistRecord
@@ -135,15 +158,23 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte, gossip bool) types
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		break
 	case "JWK":
+		if gossip {
+			resp = types2.ResponseDeliverTx{Code: code.CodeTypeUnauthorized, Tags: tags}
+			break
+		}
+		if app.activated(app.state.ChainParams.RegistryHeight) && util.LoggerError(app.logger, app.registerCoreKey(tx)) != nil {
+			resp = types2.ResponseDeliverTx{Code: code.CodeTypeUnauthorized, Tags: tags}
+			break
+		}
 		app.SaveJWK(tx)
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		break
 	case "CORE-SIGN":
-		app.CoreRewardSignatures = util.UniquifyStrings(append(app.CoreRewardSignatures, tx.Data))
+		go app.collectRewardSignature(app.CoreRewardSignatures, tx)
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		break
 	case "NODE-SIGN":
-		app.NodeRewardSignatures = util.UniquifyStrings(append(app.NodeRewardSignatures, tx.Data))
+		go app.collectRewardSignature(app.NodeRewardSignatures, tx)
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		break
 	case "NODE-RC":
@@ -151,7 +182,70 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte, gossip bool) types
 		tags = append(tags, common.KVPair{Key: []byte("NODERC"), Value: util.Int64ToByte(app.state.LastNodeMintedAtBlock)})
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		break
+	case "NODE-AUDIT":
+		var audit types.NodeAudit
+		if util.LoggerError(app.logger, json.Unmarshal([]byte(tx.Data), &audit)) != nil {
+			resp = types2.ResponseDeliverTx{Code: code.CodeTypeEncodingError, Tags: tags}
+			break
+		}
+		tags = app.incrementTxInt(tags)
+		tags = append(tags, common.KVPair{Key: []byte("NODEAUDIT"), Value: util.Int64ToByte(audit.Epoch)})
+		if !gossip {
+			app.detectMisbehavior(rawTx, tx)
+			app.creditCoreAudit(tx, audit)
+			app.putCommittedAudit(rawTx, tx, audit)
+		}
+		if tx.CoreID != app.ID {
+			go app.recordNodeAudits(tx, audit)
+		}
+		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
+		break
+	case "REWARD-EPOCH":
+		if !gossip {
+			app.detectMisbehavior(rawTx, tx)
+		}
+		var record types.RewardEpoch
+		if util.LoggerError(app.logger, json.Unmarshal([]byte(tx.Data), &record)) != nil {
+			resp = types2.ResponseDeliverTx{Code: code.CodeTypeEncodingError, Tags: tags}
+			break
+		}
+		if util.LoggerError(app.logger, VerifyRewardEpoch(record)) != nil {
+			resp = types2.ResponseDeliverTx{Code: code.CodeTypeUnauthorized, Tags: tags}
+			break
+		}
+		// evidence is only committed once the registry is active
+		if !gossip && app.activated(app.state.ChainParams.RegistryHeight) && util.LoggerError(app.logger, app.verifyRewardEvidence(record)) != nil {
+			resp = types2.ResponseDeliverTx{Code: code.CodeTypeUnauthorized, Tags: tags}
+			break
+		}
+		if !gossip {
+			app.putRewardPasses(record) // weights the next epoch's audits when audit_weighting is audit_history
+		}
+		tags = append(tags, common.KVPair{Key: []byte(rewardEpochTag(record.Kind)), Value: util.Int64ToByte(record.Epoch)})
+		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
+		break
+	case "MISBEHAVIOR":
+		var evidence types.Misbehavior
+		if util.LoggerError(app.logger, json.Unmarshal([]byte(tx.Data), &evidence)) != nil {
+			resp = types2.ResponseDeliverTx{Code: code.CodeTypeEncodingError, Tags: tags}
+			break
+		}
+		if gossip {
+			err = VerifyMisbehavior(evidence, app.committedRegistry(), app.btcaCoreID)
+		} else {
+			err = app.penalizeCore(evidence)
+		}
+		if util.LoggerError(app.logger, err) != nil {
+			resp = types2.ResponseDeliverTx{Code: code.CodeTypeUnauthorized, Tags: tags}
+			break
+		}
+		tags = append(tags, common.KVPair{Key: []byte("MISBEHAVIOR"), Value: []byte(evidence.CoreID)})
+		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
+		break
 	case "TOKEN":
+		if !gossip && app.activated(app.state.ChainParams.RegistryHeight) {
+			tags, _ = app.updateRegistryToken(tx, tags)
+		}
 		go app.pgClient.TokenHashUpsert(tx.Data)
 		resp = types2.ResponseDeliverTx{Code: code.CodeTypeOK, Tags: tags}
 		break
@@ -161,6 +255,35 @@ func (app *AnchorApplication) updateStateFromTx(rawTx []byte, gossip bool) types
 	return resp
 }
 
+// appendCalMMR: Adds a CAL root to the calendar MMR and tags the tx with its leaf index
+func (app *AnchorApplication) appendCalMMR(tx types.Tx, tags []common.KVPair) []common.KVPair {
+	calRoot, err := hex.DecodeString(tx.Data)
+	if util.LoggerError(app.logger, err) != nil {
+		calRoot = []byte(tx.Data) // still accumulate so every Core agrees on the leaf count
+	}
+	leafIndex := app.calMMR.Append(calRoot)
+	return append(tags, common.KVPair{Key: []byte("CALMMR"), Value: util.Int64ToByte(int64(leafIndex))})
+}
+
+// collectRewardSignature: Adds a SIGN tx's signature to a collector under the Ethereum address its sending Core staked with
+func (app *AnchorApplication) collectRewardSignature(collector *rewardsig.Collector, tx types.Tx) {
+	core, err := app.pgClient.GetCoreByID(tx.CoreID)
+	if app.LogError(err) != nil {
+		return
+	}
+	if core.EthAddr == "" {
+		app.logger.Info(fmt.Sprintf("Mint: ignoring %s from unstaked Core %s", tx.TxType, tx.CoreID))
+		return
+	}
+	sig, err := hex.DecodeString(tx.Data)
+	if err == nil {
+		err = collector.Add(ethcommon.HexToAddress(core.EthAddr), sig)
+	}
+	if err != nil {
+		app.logger.Info(fmt.Sprintf("Mint: rejected %s from Core %s: %s", tx.TxType, tx.CoreID, err.Error()))
+	}
+}
+
 // GetTxRange gets all CAL TXs within a particular range
 func (app *AnchorApplication) getCalTxRange(minTxInt int64, maxTxInt int64) ([]core_types.ResultTx, error) {
 	if maxTxInt <= minTxInt {
@@ -168,13 +291,12 @@ func (app *AnchorApplication) getCalTxRange(minTxInt int64, maxTxInt int64) ([]c
 	}
 	Txs := []core_types.ResultTx{}
 	for i := minTxInt; i <= maxTxInt; i++ {
-		txResult, err := app.rpc.client.TxSearch(fmt.Sprintf("TxInt=%d", i), false, 1, 1)
+		_, err := app.rpc.SearchTxs(context.Background(), fmt.Sprintf("TxInt=%d", i), func(tx *core_types.ResultTx) bool {
+			Txs = append(Txs, *tx)
+			return true
+		})
 		if err != nil {
 			return nil, err
-		} else if txResult.TotalCount > 0 {
-			for _, tx := range txResult.Txs {
-				Txs = append(Txs, *tx)
-			}
 		}
 	}
 	return Txs, nil
@@ -9,129 +9,164 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/chainpoint/tendermint/libs/log"
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
 
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
-	"github.com/google/uuid"
 
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
 	"github.com/chp-project/chainpoint-core/go-abci-service/merkletools"
-	"github.com/chp-project/chainpoint-core/go-abci-service/rabbitmq"
-	"github.com/streadway/amqp"
+	"github.com/chp-project/chainpoint-core/go-abci-service/outbox"
+	"github.com/chp-project/chainpoint-core/go-abci-service/schema"
 )
 
-const msgType = "aggregator"
-const aggQueueIn = "work.agg"
-const proofStateQueueOut = "work.proofstate"
+const maxPrefetch = 65535
 
-// Aggregator : object includes rabbitURI and Logger
+// Aggregator : object includes the message Bus and Logger. Aggregations are recorded in Outbox, when set, before their hashes are acked
 type Aggregator struct {
-	RabbitmqURI  string
+	Bus          bus.Bus
 	Logger       log.Logger
+	Outbox       *outbox.Outbox
 	LatestNist   string
 	Aggregations []types.Aggregation
 	AggMutex     sync.Mutex
-	RestartMutex sync.Mutex
-	TempStop     chan struct{}
-	WaitGroup    sync.WaitGroup
+	BatchSize    int
+	pending      []pendingHash
+	pendingMutex sync.Mutex
 }
 
+// pendingHash : a delivery waiting for its batch to be aggregated. The batch outcome is sent on result
+type pendingHash struct {
+	msg    bus.Message
+	result chan bus.Result
+}
+
+// AggregateAndReset : aggregates any hashes still waiting in the current batch, then returns and clears all aggregations
 func (aggregator *Aggregator) AggregateAndReset() []types.Aggregation {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered", r)
-		}
-	}()
-	close(aggregator.TempStop)
-	aggregator.RestartMutex.Lock()
+	aggregator.flush()
+	aggregator.AggMutex.Lock()
 	aggregations := make([]types.Aggregation, len(aggregator.Aggregations))
 	if len(aggregator.Aggregations) > 0 {
 		copy(aggregations, aggregator.Aggregations)
 		aggregator.Aggregations = make([]types.Aggregation, 0)
 	}
+	aggregator.AggMutex.Unlock()
 	aggregator.Logger.Info(fmt.Sprintf("Retrieved aggregation tree of %d items and resetting", len(aggregations)))
-	aggregator.RestartMutex.Unlock()
 	return aggregations
 }
 
-// ReceiveCalRMQ : Continually consume the calendar work queue and
-// process any resulting messages from the tx and monitor services
+// RequeueHashes : Publishes the hashes of aggregations that were excluded from a calendar tree back onto the aggregation queue,
+// so that they are included in the next CAL. Aggregation IDs are derived from their contents, so aggregations sharing an ID
+// hold the same hashes and are only requeued once. Fully requeued aggregations are dropped from the outbox
+func (aggregator *Aggregator) RequeueHashes(aggs []types.Aggregation) error {
+	var lastErr error
+	requeuedAggIDs := map[string]bool{}
+	for _, agg := range aggs {
+		if agg.AggID != "" && requeuedAggIDs[agg.AggID] {
+			aggregator.Logger.Info(fmt.Sprintf("Skipping duplicate aggregation %s", agg.AggID))
+			continue
+		}
+		requeuedAggIDs[agg.AggID] = true
+		requeued := true
+		for _, proofData := range agg.ProofData {
+			hashItemJSON, err := json.Marshal(types.HashItem{HashID: proofData.HashID, Hash: proofData.Hash})
+			if util.LogError(err) != nil {
+				lastErr = err
+				requeued = false
+				continue
+			}
+			if err := aggregator.Bus.Publish(bus.Message{Topic: bus.TopicAgg, Body: hashItemJSON}); err != nil {
+				aggregator.Logger.Error(fmt.Sprintf("failed to requeue hash %s: %s", proofData.HashID, err.Error()))
+				lastErr = err
+				requeued = false
+			}
+		}
+		aggregator.Logger.Info(fmt.Sprintf("Requeued %d hashes from aggregation %s", len(agg.ProofData), agg.AggID))
+		if requeued && aggregator.Outbox != nil {
+			aggregator.Outbox.RemoveAggregation(agg.AggID)
+		}
+	}
+	return lastErr
+}
+
+// StartAggregation : Continually consume the aggregation work queue. Hashes are batched and only acked
+// once the aggregation containing them has been recorded and published
 func (aggregator *Aggregator) StartAggregation() error {
-	var session rabbitmq.Session
-	var err error
 	aggThreads, _ := strconv.Atoi(util.GetEnv("AGGREGATION_THREADS", "4"))
 	hashBatchSize, _ := strconv.Atoi(util.GetEnv("HASHES_PER_MERKLE_TREE", "25000"))
 	aggregator.Logger.Info(fmt.Sprintf("Starting aggregation with %d threads and %d batch size", aggThreads, hashBatchSize))
-	connected := false
-	//Consume queue in goroutines with output slice guarded by mutex
+	aggregator.BatchSize = hashBatchSize
+	//Handlers block until their batch is aggregated, so prefetch must leave room for {aggThreads} batches in flight
+	prefetch := aggThreads * hashBatchSize
+	if prefetch > maxPrefetch {
+		prefetch = maxPrefetch
+	}
+	aggregator.AggMutex.Lock()
 	aggregator.Aggregations = make([]types.Aggregation, 0)
-	for {
-		aggregator.RestartMutex.Lock()
-		aggregator.TempStop = make(chan struct{})
-		if !connected {
-			session, err = rabbitmq.ConnectAndConsume(aggregator.RabbitmqURI, aggQueueIn)
-			if rabbitmq.LogError(err, "RabbitMQ connection failed") != nil {
-				rabbitmq.LogError(err, "failed to dial for work.in queue")
-				time.Sleep(5 * time.Second)
-				aggregator.RestartMutex.Unlock()
-				continue
-			}
-			connected = true
-		}
-		consume := true
-		// Spin up {aggThreads} number of threads to process incoming hashes from the API
-		for i := 0; i < aggThreads; i++ {
-			go func() {
-				msgStructSlice := make([]amqp.Delivery, 0)
-				aggregator.WaitGroup.Add(1)
-				defer aggregator.WaitGroup.Done()
-				for connected && consume {
-					select {
-					case <-aggregator.TempStop:
-						consume = false
-						break
-					case err = <-session.Notify:
-						connected = false
-						break
-					case hash := <-session.Msgs:
-						if len(hash.Body) > 0 {
-							msgStructSlice = append(msgStructSlice, hash)
-							aggregator.Logger.Info(fmt.Sprintf("Hash: %s", string(hash.Body)))
-							//create new agg roots under heavy load
-							if len(msgStructSlice) > hashBatchSize {
-								if agg := aggregator.ProcessAggregation(msgStructSlice, aggregator.LatestNist); agg.AggRoot != "" {
-									aggregator.AggMutex.Lock()
-									aggregator.Aggregations = append(aggregator.Aggregations, agg)
-									aggregator.AggMutex.Unlock()
-									msgStructSlice = make([]amqp.Delivery, 0)
-								}
-							}
-						}
-					}
-				}
-				if len(msgStructSlice) > 0 {
-					if agg := aggregator.ProcessAggregation(msgStructSlice, aggregator.LatestNist); agg.AggRoot != "" {
-						aggregator.AggMutex.Lock()
-						aggregator.Aggregations = append(aggregator.Aggregations, agg)
-						aggregator.AggMutex.Unlock()
-					}
-				}
-			}()
-		}
-		aggregator.WaitGroup.Wait()
-		aggregator.Logger.Info("aggregation threads stopped")
-		if !connected {
-			session.End()
+	//Pick up aggregations that were acked but never made it into a CAL before a restart
+	if aggregator.Outbox != nil {
+		pending, err := aggregator.Outbox.PendingAggregations()
+		if util.LogError(err) == nil && len(pending) > 0 {
+			aggregator.Logger.Info(fmt.Sprintf("Recovered %d aggregations from outbox", len(pending)))
+			aggregator.Aggregations = append(aggregator.Aggregations, pending...)
 		}
-		aggregator.RestartMutex.Unlock()
-		time.Sleep(5 * time.Second)
+	}
+	aggregator.AggMutex.Unlock()
+	_, err := aggregator.Bus.Subscribe(bus.TopicAgg, prefetch, aggregator.handleHash)
+	return util.LogError(err)
+}
+
+// handleHash : adds a hash delivery to the current batch and waits for the batch's outcome
+func (aggregator *Aggregator) handleHash(msg bus.Message) bus.Result {
+	if len(msg.Body) == 0 {
+		return bus.DeadLetter
+	}
+	var hashItem types.HashItem
+	if err := json.Unmarshal(msg.Body, &hashItem); util.LogError(err) != nil {
+		return bus.DeadLetter
+	}
+	aggregator.Logger.Info(fmt.Sprintf("Hash: %s", string(msg.Body)))
+	item := pendingHash{msg: msg, result: make(chan bus.Result, 1)}
+	aggregator.pendingMutex.Lock()
+	aggregator.pending = append(aggregator.pending, item)
+	full := aggregator.BatchSize > 0 && len(aggregator.pending) >= aggregator.BatchSize
+	aggregator.pendingMutex.Unlock()
+	//create new agg roots under heavy load
+	if full {
+		aggregator.flush()
+	}
+	return <-item.result
+}
+
+// flush : aggregates the current batch and reports the outcome to each waiting handler
+func (aggregator *Aggregator) flush() {
+	aggregator.pendingMutex.Lock()
+	batch := aggregator.pending
+	aggregator.pending = make([]pendingHash, 0)
+	aggregator.pendingMutex.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	msgStructSlice := make([]bus.Message, 0)
+	for _, item := range batch {
+		msgStructSlice = append(msgStructSlice, item.msg)
+	}
+	result := bus.Retry
+	if agg := aggregator.ProcessAggregation(msgStructSlice, aggregator.LatestNist); agg.AggRoot != "" {
+		aggregator.AggMutex.Lock()
+		aggregator.Aggregations = append(aggregator.Aggregations, agg)
+		aggregator.AggMutex.Unlock()
+		result = bus.Ack
+	}
+	for _, item := range batch {
+		item.result <- result
 	}
 }
 
 // ProcessAggregation creates merkle trees of received hashes a la https://github.com/chainpoint/chainpoint-services/blob/develop/node-aggregator-service/server.js#L66
-func (aggregator *Aggregator) ProcessAggregation(msgStructSlice []amqp.Delivery, nist string) types.Aggregation {
+// The deliveries are not acked here; an empty Aggregation tells the caller to retry them
+func (aggregator *Aggregator) ProcessAggregation(msgStructSlice []bus.Message, nist string) types.Aggregation {
 	var agg types.Aggregation
 	hashSlice := make([][]byte, 0)               // byte array
 	hashStructSlice := make([]types.HashItem, 0) // keep record for building proof path
@@ -150,7 +185,7 @@ func (aggregator *Aggregator) ProcessAggregation(msgStructSlice []amqp.Delivery,
 		hashBytes, _ := hex.DecodeString(unPackedHashItem.Hash)
 		_, err = buffer.Write(hashBytes)
 
-		rabbitmq.LogError(err, "failed to write hashes to byte buffer")
+		util.LogError(err)
 
 		//Create checksum
 		newHash := sha256.Sum256(buffer.Bytes())
@@ -172,8 +207,9 @@ func (aggregator *Aggregator) ProcessAggregation(msgStructSlice []amqp.Delivery,
 	var tree merkletools.MerkleTree
 	tree.AddLeaves(hashSlice)
 	tree.MakeTree()
-	uuid, err := uuid.NewUUID()
-	rabbitmq.LogError(err, "can't generate uuid")
+	//Derive the ID from the root so the same hashes always produce the same aggregation
+	uuid, err := util.UUIDFromHash(tree.GetMerkleRoot()[0:16])
+	util.LogError(err)
 	agg.AggID = uuid.String()
 	agg.AggRoot = hex.EncodeToString(tree.GetMerkleRoot())
 
@@ -205,21 +241,24 @@ func (aggregator *Aggregator) ProcessAggregation(msgStructSlice []amqp.Delivery,
 	}
 	agg.ProofData = proofSlice
 
+	//Record the aggregation locally before its hashes are acked, so a restart can't lose it
+	if aggregator.Outbox != nil {
+		if err := aggregator.Outbox.PutAggregation(agg); util.LogError(err) != nil {
+			return types.Aggregation{}
+		}
+	}
+
 	//Publish to proof-state service
 	aggJSON, err := json.Marshal(agg)
-	if aggregator.RabbitmqURI != "" {
-		err = rabbitmq.Publish(aggregator.RabbitmqURI, proofStateQueueOut, msgType, aggJSON)
-
+	if aggregator.Bus != nil {
+		err = aggregator.Bus.Publish(bus.Message{Topic: bus.TopicProofState, Type: schema.TypeAggregator, Body: aggJSON})
 		if err != nil {
-			rabbitmq.LogError(err, "problem publishing aggJSON message to queue")
-			for _, msg := range msgStructSlice {
-				msg.Nack(false, true)
-			}
-		} else {
-			for _, msg := range msgStructSlice {
-				errAck := msg.Ack(false)
-				rabbitmq.LogError(errAck, "error acking queue item")
+			aggregator.Logger.Error(fmt.Sprintf("problem publishing aggJSON message to queue: %s", err.Error()))
+			//the hashes will be retried, so this aggregation must not be anchored
+			if aggregator.Outbox != nil {
+				aggregator.Outbox.RemoveAggregation(agg.AggID)
 			}
+			return types.Aggregation{}
 		}
 	}
 	return agg
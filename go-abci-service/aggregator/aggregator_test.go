@@ -8,9 +8,12 @@ import (
 	"time"
 
 	"github.com/chainpoint/tendermint/libs/log"
+	"github.com/stretchr/testify/assert"
 
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
+	"github.com/chp-project/chainpoint-core/go-abci-service/calendar"
+	"github.com/chp-project/chainpoint-core/go-abci-service/schema"
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
-	"github.com/streadway/amqp"
 )
 
 func TestHashResult(t *testing.T) {
@@ -24,62 +27,77 @@ func TestHashResult(t *testing.T) {
 	}
 	itemBytes, _ := json.Marshal(item)
 	itemBytes2, _ := json.Marshal(item2)
-	msgArray := []amqp.Delivery{
-		amqp.Delivery{
-			Acknowledger:    nil,
-			Headers:         amqp.Table{},
-			ContentType:     "",
-			ContentEncoding: "",
-			DeliveryMode:    0,
-			Priority:        0,
-			CorrelationId:   "",
-			ReplyTo:         "",
-			Expiration:      "",
-			MessageId:       "",
-			Timestamp:       time.Now(),
-			Type:            "",
-			UserId:          "",
-			AppId:           "",
-			ConsumerTag:     "",
-			MessageCount:    0,
-			DeliveryTag:     0,
-			Redelivered:     false,
-			Exchange:        "",
-			RoutingKey:      "",
-			Body:            itemBytes,
-		},
-		amqp.Delivery{
-			Acknowledger:    nil,
-			Headers:         amqp.Table{},
-			ContentType:     "",
-			ContentEncoding: "",
-			DeliveryMode:    0,
-			Priority:        0,
-			CorrelationId:   "",
-			ReplyTo:         "",
-			Expiration:      "",
-			MessageId:       "",
-			Timestamp:       time.Now(),
-			Type:            "",
-			UserId:          "",
-			AppId:           "",
-			ConsumerTag:     "",
-			MessageCount:    0,
-			DeliveryTag:     0,
-			Redelivered:     false,
-			Exchange:        "",
-			RoutingKey:      "",
-			Body:            itemBytes2,
-		},
+	msgArray := []bus.Message{
+		bus.Message{Topic: bus.TopicAgg, Body: itemBytes},
+		bus.Message{Topic: bus.TopicAgg, Body: itemBytes2},
 	}
 	allowLevel, _ := log.AllowLevel(strings.ToLower("DEBUG"))
 	tmLogger := log.NewFilter(log.NewTMLogger(log.NewSyncWriter(os.Stdout)), allowLevel)
 	aggregator := Aggregator{
-		RabbitmqURI: "",
-		Logger:      tmLogger,
+		Logger: tmLogger,
 	}
 	agg := aggregator.ProcessAggregation(msgArray, "")
 	if agg.AggRoot != "58f42246b9c6d303e33206d461e05f3e2292d8eddfce92b7434f1d8be9f0e2c1" {
 		t.Errorf("merkle root value should be 58f42246b9c6d303e33206d461e05f3e2292d8eddfce92b7434f1d8be9f0e2c1, got: %s", agg.AggRoot)
 	}
 }
+
+func TestAnchoringFlowOverMemoryBus(t *testing.T) {
+	assert := assert.New(t)
+	memBus := bus.NewMemoryBus()
+	defer memBus.Close()
+	aggregator := Aggregator{Bus: memBus, Logger: log.NewNopLogger()}
+	assert.Nil(aggregator.StartAggregation())
+
+	// Node API publishes submitted hashes to the aggregation queue
+	hashes := []types.HashItem{
+		{HashID: "6d627180-1883-11e7-a8f9-edb8c212ef23", Hash: "ed10960ccc613e4ad0533a813e2027924afd051f5065bb5379a80337c69afcb4"},
+		{HashID: "a0627180-1883-11e7-a8f9-edb8c212ef23", Hash: "aa10960ccc613e4ad0533a813e2027924afd051f5065bb5379a80337c69afcb4"},
+	}
+	for _, hash := range hashes {
+		hashBytes, _ := json.Marshal(hash)
+		assert.Nil(memBus.Publish(bus.Message{Topic: bus.TopicAgg, Body: hashBytes}))
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		aggregator.pendingMutex.Lock()
+		pending := len(aggregator.pending)
+		aggregator.pendingMutex.Unlock()
+		if pending == len(hashes) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("aggregator should pick up every hash from the bus")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// at anchor time the batch is aggregated, the aggregation is announced to proof state, and a CAL tree is built from it
+	aggs := aggregator.AggregateAndReset()
+	assert.Equal(1, len(aggs))
+	assert.Equal("58f42246b9c6d303e33206d461e05f3e2292d8eddfce92b7434f1d8be9f0e2c1", aggs[0].AggRoot)
+	assert.Equal(0, len(memBus.Pending(bus.TopicAgg)), "aggregated hashes should be acked")
+	cal := calendar.NewCalendar(memBus)
+	tree := cal.GenerateCalendarTree(aggs)
+	assert.Nil(cal.QueueCalStateMessage(types.TxTm{Hash: []byte{0x01}, Data: []byte("{}")}, tree))
+
+	msgs := memBus.Pending(bus.TopicProofState)
+	assert.Equal(2, len(msgs))
+	assert.Equal(schema.TypeAggregator, msgs[0].Type)
+	assert.Equal(schema.TypeCalBatch, msgs[1].Type)
+	var calState types.CalState
+	assert.Nil(json.Unmarshal(msgs[1].Body, &calState))
+	assert.Equal(aggs[0].AggID, calState.ProofData[0].AggID)
+}
+
+func TestRequeueHashesSkipsDuplicateAggregations(t *testing.T) {
+	assert := assert.New(t)
+	memBus := bus.NewMemoryBus()
+	aggregator := Aggregator{Bus: memBus, Logger: log.NewNopLogger()}
+	agg := types.Aggregation{AggID: "f4c5445a-49ca-11e9-b3cf-0242ac190005", ProofData: []types.ProofData{
+		{HashID: "6d627180-1883-11e7-a8f9-edb8c212ef23", Hash: "ed10960ccc613e4ad0533a813e2027924afd051f5065bb5379a80337c69afcb4"},
+		{HashID: "a0627180-1883-11e7-a8f9-edb8c212ef23", Hash: "aa10960ccc613e4ad0533a813e2027924afd051f5065bb5379a80337c69afcb4"},
+	}}
+	assert.Nil(aggregator.RequeueHashes([]types.Aggregation{agg, agg}))
+	assert.Equal(2, len(memBus.Pending(bus.TopicAgg)), "an aggregation's hashes should be requeued once however often it's excluded")
+}
@@ -0,0 +1,80 @@
+package bus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Topics exchanged between the ABCI app and the rest of Core
+const (
+	TopicAgg        = "work.agg"
+	TopicCal        = "work.cal"
+	TopicProofState = "work.proofstate"
+	TopicBtcTx      = "work.btctx"
+	TopicBtcMon     = "work.btcmon"
+)
+
+// Backends accepted by New
+const (
+	BackendRabbitMQ = "rabbitmq"
+	BackendNATS     = "nats"
+	BackendMemory   = "memory"
+)
+
+// Result : tells the bus what to do with a message once its Handler returns
+type Result int
+
+const (
+	// Ack : the message was handled and can be removed
+	Ack Result = iota
+	// Retry : the message should be redelivered after a delay
+	Retry
+	// DeadLetter : the message can never be handled and is set aside
+	DeadLetter
+)
+
+// Message : A backend-independent message. RetryCount is filled in on delivery
+type Message struct {
+	Topic      string
+	Type       string
+	Body       []byte
+	Headers    map[string]string
+	RetryCount int
+}
+
+// Handler : processes a single message
+type Handler func(msg Message) Result
+
+// Subscription : A running subscription to a topic
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Bus : Durable, at-least-once message passing between Core services
+type Bus interface {
+	// Publish returns once the backend has durably accepted msg
+	Publish(msg Message) error
+	// Subscribe runs handler in the background on at most prefetch messages of topic at a time
+	Subscribe(topic string, prefetch int, handler Handler) (Subscription, error)
+	Close() error
+}
+
+// DefaultRetryDelay : how long a retried message waits before redelivery
+const DefaultRetryDelay = 30 * time.Second
+
+// DefaultMaxRetries : retries after which a message is dead-lettered
+const DefaultMaxRetries = 10
+
+// New : Returns a bus for the named backend. uri is ignored by the memory backend
+func New(backend string, uri string) (Bus, error) {
+	switch strings.ToLower(backend) {
+	case "", BackendRabbitMQ:
+		return NewRabbitMQBus(uri), nil
+	case BackendNATS:
+		return NewNATSBus(uri)
+	case BackendMemory:
+		return NewMemoryBus(), nil
+	}
+	return nil, fmt.Errorf("unknown message bus backend %s", backend)
+}
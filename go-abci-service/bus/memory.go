@@ -0,0 +1,198 @@
+package bus
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// MemoryBus : In-process bus. Topics queue messages until a subscriber takes them, like durable queues,
+// which lets whole anchoring flows run inside go test
+type MemoryBus struct {
+	RetryDelay    time.Duration
+	MaxRetries    int
+	mux           sync.Mutex
+	topics        map[string]*memoryTopic
+	subscriptions []*memorySubscription
+	closed        bool
+}
+
+// memoryTopic : a queue of messages guarded by a condition variable that wakes subscribers
+type memoryTopic struct {
+	mux         sync.Mutex
+	cond        *sync.Cond
+	queue       []Message
+	deadLetters []Message
+}
+
+// memorySubscription : a set of workers pulling from one topic
+type memorySubscription struct {
+	topic   *memoryTopic
+	stopped bool
+}
+
+// NewMemoryBus : Returns an empty in-process bus with a short retry delay
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		RetryDelay: 10 * time.Millisecond,
+		MaxRetries: DefaultMaxRetries,
+		topics:     map[string]*memoryTopic{},
+	}
+}
+
+// Publish : Queues msg on its topic
+func (b *MemoryBus) Publish(msg Message) error {
+	topic, err := b.topic(msg.Topic)
+	if err != nil {
+		return err
+	}
+	topic.push(copyMessage(msg))
+	return nil
+}
+
+// Subscribe : Starts prefetch workers handling messages from topic
+func (b *MemoryBus) Subscribe(topic string, prefetch int, handler Handler) (Subscription, error) {
+	t, err := b.topic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	sub := &memorySubscription{topic: t}
+	b.mux.Lock()
+	if b.closed {
+		b.mux.Unlock()
+		return nil, errors.New("memory bus is closed")
+	}
+	b.subscriptions = append(b.subscriptions, sub)
+	b.mux.Unlock()
+	for i := 0; i < prefetch; i++ {
+		go func() {
+			for {
+				msg, ok := t.pop(sub)
+				if !ok {
+					return
+				}
+				b.settle(t, msg, handler(msg))
+			}
+		}()
+	}
+	return sub, nil
+}
+
+// Close : Stops accepting messages, and stops every subscription's workers once they finish their current message
+func (b *MemoryBus) Close() error {
+	b.mux.Lock()
+	b.closed = true
+	subscriptions := b.subscriptions
+	b.subscriptions = nil
+	b.mux.Unlock()
+	for _, sub := range subscriptions {
+		sub.Unsubscribe()
+	}
+	return nil
+}
+
+// Pending : Returns the messages waiting on topic, without removing them
+func (b *MemoryBus) Pending(topic string) []Message {
+	t, err := b.topic(topic)
+	if err != nil {
+		return nil
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return append([]Message{}, t.queue...)
+}
+
+// DeadLetters : Returns the messages dead-lettered from topic
+func (b *MemoryBus) DeadLetters(topic string) []Message {
+	t, err := b.topic(topic)
+	if err != nil {
+		return nil
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return append([]Message{}, t.deadLetters...)
+}
+
+// Unsubscribe : Stops the subscription's workers once they finish their current message
+func (s *memorySubscription) Unsubscribe() error {
+	s.topic.mux.Lock()
+	s.stopped = true
+	s.topic.mux.Unlock()
+	s.topic.cond.Broadcast()
+	return nil
+}
+
+func (b *MemoryBus) topic(name string) (*memoryTopic, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.closed {
+		return nil, errors.New("memory bus is closed")
+	}
+	t, exists := b.topics[name]
+	if !exists {
+		t = &memoryTopic{queue: make([]Message, 0), deadLetters: make([]Message, 0)}
+		t.cond = sync.NewCond(&t.mux)
+		b.topics[name] = t
+	}
+	return t, nil
+}
+
+// settle : carries out a handler result
+func (b *MemoryBus) settle(t *memoryTopic, msg Message, result Result) {
+	switch result {
+	case Ack:
+		return
+	case Retry:
+		msg.RetryCount++
+		if b.MaxRetries > 0 && msg.RetryCount > b.MaxRetries {
+			t.deadLetter(msg)
+			return
+		}
+		time.AfterFunc(b.RetryDelay, func() {
+			t.push(msg)
+		})
+	default:
+		t.deadLetter(msg)
+	}
+}
+
+func (t *memoryTopic) push(msg Message) {
+	t.mux.Lock()
+	t.queue = append(t.queue, msg)
+	t.mux.Unlock()
+	t.cond.Broadcast()
+}
+
+// pop : blocks until a message is available or sub is stopped
+func (t *memoryTopic) pop(sub *memorySubscription) (Message, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for len(t.queue) == 0 && !sub.stopped {
+		t.cond.Wait()
+	}
+	if sub.stopped {
+		return Message{}, false
+	}
+	msg := t.queue[0]
+	t.queue = t.queue[1:]
+	return msg, true
+}
+
+func (t *memoryTopic) deadLetter(msg Message) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.deadLetters = append(t.deadLetters, msg)
+}
+
+func copyMessage(msg Message) Message {
+	headers := map[string]string{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	msg.Headers = headers
+	msg.Body = append([]byte{}, msg.Body...)
+	return msg
+}
@@ -0,0 +1,88 @@
+package bus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBusQueuesUntilSubscribed(t *testing.T) {
+	assert := assert.New(t)
+	b := NewMemoryBus()
+	assert.Nil(b.Publish(Message{Topic: TopicProofState, Type: "cal_batch", Body: []byte("msg")}))
+	assert.Equal(1, len(b.Pending(TopicProofState)), "published message should wait for a subscriber")
+	received := make(chan Message, 1)
+	sub, err := b.Subscribe(TopicProofState, 1, func(msg Message) Result {
+		received <- msg
+		return Ack
+	})
+	assert.Nil(err)
+	select {
+	case msg := <-received:
+		assert.Equal("cal_batch", msg.Type)
+		assert.Equal([]byte("msg"), msg.Body)
+	case <-time.After(time.Second):
+		t.Fatal("subscriber should receive the queued message")
+	}
+	assert.Nil(sub.Unsubscribe())
+}
+
+func TestMemoryBusRetryAndDeadLetter(t *testing.T) {
+	assert := assert.New(t)
+	b := NewMemoryBus()
+	b.RetryDelay = time.Millisecond
+	b.MaxRetries = 2
+	attempts := make(chan int, 10)
+	b.Subscribe(TopicCal, 2, func(msg Message) Result {
+		attempts <- msg.RetryCount
+		return Retry
+	})
+	b.Publish(Message{Topic: TopicCal, Type: "btctx", Body: []byte("msg")})
+	for i := 0; i <= 2; i++ {
+		select {
+		case retries := <-attempts:
+			assert.Equal(i, retries, "retry count should increase on every redelivery")
+		case <-time.After(time.Second):
+			t.Fatal("retried message should be redelivered")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(b.DeadLetters(TopicCal)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(1, len(b.DeadLetters(TopicCal)), "message retried past MaxRetries should be dead-lettered")
+}
+
+func TestMemoryBusCloseStopsSubscribers(t *testing.T) {
+	assert := assert.New(t)
+	b := NewMemoryBus()
+	received := make(chan Message, 1)
+	_, err := b.Subscribe(TopicCal, 2, func(msg Message) Result {
+		received <- msg
+		return Ack
+	})
+	assert.Nil(err)
+	topic := b.topics[TopicCal]
+	assert.Nil(b.Close())
+	assert.NotNil(b.Publish(Message{Topic: TopicCal, Body: []byte("msg")}), "a closed bus should refuse messages")
+	_, err = b.Subscribe(TopicCal, 1, func(msg Message) Result { return Ack })
+	assert.NotNil(err, "a closed bus should refuse subscriptions")
+	// a message already on the topic, such as a retry, is left for no one once the bus is closed
+	topic.push(Message{Topic: TopicCal, Body: []byte("retried")})
+	select {
+	case <-received:
+		t.Fatal("subscriber should stop when the bus is closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	b, err := New(BackendMemory, "")
+	if err != nil || b == nil {
+		t.Errorf("memory backend should be created, got %v", err)
+	}
+	if _, err := New("carrier-pigeon", ""); err == nil {
+		t.Errorf("unknown backend should be rejected")
+	}
+}
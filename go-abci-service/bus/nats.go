@@ -0,0 +1,116 @@
+package bus
+
+import (
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsStreamName : JetStream stream holding every Core topic and its dead letters
+const natsStreamName = "CHAINPOINT"
+
+// natsTypeHeader : header carrying Message.Type
+const natsTypeHeader = "Chainpoint-Type"
+
+// NATSBus : Bus backed by NATS JetStream. Topics map to subjects of a single file-backed stream,
+// and dead-lettered messages are republished under dlq.<topic>
+type NATSBus struct {
+	Conn *nats.Conn
+	JS   nats.JetStreamContext
+}
+
+// natsSubscription : wraps a JetStream queue subscription
+type natsSubscription struct {
+	sub *nats.Subscription
+}
+
+// NewNATSBus : Connects to uri and ensures the Core stream exists
+func NewNATSBus(uri string) (*NATSBus, error) {
+	conn, err := nats.Connect(uri, nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := js.StreamInfo(natsStreamName); err != nil {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:     natsStreamName,
+			Subjects: []string{"work.>", "dlq.>"},
+			Storage:  nats.FileStorage,
+		})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &NATSBus{Conn: conn, JS: js}, nil
+}
+
+// Publish : Publishes msg on its topic's subject and waits for the stream's acknowledgement
+func (b *NATSBus) Publish(msg Message) error {
+	natsMsg := nats.NewMsg(msg.Topic)
+	natsMsg.Data = msg.Body
+	for k, v := range msg.Headers {
+		natsMsg.Header.Set(k, v)
+	}
+	if msg.Type != "" {
+		natsMsg.Header.Set(natsTypeHeader, msg.Type)
+	}
+	_, err := b.JS.PublishMsg(natsMsg)
+	return err
+}
+
+// Subscribe : Starts a durable queue subscription on topic with at most prefetch unacked messages
+func (b *NATSBus) Subscribe(topic string, prefetch int, handler Handler) (Subscription, error) {
+	durable := strings.Replace(topic, ".", "_", -1)
+	sub, err := b.JS.QueueSubscribe(topic, durable, func(natsMsg *nats.Msg) {
+		msg := Message{
+			Topic:   topic,
+			Type:    natsMsg.Header.Get(natsTypeHeader),
+			Body:    natsMsg.Data,
+			Headers: map[string]string{},
+		}
+		for k := range natsMsg.Header {
+			if k != natsTypeHeader {
+				msg.Headers[k] = natsMsg.Header.Get(k)
+			}
+		}
+		if meta, err := natsMsg.Metadata(); err == nil && meta.NumDelivered > 0 {
+			msg.RetryCount = int(meta.NumDelivered - 1)
+		}
+		result := handler(msg)
+		if result == Retry && msg.RetryCount >= DefaultMaxRetries {
+			result = DeadLetter
+		}
+		switch result {
+		case Ack:
+			natsMsg.Ack()
+		case Retry:
+			natsMsg.NakWithDelay(DefaultRetryDelay)
+		default:
+			// keep a copy for inspection before telling the stream to stop redelivering
+			if b.Publish(Message{Topic: "dlq." + topic, Type: msg.Type, Body: msg.Body, Headers: msg.Headers}) != nil {
+				natsMsg.NakWithDelay(DefaultRetryDelay)
+				return
+			}
+			natsMsg.Term()
+		}
+	}, nats.Durable(durable), nats.ManualAck(), nats.AckExplicit(), nats.MaxAckPending(prefetch), nats.BindStream(natsStreamName))
+	if err != nil {
+		return nil, err
+	}
+	return &natsSubscription{sub: sub}, nil
+}
+
+// Close : Drains and closes the connection
+func (b *NATSBus) Close() error {
+	return b.Conn.Drain()
+}
+
+// Unsubscribe : Stops delivery to the subscription once in-flight messages are handled
+func (s *natsSubscription) Unsubscribe() error {
+	return s.sub.Drain()
+}
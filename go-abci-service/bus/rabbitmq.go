@@ -0,0 +1,90 @@
+package bus
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/rabbitmq"
+)
+
+// RabbitMQBus : Bus backed by RabbitMQ queues named after topics, using a confirm-mode Publisher and
+// Consumers with retry and dead-letter queues
+type RabbitMQBus struct {
+	URI       string
+	Publisher *rabbitmq.Publisher
+}
+
+// rabbitMQSubscription : wraps a running Consumer
+type rabbitMQSubscription struct {
+	consumer *rabbitmq.Consumer
+}
+
+// NewRabbitMQBus : Returns a bus using the shared publisher for uri
+func NewRabbitMQBus(uri string) *RabbitMQBus {
+	return &RabbitMQBus{
+		URI:       uri,
+		Publisher: rabbitmq.GetPublisher(uri),
+	}
+}
+
+// Publish : Publishes msg to the queue named by its topic and waits for the broker's confirmation
+func (b *RabbitMQBus) Publish(msg Message) error {
+	var headers amqp.Table
+	if len(msg.Headers) > 0 {
+		headers = amqp.Table{}
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+	}
+	return b.Publisher.PublishWithHeaders(msg.Topic, msg.Type, headers, msg.Body)
+}
+
+// Subscribe : Starts a Consumer on the queue named topic
+func (b *RabbitMQBus) Subscribe(topic string, prefetch int, handler Handler) (Subscription, error) {
+	consumer := rabbitmq.NewConsumer(b.URI, topic, prefetch, func(delivery amqp.Delivery) rabbitmq.Result {
+		switch handler(fromDelivery(topic, delivery)) {
+		case Ack:
+			return rabbitmq.Ack
+		case Retry:
+			return rabbitmq.Retry
+		default:
+			return rabbitmq.DeadLetter
+		}
+	})
+	consumer.RetryDelay = DefaultRetryDelay
+	consumer.MaxRetries = DefaultMaxRetries
+	go consumer.Run()
+	return &rabbitMQSubscription{consumer: consumer}, nil
+}
+
+// Close : Closes the publisher's connection
+func (b *RabbitMQBus) Close() error {
+	return b.Publisher.Close()
+}
+
+// Unsubscribe : Stops the consumer
+func (s *rabbitMQSubscription) Unsubscribe() error {
+	s.consumer.Stop()
+	return nil
+}
+
+// fromDelivery : converts an AMQP delivery into a bus Message
+func fromDelivery(topic string, delivery amqp.Delivery) Message {
+	msg := Message{
+		Topic:   topic,
+		Type:    delivery.Type,
+		Body:    delivery.Body,
+		Headers: map[string]string{},
+	}
+	for k, v := range delivery.Headers {
+		if k == rabbitmq.RetryCountHeader {
+			continue
+		}
+		msg.Headers[k] = fmt.Sprintf("%v", v)
+	}
+	if retries, ok := delivery.Headers[rabbitmq.RetryCountHeader].(int32); ok {
+		msg.RetryCount = int(retries)
+	}
+	return msg
+}
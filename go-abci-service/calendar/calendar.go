@@ -1,6 +1,7 @@
 package calendar
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -12,64 +13,119 @@ import (
 	core_types "github.com/chainpoint/tendermint/rpc/core/types"
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
 
-	"github.com/chp-project/chainpoint-core/go-abci-service/rabbitmq"
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
+	"github.com/chp-project/chainpoint-core/go-abci-service/schema"
 
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
 
 	"github.com/chp-project/chainpoint-core/go-abci-service/merkletools"
 )
 
+// Calendar : object includes the message Bus and Logger. HardenedTree switches CAL trees to domain-separated hashing,
+// and is set by the caller before each tree from the chain's activation height
 type Calendar struct {
-	RabbitmqURI string
-	Logger      log.Logger
+	Bus          bus.Bus
+	Logger       log.Logger
+	HardenedTree bool
 }
 
 // NewCalendar returns a new Calendar Object with a built-in logger. Useful for testing
-func NewCalendar(rmqUri string) *Calendar {
+func NewCalendar(messageBus bus.Bus) *Calendar {
 	allowLevel, _ := log.AllowLevel(strings.ToLower("ERROR"))
 	tmLogger := log.NewFilter(log.NewTMLogger(log.NewSyncWriter(os.Stdout)), allowLevel)
 	calendar := Calendar{
-		RabbitmqURI: rmqUri,
-		Logger:      tmLogger,
+		Bus:    messageBus,
+		Logger: tmLogger,
 	}
 	return &calendar
 }
 
-// GenerateCalendarTree creates the MerkleTree for the aggregation roots which will be committed to the calendar
+// GenerateCalendarTree creates the MerkleTree for the aggregation roots which will be committed to the calendar.
+// Aggregations with unusable roots are left out of the tree and returned in Excluded so their hashes can be retried
 func (calendar *Calendar) GenerateCalendarTree(aggs []types.Aggregation) types.CalAgg {
 	var treeDataObj types.CalAgg
 	var tree merkletools.MerkleTree
+	leafAggs := make([]types.Aggregation, 0) // leafAggs[i] is the aggregation whose root is leaf i
+	seenAggIDs := map[string]bool{}
+	treeDataObj.Excluded = make([]types.ExcludedAggregation, 0)
 	for _, agg := range aggs {
-		aggRootBytes, err := hex.DecodeString(agg.AggRoot)
-		if util.LogError(err) != nil {
+		aggRootBytes, reason := validateAggregation(agg, seenAggIDs)
+		if reason != "" {
+			calendar.Logger.Error(fmt.Sprintf("Excluding aggregation %s from calendar tree: %s", agg.AggID, reason))
+			treeDataObj.Excluded = append(treeDataObj.Excluded, types.ExcludedAggregation{Aggregation: agg, Reason: reason})
 			continue
 		}
+		seenAggIDs[agg.AggID] = true
 		tree.AddLeaf(aggRootBytes)
+		leafAggs = append(leafAggs, agg)
+	}
+	treeDataObj.ProofData = make([]types.CalProofData, 0)
+	if len(leafAggs) == 0 {
+		return treeDataObj
+	}
+	if calendar.HardenedTree {
+		tree.MakeHardenedTree()
+	} else {
+		tree.MakeTree()
 	}
-	tree.MakeTree()
 	treeDataObj.CalRoot = hex.EncodeToString(tree.GetMerkleRoot())
-	treeDataObj.ProofData = make([]types.CalProofData, len(aggs))
-	for i, agg := range aggs {
+	for i, agg := range leafAggs {
 		var proofData types.CalProofData
 		proofData.AggID = agg.AggID
 		proof := tree.GetProof(i)
-		proofData.Proof = make([]types.ProofLineItem, 0)
-		for _, p := range proof {
-			if p.Left {
-				proofData.Proof = append(proofData.Proof, types.ProofLineItem{Left: hex.EncodeToString(p.Value)})
-			} else {
-				proofData.Proof = append(proofData.Proof, types.ProofLineItem{Right: hex.EncodeToString(p.Value)})
+		if calendar.HardenedTree {
+			proofData.Proof = hardenedProofLineItems(proof)
+		} else {
+			proofData.Proof = make([]types.ProofLineItem, 0)
+			for _, p := range proof {
+				if p.Left {
+					proofData.Proof = append(proofData.Proof, types.ProofLineItem{Left: hex.EncodeToString(p.Value)})
+				} else {
+					proofData.Proof = append(proofData.Proof, types.ProofLineItem{Right: hex.EncodeToString(p.Value)})
+				}
+				proofData.Proof = append(proofData.Proof, types.ProofLineItem{Op: "sha-256"})
 			}
-			proofData.Proof = append(proofData.Proof, types.ProofLineItem{Op: "sha-256"})
 		}
-		treeDataObj.ProofData[i] = proofData
+		treeDataObj.ProofData = append(treeDataObj.ProofData, proofData)
 	}
 	//calendar.Logger.Info(fmt.Sprintf("AggTree Input: %#v\nCalTree Output: %#v\n", aggs, treeDataObj))
 	return treeDataObj
 }
 
-// QueueCalStateMessage lets proof state service know about a cal anchoring via rabbitmq
-func (calendar *Calendar) QueueCalStateMessage(tx types.TxTm, treeDataObj types.CalAgg) {
+// validateAggregation returns the decoded root of an aggregation, or the reason it can't be included in a calendar tree
+func validateAggregation(agg types.Aggregation, seenAggIDs map[string]bool) ([]byte, string) {
+	if agg.AggID == "" {
+		return nil, "missing agg_id"
+	}
+	if seenAggIDs[agg.AggID] {
+		return nil, "duplicate agg_id"
+	}
+	aggRootBytes, err := hex.DecodeString(agg.AggRoot)
+	if err != nil {
+		return nil, fmt.Sprintf("agg_root is not valid hex: %s", err.Error())
+	}
+	if len(aggRootBytes) != sha256.Size {
+		return nil, fmt.Sprintf("agg_root is %d bytes, expected %d", len(aggRootBytes), sha256.Size)
+	}
+	return aggRootBytes, ""
+}
+
+// hardenedProofLineItems converts a hardened tree proof into Chainpoint proof steps using the domain-separated ops
+func hardenedProofLineItems(proof []merkletools.ProofStep) []types.ProofLineItem {
+	lineItems := []types.ProofLineItem{types.ProofLineItem{Op: merkletools.HardenedLeafOp}}
+	for _, p := range proof {
+		if p.Left {
+			lineItems = append(lineItems, types.ProofLineItem{Left: hex.EncodeToString(p.Value)})
+		} else {
+			lineItems = append(lineItems, types.ProofLineItem{Right: hex.EncodeToString(p.Value)})
+		}
+		lineItems = append(lineItems, types.ProofLineItem{Op: merkletools.HardenedNodeOp})
+	}
+	return lineItems
+}
+
+// QueueCalStateMessage lets proof state service know about a cal anchoring via the message bus. Returns an error if the message wasn't published
+func (calendar *Calendar) QueueCalStateMessage(tx types.TxTm, treeDataObj types.CalAgg) error {
 	var calState types.CalState
 	baseURI := util.GetEnv("CHAINPOINT_CORE_BASE_URI", "https://tendermint.chainpoint.org")
 	uri := fmt.Sprintf("%s/calendar/%x/data", baseURI, tx.Hash)
@@ -80,11 +136,16 @@ func (calendar *Calendar) QueueCalStateMessage(tx types.TxTm, treeDataObj types.
 	calState.Anchor = anchor
 	calState.ProofData = treeDataObj.ProofData
 	calState.CalID = hex.EncodeToString(tx.Hash)
-	calStateJSON, _ := json.Marshal(calState)
-	err := rabbitmq.Publish(calendar.RabbitmqURI, "work.proofstate", "cal_batch", calStateJSON)
+	calStateJSON, err := json.Marshal(calState)
+	if util.LogError(err) != nil {
+		return err
+	}
+	err = calendar.Bus.Publish(bus.Message{Topic: bus.TopicProofState, Type: schema.TypeCalBatch, Body: calStateJSON})
 	if err != nil {
-		rabbitmq.LogError(err, "rmq dial failure, is rmq connected?")
+		calendar.Logger.Error(fmt.Sprintf("failed to publish cal_batch message: %s", err.Error()))
+		return err
 	}
+	return nil
 }
 
 // AggregateAnchorTx takes in cal transactions and creates a merkleroot and proof path. Called by the anchor loop
@@ -144,7 +205,7 @@ func (calendar *Calendar) QueueBtcaStateDataMessage(anchorDataObj types.BtcAgg)
 	if util.LogError(err) != nil {
 		return err
 	}
-	errBatch := rabbitmq.Publish(calendar.RabbitmqURI, "work.proofstate", "anchor_btc_agg_batch", treeDataJSON)
+	errBatch := calendar.Bus.Publish(bus.Message{Topic: bus.TopicProofState, Type: schema.TypeBtcAggBatch, Body: treeDataJSON})
 	if errBatch != nil {
 		return errBatch
 	}
@@ -157,7 +218,7 @@ func (calendar *Calendar) QueueBtcTxStateDataMessage(anchorDataObj types.BtcAgg)
 	if util.LogError(err) != nil {
 		return err
 	}
-	errBtcTx := rabbitmq.Publish(calendar.RabbitmqURI, "work.btctx", "", treeDataJSON)
+	errBtcTx := calendar.Bus.Publish(bus.Message{Topic: bus.TopicBtcTx, Body: treeDataJSON})
 	if errBtcTx != nil {
 		return errBtcTx
 	}
@@ -0,0 +1,342 @@
+// Package ethsync ingests the Node and Core registry contracts' staking events into the staking ledger and current-state tables.
+// Events are read by range polling from a persisted cursor and, when a websocket endpoint is configured, a live log subscription.
+//
+// The subscription deliberately doesn't apply events as they arrive. It filters the registry contract's raw logs rather than
+// using generated Watch* bindings, and buffers each log until its block is Confirmations deep, then polls the confirmed range.
+// Staked sets feed audit assignment and rewards, so every Core must see the same events at the same block: applying unconfirmed
+// events would let a reorg move a Node in and out of those sets between Cores. The subscription therefore only shortens the wait
+// for confirmed events, and the Recheck that starts every poll rolls back any confirmed event a deeper reorg later removes
+package ethsync
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chainpoint/tendermint/libs/log"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// Event : A registry contract event, normalized by an Adapter. Type is one of the types.StakingEvent* constants,
+// and ID is any registry-assigned identity of the entity, such as a Core's Tendermint ID
+type Event struct {
+	Type     string
+	EthAddr  string
+	PublicIP string
+	ID       string
+	Log      ethtypes.Log
+}
+
+// Adapter : Connects the Engine to one kind of registry entity. Adapters translate the entity's contract events into Events
+// and apply them to its current-state table; everything else is handled by the Engine
+type Adapter interface {
+	// Kind : the entity's kind in the staking ledger, such as types.StakingKindNode
+	Kind() string
+	// FetchRange : the entity's staked, updated and unstaked events emitted in blocks start through end
+	FetchRange(start int64, end int64) ([]Event, error)
+	// Upsert : applies a staked or updated event to the current-state table
+	Upsert(e Event) (bool, error)
+	// Delete : applies an unstaked event to the current-state table
+	Delete(e Event) (bool, error)
+}
+
+// Ledger : The staking ledger and ingestion cursors the Engine records into. Satisfied by postgres.Store
+type Ledger interface {
+	RecordStakingEvent(event types.StakingEvent) (bool, error)
+	GetStakingEventsAfter(kind string, block int64) ([]types.StakingEvent, error)
+	RemoveStakingEvent(event types.StakingEvent) (bool, error)
+	ReconcileStaking(kind string, ethAddr string) error
+	GetIngestionCursor(name string) (int64, bool, error)
+	SetIngestionCursor(name string, block int64) error
+}
+
+// Engine : Keeps one registry entity's staking ledger and current-state table in sync with the registry contract.
+// Events are polled in pages of at most PageSize blocks, from StartBlock and never past Confirmations blocks behind HighestBlock.
+// When Logs is set, the Engine also subscribes to the Contract's logs and polls as soon as a new log is Confirmations deep.
+// Progress is persisted in the "<kind>_registry" ingestion cursor. Before each poll, events recorded in the last RecheckDepth
+// blocks are checked against BlockHash, so a reorg deeper than Confirmations is still rolled back
+type Engine struct {
+	Adapter       Adapter
+	Ledger        Ledger
+	HighestBlock  func() (*big.Int, error)
+	BlockHash     func(block int64) (common.Hash, error)
+	Logs          ethereum.LogFilterer
+	Contract      common.Address
+	StartBlock    int64
+	Confirmations int64
+	RecheckDepth  int64
+	PageSize      int64
+	PollInterval  time.Duration
+	Logger        log.Logger
+	pollMux       sync.Mutex
+}
+
+// Run : syncs the entity until stop is closed. A nil stop runs forever
+func (e *Engine) Run(stop <-chan struct{}) {
+	stream := Stream{
+		Name:         fmt.Sprintf("%s registry", e.Adapter.Kind()),
+		Subscribe:    e.Subscribe,
+		Poll:         e.Poll,
+		PollInterval: e.PollInterval,
+		Logger:       e.Logger,
+	}
+	stream.Run(stop)
+}
+
+// Cursor : name of the entity's ingestion cursor
+func (e *Engine) Cursor() string {
+	return CursorName(e.Adapter.Kind())
+}
+
+// CursorName : name of the ingestion cursor for an entity kind, such as types.StakingKindNode
+func CursorName(kind string) string {
+	return kind + "_registry"
+}
+
+// Poll : ingests events one block range at a time until caught up with the confirmed head. The cursor only advances once every
+// event in a range is stored, so a failed range is read again on the next poll
+func (e *Engine) Poll() error {
+	e.pollMux.Lock()
+	defer e.pollMux.Unlock()
+	if err := e.Recheck(); err != nil {
+		return err
+	}
+	for {
+		start, end, caughtUp, err := e.nextRange()
+		if err != nil || start > end {
+			return err
+		}
+		e.Logger.Info(fmt.Sprintf("Polling for %s registry events in blocks %d-%d", e.Adapter.Kind(), start, end))
+		events, err := e.Adapter.FetchRange(start, end)
+		if err != nil {
+			return err
+		}
+		// apply in chain order, so that later events supersede earlier ones regardless of their type
+		sort.SliceStable(events, func(i, j int) bool {
+			if events[i].Log.BlockNumber != events[j].Log.BlockNumber {
+				return events[i].Log.BlockNumber < events[j].Log.BlockNumber
+			}
+			return events[i].Log.Index < events[j].Log.Index
+		})
+		failed := 0
+		for _, ev := range events {
+			if e.Apply(ev) != nil {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("failed to ingest %d of %d %s registry events in blocks %d-%d", failed, len(events), e.Adapter.Kind(), start, end)
+		}
+		if err := e.Ledger.SetIngestionCursor(e.Cursor(), end); err != nil {
+			return err
+		}
+		if caughtUp {
+			return nil
+		}
+	}
+}
+
+// Recheck : compares the block hash of each event recorded in the last RecheckDepth blocks before the cursor with the chain's.
+// Events from blocks that are no longer canonical are marked removed in the ledger, their addresses' current state is rebuilt
+// from their remaining events, and the cursor is moved back before the earliest of them, so the next poll reads the canonical events
+func (e *Engine) Recheck() error {
+	if e.BlockHash == nil || e.RecheckDepth <= 0 {
+		return nil
+	}
+	last, found, err := e.Ledger.GetIngestionCursor(e.Cursor())
+	if err != nil || !found {
+		return err
+	}
+	kind := e.Adapter.Kind()
+	events, err := e.Ledger.GetStakingEventsAfter(kind, last-e.RecheckDepth)
+	if err != nil {
+		return err
+	}
+	canonical := map[int64]string{}
+	orphaned := make([]types.StakingEvent, 0)
+	for _, event := range events {
+		if event.BlockNumber > last {
+			break
+		}
+		if _, checked := canonical[event.BlockNumber]; !checked {
+			hash, err := e.BlockHash(event.BlockNumber)
+			if err != nil {
+				return err
+			}
+			canonical[event.BlockNumber] = hash.Hex()
+		}
+		if canonical[event.BlockNumber] != event.BlockHash {
+			orphaned = append(orphaned, event)
+		}
+	}
+	if len(orphaned) == 0 {
+		return nil
+	}
+	reconciled := map[string]bool{}
+	for _, event := range orphaned {
+		if _, err := e.Ledger.RemoveStakingEvent(event); err != nil {
+			return e.logError(err)
+		}
+		e.Logger.Info(fmt.Sprintf("Reorg removed %s %s event for %s at block %d", kind, event.EventType, event.EthAddr, event.BlockNumber))
+		if !reconciled[event.EthAddr] {
+			if err := e.Ledger.ReconcileStaking(kind, event.EthAddr); err != nil {
+				return e.logError(err)
+			}
+			reconciled[event.EthAddr] = true
+		}
+	}
+	return e.Ledger.SetIngestionCursor(e.Cursor(), orphaned[0].BlockNumber-1)
+}
+
+// Subscribe : subscribes to the registry Contract's logs. Logs aren't applied as they arrive: each is buffered until its block
+// is Confirmations deep, then the confirmed blocks are polled, so the subscription only speeds up ingestion. A log removed by a reorg
+// while buffered is dropped; one removed later, or while unsubscribed, is rolled back by the Recheck that starts every poll
+func (e *Engine) Subscribe() (event.Subscription, error) {
+	if e.Logs == nil {
+		return nil, ErrNoSubscription
+	}
+	logs := make(chan ethtypes.Log)
+	query := ethereum.FilterQuery{Addresses: []common.Address{e.Contract}}
+	sub, err := e.Logs.SubscribeFilterLogs(context.Background(), query, logs)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		buffer := confirmBuffer{}
+		ticker := time.NewTicker(interval(e.PollInterval, DefaultPollInterval))
+		defer ticker.Stop()
+		for {
+			select {
+			case l := <-logs:
+				buffer.Add(l)
+			case <-ticker.C:
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+			if len(buffer) == 0 {
+				continue
+			}
+			head, err := e.HighestBlock()
+			if e.logError(err) != nil {
+				continue
+			}
+			confirmed := head.Int64() - e.Confirmations
+			if buffer.Confirmed(confirmed) && e.logError(e.Poll()) == nil {
+				buffer.Drop(confirmed)
+			}
+		}
+	}), nil
+}
+
+// Apply : appends an event to the staking ledger, then applies it to the current-state table.
+// Events already recorded are ignored by the ledger, so re-reading a range is safe
+func (e *Engine) Apply(ev Event) error {
+	kind := e.Adapter.Kind()
+	record := types.StakingEvent{
+		Kind:        kind,
+		EventType:   ev.Type,
+		EthAddr:     ev.EthAddr,
+		PublicIP:    ev.PublicIP,
+		CoreID:      ev.ID,
+		BlockNumber: int64(ev.Log.BlockNumber),
+		BlockHash:   ev.Log.BlockHash.Hex(),
+		TxHash:      ev.Log.TxHash.Hex(),
+		LogIndex:    int64(ev.Log.Index),
+	}
+	recorded, err := e.Ledger.RecordStakingEvent(record)
+	if err != nil {
+		return e.logError(err)
+	}
+	if recorded {
+		e.Logger.Info(fmt.Sprintf("Recorded %s %s event for %s at block %d", kind, ev.Type, ev.EthAddr, record.BlockNumber))
+	}
+	var applied bool
+	switch ev.Type {
+	case types.StakingEventStaked, types.StakingEventUpdated:
+		applied, err = e.Adapter.Upsert(ev)
+	case types.StakingEventUnstaked:
+		applied, err = e.Adapter.Delete(ev)
+	default:
+		err = fmt.Errorf("unknown staking event type %s", ev.Type)
+	}
+	if err != nil {
+		return e.logError(err)
+	}
+	e.Logger.Info(fmt.Sprintf("Applied %s %s event for %s at block %d: %t", kind, ev.Type, ev.EthAddr, record.BlockNumber, applied))
+	return nil
+}
+
+// nextRange : the next block range to poll, starting just after the persisted cursor (or at StartBlock) and ending at most one page later,
+// without passing the newest confirmed block. start > end when there's nothing new to read. caughtUp is true once the range
+// reaches the confirmed head
+func (e *Engine) nextRange() (start int64, end int64, caughtUp bool, err error) {
+	head, err := e.HighestBlock()
+	if err != nil {
+		return 0, -1, true, err
+	}
+	confirmed := head.Int64() - e.Confirmations
+	last, found, err := e.Ledger.GetIngestionCursor(e.Cursor())
+	if err != nil {
+		return 0, -1, true, err
+	}
+	start = e.StartBlock
+	if found && last+1 > start {
+		start = last + 1
+	}
+	end = confirmed
+	if e.PageSize > 0 && start+e.PageSize-1 < confirmed {
+		end = start + e.PageSize - 1
+	}
+	return start, end, end >= confirmed, nil
+}
+
+// logError : logs err, if any, and returns it
+func (e *Engine) logError(err error) error {
+	if err != nil {
+		e.Logger.Error(fmt.Sprintf("Error: %s", err.Error()))
+	}
+	return err
+}
+
+// confirmBuffer : live logs awaiting confirmation, by block hash, tx hash and log index
+type confirmBuffer map[string]ethtypes.Log
+
+// Add : buffers a log, or drops it if the Ethereum node reports it removed by a reorg
+func (b confirmBuffer) Add(l ethtypes.Log) {
+	key := fmt.Sprintf("%s|%s|%d", l.BlockHash.Hex(), l.TxHash.Hex(), l.Index)
+	if l.Removed {
+		delete(b, key)
+		return
+	}
+	b[key] = l
+}
+
+// Confirmed : whether any buffered log was emitted at or before block
+func (b confirmBuffer) Confirmed(block int64) bool {
+	for _, l := range b {
+		if int64(l.BlockNumber) <= block {
+			return true
+		}
+	}
+	return false
+}
+
+// Drop : removes the logs emitted at or before block, once they've been polled
+func (b confirmBuffer) Drop(block int64) {
+	for key, l := range b {
+		if int64(l.BlockNumber) <= block {
+			delete(b, key)
+		}
+	}
+}
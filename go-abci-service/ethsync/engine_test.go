@@ -0,0 +1,283 @@
+package ethsync
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chainpoint/tendermint/libs/log"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/postgres"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// fakeRegistry : an Adapter over an in-memory list of chain events, applying them to a MemoryStore.
+// reorged holds the canonical hash of blocks that no longer match their events' recorded hash
+type fakeRegistry struct {
+	mux        sync.Mutex
+	store      *postgres.MemoryStore
+	events     []Event
+	ranges     [][2]int64
+	failUpsert bool
+	reorged    map[int64]common.Hash
+}
+
+func (f *fakeRegistry) BlockHash(block int64) (common.Hash, error) {
+	if hash, ok := f.reorged[block]; ok {
+		return hash, nil
+	}
+	return common.BigToHash(big.NewInt(block)), nil
+}
+
+func (f *fakeRegistry) Kind() string {
+	return types.StakingKindNode
+}
+
+func (f *fakeRegistry) FetchRange(start int64, end int64) ([]Event, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.ranges = append(f.ranges, [2]int64{start, end})
+	events := make([]Event, 0)
+	for _, ev := range f.events {
+		if block := int64(ev.Log.BlockNumber); block >= start && block <= end {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+func (f *fakeRegistry) Upsert(ev Event) (bool, error) {
+	if f.failUpsert {
+		return false, errors.New("postgres unavailable")
+	}
+	return f.store.NodeUpsert(fakeNode(ev))
+}
+
+func (f *fakeRegistry) Delete(ev Event) (bool, error) {
+	return f.store.NodeDelete(fakeNode(ev))
+}
+
+func fakeNode(ev Event) types.Node {
+	node := types.Node{EthAddr: ev.EthAddr}
+	node.PublicIP.String, node.PublicIP.Valid = ev.PublicIP, true
+	node.BlockNumber.Int64, node.BlockNumber.Valid = int64(ev.Log.BlockNumber), true
+	return node
+}
+
+func fakeEvent(eventType string, addr string, ip string, block uint64, index uint) Event {
+	return Event{
+		Type:     eventType,
+		EthAddr:  addr,
+		PublicIP: ip,
+		Log: ethtypes.Log{
+			BlockNumber: block,
+			BlockHash:   common.BigToHash(new(big.Int).SetUint64(block)),
+			TxHash:      common.BigToHash(new(big.Int).SetUint64(block*1000 + uint64(index))),
+			Index:       index,
+		},
+	}
+}
+
+func newTestEngine(registry *fakeRegistry, head int64) *Engine {
+	return &Engine{
+		Adapter:       registry,
+		Ledger:        registry.store,
+		HighestBlock:  func() (*big.Int, error) { return big.NewInt(head), nil },
+		BlockHash:     registry.BlockHash,
+		Confirmations: 10,
+		RecheckDepth:  100,
+		PageSize:      40,
+		Logger:        log.NewNopLogger(),
+	}
+}
+
+func TestEnginePollsConfirmedPages(t *testing.T) {
+	assert := assert.New(t)
+	registry := &fakeRegistry{
+		store: postgres.NewMemoryStore(),
+		events: []Event{
+			// the unstake is listed before the stake it follows, as it would be when fetched by event type
+			fakeEvent(types.StakingEventUnstaked, "0xb", "10.0.0.2", 70, 0),
+			fakeEvent(types.StakingEventStaked, "0xa", "10.0.0.1", 5, 0),
+			fakeEvent(types.StakingEventUpdated, "0xa", "10.0.0.3", 50, 1),
+			fakeEvent(types.StakingEventStaked, "0xb", "10.0.0.2", 60, 0),
+			fakeEvent(types.StakingEventStaked, "0xc", "10.0.0.4", 95, 0),
+		},
+	}
+	engine := newTestEngine(registry, 100)
+	assert.Nil(engine.Poll())
+	assert.Equal([][2]int64{{0, 39}, {40, 79}, {80, 90}}, registry.ranges, "ranges should be paged and stop at the confirmed head")
+	cursor, found, _ := registry.store.GetIngestionCursor("node_registry")
+	assert.True(found)
+	assert.Equal(int64(90), cursor)
+
+	count, _ := registry.store.GetNodeCount()
+	assert.Equal(1, count, "0xb was unstaked and 0xc isn't confirmed yet")
+	node, _ := registry.store.GetNodeByEthAddr("0xa")
+	assert.Equal("10.0.0.3", node.PublicIP.String)
+
+	// nothing new is confirmed, so nothing is fetched
+	assert.Nil(engine.Poll())
+	assert.Equal(3, len(registry.ranges))
+}
+
+func TestEngineStartsAtStartBlock(t *testing.T) {
+	registry := &fakeRegistry{store: postgres.NewMemoryStore()}
+	engine := newTestEngine(registry, 100)
+	engine.StartBlock = 75
+	assert.Nil(t, engine.Poll())
+	assert.Equal(t, [][2]int64{{75, 90}}, registry.ranges, "blocks before the registry's deployment shouldn't be read")
+}
+
+func TestEngineRollsBackDeepReorgs(t *testing.T) {
+	assert := assert.New(t)
+	registry := &fakeRegistry{
+		store: postgres.NewMemoryStore(),
+		events: []Event{
+			fakeEvent(types.StakingEventStaked, "0xa", "10.0.0.1", 5, 0),
+			fakeEvent(types.StakingEventUpdated, "0xa", "10.0.0.3", 50, 1),
+			fakeEvent(types.StakingEventStaked, "0xb", "10.0.0.2", 60, 0),
+		},
+	}
+	engine := newTestEngine(registry, 100)
+	assert.Nil(engine.Poll())
+
+	// a reorg deeper than the confirmation depth replaces block 50, dropping 0xa's update, and moves 0xb's stake to block 70
+	registry.reorged = map[int64]common.Hash{50: common.HexToHash("0x50"), 60: common.HexToHash("0x60")}
+	moved := fakeEvent(types.StakingEventStaked, "0xb", "10.0.0.4", 70, 0)
+	registry.events = []Event{registry.events[0], moved}
+	registry.ranges = nil
+	assert.Nil(engine.Poll())
+	assert.Equal([][2]int64{{50, 89}, {90, 90}}, registry.ranges, "the cursor should move back before the earliest orphaned event")
+
+	node, _ := registry.store.GetNodeByEthAddr("0xa")
+	assert.Equal("10.0.0.1", node.PublicIP.String, "0xa should be rolled back to its original stake")
+	history, _ := registry.store.GetStakingHistory(types.StakingKindNode, "0xa")
+	assert.Equal(1, len(history))
+	node, _ = registry.store.GetNodeByEthAddr("0xb")
+	assert.Equal("10.0.0.4", node.PublicIP.String)
+	history, _ = registry.store.GetStakingHistory(types.StakingKindNode, "0xb")
+	assert.Equal(1, len(history))
+	assert.Equal(int64(70), history[0].BlockNumber)
+}
+
+func TestEngineHoldsCursorOnFailure(t *testing.T) {
+	registry := &fakeRegistry{
+		store:      postgres.NewMemoryStore(),
+		events:     []Event{fakeEvent(types.StakingEventStaked, "0xa", "10.0.0.1", 5, 0)},
+		failUpsert: true,
+	}
+	engine := newTestEngine(registry, 100)
+	assert.NotNil(t, engine.Poll())
+	_, found, _ := registry.store.GetIngestionCursor("node_registry")
+	assert.False(t, found, "a failed range should be read again")
+
+	registry.failUpsert = false
+	assert.Nil(t, engine.Poll())
+	count, _ := registry.store.GetNodeCount()
+	assert.Equal(t, 1, count)
+}
+
+// emitterCode : deploys a contract that emits an empty log whenever it's called
+const emitterCode = "600680600b6000396000f360006000a000"
+
+// simulatedChain : a simulated Ethereum backend with an emitter contract deployed by a funded account
+type simulatedChain struct {
+	backend  *backends.SimulatedBackend
+	auth     *bind.TransactOpts
+	contract common.Address
+	nonce    uint64
+	head     int64
+}
+
+func newSimulatedChain(t *testing.T) *simulatedChain {
+	key, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	auth := bind.NewKeyedTransactor(key)
+	chain := &simulatedChain{
+		backend: backends.NewSimulatedBackend(core.GenesisAlloc{auth.From: {Balance: big.NewInt(1000000000000000000)}}, 8000000),
+		auth:    auth,
+	}
+	chain.send(t, ethtypes.NewContractCreation(0, big.NewInt(0), 100000, big.NewInt(1), common.FromHex(emitterCode)))
+	chain.contract = crypto.CreateAddress(auth.From, 0)
+	return chain
+}
+
+// send : mines a block holding tx
+func (c *simulatedChain) send(t *testing.T, tx *ethtypes.Transaction) {
+	signed, err := c.auth.Signer(ethtypes.HomesteadSigner{}, c.auth.From, tx)
+	assert.Nil(t, err)
+	assert.Nil(t, c.backend.SendTransaction(context.Background(), signed))
+	c.nonce++
+	c.mine()
+}
+
+// emit : mines a block in which the emitter contract logs, returning its number
+func (c *simulatedChain) emit(t *testing.T) uint64 {
+	c.send(t, ethtypes.NewTransaction(c.nonce, c.contract, big.NewInt(0), 100000, big.NewInt(1), nil))
+	return uint64(c.Head())
+}
+
+func (c *simulatedChain) mine() {
+	c.backend.Commit()
+	atomic.AddInt64(&c.head, 1)
+}
+
+func (c *simulatedChain) Head() int64 {
+	return atomic.LoadInt64(&c.head)
+}
+
+func TestEngineAppliesLiveEventsOnceConfirmed(t *testing.T) {
+	assert := assert.New(t)
+	chain := newSimulatedChain(t)
+	registry := &fakeRegistry{store: postgres.NewMemoryStore()}
+	engine := newTestEngine(registry, 0)
+	engine.HighestBlock = func() (*big.Int, error) { return big.NewInt(chain.Head()), nil }
+	engine.Logs = chain.backend
+	engine.Contract = chain.contract
+	engine.Confirmations = 2
+	engine.PollInterval = time.Millisecond
+	sub, err := engine.Subscribe()
+	assert.Nil(err)
+	defer sub.Unsubscribe()
+
+	registry.mux.Lock()
+	block := chain.emit(t)
+	registry.events = append(registry.events, fakeEvent(types.StakingEventStaked, "0xa", "10.0.0.1", block, 0))
+	registry.mux.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	count, _ := registry.store.GetNodeCount()
+	assert.Equal(0, count, "the event isn't confirmed yet")
+
+	chain.mine()
+	chain.mine()
+	waitFor(t, func() bool { count, _ := registry.store.GetNodeCount(); return count == 1 }, "confirmed live event was never applied")
+	cursor, _, _ := registry.store.GetIngestionCursor("node_registry")
+	assert.Equal(int64(block), cursor)
+}
+
+func TestConfirmBufferDropsRemovedLogs(t *testing.T) {
+	assert := assert.New(t)
+	buffer := confirmBuffer{}
+	kept := fakeEvent(types.StakingEventStaked, "0xa", "10.0.0.1", 10, 0).Log
+	removed := fakeEvent(types.StakingEventStaked, "0xb", "10.0.0.2", 8, 0).Log
+	buffer.Add(kept)
+	buffer.Add(removed)
+	removed.Removed = true
+	buffer.Add(removed)
+	assert.False(buffer.Confirmed(9), "the log at block 8 was removed by a reorg")
+	assert.True(buffer.Confirmed(10))
+	buffer.Drop(10)
+	assert.Equal(0, len(buffer))
+}
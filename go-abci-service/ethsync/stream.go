@@ -0,0 +1,122 @@
+package ethsync
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chainpoint/tendermint/libs/log"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Default intervals used when a Stream leaves them unset
+const (
+	DefaultPollInterval     = 30 * time.Second
+	DefaultConfirmInterval  = 10 * time.Minute
+	DefaultResubscribeAfter = 2 * time.Minute
+)
+
+// ErrNoSubscription : returned by Subscribe functions when no websocket endpoint is configured
+var ErrNoSubscription = errors.New("no log subscription available")
+
+// Stream : Applies registry events as they arrive over a live log subscription, falling back to range polling
+// whenever the subscription can't be opened or drops.
+// Subscribe opens the subscription and starts delivering its events; the subscription ends when its Err channel yields.
+// Poll catches up by range polling from the persisted ingestion cursor, and runs before every (re)subscription so that
+// events emitted while unsubscribed aren't missed. While subscribed, Poll still runs every ConfirmInterval to advance the cursor
+// over confirmed blocks
+type Stream struct {
+	Name             string
+	Subscribe        func() (event.Subscription, error)
+	Poll             func() error
+	PollInterval     time.Duration
+	ConfirmInterval  time.Duration
+	ResubscribeAfter time.Duration
+	Logger           log.Logger
+}
+
+// Run : follows the stream until stop is closed. A nil stop runs forever
+func (s *Stream) Run(stop <-chan struct{}) {
+	for {
+		s.poll()
+		sub, err := s.subscribe()
+		if err != nil {
+			if err != ErrNoSubscription {
+				s.Logger.Error(fmt.Sprintf("%s: subscription failed, falling back to polling: %s", s.Name, err.Error()))
+			}
+			if !s.fallback(stop) {
+				return
+			}
+			continue
+		}
+		s.Logger.Info(fmt.Sprintf("%s: subscribed to registry events", s.Name))
+		if !s.follow(sub, stop) {
+			return
+		}
+	}
+}
+
+// follow : waits on a live subscription, polling every ConfirmInterval. Returns false if stopped, or true once the subscription drops
+func (s *Stream) follow(sub event.Subscription, stop <-chan struct{}) bool {
+	defer sub.Unsubscribe()
+	confirm := time.NewTicker(interval(s.ConfirmInterval, DefaultConfirmInterval))
+	defer confirm.Stop()
+	for {
+		select {
+		case <-stop:
+			return false
+		case err := <-sub.Err():
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			s.Logger.Error(fmt.Sprintf("%s: subscription dropped, falling back to polling: %s", s.Name, err.Error()))
+			return true
+		case <-confirm.C:
+			s.poll()
+		}
+	}
+}
+
+// fallback : polls every PollInterval until it's time to try subscribing again. Returns false if stopped.
+// Streams without a Subscribe function poll here until stopped
+func (s *Stream) fallback(stop <-chan struct{}) bool {
+	var resubscribe <-chan time.Time
+	if s.Subscribe != nil {
+		resubscribe = time.After(interval(s.ResubscribeAfter, DefaultResubscribeAfter))
+	}
+	ticker := time.NewTicker(interval(s.PollInterval, DefaultPollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-resubscribe:
+			return true
+		case <-ticker.C:
+			s.poll()
+		}
+	}
+}
+
+// subscribe : opens the subscription, if the stream has one
+func (s *Stream) subscribe() (event.Subscription, error) {
+	if s.Subscribe == nil {
+		return nil, ErrNoSubscription
+	}
+	return s.Subscribe()
+}
+
+// poll : runs Poll, logging failures; the next poll retries from the same cursor
+func (s *Stream) poll() {
+	if err := s.Poll(); err != nil {
+		s.Logger.Error(fmt.Sprintf("%s: polling failed: %s", s.Name, err.Error()))
+	}
+}
+
+// interval : d, or fallback when d is unset
+func interval(d time.Duration, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,118 @@
+package ethsync
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chainpoint/tendermint/libs/log"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLogs : a subscription source whose live subscriptions can be dropped on demand
+type fakeLogs struct {
+	mux           sync.Mutex
+	subscriptions int
+	polls         int
+	fail          bool
+	drop          chan error
+}
+
+func (f *fakeLogs) subscribe() (event.Subscription, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.fail {
+		return nil, errors.New("dial failed")
+	}
+	f.subscriptions++
+	drop := f.drop
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		select {
+		case err := <-drop:
+			return err
+		case <-quit:
+			return nil
+		}
+	}), nil
+}
+
+func (f *fakeLogs) poll() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.polls++
+	return nil
+}
+
+func (f *fakeLogs) counts() (int, int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.subscriptions, f.polls
+}
+
+// waitFor : fails the test if cond doesn't hold within a second
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamFallsBackAndResubscribes(t *testing.T) {
+	assert := assert.New(t)
+	logs := &fakeLogs{drop: make(chan error)}
+	stream := Stream{
+		Name:             "test",
+		Subscribe:        logs.subscribe,
+		Poll:             logs.poll,
+		PollInterval:     5 * time.Millisecond,
+		ConfirmInterval:  time.Hour,
+		ResubscribeAfter: 50 * time.Millisecond,
+		Logger:           log.NewNopLogger(),
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		stream.Run(stop)
+		close(done)
+	}()
+
+	waitFor(t, func() bool { subs, _ := logs.counts(); return subs == 1 }, "stream never subscribed")
+	_, polls := logs.counts()
+	assert.Equal(1, polls, "the stream should catch up once before subscribing")
+	time.Sleep(20 * time.Millisecond)
+	_, polls = logs.counts()
+	assert.Equal(1, polls, "no polling while subscribed")
+
+	// drop the subscription while resubscribing fails, so the stream has to poll
+	logs.mux.Lock()
+	logs.fail = true
+	logs.mux.Unlock()
+	logs.drop <- errors.New("connection reset")
+	waitFor(t, func() bool { _, polls := logs.counts(); return polls > 3 }, "stream should poll while the subscription is down")
+
+	logs.mux.Lock()
+	logs.fail = false
+	logs.mux.Unlock()
+	waitFor(t, func() bool { subs, _ := logs.counts(); return subs == 2 }, "stream never resubscribed")
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream did not stop")
+	}
+}
+
+func TestStreamWithoutSubscriptionPolls(t *testing.T) {
+	logs := &fakeLogs{}
+	stream := Stream{Name: "test", Poll: logs.poll, PollInterval: time.Millisecond, Logger: log.NewNopLogger()}
+	stop := make(chan struct{})
+	go stream.Run(stop)
+	defer close(stop)
+	waitFor(t, func() bool { _, polls := logs.counts(); return polls > 3 }, "stream should poll without a subscription")
+}
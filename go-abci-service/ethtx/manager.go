@@ -0,0 +1,466 @@
+package ethtx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	dbm "github.com/chainpoint/tendermint/libs/db"
+	"github.com/chainpoint/tendermint/libs/log"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Statuses a managed transaction moves through. Confirmed and Failed are final
+const (
+	StatusPending   = "pending"
+	StatusMined     = "mined"
+	StatusConfirmed = "confirmed"
+	StatusFailed    = "failed"
+)
+
+// Defaults used when a Manager leaves them unset
+const (
+	DefaultConfirmations = 12
+	DefaultBumpAfter     = 10 * time.Minute
+	DefaultBumpPercent   = 25
+)
+
+// txPrefix : key prefix for transaction records within the app database
+const txPrefix = "ethtx:"
+
+// minBumpPercent : the smallest gas price increase ethereum nodes accept for a replacement transaction
+const minBumpPercent = 10
+
+// ErrUnknownTx : returned when no transaction has been sent under an ID
+var ErrUnknownTx = errors.New("unknown transaction")
+
+// Backend : The ethereum node calls the Manager needs. Satisfied by *ethclient.Client
+type Backend interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error)
+}
+
+// Tx : A managed transaction. Every attempt shares one nonce; Hashes lists them oldest first, so whichever is mined is found
+type Tx struct {
+	ID            string   `json:"id"`
+	Nonce         uint64   `json:"nonce"`
+	To            string   `json:"to"`
+	Data          []byte   `json:"data"`
+	GasLimit      uint64   `json:"gas_limit"`
+	GasPrice      *big.Int `json:"gas_price"`
+	Hash          string   `json:"hash"`
+	Hashes        []string `json:"hashes"`
+	Status        string   `json:"status"`
+	SentAt        int64    `json:"sent_at"`
+	MinedHash     string   `json:"mined_hash"`
+	BlockNumber   int64    `json:"block_number"`
+	Confirmations int64    `json:"confirmations"`
+	Events        []Event  `json:"events"`
+	Error         string   `json:"error"`
+}
+
+// Event : a log emitted by a mined transaction, with the contract that emitted it and the block it was emitted in
+type Event struct {
+	Address     string `json:"address"`
+	BlockNumber int64  `json:"block_number"`
+}
+
+// Final : whether the transaction's status will no longer change
+func (tx Tx) Final() bool {
+	return tx.Status == StatusConfirmed || tx.Status == StatusFailed
+}
+
+// Manager : Sends transactions from one account and sees them through to Confirmations blocks.
+// Records are persisted in Db, so a restarted Core resumes tracking what it already sent. Nonces are assigned under a lock,
+// so overlapping sends never collide, and a transaction left unmined for BumpAfter is replaced at a BumpPercent higher
+// gas price, up to MaxGasPrice
+type Manager struct {
+	Backend       Backend
+	Db            dbm.DB
+	Key           *ecdsa.PrivateKey
+	ChainID       *big.Int
+	Confirmations int64
+	BumpAfter     time.Duration
+	BumpPercent   int64
+	MaxGasPrice   *big.Int
+	Logger        log.Logger
+	mux           sync.Mutex
+	nonce         *uint64
+	waiters       map[string][]chan Tx
+}
+
+// NewManager : Returns a manager sending from key's account, with default confirmation and gas bumping settings
+func NewManager(backend Backend, db dbm.DB, key *ecdsa.PrivateKey, chainID *big.Int, logger log.Logger) *Manager {
+	return &Manager{
+		Backend:       backend,
+		Db:            db,
+		Key:           key,
+		ChainID:       chainID,
+		Confirmations: DefaultConfirmations,
+		BumpAfter:     DefaultBumpAfter,
+		BumpPercent:   DefaultBumpPercent,
+		Logger:        logger,
+		waiters:       map[string][]chan Tx{},
+	}
+}
+
+// From : the account transactions are sent from
+func (m *Manager) From() common.Address {
+	return crypto.PubkeyToAddress(m.Key.PublicKey)
+}
+
+// Send : signs and broadcasts a call under id at the suggested gas price. A gasLimit of 0 is estimated.
+// Sending an id that is already pending or confirmed returns the existing transaction instead of sending another
+func (m *Manager) Send(id string, to common.Address, data []byte, gasLimit uint64) (Tx, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if existing, err := m.get(id); err == nil && existing.Status != StatusFailed {
+		return existing, nil
+	}
+	ctx := context.Background()
+	if gasLimit == 0 {
+		estimate, err := m.Backend.EstimateGas(ctx, ethereum.CallMsg{From: m.From(), To: &to, Data: data})
+		if err != nil {
+			return Tx{}, m.logError(err)
+		}
+		gasLimit = estimate
+	}
+	gasPrice, err := m.Backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return Tx{}, m.logError(err)
+	}
+	nonce, err := m.nextNonce(ctx)
+	if err != nil {
+		return Tx{}, m.logError(err)
+	}
+	tx := Tx{
+		ID:       id,
+		Nonce:    nonce,
+		To:       to.Hex(),
+		Data:     data,
+		GasLimit: gasLimit,
+		GasPrice: m.capGasPrice(gasPrice),
+		Hashes:   []string{},
+		Status:   StatusPending,
+	}
+	err = m.broadcast(ctx, &tx)
+	if err != nil && isNonceTooLow(err) {
+		// another sender used this account; resync with the node and try once more
+		m.nonce = nil
+		if tx.Nonce, err = m.nextNonce(ctx); err == nil {
+			err = m.broadcast(ctx, &tx)
+		}
+	}
+	if err != nil {
+		tx.Status = StatusFailed
+		tx.Error = err.Error()
+		m.put(tx)
+		return tx, m.logError(err)
+	}
+	next := tx.Nonce + 1
+	m.nonce = &next
+	m.Logger.Info(fmt.Sprintf("ethtx: sent %s as %s with nonce %d at gas price %s", id, tx.Hash, tx.Nonce, tx.GasPrice.String()))
+	return tx, m.put(tx)
+}
+
+// Get : the current record of the transaction sent under id
+func (m *Manager) Get(id string) (Tx, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.get(id)
+}
+
+// Pending : every transaction that hasn't reached a final status
+func (m *Manager) Pending() ([]Tx, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.pending()
+}
+
+// Await : returns a channel that receives the transaction sent under id once it is confirmed or has failed, then closes
+func (m *Manager) Await(id string) <-chan Tx {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	done := make(chan Tx, 1)
+	tx, err := m.get(id)
+	if err == ErrUnknownTx {
+		tx = Tx{ID: id, Status: StatusFailed, Error: err.Error()}
+	}
+	if tx.Final() {
+		done <- tx
+		close(done)
+		return done
+	}
+	m.waiters[id] = append(m.waiters[id], done)
+	return done
+}
+
+// Run : checks on pending transactions every interval until stop is closed. A nil stop runs forever
+func (m *Manager) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Check()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check : updates every pending transaction from its receipts, counting confirmations from the current head.
+// A mined transaction whose receipt disappears was reorged out and is pending again. A pending transaction left unmined
+// for BumpAfter is replaced at a higher gas price
+func (m *Manager) Check() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	ctx := context.Background()
+	txs, err := m.pending()
+	if err != nil || len(txs) == 0 {
+		return m.logError(err)
+	}
+	header, err := m.Backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return m.logError(err)
+	}
+	head := header.Number.Int64()
+	for _, tx := range txs {
+		before := tx.Status
+		if err := m.check(ctx, &tx, head); err != nil {
+			m.logError(err)
+			continue
+		}
+		if err := m.put(tx); err != nil {
+			m.logError(err)
+			continue
+		}
+		if tx.Status != before {
+			m.Logger.Info(fmt.Sprintf("ethtx: %s is %s (%s, %d confirmations)", tx.ID, tx.Status, tx.Hash, tx.Confirmations))
+		}
+		if tx.Final() {
+			for _, waiter := range m.waiters[tx.ID] {
+				waiter <- tx
+				close(waiter)
+			}
+			delete(m.waiters, tx.ID)
+		}
+	}
+	return nil
+}
+
+// check : advances one transaction's status given the current head
+func (m *Manager) check(ctx context.Context, tx *Tx, head int64) error {
+	receipt, hash, err := m.receipt(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if receipt == nil {
+		if tx.Status == StatusMined {
+			m.Logger.Info(fmt.Sprintf("ethtx: %s was reorged out of block %d", tx.ID, tx.BlockNumber))
+			tx.Status, tx.MinedHash, tx.BlockNumber, tx.Confirmations, tx.Events = StatusPending, "", 0, 0, nil
+			tx.SentAt = time.Now().Unix()
+			return nil
+		}
+		if time.Since(time.Unix(tx.SentAt, 0)) >= m.bumpAfter() {
+			return m.bump(ctx, tx)
+		}
+		return nil
+	}
+	// receipts don't carry their block number; take it from the logs, or from the head the receipt was first seen at
+	if len(receipt.Logs) > 0 {
+		tx.BlockNumber = int64(receipt.Logs[0].BlockNumber)
+	} else if tx.MinedHash != hash || tx.BlockNumber == 0 {
+		tx.BlockNumber = head
+	}
+	tx.MinedHash = hash
+	tx.Events = make([]Event, 0, len(receipt.Logs))
+	for _, eventLog := range receipt.Logs {
+		tx.Events = append(tx.Events, Event{Address: eventLog.Address.Hex(), BlockNumber: int64(eventLog.BlockNumber)})
+	}
+	tx.Status = StatusMined
+	tx.Confirmations = head - tx.BlockNumber + 1
+	if tx.Confirmations >= m.confirmations() {
+		if receipt.Status == ethtypes.ReceiptStatusFailed {
+			tx.Status = StatusFailed
+			tx.Error = "transaction reverted"
+		} else {
+			tx.Status = StatusConfirmed
+		}
+	}
+	return nil
+}
+
+// receipt : the receipt of whichever attempt was mined, if any
+func (m *Manager) receipt(ctx context.Context, tx *Tx) (*ethtypes.Receipt, string, error) {
+	for _, hash := range tx.Hashes {
+		receipt, err := m.Backend.TransactionReceipt(ctx, common.HexToHash(hash))
+		if err == ethereum.NotFound || (err == nil && receipt == nil) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return receipt, hash, nil
+	}
+	return nil, "", nil
+}
+
+// bump : replaces a stuck transaction with the same nonce at a higher gas price, or the suggested price if that's higher still.
+// A replacement the node rejects as underpriced keeps its raised price, so the next bump goes higher again
+func (m *Manager) bump(ctx context.Context, tx *Tx) error {
+	percent := m.BumpPercent
+	if percent < minBumpPercent {
+		percent = minBumpPercent
+	}
+	price := new(big.Int).Mul(tx.GasPrice, big.NewInt(100+percent))
+	price.Div(price, big.NewInt(100))
+	if suggested, err := m.Backend.SuggestGasPrice(ctx); err == nil && suggested.Cmp(price) > 0 {
+		price = suggested
+	}
+	price = m.capGasPrice(price)
+	if price.Cmp(tx.GasPrice) <= 0 {
+		m.Logger.Info(fmt.Sprintf("ethtx: %s is stuck at the maximum gas price %s", tx.ID, tx.GasPrice.String()))
+		tx.SentAt = time.Now().Unix()
+		return nil
+	}
+	tx.GasPrice = price
+	if err := m.broadcast(ctx, tx); err != nil {
+		tx.SentAt = time.Now().Unix()
+		if isUnderpriced(err) || isNonceTooLow(err) {
+			// nonce too low means an earlier attempt was mined and its receipt will turn up on a later check
+			m.Logger.Info(fmt.Sprintf("ethtx: replacement for %s not accepted: %s", tx.ID, err.Error()))
+			return nil
+		}
+		return err
+	}
+	m.Logger.Info(fmt.Sprintf("ethtx: replaced %s with %s at gas price %s", tx.ID, tx.Hash, price.String()))
+	return nil
+}
+
+// broadcast : signs and sends tx at its current nonce and gas price, recording the new attempt
+func (m *Manager) broadcast(ctx context.Context, tx *Tx) error {
+	signed, err := ethtypes.SignTx(
+		ethtypes.NewTransaction(tx.Nonce, common.HexToAddress(tx.To), big.NewInt(0), tx.GasLimit, tx.GasPrice, tx.Data),
+		ethtypes.NewEIP155Signer(m.ChainID), m.Key)
+	if err != nil {
+		return err
+	}
+	if err := m.Backend.SendTransaction(ctx, signed); err != nil {
+		return err
+	}
+	tx.Hash = signed.Hash().Hex()
+	tx.Hashes = append(tx.Hashes, tx.Hash)
+	tx.SentAt = time.Now().Unix()
+	return nil
+}
+
+// nextNonce : the next unused nonce, taking the higher of the node's pending nonce and any this manager has handed out
+func (m *Manager) nextNonce(ctx context.Context) (uint64, error) {
+	pending, err := m.Backend.PendingNonceAt(ctx, m.From())
+	if err != nil {
+		return 0, err
+	}
+	if m.nonce == nil {
+		txs, err := m.pending()
+		if err != nil {
+			return 0, err
+		}
+		for _, tx := range txs {
+			if tx.Nonce >= pending {
+				pending = tx.Nonce + 1
+			}
+		}
+	} else if *m.nonce > pending {
+		pending = *m.nonce
+	}
+	return pending, nil
+}
+
+// capGasPrice : price, limited to MaxGasPrice when one is set
+func (m *Manager) capGasPrice(price *big.Int) *big.Int {
+	if m.MaxGasPrice != nil && m.MaxGasPrice.Sign() > 0 && price.Cmp(m.MaxGasPrice) > 0 {
+		return new(big.Int).Set(m.MaxGasPrice)
+	}
+	return price
+}
+
+func (m *Manager) confirmations() int64 {
+	if m.Confirmations <= 0 {
+		return 1
+	}
+	return m.Confirmations
+}
+
+func (m *Manager) bumpAfter() time.Duration {
+	if m.BumpAfter <= 0 {
+		return DefaultBumpAfter
+	}
+	return m.BumpAfter
+}
+
+func (m *Manager) get(id string) (Tx, error) {
+	txJSON := m.Db.Get([]byte(txPrefix + id))
+	if txJSON == nil {
+		return Tx{}, ErrUnknownTx
+	}
+	var tx Tx
+	err := json.Unmarshal(txJSON, &tx)
+	return tx, err
+}
+
+func (m *Manager) put(tx Tx) error {
+	txJSON, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	m.Db.SetSync([]byte(txPrefix+tx.ID), txJSON)
+	return nil
+}
+
+func (m *Manager) pending() ([]Tx, error) {
+	txs := make([]Tx, 0)
+	itr := dbm.IteratePrefix(m.Db, []byte(txPrefix))
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		var tx Tx
+		if err := json.Unmarshal(itr.Value(), &tx); err != nil {
+			return nil, err
+		}
+		if !tx.Final() {
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
+
+// logError : logs err, if any, and returns it
+func (m *Manager) logError(err error) error {
+	if err != nil {
+		m.Logger.Error(fmt.Sprintf("Error: %s", err.Error()))
+	}
+	return err
+}
+
+// isUnderpriced : whether the node rejected a transaction for its gas price
+func isUnderpriced(err error) bool {
+	return strings.Contains(err.Error(), "underpriced")
+}
+
+// isNonceTooLow : whether the node rejected a transaction because its nonce was already used
+func isNonceTooLow(err error) bool {
+	return strings.Contains(err.Error(), "nonce too low")
+}
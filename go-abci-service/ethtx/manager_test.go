@@ -0,0 +1,218 @@
+package ethtx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	dbm "github.com/chainpoint/tendermint/libs/db"
+	"github.com/chainpoint/tendermint/libs/log"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeChain : an ethereum node whose pool accepts one transaction per nonce unless replaced at a 10% higher gas price
+type fakeChain struct {
+	mux      sync.Mutex
+	head     int64
+	gasPrice int64
+	pool     map[uint64]*ethtypes.Transaction
+	receipts map[common.Hash]*ethtypes.Receipt
+	sent     int
+}
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{head: 100, gasPrice: 10, pool: map[uint64]*ethtypes.Transaction{}, receipts: map[common.Hash]*ethtypes.Receipt{}}
+}
+
+func (f *fakeChain) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return uint64(len(f.pool)), nil
+}
+
+func (f *fakeChain) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(f.gasPrice), nil
+}
+
+func (f *fakeChain) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return 21000, nil
+}
+
+func (f *fakeChain) SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if existing, ok := f.pool[tx.Nonce()]; ok {
+		if _, mined := f.receipts[existing.Hash()]; mined {
+			return errors.New("nonce too low")
+		}
+		if new(big.Int).Mul(tx.GasPrice(), big.NewInt(100)).Cmp(new(big.Int).Mul(existing.GasPrice(), big.NewInt(110))) < 0 {
+			return errors.New("replacement transaction underpriced")
+		}
+	}
+	f.pool[tx.Nonce()] = tx
+	f.sent++
+	return nil
+}
+
+func (f *fakeChain) TransactionReceipt(ctx context.Context, hash common.Hash) (*ethtypes.Receipt, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if receipt, ok := f.receipts[hash]; ok {
+		return receipt, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (f *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return &ethtypes.Header{Number: big.NewInt(f.head)}, nil
+}
+
+// mine : includes the pooled transaction with nonce in the next block
+func (f *fakeChain) mine(nonce uint64, status uint64) common.Hash {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.head++
+	tx := f.pool[nonce]
+	f.receipts[tx.Hash()] = &ethtypes.Receipt{Status: status, TxHash: tx.Hash(), Logs: []*ethtypes.Log{{BlockNumber: uint64(f.head)}}}
+	return tx.Hash()
+}
+
+func (f *fakeChain) advance(blocks int64) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.head += blocks
+}
+
+func newTestManager(t *testing.T, chain *fakeChain) *Manager {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(chain, dbm.NewMemDB(), key, big.NewInt(3), log.NewNopLogger())
+	m.Confirmations = 3
+	return m
+}
+
+func TestManagerConfirmsAfterDepth(t *testing.T) {
+	assert := assert.New(t)
+	chain := newFakeChain()
+	m := newTestManager(t, chain)
+	to := common.HexToAddress("0x01")
+
+	tx, err := m.Send("NODE-MINT:100", to, []byte{0x01}, 0)
+	assert.NoError(err)
+	assert.Equal(StatusPending, tx.Status)
+	assert.Equal(uint64(21000), tx.GasLimit)
+	again, err := m.Send("NODE-MINT:100", to, []byte{0x01}, 0)
+	assert.NoError(err)
+	assert.Equal(tx.Hash, again.Hash, "resending an id should return the existing transaction")
+	assert.Equal(1, chain.sent)
+
+	done := m.Await(tx.ID)
+	chain.mine(tx.Nonce, ethtypes.ReceiptStatusSuccessful)
+	assert.NoError(m.Check())
+	tx, _ = m.Get(tx.ID)
+	assert.Equal(StatusMined, tx.Status)
+	assert.Equal(int64(101), tx.BlockNumber)
+
+	chain.advance(2)
+	assert.NoError(m.Check())
+	select {
+	case final := <-done:
+		assert.Equal(StatusConfirmed, final.Status)
+		assert.Equal(int64(3), final.Confirmations)
+		assert.Equal([]Event{{Address: common.Address{}.Hex(), BlockNumber: 101}}, final.Events, "the receipt's events are kept")
+	case <-time.After(time.Second):
+		t.Fatal("Await never delivered the confirmed transaction")
+	}
+	pending, _ := m.Pending()
+	assert.Len(pending, 0)
+}
+
+func TestManagerAssignsDistinctNonces(t *testing.T) {
+	chain := newFakeChain()
+	m := newTestManager(t, chain)
+	var wg sync.WaitGroup
+	for _, id := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			m.Send(id, common.HexToAddress("0x01"), nil, 21000)
+		}(id)
+	}
+	wg.Wait()
+	nonces := map[uint64]bool{}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		tx, err := m.Get(id)
+		assert.NoError(t, err)
+		nonces[tx.Nonce] = true
+	}
+	assert.Len(t, nonces, 4)
+}
+
+func TestManagerReplacesStuckTx(t *testing.T) {
+	assert := assert.New(t)
+	chain := newFakeChain()
+	m := newTestManager(t, chain)
+	m.BumpAfter = time.Nanosecond
+	m.BumpPercent = 5 // raised to the 10% nodes require
+	m.MaxGasPrice = big.NewInt(12)
+
+	tx, err := m.Send("CORE-MINT:100", common.HexToAddress("0x01"), nil, 21000)
+	assert.NoError(err)
+	time.Sleep(time.Millisecond)
+	assert.NoError(m.Check())
+	tx, _ = m.Get(tx.ID)
+	assert.Len(tx.Hashes, 2)
+	assert.Equal(int64(11), tx.GasPrice.Int64())
+
+	// the next bump is capped below the 10% the pool needs, so the replacement is rejected without losing the record
+	time.Sleep(time.Millisecond)
+	assert.NoError(m.Check())
+	tx, _ = m.Get(tx.ID)
+	assert.Len(tx.Hashes, 2)
+	assert.Equal(int64(12), tx.GasPrice.Int64())
+
+	// the first attempt is the one that gets mined
+	chain.mux.Lock()
+	first := tx.Hashes[0]
+	chain.head++
+	chain.receipts[common.HexToHash(first)] = &ethtypes.Receipt{Status: ethtypes.ReceiptStatusFailed}
+	chain.mux.Unlock()
+	assert.NoError(m.Check())
+	tx, _ = m.Get(tx.ID)
+	assert.Equal(StatusMined, tx.Status)
+	chain.advance(2)
+	assert.NoError(m.Check())
+	tx, _ = m.Get(tx.ID)
+	assert.Equal(StatusFailed, tx.Status, "a reverted transaction fails once confirmed")
+	assert.Equal(first, tx.MinedHash)
+}
+
+func TestManagerReorgReturnsToPending(t *testing.T) {
+	assert := assert.New(t)
+	chain := newFakeChain()
+	m := newTestManager(t, chain)
+	tx, _ := m.Send("NODE-MINT:200", common.HexToAddress("0x01"), nil, 21000)
+	hash := chain.mine(tx.Nonce, ethtypes.ReceiptStatusSuccessful)
+	assert.NoError(m.Check())
+	tx, _ = m.Get(tx.ID)
+	assert.Equal(StatusMined, tx.Status)
+
+	chain.mux.Lock()
+	delete(chain.receipts, hash)
+	chain.mux.Unlock()
+	assert.NoError(m.Check())
+	tx, _ = m.Get(tx.ID)
+	assert.Equal(StatusPending, tx.Status)
+	assert.Equal(int64(0), tx.BlockNumber)
+}
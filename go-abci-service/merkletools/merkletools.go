@@ -18,6 +18,16 @@ import (
 	"sync"
 )
 
+// Domain separation prefixes used by hardened (RFC 6962 style) trees, along with the proof ops they imply.
+// Both ops hash the running proof value: HardenedLeafOp computes sha256(0x00||value) and HardenedNodeOp computes
+// sha256(0x01||value), where the preceding l/r step has already made value left||right
+const (
+	LeafPrefix     byte   = 0x00
+	NodePrefix     byte   = 0x01
+	HardenedLeafOp string = "sha-256-leaf"
+	HardenedNodeOp string = "sha-256-node"
+)
+
 // Node : A node on the tree
 type Node struct {
 	Parent     *Node
@@ -113,11 +123,54 @@ func VerifyBTCProof(proofSteps []ProofStep, targetHash []byte, merkleRoot []byte
 	return verifyProof(proofSteps, targetHash, merkleRoot, performHashesTwice)
 }
 
+// VerifyHardenedProof : Checks the validity of the proof generated with MakeHardenedTree and returns true or false
+func VerifyHardenedProof(proofSteps []ProofStep, targetHash []byte, merkleRoot []byte) bool {
+	currentValue := HashLeaf(targetHash)
+	for _, proofStep := range proofSteps {
+		if proofStep.Left {
+			currentValue = HashNode(proofStep.Value, currentValue)
+		} else {
+			currentValue = HashNode(currentValue, proofStep.Value)
+		}
+	}
+	return bytes.Equal(currentValue, merkleRoot)
+}
+
+// HashLeaf : Returns sha256(0x00 || leaf), the hardened hash of a leaf value
+func HashLeaf(leaf []byte) []byte {
+	buf := make([]byte, 0, len(leaf)+1)
+	buf = append(buf, LeafPrefix)
+	buf = append(buf, leaf...)
+	hash := sha256.Sum256(buf)
+	return hash[:]
+}
+
+// HashNode : Returns sha256(0x01 || left || right), the hardened hash of an internal node
+func HashNode(left []byte, right []byte) []byte {
+	buf := make([]byte, 0, len(left)+len(right)+1)
+	buf = append(buf, NodePrefix)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+	hash := sha256.Sum256(buf)
+	return hash[:]
+}
+
 // MakeTree : Builds the tree using the given Leaves
 func (mt *MerkleTree) MakeTree() {
 	useOddNodeDuplication := false
 	performHashesTwice := false
-	makeTree(mt, useOddNodeDuplication, performHashesTwice)
+	useDomainSeparation := false
+	makeTree(mt, useOddNodeDuplication, performHashesTwice, useDomainSeparation)
+}
+
+// MakeHardenedTree : Builds the tree using the given Leaves
+// Leaves are hashed as sha256(0x00||leaf) and internal nodes as sha256(0x01||left||right),
+// so an internal node can never be presented as a leaf. Proofs must be checked with VerifyHardenedProof
+func (mt *MerkleTree) MakeHardenedTree() {
+	useOddNodeDuplication := false
+	performHashesTwice := false
+	useDomainSeparation := true
+	makeTree(mt, useOddNodeDuplication, performHashesTwice, useDomainSeparation)
 }
 
 // MakeBTCTree : Builds the tree using the given Leaves
@@ -127,10 +180,11 @@ func (mt *MerkleTree) MakeTree() {
 func (mt *MerkleTree) MakeBTCTree() {
 	useOddNodeDuplication := true
 	performHashesTwice := true
-	makeTree(mt, useOddNodeDuplication, performHashesTwice)
+	useDomainSeparation := false
+	makeTree(mt, useOddNodeDuplication, performHashesTwice, useDomainSeparation)
 }
 
-func makeTree(mt *MerkleTree, useOddNodeDuplication bool, performHashesTwice bool) {
+func makeTree(mt *MerkleTree, useOddNodeDuplication bool, performHashesTwice bool, useDomainSeparation bool) {
 	if len(mt.Leaves) > 0 {
 		// Initialize mt.Nodes and newNodeSet to start off containing all the Leaves
 		mt.Nodes = make([]*Node, len(mt.Leaves))
@@ -138,6 +192,17 @@ func makeTree(mt *MerkleTree, useOddNodeDuplication bool, performHashesTwice boo
 		copy(mt.Nodes, mt.Leaves)
 		copy(newNodeSet, mt.Leaves)
 
+		// In hardened mode each raw leaf gets a prefixed-hash parent, which becomes the
+		// first level of the tree. GetProof skips these links since they have no sibling
+		if useDomainSeparation {
+			for i, leaf := range mt.Leaves {
+				hashedLeaf := Node{nil, nil, false, false, HashLeaf(leaf.Hash)}
+				leaf.Parent = &hashedLeaf
+				newNodeSet[i] = &hashedLeaf
+			}
+			mt.Nodes = append(mt.Nodes, newNodeSet...)
+		}
+
 		// Process newNodeSet while there are still node pairs to process
 		for len(newNodeSet) > 1 {
 			// Set the current working set to newNodeSet and clear newNodeSet
@@ -165,7 +230,7 @@ func makeTree(mt *MerkleTree, useOddNodeDuplication bool, performHashesTwice boo
 						mt.Nodes = append(mt.Nodes, &duplicateNode)
 					}
 				}
-				go hashNodePair(&currentNodeSet, i, useOddNodeDuplication, performHashesTwice, &wg, &c)
+				go hashNodePair(&currentNodeSet, i, useOddNodeDuplication, performHashesTwice, useDomainSeparation, &wg, &c)
 				newNodeSet = append(newNodeSet, <-c)
 			}
 			// Wait for all tasks to complete
@@ -183,7 +248,7 @@ func makeTree(mt *MerkleTree, useOddNodeDuplication bool, performHashesTwice boo
 	}
 }
 
-func hashNodePair(nodes *[]*Node, index int, useOddNodeDuplication bool, performHashesTwice bool, wg *sync.WaitGroup, c *chan *Node) {
+func hashNodePair(nodes *[]*Node, index int, useOddNodeDuplication bool, performHashesTwice bool, useDomainSeparation bool, wg *sync.WaitGroup, c *chan *Node) {
 	// Always call Done() before exiting
 	defer wg.Done()
 	// Initialize the hash pair nodes we will be working with
@@ -202,13 +267,19 @@ func hashNodePair(nodes *[]*Node, index int, useOddNodeDuplication bool, perform
 		// concat hashes values
 		leftHash := hashPair[0].Hash[:]
 		rightHash := hashPair[1].Hash[:]
-		// calculate the hash, respecting the optional performHashesTwice setting
-		hash := sha256.Sum256(append(leftHash, rightHash...))
-		if performHashesTwice {
-			hash = sha256.Sum256(hash[:])
+		// calculate the hash, respecting the optional performHashesTwice and useDomainSeparation settings
+		var parentHash []byte
+		if useDomainSeparation {
+			parentHash = HashNode(leftHash, rightHash)
+		} else {
+			hash := sha256.Sum256(append(leftHash, rightHash...))
+			if performHashesTwice {
+				hash = sha256.Sum256(hash[:])
+			}
+			parentHash = hash[:]
 		}
 		// create new parent node
-		newParentNode = Node{nil, nil, false, false, hash[:]}
+		newParentNode = Node{nil, nil, false, false, parentHash}
 		// make parent links
 		hashPair[0].Parent = &newParentNode
 		hashPair[1].Parent = &newParentNode
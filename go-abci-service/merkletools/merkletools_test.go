@@ -356,3 +356,74 @@ func reverseBytes(bytes []byte) []byte {
 	}
 	return bytes
 }
+
+func TestHardenedTwoLeaves(t *testing.T) {
+	mt := MerkleTree{}
+	mt.AddLeaf(hLeft)
+	mt.AddLeaf(hRight)
+	mt.MakeHardenedTree()
+	expRoot := HashNode(HashLeaf(hLeft), HashLeaf(hRight))
+	if !bytes.Equal(mt.GetMerkleRoot(), expRoot) {
+		t.Errorf("merkle root value should be correct, got: %x, want: %x.", mt.GetMerkleRoot(), expRoot)
+	}
+	if !bytes.Equal(mt.GetLeaf(0).Hash, hLeft) {
+		t.Errorf("returned leaf should be the raw value, got: %x, want: %x.", mt.GetLeaf(0).Hash, hLeft)
+	}
+}
+
+func TestHardenedOneLeaf(t *testing.T) {
+	mt := MerkleTree{}
+	mt.AddLeaf(hLeft)
+	mt.MakeHardenedTree()
+	if !bytes.Equal(mt.GetMerkleRoot(), HashLeaf(hLeft)) {
+		t.Errorf("merkle root value should be correct, got: %x, want: %x.", mt.GetMerkleRoot(), HashLeaf(hLeft))
+	}
+	proof := mt.GetProof(0)
+	if len(proof) != 0 {
+		t.Errorf("proof should be [], got: %d, want: %d.", len(proof), 0)
+	}
+	if !VerifyHardenedProof(proof, hLeft, mt.GetMerkleRoot()) {
+		t.Errorf("proof should be valid, got: %t, want: %t.", false, true)
+	}
+}
+
+func TestHardenedProofFiveLeaves(t *testing.T) {
+	mt := MerkleTree{}
+	var h1, _ = hex.DecodeString("ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb")
+	var h2, _ = hex.DecodeString("3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d")
+	var h3, _ = hex.DecodeString("2e7d2c03a9507ae265ecf5b5356885a53393a2029d241394997265a1a25aefc6")
+	var h4, _ = hex.DecodeString("18ac3e7343f016890c510e93f935261169d9e3f565436429830faf0934f4f8e4")
+	var h5, _ = hex.DecodeString("3f79bb7b435b05321651daefd374cdc681dc06faa65e374e38337b88ca046dea")
+	leaves := [][]byte{h1, h2, h3, h4, h5}
+	mt.AddLeaves(leaves)
+	mt.MakeHardenedTree()
+	for i, leaf := range leaves {
+		proof := mt.GetProof(i)
+		if !VerifyHardenedProof(proof, leaf, mt.GetMerkleRoot()) {
+			t.Errorf("proof for leaf %d should be valid, got: %t, want: %t.", i, false, true)
+		}
+		if VerifyProof(proof, leaf, mt.GetMerkleRoot()) {
+			t.Errorf("hardened proof for leaf %d should not verify as a plain proof", i)
+		}
+	}
+}
+
+func TestHardenedRejectsInternalNodeAsLeaf(t *testing.T) {
+	mt := MerkleTree{}
+	mt.AddLeaves([][]byte{hLeft, hRight, hLeft, hRight})
+	mt.MakeHardenedTree()
+	// the left internal node of a four leaf tree, presented as though it were a leaf
+	internal := HashNode(HashLeaf(hLeft), HashLeaf(hRight))
+	proof := []ProofStep{ProofStep{Left: false, Value: internal}}
+	if VerifyHardenedProof(proof, internal, mt.GetMerkleRoot()) {
+		t.Errorf("internal node should not verify as a leaf, got: %t, want: %t.", true, false)
+	}
+	plain := MerkleTree{}
+	plain.AddLeaves([][]byte{hLeft, hRight, hLeft, hRight})
+	plain.MakeTree()
+	plainInternal := sha256.Sum256(append(append([]byte{}, hLeft...), hRight...))
+	plainProof := []ProofStep{ProofStep{Left: false, Value: plainInternal[:]}}
+	if !VerifyProof(plainProof, plainInternal[:], plain.GetMerkleRoot()) {
+		t.Errorf("plain trees accept internal nodes as leaves, got: %t, want: %t.", false, true)
+	}
+}
@@ -0,0 +1,278 @@
+/* Copyright 2019 Tierion
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*     http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package merkletools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// KVStore : Minimal key/value persistence needed by the MMR. Satisfied by tendermint's dbm.DB
+type KVStore interface {
+	Get(key []byte) []byte
+	Set(key []byte, value []byte)
+}
+
+// MemStore : In-memory KVStore, useful for testing
+type MemStore struct {
+	mux  sync.Mutex
+	data map[string][]byte
+}
+
+// Get : Returns the value stored under key, or nil
+func (ms *MemStore) Get(key []byte) []byte {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	return ms.data[string(key)]
+}
+
+// Set : Stores value under key
+func (ms *MemStore) Set(key []byte, value []byte) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if ms.data == nil {
+		ms.data = map[string][]byte{}
+	}
+	ms.data[string(key)] = value
+}
+
+// MerkleMountainRange : An append-only accumulator made of perfect hardened trees (peaks).
+// Nodes are stored by their post-order position, so positions never change as leaves are appended.
+// LeafCount is owned by the caller so that it can be persisted alongside the rest of its state
+type MerkleMountainRange struct {
+	Store     KVStore
+	Prefix    string
+	LeafCount uint64
+}
+
+// MMRConsistencyProof : Shows that the MMR with OldLeafCount leaves is a prefix of the MMR with NewLeafCount leaves.
+// Paths[i] leads from OldPeaks[i] up to the new peak that contains it
+type MMRConsistencyProof struct {
+	OldLeafCount uint64        `json:"old_leaf_count"`
+	NewLeafCount uint64        `json:"new_leaf_count"`
+	OldPeaks     [][]byte      `json:"old_peaks"`
+	NewPeaks     [][]byte      `json:"new_peaks"`
+	Paths        [][]ProofStep `json:"paths"`
+}
+
+// NewMerkleMountainRange : Returns an MMR over store containing leafCount leaves
+func NewMerkleMountainRange(store KVStore, prefix string, leafCount uint64) *MerkleMountainRange {
+	return &MerkleMountainRange{
+		Store:     store,
+		Prefix:    prefix,
+		LeafCount: leafCount,
+	}
+}
+
+// Append : Adds a leaf, merging equal-height peaks, and returns the index of the new leaf
+func (mmr *MerkleMountainRange) Append(leaf []byte) uint64 {
+	leafIndex := mmr.LeafCount
+	pos := mmrSize(leafIndex)
+	current := HashLeaf(leaf)
+	mmr.setNode(pos, current)
+	// every trailing one bit of the old leaf count is a peak of the same height we must merge with
+	for height := uint(0); height < uint(bits.TrailingZeros64(^leafIndex)); height++ {
+		left := mmr.getNode(pos - (uint64(1)<<(height+1) - 1))
+		current = HashNode(left, current)
+		pos++
+		mmr.setNode(pos, current)
+	}
+	mmr.LeafCount++
+	return leafIndex
+}
+
+// Root : Returns the current root of the MMR, or nil when empty
+func (mmr *MerkleMountainRange) Root() []byte {
+	return bagPeaks(mmr.Peaks(mmr.LeafCount))
+}
+
+// RootAt : Returns the root of the MMR as it was when it held leafCount leaves
+func (mmr *MerkleMountainRange) RootAt(leafCount uint64) ([]byte, error) {
+	if leafCount > mmr.LeafCount {
+		return nil, fmt.Errorf("leaf count %d exceeds MMR size %d", leafCount, mmr.LeafCount)
+	}
+	return bagPeaks(mmr.Peaks(leafCount)), nil
+}
+
+// Peaks : Returns the peak hashes, left to right, of the MMR with leafCount leaves
+func (mmr *MerkleMountainRange) Peaks(leafCount uint64) [][]byte {
+	peaks := make([][]byte, 0)
+	for _, p := range peakPositions(leafCount) {
+		peaks = append(peaks, mmr.getNode(p))
+	}
+	return peaks
+}
+
+// GetInclusionProof : Returns a proof that leafIndex is included in the MMR with leafCount leaves.
+// The proof is checked with VerifyHardenedProof against the root for leafCount
+func (mmr *MerkleMountainRange) GetInclusionProof(leafIndex uint64, leafCount uint64) ([]ProofStep, error) {
+	if leafCount > mmr.LeafCount || leafIndex >= leafCount {
+		return nil, fmt.Errorf("leaf %d is not within an MMR of %d leaves", leafIndex, leafCount)
+	}
+	peakIndex, _, _ := peakForLeaf(leafCount, leafIndex)
+	proof := mmr.pathToPeak(leafCount, leafIndex, 0)
+	peaks := mmr.Peaks(leafCount)
+	// peaks are bagged right to left, so the bag of everything right of our peak is hashed in first
+	if peakIndex+1 < len(peaks) {
+		proof = append(proof, ProofStep{Left: false, Value: bagPeaks(peaks[peakIndex+1:])})
+	}
+	for i := peakIndex - 1; i >= 0; i-- {
+		proof = append(proof, ProofStep{Left: true, Value: peaks[i]})
+	}
+	return proof, nil
+}
+
+// GetConsistencyProof : Returns a proof that the MMR with oldLeafCount leaves is a prefix of the one with newLeafCount leaves
+func (mmr *MerkleMountainRange) GetConsistencyProof(oldLeafCount uint64, newLeafCount uint64) (MMRConsistencyProof, error) {
+	if oldLeafCount > newLeafCount || newLeafCount > mmr.LeafCount {
+		return MMRConsistencyProof{}, fmt.Errorf("cannot prove consistency from %d to %d leaves in an MMR of %d leaves", oldLeafCount, newLeafCount, mmr.LeafCount)
+	}
+	proof := MMRConsistencyProof{
+		OldLeafCount: oldLeafCount,
+		NewLeafCount: newLeafCount,
+		OldPeaks:     mmr.Peaks(oldLeafCount),
+		NewPeaks:     mmr.Peaks(newLeafCount),
+		Paths:        make([][]ProofStep, 0),
+	}
+	for _, peak := range peakRanges(oldLeafCount) {
+		proof.Paths = append(proof.Paths, mmr.pathToPeak(newLeafCount, peak.firstLeaf, peak.height))
+	}
+	return proof, nil
+}
+
+// VerifyMMRConsistency : Checks that proof links oldRoot to newRoot and returns true or false
+func VerifyMMRConsistency(proof MMRConsistencyProof, oldRoot []byte, newRoot []byte) bool {
+	oldRanges := peakRanges(proof.OldLeafCount)
+	if proof.OldLeafCount > proof.NewLeafCount || len(proof.OldPeaks) != len(oldRanges) ||
+		len(proof.Paths) != len(oldRanges) || len(proof.NewPeaks) != len(peakRanges(proof.NewLeafCount)) {
+		return false
+	}
+	if !bytes.Equal(bagPeaks(proof.OldPeaks), oldRoot) || !bytes.Equal(bagPeaks(proof.NewPeaks), newRoot) {
+		return false
+	}
+	for i, peak := range oldRanges {
+		current := proof.OldPeaks[i]
+		for _, step := range proof.Paths[i] {
+			if step.Left {
+				current = HashNode(step.Value, current)
+			} else {
+				current = HashNode(current, step.Value)
+			}
+		}
+		newPeakIndex, _, _ := peakForLeaf(proof.NewLeafCount, peak.firstLeaf)
+		if !bytes.Equal(current, proof.NewPeaks[newPeakIndex]) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathToPeak : collects the siblings leading from the node of the given height above leafIndex to its peak
+func (mmr *MerkleMountainRange) pathToPeak(leafCount uint64, leafIndex uint64, height uint) []ProofStep {
+	_, peak, peakPos := peakForLeaf(leafCount, leafIndex)
+	steps := make([]ProofStep, 0)
+	pos := peakPos
+	firstLeaf := peak.firstLeaf
+	for h := peak.height; h > height; h-- {
+		half := uint64(1) << (h - 1)
+		rightPos := pos - 1
+		leftPos := pos - (uint64(1) << h)
+		if leafIndex < firstLeaf+half {
+			steps = append(steps, ProofStep{Left: false, Value: mmr.getNode(rightPos)})
+			pos = leftPos
+		} else {
+			steps = append(steps, ProofStep{Left: true, Value: mmr.getNode(leftPos)})
+			pos = rightPos
+			firstLeaf += half
+		}
+	}
+	// siblings were gathered top-down, proofs are read bottom-up
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return steps
+}
+
+func (mmr *MerkleMountainRange) key(pos uint64) []byte {
+	key := make([]byte, len(mmr.Prefix)+8)
+	copy(key, mmr.Prefix)
+	binary.BigEndian.PutUint64(key[len(mmr.Prefix):], pos)
+	return key
+}
+
+func (mmr *MerkleMountainRange) getNode(pos uint64) []byte {
+	return mmr.Store.Get(mmr.key(pos))
+}
+
+func (mmr *MerkleMountainRange) setNode(pos uint64, hash []byte) {
+	mmr.Store.Set(mmr.key(pos), hash)
+}
+
+// peakRange : describes a single peak by the first leaf it covers and its height
+type peakRange struct {
+	firstLeaf uint64
+	height    uint
+}
+
+// peakRanges : one peak per set bit of leafCount, tallest first
+func peakRanges(leafCount uint64) []peakRange {
+	ranges := make([]peakRange, 0)
+	var firstLeaf uint64
+	for h := 63; h >= 0; h-- {
+		if leafCount&(uint64(1)<<uint(h)) != 0 {
+			ranges = append(ranges, peakRange{firstLeaf: firstLeaf, height: uint(h)})
+			firstLeaf += uint64(1) << uint(h)
+		}
+	}
+	return ranges
+}
+
+// peakPositions : node positions of each peak, left to right
+func peakPositions(leafCount uint64) []uint64 {
+	positions := make([]uint64, 0)
+	for _, peak := range peakRanges(leafCount) {
+		positions = append(positions, mmrSize(peak.firstLeaf)+(uint64(1)<<(peak.height+1))-2)
+	}
+	return positions
+}
+
+// peakForLeaf : returns the index, range and node position of the peak covering leafIndex
+func peakForLeaf(leafCount uint64, leafIndex uint64) (int, peakRange, uint64) {
+	positions := peakPositions(leafCount)
+	for i, peak := range peakRanges(leafCount) {
+		if leafIndex < peak.firstLeaf+(uint64(1)<<peak.height) {
+			return i, peak, positions[i]
+		}
+	}
+	return -1, peakRange{}, 0
+}
+
+// mmrSize : number of nodes in an MMR with leafCount leaves
+func mmrSize(leafCount uint64) uint64 {
+	return 2*leafCount - uint64(bits.OnesCount64(leafCount))
+}
+
+// bagPeaks : folds the peaks right to left into a single root
+func bagPeaks(peaks [][]byte) []byte {
+	if len(peaks) == 0 {
+		return nil
+	}
+	root := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		root = HashNode(peaks[i], root)
+	}
+	return root
+}
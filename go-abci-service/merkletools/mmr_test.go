@@ -0,0 +1,114 @@
+/* Copyright 2019 Tierion
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*     http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package merkletools
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func mmrLeaf(i int) []byte {
+	hash := sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+	return hash[:]
+}
+
+func TestMMREmpty(t *testing.T) {
+	mmr := NewMerkleMountainRange(&MemStore{}, "mmr:", 0)
+	if mmr.Root() != nil {
+		t.Errorf("empty MMR root should be nil, got: %x", mmr.Root())
+	}
+}
+
+func TestMMRMatchesTreeForPowerOfTwo(t *testing.T) {
+	mmr := NewMerkleMountainRange(&MemStore{}, "mmr:", 0)
+	mt := MerkleTree{}
+	for i := 0; i < 8; i++ {
+		mmr.Append(mmrLeaf(i))
+		mt.AddLeaf(mmrLeaf(i))
+	}
+	mt.MakeHardenedTree()
+	if !bytes.Equal(mmr.Root(), mt.GetMerkleRoot()) {
+		t.Errorf("MMR with a single peak should equal the hardened tree root, got: %x, want: %x.", mmr.Root(), mt.GetMerkleRoot())
+	}
+}
+
+func TestMMRInclusionProofs(t *testing.T) {
+	mmr := NewMerkleMountainRange(&MemStore{}, "mmr:", 0)
+	for i := 0; i < 23; i++ {
+		if idx := mmr.Append(mmrLeaf(i)); idx != uint64(i) {
+			t.Errorf("leaf index should be sequential, got: %d, want: %d.", idx, i)
+		}
+		for count := uint64(1); count <= mmr.LeafCount; count++ {
+			root, err := mmr.RootAt(count)
+			if err != nil {
+				t.Fatalf("RootAt(%d) failed: %s", count, err)
+			}
+			for leaf := uint64(0); leaf < count; leaf++ {
+				proof, err := mmr.GetInclusionProof(leaf, count)
+				if err != nil {
+					t.Fatalf("GetInclusionProof(%d, %d) failed: %s", leaf, count, err)
+				}
+				if !VerifyHardenedProof(proof, mmrLeaf(int(leaf)), root) {
+					t.Errorf("inclusion proof for leaf %d of %d should be valid", leaf, count)
+				}
+				if VerifyHardenedProof(proof, mmrLeaf(int(leaf)+1), root) {
+					t.Errorf("inclusion proof for leaf %d of %d should not verify a different leaf", leaf, count)
+				}
+			}
+		}
+	}
+	if _, err := mmr.GetInclusionProof(23, 23); err == nil {
+		t.Errorf("inclusion proof for a leaf past the end should fail")
+	}
+}
+
+func TestMMRConsistencyProofs(t *testing.T) {
+	mmr := NewMerkleMountainRange(&MemStore{}, "mmr:", 0)
+	for i := 0; i < 19; i++ {
+		mmr.Append(mmrLeaf(i))
+	}
+	for oldCount := uint64(0); oldCount <= mmr.LeafCount; oldCount++ {
+		for newCount := oldCount; newCount <= mmr.LeafCount; newCount++ {
+			proof, err := mmr.GetConsistencyProof(oldCount, newCount)
+			if err != nil {
+				t.Fatalf("GetConsistencyProof(%d, %d) failed: %s", oldCount, newCount, err)
+			}
+			oldRoot, _ := mmr.RootAt(oldCount)
+			newRoot, _ := mmr.RootAt(newCount)
+			if !VerifyMMRConsistency(proof, oldRoot, newRoot) {
+				t.Errorf("consistency proof from %d to %d should be valid", oldCount, newCount)
+			}
+			if oldCount > 0 && VerifyMMRConsistency(proof, newRoot, oldRoot) && oldCount != newCount {
+				t.Errorf("consistency proof from %d to %d should not verify with swapped roots", oldCount, newCount)
+			}
+		}
+	}
+}
+
+func TestMMRReload(t *testing.T) {
+	store := &MemStore{}
+	mmr := NewMerkleMountainRange(store, "mmr:", 0)
+	for i := 0; i < 5; i++ {
+		mmr.Append(mmrLeaf(i))
+	}
+	reloaded := NewMerkleMountainRange(store, "mmr:", mmr.LeafCount)
+	if !bytes.Equal(reloaded.Root(), mmr.Root()) {
+		t.Errorf("reloaded MMR root should match, got: %x, want: %x.", reloaded.Root(), mmr.Root())
+	}
+	reloaded.Append(mmrLeaf(5))
+	mmr.Append(mmrLeaf(5))
+	if !bytes.Equal(reloaded.Root(), mmr.Root()) {
+		t.Errorf("reloaded MMR should keep accumulating, got: %x, want: %x.", reloaded.Root(), mmr.Root())
+	}
+}
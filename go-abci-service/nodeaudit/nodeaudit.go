@@ -0,0 +1,242 @@
+// Package nodeaudit runs a configurable set of checks against a Node and scores the outcome. Checks are registered
+// with a Registry; each is given a weight and a passing threshold, and every audit produces a Report that is persisted
+// per node so later checks can take a node's history into account.
+package nodeaudit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// Target : the node being audited, and what checks have learned about it so far in this audit. Checks run in
+// registration order, so a check may rely on fields set by the checks before it
+type Target struct {
+	Node        types.Node
+	Hash        string
+	HashID      string
+	SubmittedAt time.Time
+	Reachable   bool
+	Flags       []string
+	History     []Report
+}
+
+// Check : a single node audit check. Run returns a score between 0 (failed outright) and 1 (fully passed), and a
+// short explanation of the score
+type Check interface {
+	Name() string
+	Run(ctx context.Context, target *Target) (float64, string)
+}
+
+// CheckConfig : how a check counts towards an audit. A check with no weight isn't run; a check passes when its
+// score reaches its threshold
+type CheckConfig struct {
+	Weight    float64 `json:"weight"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Config : weights and thresholds for each check by name. An audit passes when every check that ran passed and the
+// weighted score reaches PassScore
+type Config struct {
+	Checks    map[string]CheckConfig `json:"checks"`
+	PassScore float64                `json:"pass_score"`
+}
+
+// Result : the outcome of one check
+type Result struct {
+	Check     string  `json:"check"`
+	Score     float64 `json:"score"`
+	Weight    float64 `json:"weight"`
+	Threshold float64 `json:"threshold"`
+	Passed    bool    `json:"passed"`
+	Detail    string  `json:"detail"`
+	Millis    int64   `json:"millis"`
+}
+
+// Report : the outcome of one audit of a node
+type Report struct {
+	EthAddr   string   `json:"eth_address"`
+	PublicIP  string   `json:"public_ip"`
+	Round     int64    `json:"round"`
+	Time      int64    `json:"time"`
+	Results   []Result `json:"results"`
+	Score     float64  `json:"score"`
+	Passed    bool     `json:"passed"`
+	Reachable bool     `json:"reachable"`
+	Flags     []string `json:"flags,omitempty"`
+	Reason    string   `json:"reason,omitempty"`
+}
+
+// Registry : the checks an Auditor can run, in the order they run
+type Registry struct {
+	checks []Check
+	mux    sync.Mutex
+}
+
+// NewRegistry : creates a registry holding checks
+func NewRegistry(checks ...Check) *Registry {
+	r := &Registry{}
+	for _, check := range checks {
+		r.Register(check)
+	}
+	return r
+}
+
+// Register : adds a check, replacing any registered under the same name in place
+func (r *Registry) Register(check Check) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for i, registered := range r.checks {
+		if registered.Name() == check.Name() {
+			r.checks[i] = check
+			return
+		}
+	}
+	r.checks = append(r.checks, check)
+}
+
+// Checks : the registered checks, in the order they run
+func (r *Registry) Checks() []Check {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]Check{}, r.checks...)
+}
+
+// ParseConfig : overrides defaults with a comma-delimited list of name=weight:threshold entries, e.g.
+// "tls=1:1,uptime=2:0.5". The threshold may be omitted to keep the default one
+func ParseConfig(spec string, defaults Config) (Config, error) {
+	config := Config{Checks: make(map[string]CheckConfig), PassScore: defaults.PassScore}
+	for name, check := range defaults.Checks {
+		config.Checks[name] = check
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return defaults, fmt.Errorf("audit check setting %s isn't of the form name=weight:threshold", entry)
+		}
+		name := strings.TrimSpace(parts[0])
+		check := config.Checks[name]
+		values := strings.SplitN(parts[1], ":", 2)
+		weight, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || weight < 0 {
+			return defaults, fmt.Errorf("invalid weight for audit check %s", name)
+		}
+		check.Weight = weight
+		if len(values) == 2 {
+			threshold, err := strconv.ParseFloat(values[1], 64)
+			if err != nil || threshold < 0 || threshold > 1 {
+				return defaults, fmt.Errorf("invalid threshold for audit check %s", name)
+			}
+			check.Threshold = threshold
+		}
+		config.Checks[name] = check
+	}
+	return config, nil
+}
+
+// Auditor : runs the registered checks against nodes according to a Config and persists the reports
+type Auditor struct {
+	Registry *Registry
+	Config   Config
+	Store    Store
+}
+
+// NewAuditor : creates an auditor. store may be nil, in which case reports aren't persisted and history is empty
+func NewAuditor(registry *Registry, config Config, store Store) *Auditor {
+	return &Auditor{Registry: registry, Config: config, Store: store}
+}
+
+// Audit : runs every weighted check against a node in turn, then scores and persists the report
+func (a *Auditor) Audit(ctx context.Context, round int64, target *Target) (Report, error) {
+	report := Report{
+		EthAddr:  target.Node.EthAddr,
+		PublicIP: target.Node.PublicIP.String,
+		Round:    round,
+		Time:     time.Now().Unix(),
+		Results:  []Result{},
+	}
+	if a.Store != nil && target.History == nil {
+		history, err := a.Store.History(target.Node)
+		if err != nil {
+			return report, err
+		}
+		target.History = history
+	}
+	var weighted, total float64
+	passed := true
+	for _, check := range a.Registry.Checks() {
+		config, ok := a.Config.Checks[check.Name()]
+		if !ok || config.Weight <= 0 {
+			continue
+		}
+		start := time.Now()
+		score, detail := check.Run(ctx, target)
+		score = math.Max(0, math.Min(1, score))
+		result := Result{
+			Check:     check.Name(),
+			Score:     score,
+			Weight:    config.Weight,
+			Threshold: config.Threshold,
+			Passed:    score >= config.Threshold,
+			Detail:    detail,
+			Millis:    int64(time.Since(start) / time.Millisecond),
+		}
+		if !result.Passed && passed {
+			passed = false
+			report.Reason = fmt.Sprintf("%s: %s", check.Name(), detail)
+		}
+		report.Results = append(report.Results, result)
+		weighted += score * config.Weight
+		total += config.Weight
+	}
+	if total == 0 {
+		return report, errors.New("no audit checks are enabled")
+	}
+	report.Score = weighted / total
+	report.Reachable = target.Reachable
+	report.Flags = target.Flags
+	report.Passed = passed && report.Score >= a.Config.PassScore
+	if passed && !report.Passed {
+		report.Reason = fmt.Sprintf("score %.2f is below %.2f", report.Score, a.Config.PassScore)
+	}
+	if a.Store != nil {
+		if err := a.Store.Put(report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Poll : calls try every interval until it reports done or ctx ends, returning the last attempt's error. The first
+// attempt is made after one interval
+func Poll(ctx context.Context, interval time.Duration, try func() (bool, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return lastErr
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			done, err := try()
+			if done {
+				return err
+			}
+			lastErr = err
+		}
+	}
+}
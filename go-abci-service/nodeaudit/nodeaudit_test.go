@@ -0,0 +1,101 @@
+package nodeaudit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+type fixedCheck struct {
+	name  string
+	score float64
+}
+
+func (c fixedCheck) Name() string {
+	return c.name
+}
+
+func (c fixedCheck) Run(ctx context.Context, target *Target) (float64, string) {
+	target.Reachable = true
+	return c.score, c.name
+}
+
+//memoryStore : keeps the last two reports of each node
+type memoryStore map[string][]Report
+
+func (s memoryStore) Put(report Report) error {
+	history := append(s[report.EthAddr], report)
+	if len(history) > 2 {
+		history = history[len(history)-2:]
+	}
+	s[report.EthAddr] = history
+	return nil
+}
+
+func (s memoryStore) History(node types.Node) ([]Report, error) {
+	return s[node.EthAddr], nil
+}
+
+func TestAuditScoresAndPersistsReports(t *testing.T) {
+	assert := assert.New(t)
+	config, err := ParseConfig("latency=1:0.5, tls=0", Config{Checks: map[string]CheckConfig{
+		"reputation": {Weight: 3, Threshold: 1},
+		"latency":    {Weight: 2, Threshold: 0.1},
+		"tls":        {Weight: 1, Threshold: 1},
+	}, PassScore: 0.5})
+	assert.NoError(err)
+	assert.Equal(CheckConfig{Weight: 1, Threshold: 0.5}, config.Checks["latency"])
+	_, err = ParseConfig("latency=fast", config)
+	assert.Error(err)
+
+	store := memoryStore{}
+	registry := NewRegistry(fixedCheck{"reputation", 1}, fixedCheck{"latency", 0.25}, fixedCheck{"tls", 0})
+	auditor := NewAuditor(registry, config, store)
+	node := types.Node{EthAddr: "0xAbC0000000000000000000000000000000000001", PublicIP: sql.NullString{String: "10.0.0.1", Valid: true}}
+
+	report, err := auditor.Audit(context.Background(), 1, &Target{Node: node})
+	assert.NoError(err)
+	assert.Len(report.Results, 2, "checks with no weight aren't run")
+	assert.False(report.Passed)
+	assert.Equal("latency: latency", report.Reason)
+	assert.InDelta(0.8125, report.Score, 0.0001)
+
+	registry.Register(fixedCheck{"latency", 0.75})
+	for round := int64(2); round <= 3; round++ {
+		report, err = auditor.Audit(context.Background(), round, &Target{Node: node})
+		assert.NoError(err)
+		assert.True(report.Passed)
+	}
+	history, err := store.History(node)
+	assert.NoError(err)
+	assert.Len(history, 2)
+	assert.Equal(int64(2), history[0].Round)
+	target := Target{Node: node}
+	auditor.Audit(context.Background(), 4, &target)
+	assert.Len(target.History, 2, "checks are given the node's stored history")
+	assert.Equal(int64(3), target.History[1].Round)
+}
+
+func TestPollRetriesUntilDone(t *testing.T) {
+	assert := assert.New(t)
+	attempts := 0
+	err := Poll(context.Background(), time.Millisecond, func() (bool, error) {
+		attempts++
+		return attempts == 3, nil
+	})
+	assert.NoError(err)
+	assert.Equal(3, attempts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = Poll(ctx, time.Millisecond, func() (bool, error) {
+		return false, errors.New("no Cal anchor")
+	})
+	assert.EqualError(err, "no Cal anchor", "the last attempt's error is returned once time runs out")
+}
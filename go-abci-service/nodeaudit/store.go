@@ -0,0 +1,14 @@
+package nodeaudit
+
+import (
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// DefaultHistoryLength : how many of a node's most recent reports checks are given by default
+const DefaultHistoryLength = 48
+
+// Store : persists reports, and supplies each node's recent reports, oldest first, to checks that take its history into account
+type Store interface {
+	Put(report Report) error
+	History(node types.Node) ([]Report, error)
+}
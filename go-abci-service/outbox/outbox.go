@@ -0,0 +1,98 @@
+package outbox
+
+import (
+	"encoding/json"
+
+	dbm "github.com/chainpoint/tendermint/libs/db"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// key prefixes for outbox records within the app database
+const (
+	aggPrefix = "outbox:agg:"
+	calPrefix = "outbox:cal:"
+)
+
+// Outbox : Durable record of aggregations and CAL submissions that haven't been fully handed off yet,
+// so that a restarted Core can finish what it started
+type Outbox struct {
+	Db dbm.DB
+}
+
+// NewOutbox : Returns an outbox stored in db
+func NewOutbox(db dbm.DB) *Outbox {
+	return &Outbox{Db: db}
+}
+
+// PutAggregation : Records an aggregation before its hashes are acked from the aggregation queue
+func (outbox *Outbox) PutAggregation(agg types.Aggregation) error {
+	aggJSON, err := json.Marshal(agg)
+	if err != nil {
+		return err
+	}
+	outbox.Db.SetSync([]byte(aggPrefix+agg.AggID), aggJSON)
+	return nil
+}
+
+// RemoveAggregation : Drops an aggregation once the CAL tx covering it is committed, or it has been requeued
+func (outbox *Outbox) RemoveAggregation(aggID string) {
+	outbox.Db.DeleteSync([]byte(aggPrefix + aggID))
+}
+
+// PendingAggregations : Returns recorded aggregations that aren't already part of a pending CAL
+func (outbox *Outbox) PendingAggregations() ([]types.Aggregation, error) {
+	cals, err := outbox.PendingCals()
+	if err != nil {
+		return nil, err
+	}
+	inCal := map[string]bool{}
+	for _, cal := range cals {
+		for _, proofData := range cal.CalAgg.ProofData {
+			inCal[proofData.AggID] = true
+		}
+	}
+	aggs := make([]types.Aggregation, 0)
+	itr := dbm.IteratePrefix(outbox.Db, []byte(aggPrefix))
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		var agg types.Aggregation
+		if err := json.Unmarshal(itr.Value(), &agg); err != nil {
+			return nil, err
+		}
+		if !inCal[agg.AggID] {
+			aggs = append(aggs, agg)
+		}
+	}
+	return aggs, nil
+}
+
+// PutCal : Records a CAL submission, keyed by its root
+func (outbox *Outbox) PutCal(cal types.PendingCal) error {
+	calJSON, err := json.Marshal(cal)
+	if err != nil {
+		return err
+	}
+	outbox.Db.SetSync([]byte(calPrefix+cal.CalRoot), calJSON)
+	return nil
+}
+
+// RemoveCal : Drops a CAL submission once its proof state has been acknowledged
+func (outbox *Outbox) RemoveCal(calRoot string) {
+	outbox.Db.DeleteSync([]byte(calPrefix + calRoot))
+}
+
+// PendingCals : Returns every CAL submission that hasn't been acknowledged yet
+func (outbox *Outbox) PendingCals() ([]types.PendingCal, error) {
+	cals := make([]types.PendingCal, 0)
+	itr := dbm.IteratePrefix(outbox.Db, []byte(calPrefix))
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		var cal types.PendingCal
+		if err := json.Unmarshal(itr.Value(), &cal); err != nil {
+			return nil, err
+		}
+		cals = append(cals, cal)
+	}
+	return cals, nil
+}
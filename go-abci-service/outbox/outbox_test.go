@@ -0,0 +1,46 @@
+package outbox
+
+import (
+	"testing"
+
+	dbm "github.com/chainpoint/tendermint/libs/db"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+func TestOutboxAggregations(t *testing.T) {
+	assert := assert.New(t)
+	outbox := NewOutbox(dbm.NewMemDB())
+	assert.Nil(outbox.PutAggregation(types.Aggregation{AggID: "a", AggRoot: "aa"}))
+	assert.Nil(outbox.PutAggregation(types.Aggregation{AggID: "b", AggRoot: "bb"}))
+	pending, err := outbox.PendingAggregations()
+	assert.Nil(err)
+	assert.Equal(2, len(pending), "both aggregations should be pending")
+	outbox.RemoveAggregation("a")
+	pending, _ = outbox.PendingAggregations()
+	assert.Equal(1, len(pending), "removed aggregation should no longer be pending")
+	assert.Equal("b", pending[0].AggID)
+}
+
+func TestOutboxSkipsAggregationsInPendingCal(t *testing.T) {
+	assert := assert.New(t)
+	outbox := NewOutbox(dbm.NewMemDB())
+	outbox.PutAggregation(types.Aggregation{AggID: "a", AggRoot: "aa"})
+	outbox.PutAggregation(types.Aggregation{AggID: "b", AggRoot: "bb"})
+	cal := types.PendingCal{CalRoot: "cc", CalAgg: types.CalAgg{CalRoot: "cc", ProofData: []types.CalProofData{types.CalProofData{AggID: "a"}}}}
+	assert.Nil(outbox.PutCal(cal))
+	pending, _ := outbox.PendingAggregations()
+	assert.Equal(1, len(pending), "aggregations already in a pending CAL should not be anchored again")
+	assert.Equal("b", pending[0].AggID)
+
+	cal.TxHash = "dd"
+	outbox.PutCal(cal)
+	cals, err := outbox.PendingCals()
+	assert.Nil(err)
+	assert.Equal(1, len(cals), "CALs should be tracked by root")
+	assert.Equal("dd", cals[0].TxHash)
+	outbox.RemoveCal("cc")
+	cals, _ = outbox.PendingCals()
+	assert.Equal(0, len(cals), "acknowledged CAL should be removed")
+}
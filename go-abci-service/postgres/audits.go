@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+)
+
+// auditFilter : restricts audits to one node, unless $1 is empty, and to those since unix time $2
+const auditFilter = "($1::text = '' OR eth_addr = $1) AND audited_at >= to_timestamp($2)"
+
+//RecordNodeAudit : stores one Core's audit of a node. Returns false if that Core's audit of the node for the round was already recorded.
+//Nodes are told apart by address and IP, since private network nodes have no address
+func (pg *Postgres) RecordNodeAudit(record types.NodeAuditRecord) (bool, error) {
+	flags, err := json.Marshal(nonNilStrings(record.Flags))
+	if util.LoggerError(pg.Logger, err) != nil {
+		return false, err
+	}
+	checks, err := json.Marshal(nonNilChecks(record.Checks))
+	if util.LoggerError(pg.Logger, err) != nil {
+		return false, err
+	}
+	stmt := "INSERT INTO audits (eth_addr, public_ip, core_id, round, passed, reachable, score, error, flags, checks, duration_ms, audited_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, to_timestamp($12)) " +
+		"ON CONFLICT (core_id, round, eth_addr, public_ip) DO NOTHING;"
+	res, err := pg.DB.Exec(stmt, strings.ToLower(record.EthAddr), record.PublicIP, record.CoreID, record.Round, record.Passed,
+		record.Reachable, record.Score, record.Error, string(flags), string(checks), record.Millis, record.AuditedAt)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return false, err
+	}
+	affect, err := res.RowsAffected()
+	if util.LoggerError(pg.Logger, err) != nil {
+		return false, err
+	}
+	return affect > 0, nil
+}
+
+// auditColumns : the columns scanNodeAudits reads, in order
+const auditColumns = "eth_addr, public_ip, core_id, round, passed, reachable, score, error, flags, checks, duration_ms, " +
+	"extract(epoch FROM audited_at)::bigint"
+
+//GetNodeAudits : a node's most recent audits by any Core, newest first
+func (pg *Postgres) GetNodeAudits(ethAddr string, limit int) ([]types.NodeAuditRecord, error) {
+	stmt := "SELECT " + auditColumns + " FROM audits WHERE eth_addr = $1 ORDER BY audited_at DESC, id DESC LIMIT $2;"
+	rows, err := pg.DB.Query(stmt, strings.ToLower(ethAddr), limit)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return []types.NodeAuditRecord{}, err
+	}
+	return pg.scanNodeAudits(rows)
+}
+
+//GetCoreNodeAudits : one Core's most recent audits of a node, newest first. Private network nodes have no address, so
+//theirs are found by IP
+func (pg *Postgres) GetCoreNodeAudits(coreID string, ethAddr string, publicIP string, limit int) ([]types.NodeAuditRecord, error) {
+	stmt := "SELECT " + auditColumns + " FROM audits WHERE core_id = $1 AND eth_addr = $2 AND ($2::text <> '' OR public_ip = $3) " +
+		"ORDER BY audited_at DESC, id DESC LIMIT $4;"
+	rows, err := pg.DB.Query(stmt, coreID, strings.ToLower(ethAddr), publicIP, limit)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return []types.NodeAuditRecord{}, err
+	}
+	return pg.scanNodeAudits(rows)
+}
+
+//scanNodeAudits : reads audit rows selected with auditColumns, closing rows
+func (pg *Postgres) scanNodeAudits(rows *sql.Rows) ([]types.NodeAuditRecord, error) {
+	defer rows.Close()
+	records := make([]types.NodeAuditRecord, 0)
+	for rows.Next() {
+		var record types.NodeAuditRecord
+		var flags, checks []byte
+		err := rows.Scan(&record.EthAddr, &record.PublicIP, &record.CoreID, &record.Round, &record.Passed, &record.Reachable,
+			&record.Score, &record.Error, &flags, &checks, &record.Millis, &record.AuditedAt)
+		if util.LoggerError(pg.Logger, err) != nil {
+			return []types.NodeAuditRecord{}, err
+		}
+		if err := json.Unmarshal(flags, &record.Flags); util.LoggerError(pg.Logger, err) != nil {
+			return []types.NodeAuditRecord{}, err
+		}
+		if err := json.Unmarshal(checks, &record.Checks); util.LoggerError(pg.Logger, err) != nil {
+			return []types.NodeAuditRecord{}, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+//GetNodeAuditStats : how often a node, or every node if ethAddr is empty, passed its audits and each audit check since a unix time
+func (pg *Postgres) GetNodeAuditStats(ethAddr string, since int64) (types.NodeAuditStats, error) {
+	ethAddr = strings.ToLower(ethAddr)
+	stats := types.NodeAuditStats{EthAddr: ethAddr, Since: since, Checks: []types.NodeAuditCheckStats{}}
+	stmt := "SELECT count(*), coalesce(sum(CASE WHEN passed THEN 1 ELSE 0 END), 0) FROM audits WHERE " + auditFilter + ";"
+	if err := pg.DB.QueryRow(stmt, ethAddr, since).Scan(&stats.Audits, &stats.Passed); util.LoggerError(pg.Logger, err) != nil {
+		return stats, err
+	}
+	stmt = "SELECT c->>'check', count(*), sum(CASE WHEN (c->>'passed')::boolean THEN 1 ELSE 0 END), avg((c->>'millis')::bigint) " +
+		"FROM audits, jsonb_array_elements(checks) c WHERE " + auditFilter + " GROUP BY 1 ORDER BY 1;"
+	rows, err := pg.DB.Query(stmt, ethAddr, since)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var check types.NodeAuditCheckStats
+		if err := rows.Scan(&check.Check, &check.Runs, &check.Passed, &check.AvgMillis); util.LoggerError(pg.Logger, err) != nil {
+			return stats, err
+		}
+		stats.Checks = append(stats.Checks, check)
+	}
+	return withPassRates(stats), rows.Err()
+}
+
+//withPassRates : fills in pass rates from the pass and run counts
+func withPassRates(stats types.NodeAuditStats) types.NodeAuditStats {
+	if stats.Audits > 0 {
+		stats.PassRate = float64(stats.Passed) / float64(stats.Audits)
+	}
+	for i, check := range stats.Checks {
+		if check.Runs > 0 {
+			stats.Checks[i].PassRate = float64(check.Passed) / float64(check.Runs)
+		}
+	}
+	return stats
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func nonNilChecks(checks []types.NodeAuditCheck) []types.NodeAuditCheck {
+	if checks == nil {
+		return []types.NodeAuditCheck{}
+	}
+	return checks
+}
@@ -0,0 +1,474 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// MemoryStore : In-memory Store for tests, following the same upsert and delete rules as the Postgres queries
+type MemoryStore struct {
+	mux     sync.Mutex
+	nodes   map[string]types.Node
+	cores   map[string]types.Core
+	tokens  map[string]string
+	events  []types.StakingEvent
+	removed map[string]bool
+	cursors map[string]int64
+	audits  []types.NodeAuditRecord
+}
+
+// NewMemoryStore : Returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nodes:   map[string]types.Node{},
+		cores:   map[string]types.Core{},
+		tokens:  map[string]string{},
+		removed: map[string]bool{},
+		cursors: map[string]int64{},
+	}
+}
+
+// NodeUpsert : Inserts a new node if it doesn't exist, otherwise updates it if the block number is higher or the IP changed
+func (m *MemoryStore) NodeUpsert(node types.Node) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	existing, exists := m.nodes[node.EthAddr]
+	if exists && !supersedes(node.BlockNumber.Int64, node.BlockNumber.Valid, existing.BlockNumber.Int64, existing.BlockNumber.Valid) &&
+		!ipChanged(node.PublicIP.String, node.PublicIP.Valid, existing.PublicIP.String, existing.PublicIP.Valid) {
+		return false, nil
+	}
+	m.nodes[node.EthAddr] = node
+	return true, nil
+}
+
+// NodeDelete : deletes a node if the input's block number is at least its stored block number
+func (m *MemoryStore) NodeDelete(node types.Node) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	existing, exists := m.nodes[node.EthAddr]
+	if !exists || !notAfter(existing.BlockNumber.Int64, existing.BlockNumber.Valid, node.BlockNumber.Int64, node.BlockNumber.Valid) {
+		return false, nil
+	}
+	delete(m.nodes, node.EthAddr)
+	return true, nil
+}
+
+// CoreUpsert : Inserts a new core if it doesn't exist, otherwise updates it if the block number is higher or the IP changed.
+// Like the staked_cores unique index, two cores may not share a public IP
+func (m *MemoryStore) CoreUpsert(core types.Core) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for addr, other := range m.cores {
+		if addr != core.EthAddr && other.PublicIP.Valid && core.PublicIP.Valid && other.PublicIP.String == core.PublicIP.String {
+			return false, fmt.Errorf("public_ip %s already belongs to core %s", core.PublicIP.String, addr)
+		}
+	}
+	existing, exists := m.cores[core.EthAddr]
+	if exists && !supersedes(core.BlockNumber.Int64, core.BlockNumber.Valid, existing.BlockNumber.Int64, existing.BlockNumber.Valid) &&
+		!ipChanged(core.PublicIP.String, core.PublicIP.Valid, existing.PublicIP.String, existing.PublicIP.Valid) {
+		return false, nil
+	}
+	m.cores[core.EthAddr] = core
+	return true, nil
+}
+
+// CoreDelete : deletes a core if the input's block number is at least its stored block number
+func (m *MemoryStore) CoreDelete(core types.Core) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	existing, exists := m.cores[core.EthAddr]
+	if !exists || !notAfter(existing.BlockNumber.Int64, existing.BlockNumber.Valid, core.BlockNumber.Int64, core.BlockNumber.Valid) {
+		return false, nil
+	}
+	delete(m.cores, core.EthAddr)
+	return true, nil
+}
+
+// TokenHashUpsert : records the token hash in an "ip|hash" payload for a known node
+func (m *MemoryStore) TokenHashUpsert(data string) (bool, error) {
+	payloadSlice := strings.Split(data, "|")
+	if len(payloadSlice) != 2 {
+		return false, errors.New("TOKEN tx is malformed")
+	}
+	nodeIP := payloadSlice[0]
+	node, err := m.GetNodeByPublicIP(nodeIP)
+	if err != nil || node.PublicIP.String != nodeIP {
+		return false, err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.tokens[nodeIP] = payloadSlice[1]
+	return true, nil
+}
+
+// GetTokenHash : returns the active token hash for a node IP, for use in tests
+func (m *MemoryStore) GetTokenHash(nodeIP string) (string, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	hash, exists := m.tokens[nodeIP]
+	return hash, exists
+}
+
+// GetRandomNodes : Get a random sequence of 3 nodes
+func (m *MemoryStore) GetRandomNodes() ([]types.Node, error) {
+	return m.randomNodes(rand.New(rand.NewSource(rand.Int63()))), nil
+}
+
+// GetNodeCount : number of staked nodes
+func (m *MemoryStore) GetNodeCount() (int, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return len(m.nodes), nil
+}
+
+// GetCoreCount : number of staked cores
+func (m *MemoryStore) GetCoreCount() (int, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return len(m.cores), nil
+}
+
+// GetNodeByEthAddr : gets a staked node by its ethereum address, or an empty Node
+func (m *MemoryStore) GetNodeByEthAddr(ethAddr string) (types.Node, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.nodes[ethAddr], nil
+}
+
+// GetNodeByPublicIP : gets a staked node by its public IP, or an empty Node
+func (m *MemoryStore) GetNodeByPublicIP(publicIP string) (types.Node, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, node := range m.sortedNodes() {
+		if node.PublicIP.Valid && node.PublicIP.String == publicIP {
+			return node, nil
+		}
+	}
+	return types.Node{}, nil
+}
+
+// GetCoreByID : gets a staked core by its Tendermint ID, or an empty Core
+func (m *MemoryStore) GetCoreByID(coreId string) (types.Core, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	addrs := make([]string, 0, len(m.cores))
+	for addr := range m.cores {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		if core := m.cores[addr]; core.CoreId.Valid && core.CoreId.String == coreId {
+			return core, nil
+		}
+	}
+	return types.Core{}, nil
+}
+
+// RecordStakingEvent : appends event to the ledger unless an event with the same block hash, tx hash and log index exists
+func (m *MemoryStore) RecordStakingEvent(event types.StakingEvent) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, recorded := range m.events {
+		if logKey(recorded) == logKey(event) {
+			return false, nil
+		}
+	}
+	m.events = append(m.events, event)
+	return true, nil
+}
+
+// GetStakedNodesAtBlock : nodes that were staked as of ethereum block number
+func (m *MemoryStore) GetStakedNodesAtBlock(block int64) ([]types.Node, error) {
+	nodes := make([]types.Node, 0)
+	for _, event := range m.stakedAtBlock(types.StakingKindNode, block) {
+		nodes = append(nodes, types.Node{
+			EthAddr:     event.EthAddr,
+			PublicIP:    sql.NullString{String: event.PublicIP, Valid: true},
+			BlockNumber: sql.NullInt64{Int64: event.BlockNumber, Valid: true},
+		})
+	}
+	return nodes, nil
+}
+
+// GetStakedCoresAtBlock : cores that were staked as of ethereum block number
+func (m *MemoryStore) GetStakedCoresAtBlock(block int64) ([]types.Core, error) {
+	cores := make([]types.Core, 0)
+	for _, event := range m.stakedAtBlock(types.StakingKindCore, block) {
+		cores = append(cores, types.Core{
+			EthAddr:     event.EthAddr,
+			CoreId:      sql.NullString{String: event.CoreID, Valid: event.CoreID != ""},
+			PublicIP:    sql.NullString{String: event.PublicIP, Valid: true},
+			BlockNumber: sql.NullInt64{Int64: event.BlockNumber, Valid: true},
+		})
+	}
+	return cores, nil
+}
+
+// GetStakingHistory : every live event for an address of the given kind, oldest first
+func (m *MemoryStore) GetStakingHistory(kind string, ethAddr string) ([]types.StakingEvent, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	history := make([]types.StakingEvent, 0)
+	for _, event := range m.sortedEvents() {
+		if event.Kind == kind && event.EthAddr == ethAddr {
+			history = append(history, event)
+		}
+	}
+	return history, nil
+}
+
+// GetStakingEventsAfter : every live event of the given kind emitted after block, oldest first
+func (m *MemoryStore) GetStakingEventsAfter(kind string, block int64) ([]types.StakingEvent, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	events := make([]types.StakingEvent, 0)
+	for _, event := range m.sortedEvents() {
+		if event.Kind == kind && event.BlockNumber > block {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// RemoveStakingEvent : marks a recorded event as removed by a reorg
+func (m *MemoryStore) RemoveStakingEvent(event types.StakingEvent) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.removed[logKey(event)] {
+		return false, nil
+	}
+	m.removed[logKey(event)] = true
+	return true, nil
+}
+
+// ReconcileStaking : rebuilds the node or core entry for an address from its latest live event
+func (m *MemoryStore) ReconcileStaking(kind string, ethAddr string) error {
+	var latest *types.StakingEvent
+	for _, event := range m.stakedAtBlock(kind, math.MaxInt64) {
+		if event.EthAddr == ethAddr {
+			current := event
+			latest = &current
+		}
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	switch kind {
+	case types.StakingKindNode:
+		delete(m.nodes, ethAddr)
+		if latest != nil {
+			m.nodes[ethAddr] = types.Node{
+				EthAddr:     ethAddr,
+				PublicIP:    sql.NullString{String: latest.PublicIP, Valid: true},
+				BlockNumber: sql.NullInt64{Int64: latest.BlockNumber, Valid: true},
+			}
+		}
+	case types.StakingKindCore:
+		delete(m.cores, ethAddr)
+		if latest != nil {
+			m.cores[ethAddr] = types.Core{
+				EthAddr:     ethAddr,
+				CoreId:      sql.NullString{String: latest.CoreID, Valid: latest.CoreID != ""},
+				PublicIP:    sql.NullString{String: latest.PublicIP, Valid: true},
+				BlockNumber: sql.NullInt64{Int64: latest.BlockNumber, Valid: true},
+			}
+		}
+	default:
+		return fmt.Errorf("unknown staking kind %s", kind)
+	}
+	return nil
+}
+
+// GetIngestionCursor : the last block fully ingested by the named poller
+func (m *MemoryStore) GetIngestionCursor(name string) (int64, bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	block, found := m.cursors[name]
+	return block, found, nil
+}
+
+// SetIngestionCursor : records the last block fully ingested by the named poller
+func (m *MemoryStore) SetIngestionCursor(name string, block int64) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.cursors[name] = block
+	return nil
+}
+
+// RecordNodeAudit : stores one Core's audit of a node, unless that Core's audit of the node for the round is already stored
+func (m *MemoryStore) RecordNodeAudit(record types.NodeAuditRecord) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	record.EthAddr = strings.ToLower(record.EthAddr)
+	for _, existing := range m.audits {
+		if existing.CoreID == record.CoreID && existing.Round == record.Round && existing.EthAddr == record.EthAddr && existing.PublicIP == record.PublicIP {
+			return false, nil
+		}
+	}
+	m.audits = append(m.audits, record)
+	return true, nil
+}
+
+// GetNodeAudits : a node's most recent audits by any Core, newest first
+func (m *MemoryStore) GetNodeAudits(ethAddr string, limit int) ([]types.NodeAuditRecord, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	records := make([]types.NodeAuditRecord, 0)
+	for i := len(m.audits) - 1; i >= 0; i-- {
+		if m.audits[i].EthAddr == strings.ToLower(ethAddr) {
+			records = append(records, m.audits[i])
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].AuditedAt > records[j].AuditedAt
+	})
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+// GetCoreNodeAudits : one Core's most recent audits of a node, newest first. Private network nodes have no address, so
+// theirs are found by IP
+func (m *MemoryStore) GetCoreNodeAudits(coreID string, ethAddr string, publicIP string, limit int) ([]types.NodeAuditRecord, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	ethAddr = strings.ToLower(ethAddr)
+	records := make([]types.NodeAuditRecord, 0)
+	for i := len(m.audits) - 1; i >= 0; i-- {
+		audit := m.audits[i]
+		if audit.CoreID == coreID && audit.EthAddr == ethAddr && (ethAddr != "" || audit.PublicIP == publicIP) {
+			records = append(records, audit)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].AuditedAt > records[j].AuditedAt
+	})
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+// GetNodeAuditStats : how often a node, or every node if ethAddr is empty, passed its audits and each audit check since a unix time
+func (m *MemoryStore) GetNodeAuditStats(ethAddr string, since int64) (types.NodeAuditStats, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	ethAddr = strings.ToLower(ethAddr)
+	stats := types.NodeAuditStats{EthAddr: ethAddr, Since: since, Checks: []types.NodeAuditCheckStats{}}
+	checks := map[string]*types.NodeAuditCheckStats{}
+	millis := map[string]int64{}
+	for _, record := range m.audits {
+		if (ethAddr != "" && record.EthAddr != ethAddr) || record.AuditedAt < since {
+			continue
+		}
+		stats.Audits++
+		if record.Passed {
+			stats.Passed++
+		}
+		for _, check := range record.Checks {
+			if checks[check.Check] == nil {
+				checks[check.Check] = &types.NodeAuditCheckStats{Check: check.Check}
+			}
+			checks[check.Check].Runs++
+			if check.Passed {
+				checks[check.Check].Passed++
+			}
+			millis[check.Check] += check.Millis
+		}
+	}
+	for name, check := range checks {
+		check.AvgMillis = float64(millis[name]) / float64(check.Runs)
+		stats.Checks = append(stats.Checks, *check)
+	}
+	sort.Slice(stats.Checks, func(i, j int) bool {
+		return stats.Checks[i].Check < stats.Checks[j].Check
+	})
+	return withPassRates(stats), nil
+}
+
+// stakedAtBlock : the latest event per address at or before block, for addresses whose latest event isn't an unstake
+func (m *MemoryStore) stakedAtBlock(kind string, block int64) []types.StakingEvent {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	latest := map[string]types.StakingEvent{}
+	for _, event := range m.sortedEvents() {
+		if event.Kind == kind && event.BlockNumber <= block {
+			latest[event.EthAddr] = event
+		}
+	}
+	staked := make([]types.StakingEvent, 0)
+	for _, event := range latest {
+		if event.EventType != types.StakingEventUnstaked {
+			staked = append(staked, event)
+		}
+	}
+	sort.Slice(staked, func(i, j int) bool { return staked[i].EthAddr < staked[j].EthAddr })
+	return staked
+}
+
+// sortedEvents : live events in chain order, leaving out those removed by a reorg. Caller holds mux
+func (m *MemoryStore) sortedEvents() []types.StakingEvent {
+	events := make([]types.StakingEvent, 0, len(m.events))
+	for _, event := range m.events {
+		if !m.removed[logKey(event)] {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].BlockNumber != events[j].BlockNumber {
+			return events[i].BlockNumber < events[j].BlockNumber
+		}
+		return events[i].LogIndex < events[j].LogIndex
+	})
+	return events
+}
+
+// logKey : identifies an event's log on chain
+func logKey(event types.StakingEvent) string {
+	return fmt.Sprintf("%s|%s|%d", event.BlockHash, event.TxHash, event.LogIndex)
+}
+
+// randomNodes : up to 3 nodes in an order drawn from r
+func (m *MemoryStore) randomNodes(r *rand.Rand) []types.Node {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	nodes := m.sortedNodes()
+	r.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	if len(nodes) > 3 {
+		nodes = nodes[:3]
+	}
+	return nodes
+}
+
+// sortedNodes : nodes ordered by eth address, so lookups and shuffles are deterministic. Caller holds mux
+func (m *MemoryStore) sortedNodes() []types.Node {
+	nodes := make([]types.Node, 0, len(m.nodes))
+	for _, node := range m.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].EthAddr < nodes[j].EthAddr })
+	return nodes
+}
+
+// supersedes : whether block a is higher than block b. Like SQL, comparisons involving NULL are false
+func supersedes(a int64, aValid bool, b int64, bValid bool) bool {
+	return aValid && bValid && a > b
+}
+
+// notAfter : whether block a is no higher than block b. Like SQL, comparisons involving NULL are false
+func notAfter(a int64, aValid bool, b int64, bValid bool) bool {
+	return aValid && bValid && a <= b
+}
+
+// ipChanged : whether IP a differs from IP b. Like SQL, comparisons involving NULL are false
+func ipChanged(a string, aValid bool, b string, bValid bool) bool {
+	return aValid && bValid && a != b
+}
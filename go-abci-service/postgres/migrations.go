@@ -0,0 +1,204 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+)
+
+// migrationLockID : key of the Postgres advisory lock held while migrations run, so that concurrent
+// service starts apply each migration exactly once
+const migrationLockID = 0x63687063 // "chpc"
+
+// Migration : A versioned schema change. Applied migrations are recorded in schema_migrations and never edited;
+// further changes get a new, higher Version
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations : Every schema change owned by this service, in the order they're applied.
+// Version 1 adopts tables previously created by the API service, so its statements tolerate existing objects
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_staked_nodes_cores_and_active_tokens",
+		SQL: `CREATE TABLE IF NOT EXISTS staked_nodes (
+	eth_addr VARCHAR(255) PRIMARY KEY,
+	public_ip VARCHAR(255),
+	block_number BIGINT,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS staked_nodes_created_at ON staked_nodes (created_at);
+CREATE TABLE IF NOT EXISTS staked_cores (
+	eth_addr VARCHAR(255) PRIMARY KEY,
+	core_id VARCHAR(255),
+	public_ip VARCHAR(255) NOT NULL,
+	block_number BIGINT,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS staked_cores_created_at ON staked_cores (created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS staked_cores_public_ip ON staked_cores (public_ip);
+CREATE TABLE IF NOT EXISTS active_tokens (
+	node_ip VARCHAR(255) PRIMARY KEY,
+	token_hash VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS active_tokens_updated_at ON active_tokens (updated_at);`,
+	},
+	{
+		Version: 2,
+		Name:    "create_staking_events_ledger",
+		SQL: `CREATE TABLE IF NOT EXISTS staking_events (
+	id BIGSERIAL PRIMARY KEY,
+	kind VARCHAR(16) NOT NULL CHECK (kind IN ('node', 'core')),
+	event_type VARCHAR(32) NOT NULL CHECK (event_type IN ('staked', 'stake_updated', 'unstaked')),
+	eth_addr VARCHAR(255) NOT NULL,
+	public_ip VARCHAR(255),
+	core_id VARCHAR(255),
+	block_number BIGINT NOT NULL,
+	tx_hash VARCHAR(66) NOT NULL,
+	log_index INTEGER NOT NULL,
+	recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	UNIQUE (tx_hash, log_index)
+);
+CREATE INDEX IF NOT EXISTS staking_events_history ON staking_events (kind, eth_addr, block_number, log_index);
+CREATE OR REPLACE FUNCTION staking_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'staking_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER staking_events_append_only BEFORE UPDATE OR DELETE ON staking_events
+	FOR EACH ROW EXECUTE PROCEDURE staking_events_append_only();
+CREATE OR REPLACE VIEW staking_current AS
+SELECT kind, eth_addr, public_ip, core_id, block_number, tx_hash, log_index FROM (
+	SELECT DISTINCT ON (kind, eth_addr) * FROM staking_events
+	ORDER BY kind, eth_addr, block_number DESC, log_index DESC
+) latest WHERE event_type <> 'unstaked';`,
+	},
+	{
+		Version: 3,
+		Name:    "add_ingestion_cursors_and_reorg_removals",
+		SQL: `ALTER TABLE staking_events ADD COLUMN IF NOT EXISTS block_hash VARCHAR(66) NOT NULL DEFAULT '';
+ALTER TABLE staking_events DROP CONSTRAINT IF EXISTS staking_events_tx_hash_log_index_key;
+ALTER TABLE staking_events ADD CONSTRAINT staking_events_log_key UNIQUE (block_hash, tx_hash, log_index);
+CREATE TABLE IF NOT EXISTS staking_event_removals (
+	block_hash VARCHAR(66) NOT NULL,
+	tx_hash VARCHAR(66) NOT NULL,
+	log_index INTEGER NOT NULL,
+	removed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	PRIMARY KEY (block_hash, tx_hash, log_index)
+);
+CREATE OR REPLACE VIEW staking_live AS
+SELECT e.* FROM staking_events e WHERE NOT EXISTS (
+	SELECT 1 FROM staking_event_removals r
+	WHERE r.block_hash = e.block_hash AND r.tx_hash = e.tx_hash AND r.log_index = e.log_index
+);
+CREATE OR REPLACE VIEW staking_current AS
+SELECT kind, eth_addr, public_ip, core_id, block_number, tx_hash, log_index FROM (
+	SELECT DISTINCT ON (kind, eth_addr) * FROM staking_live
+	ORDER BY kind, eth_addr, block_number DESC, log_index DESC
+) latest WHERE event_type <> 'unstaked';
+CREATE TABLE IF NOT EXISTS ingestion_cursors (
+	name VARCHAR(64) PRIMARY KEY,
+	block_number BIGINT NOT NULL,
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);`,
+	},
+	{
+		Version: 4,
+		Name:    "create_audits",
+		SQL: `CREATE TABLE IF NOT EXISTS audits (
+	id BIGSERIAL PRIMARY KEY,
+	eth_addr VARCHAR(255) NOT NULL,
+	public_ip VARCHAR(255) NOT NULL DEFAULT '',
+	core_id VARCHAR(255) NOT NULL,
+	round BIGINT NOT NULL,
+	passed BOOLEAN NOT NULL,
+	score DOUBLE PRECISION NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	flags JSONB NOT NULL DEFAULT '[]',
+	checks JSONB NOT NULL DEFAULT '[]',
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	audited_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	UNIQUE (core_id, round, eth_addr)
+);
+CREATE INDEX IF NOT EXISTS audits_node_history ON audits (eth_addr, audited_at DESC);
+CREATE INDEX IF NOT EXISTS audits_audited_at ON audits (audited_at);`,
+	},
+	{
+		Version: 5,
+		Name:    "audits_reachable",
+		SQL: `ALTER TABLE audits ADD COLUMN IF NOT EXISTS reachable BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE audits DROP CONSTRAINT IF EXISTS audits_core_id_round_eth_addr_key;
+ALTER TABLE audits ADD CONSTRAINT audits_core_id_round_node_key UNIQUE (core_id, round, eth_addr, public_ip);
+CREATE INDEX IF NOT EXISTS audits_core_history ON audits (core_id, eth_addr, audited_at DESC);`,
+	},
+}
+
+// Migrate : Applies any migrations not yet recorded in schema_migrations, each in its own transaction,
+// while holding an advisory lock
+func (pg *Postgres) Migrate() error {
+	ctx := context.Background()
+	// session-level advisory locks belong to a connection, so every statement below must use the same one
+	conn, err := pg.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1);", migrationLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1);", migrationLockID)
+
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations ("+
+		"version INTEGER PRIMARY KEY, "+
+		"name VARCHAR(255) NOT NULL, "+
+		"applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now());")
+	if err != nil {
+		return err
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations;")
+	if err != nil {
+		return err
+	}
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, migration := range Migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s) failed: %s", migration.Version, migration.Name, err.Error())
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2);", migration.Version, migration.Name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		pg.Logger.Info(fmt.Sprintf("Applied postgres migration %d: %s", migration.Version, migration.Name))
+	}
+	return nil
+}
@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/chp-project/chainpoint-core/go-abci-service/ethcontracts"
-
 	"github.com/chp-project/chainpoint-core/go-abci-service/types"
 
 	"github.com/chp-project/chainpoint-core/go-abci-service/util"
@@ -78,9 +76,9 @@ func (pg *Postgres) NodeUpsert(node types.Node) (bool, error) {
 	return false, nil
 }
 
-//NodeDelete : deletes a row if the blockNumber of the input unstake event is higher
+//NodeDelete : deletes a row if the blockNumber of the input unstake event is at least that of the stake or update
 func (pg *Postgres) NodeDelete(node types.Node) (bool, error) {
-	stmt := "DELETE FROM staked_nodes WHERE eth_addr = $1 AND block_number > $2;"
+	stmt := "DELETE FROM staked_nodes WHERE eth_addr = $1 AND block_number <= $2;"
 	res, err := pg.DB.Exec(stmt, node.EthAddr, node.BlockNumber)
 	if util.LoggerError(pg.Logger, err) != nil {
 		return false, err
@@ -120,9 +118,9 @@ func (pg *Postgres) CoreUpsert(core types.Core) (bool, error) {
 	return false, nil
 }
 
-//CoreDelete : deletes a row if the blockNumber of the input unstake event is higher
+//CoreDelete : deletes a row if the blockNumber of the input unstake event is at least that of the stake or update
 func (pg *Postgres) CoreDelete(core types.Core) (bool, error) {
-	stmt := "DELETE FROM staked_cores WHERE eth_addr = $1 AND block_number > $2;"
+	stmt := "DELETE FROM staked_cores WHERE eth_addr = $1 AND block_number <= $2;"
 	res, err := pg.DB.Exec(stmt, core.EthAddr, core.BlockNumber)
 	if util.LoggerError(pg.Logger, err) != nil {
 		return false, err
@@ -175,40 +173,6 @@ func (pg *Postgres) TokenHashUpsert(data string) (bool, error) {
 	return false, nil
 }
 
-//GetSeededRandomNodes : Get seeded random sequence of 3 nodes from the staked_nodes table
-func (pg *Postgres) GetSeededRandomNodes(seed []byte) ([]types.Node, error) {
-	//Usage:
-	//nodes, err := app.pgClient.GetSeededRandomNodes([]byte("3719ADA3EEE198F3A7A33616EA60ED6D72D94D31A2B2422FA12E2BCDDCABD4D4"))
-	//fmt.Printf("Random nodes: %#v\n", nodes)
-	seedFloat := util.GetSeededRandFloat([]byte(seed))
-	seedStmt := fmt.Sprintf("SET seed TO %f;", seedFloat)
-	_, err := pg.DB.Exec(seedStmt)
-	if util.LoggerError(pg.Logger, err) != nil {
-		return []types.Node{}, err
-	}
-	randomStmt := "SELECT eth_addr, public_ip,block_number FROM staked_nodes ORDER BY random() LIMIT 3;"
-	rows, err := pg.DB.Query(randomStmt)
-	if util.LoggerError(pg.Logger, err) != nil {
-		return []types.Node{}, err
-	}
-	defer rows.Close()
-	nodes := make([]types.Node, 0)
-	for rows.Next() {
-		var node types.Node
-		switch err := rows.Scan(&node.EthAddr, &node.PublicIP, &node.BlockNumber); err {
-		case sql.ErrNoRows:
-			return []types.Node{}, nil
-		case nil:
-			nodes = append(nodes, node)
-			break
-		default:
-			util.LoggerError(pg.Logger, err)
-			return []types.Node{}, err
-		}
-	}
-	return nodes, nil
-}
-
 //GetRandomNodes : Get random sequence of 3 nodes from the staked_nodes table
 func (pg *Postgres) GetRandomNodes() ([]types.Node, error) {
 	randomStmt := "SELECT eth_addr, public_ip,block_number FROM staked_nodes ORDER BY random() LIMIT 3;"
@@ -309,48 +273,3 @@ func (pg *Postgres) GetCoreByID(coreId string) (types.Core, error) {
 		return types.Core{}, err
 	}
 }
-
-//HandleNodeStaking : receive watch event and upsert it into db
-func (pg *Postgres) HandleNodeStaking(node ethcontracts.ChpRegistryNodeStaked) error {
-	newNode := types.Node{
-		EthAddr:     node.Sender.Hex(),
-		PublicIP:    sql.NullString{String: util.Int2Ip(node.NodeIp).String(), Valid: true},
-		BlockNumber: sql.NullInt64{Int64: int64(node.Raw.BlockNumber), Valid: true},
-	}
-	inserted, err := pg.NodeUpsert(newNode)
-	if util.LoggerError(pg.Logger, err) != nil {
-		return err
-	}
-	pg.Logger.Info(fmt.Sprintf("Inserted for %#v: %t\n", newNode, inserted))
-	return nil
-}
-
-//HandleNodeStakeUpdating : receive update event and upsert it into db if it supercedes the existing row
-func (pg *Postgres) HandleNodeStakeUpdating(node ethcontracts.ChpRegistryNodeStakeUpdated) error {
-	newNode := types.Node{
-		EthAddr:     node.Sender.Hex(),
-		PublicIP:    sql.NullString{String: util.Int2Ip(node.NodeIp).String(), Valid: true},
-		BlockNumber: sql.NullInt64{Int64: int64(node.Raw.BlockNumber), Valid: true},
-	}
-	inserted, err := pg.NodeUpsert(newNode)
-	if util.LoggerError(pg.Logger, err) != nil {
-		return err
-	}
-	pg.Logger.Info(fmt.Sprintf("Updated for %#v: %t\n", newNode, inserted))
-	return nil
-}
-
-//HandleNodeUnstaking : receive unstake event and delete it if the event is more recent than the stake or update
-func (pg *Postgres) HandleNodeUnstake(node ethcontracts.ChpRegistryNodeUnStaked) error {
-	newNode := types.Node{
-		EthAddr:     node.Sender.Hex(),
-		PublicIP:    sql.NullString{String: util.Int2Ip(node.NodeIp).String(), Valid: true},
-		BlockNumber: sql.NullInt64{Int64: int64(node.Raw.BlockNumber), Valid: true},
-	}
-	inserted, err := pg.NodeDelete(newNode)
-	if util.LoggerError(pg.Logger, err) != nil {
-		return err
-	}
-	pg.Logger.Info(fmt.Sprintf("Deleted for %#v: %t\n", newNode, inserted))
-	return nil
-}
@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/chp-project/chainpoint-core/go-abci-service/util"
+)
+
+// stakedAtBlockStmt : the latest live event per address at or before a block, keeping addresses whose latest event isn't an unstake
+const stakedAtBlockStmt = "SELECT eth_addr, public_ip, core_id, block_number FROM (" +
+	"SELECT DISTINCT ON (eth_addr) eth_addr, public_ip, core_id, block_number, event_type FROM staking_live " +
+	"WHERE kind = $1 AND block_number <= $2 " +
+	"ORDER BY eth_addr, block_number DESC, log_index DESC" +
+	") latest WHERE event_type <> 'unstaked' ORDER BY eth_addr;"
+
+//RecordStakingEvent : appends a registry event to the staking_events ledger. Returns false if the event was already recorded
+func (pg *Postgres) RecordStakingEvent(event types.StakingEvent) (bool, error) {
+	stmt := "INSERT INTO staking_events (kind, event_type, eth_addr, public_ip, core_id, block_number, block_hash, tx_hash, log_index) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) " +
+		"ON CONFLICT (block_hash, tx_hash, log_index) DO NOTHING;"
+	res, err := pg.DB.Exec(stmt, event.Kind, event.EventType, event.EthAddr, event.PublicIP,
+		sql.NullString{String: event.CoreID, Valid: event.CoreID != ""}, event.BlockNumber, event.BlockHash, event.TxHash, event.LogIndex)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return false, err
+	}
+	affect, err := res.RowsAffected()
+	if util.LoggerError(pg.Logger, err) != nil {
+		return false, err
+	}
+	return affect > 0, nil
+}
+
+//GetStakedNodesAtBlock : nodes that were staked as of ethereum block number, according to the staking ledger
+func (pg *Postgres) GetStakedNodesAtBlock(block int64) ([]types.Node, error) {
+	rows, err := pg.DB.Query(stakedAtBlockStmt, types.StakingKindNode, block)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return []types.Node{}, err
+	}
+	defer rows.Close()
+	nodes := make([]types.Node, 0)
+	for rows.Next() {
+		var node types.Node
+		var coreID sql.NullString
+		if err := rows.Scan(&node.EthAddr, &node.PublicIP, &coreID, &node.BlockNumber); util.LoggerError(pg.Logger, err) != nil {
+			return []types.Node{}, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+//GetStakedCoresAtBlock : cores that were staked as of ethereum block number, according to the staking ledger
+func (pg *Postgres) GetStakedCoresAtBlock(block int64) ([]types.Core, error) {
+	rows, err := pg.DB.Query(stakedAtBlockStmt, types.StakingKindCore, block)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return []types.Core{}, err
+	}
+	defer rows.Close()
+	cores := make([]types.Core, 0)
+	for rows.Next() {
+		var core types.Core
+		if err := rows.Scan(&core.EthAddr, &core.PublicIP, &core.CoreId, &core.BlockNumber); util.LoggerError(pg.Logger, err) != nil {
+			return []types.Core{}, err
+		}
+		cores = append(cores, core)
+	}
+	return cores, rows.Err()
+}
+
+//GetStakingHistory : every live event for an address of the given kind, oldest first. Events removed by a reorg are omitted
+func (pg *Postgres) GetStakingHistory(kind string, ethAddr string) ([]types.StakingEvent, error) {
+	stmt := "SELECT kind, event_type, eth_addr, public_ip, core_id, block_number, block_hash, tx_hash, log_index FROM staking_live " +
+		"WHERE kind = $1 AND eth_addr = $2 ORDER BY block_number, log_index;"
+	rows, err := pg.DB.Query(stmt, kind, ethAddr)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return []types.StakingEvent{}, err
+	}
+	return pg.scanStakingEvents(rows)
+}
+
+//GetStakingEventsAfter : every live event of the given kind emitted after an ethereum block, oldest first
+func (pg *Postgres) GetStakingEventsAfter(kind string, block int64) ([]types.StakingEvent, error) {
+	stmt := "SELECT kind, event_type, eth_addr, public_ip, core_id, block_number, block_hash, tx_hash, log_index FROM staking_live " +
+		"WHERE kind = $1 AND block_number > $2 ORDER BY block_number, log_index;"
+	rows, err := pg.DB.Query(stmt, kind, block)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return []types.StakingEvent{}, err
+	}
+	return pg.scanStakingEvents(rows)
+}
+
+//scanStakingEvents : reads staking_live rows into events, closing rows
+func (pg *Postgres) scanStakingEvents(rows *sql.Rows) ([]types.StakingEvent, error) {
+	defer rows.Close()
+	events := make([]types.StakingEvent, 0)
+	for rows.Next() {
+		var event types.StakingEvent
+		var publicIP, coreID sql.NullString
+		err := rows.Scan(&event.Kind, &event.EventType, &event.EthAddr, &publicIP, &coreID, &event.BlockNumber, &event.BlockHash, &event.TxHash, &event.LogIndex)
+		if util.LoggerError(pg.Logger, err) != nil {
+			return []types.StakingEvent{}, err
+		}
+		event.PublicIP = publicIP.String
+		event.CoreID = coreID.String
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+//RemoveStakingEvent : marks a recorded event as removed from the canonical chain by a reorg. The event row itself is kept
+func (pg *Postgres) RemoveStakingEvent(event types.StakingEvent) (bool, error) {
+	stmt := "INSERT INTO staking_event_removals (block_hash, tx_hash, log_index) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (block_hash, tx_hash, log_index) DO NOTHING;"
+	res, err := pg.DB.Exec(stmt, event.BlockHash, event.TxHash, event.LogIndex)
+	if util.LoggerError(pg.Logger, err) != nil {
+		return false, err
+	}
+	affect, err := res.RowsAffected()
+	if util.LoggerError(pg.Logger, err) != nil {
+		return false, err
+	}
+	return affect > 0, nil
+}
+
+//ReconcileStaking : rebuilds the staked_nodes or staked_cores row for an address from its latest live ledger event,
+//rolling back any state written by events that have since been removed
+func (pg *Postgres) ReconcileStaking(kind string, ethAddr string) error {
+	var deleteStmt, insertStmt string
+	switch kind {
+	case types.StakingKindNode:
+		deleteStmt = "DELETE FROM staked_nodes WHERE eth_addr = $1;"
+		insertStmt = "INSERT INTO staked_nodes (eth_addr, public_ip, block_number, created_at, updated_at) " +
+			"SELECT eth_addr, public_ip, block_number, now(), now() FROM staking_current WHERE kind = $1 AND eth_addr = $2;"
+	case types.StakingKindCore:
+		deleteStmt = "DELETE FROM staked_cores WHERE eth_addr = $1;"
+		insertStmt = "INSERT INTO staked_cores (eth_addr, core_id, public_ip, block_number, created_at, updated_at) " +
+			"SELECT eth_addr, core_id, public_ip, block_number, now(), now() FROM staking_current WHERE kind = $1 AND eth_addr = $2;"
+	default:
+		return fmt.Errorf("unknown staking kind %s", kind)
+	}
+	tx, err := pg.DB.Begin()
+	if util.LoggerError(pg.Logger, err) != nil {
+		return err
+	}
+	if _, err := tx.Exec(deleteStmt, ethAddr); util.LoggerError(pg.Logger, err) != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(insertStmt, kind, ethAddr); util.LoggerError(pg.Logger, err) != nil {
+		tx.Rollback()
+		return err
+	}
+	return util.LoggerError(pg.Logger, tx.Commit())
+}
+
+//GetIngestionCursor : the last block fully ingested by the named registry poller. found is false if it has never run
+func (pg *Postgres) GetIngestionCursor(name string) (int64, bool, error) {
+	var block int64
+	err := pg.DB.QueryRow("SELECT block_number FROM ingestion_cursors WHERE name = $1;", name).Scan(&block)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case util.LoggerError(pg.Logger, err) != nil:
+		return 0, false, err
+	default:
+		return block, true, nil
+	}
+}
+
+//SetIngestionCursor : persists the last block fully ingested by the named registry poller
+func (pg *Postgres) SetIngestionCursor(name string, block int64) error {
+	stmt := "INSERT INTO ingestion_cursors (name, block_number, updated_at) VALUES ($1, $2, now()) " +
+		"ON CONFLICT (name) DO UPDATE SET block_number = EXCLUDED.block_number, updated_at = now();"
+	_, err := pg.DB.Exec(stmt, name, block)
+	return util.LoggerError(pg.Logger, err)
+}
@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// Store : Data access for the staked_nodes, staked_cores and active_tokens tables, the staking_events ledger, registry ingestion cursors
+// and node audit history
+type Store interface {
+	NodeUpsert(node types.Node) (bool, error)
+	NodeDelete(node types.Node) (bool, error)
+	CoreUpsert(core types.Core) (bool, error)
+	CoreDelete(core types.Core) (bool, error)
+	TokenHashUpsert(data string) (bool, error)
+	GetRandomNodes() ([]types.Node, error)
+	GetNodeCount() (int, error)
+	GetCoreCount() (int, error)
+	GetNodeByEthAddr(ethAddr string) (types.Node, error)
+	GetNodeByPublicIP(publicIP string) (types.Node, error)
+	GetCoreByID(coreId string) (types.Core, error)
+	RecordStakingEvent(event types.StakingEvent) (bool, error)
+	GetStakedNodesAtBlock(block int64) ([]types.Node, error)
+	GetStakedCoresAtBlock(block int64) ([]types.Core, error)
+	GetStakingHistory(kind string, ethAddr string) ([]types.StakingEvent, error)
+	GetStakingEventsAfter(kind string, block int64) ([]types.StakingEvent, error)
+	RemoveStakingEvent(event types.StakingEvent) (bool, error)
+	ReconcileStaking(kind string, ethAddr string) error
+	GetIngestionCursor(name string) (int64, bool, error)
+	SetIngestionCursor(name string, block int64) error
+	RecordNodeAudit(record types.NodeAuditRecord) (bool, error)
+	GetNodeAudits(ethAddr string, limit int) ([]types.NodeAuditRecord, error)
+	GetCoreNodeAudits(coreID string, ethAddr string, publicIP string, limit int) ([]types.NodeAuditRecord, error)
+	GetNodeAuditStats(ethAddr string, since int64) (types.NodeAuditStats, error)
+}
+
+var _ Store = (*Postgres)(nil)
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,207 @@
+package postgres
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func testNode(addr string, ip string, block int64) types.Node {
+	return types.Node{
+		EthAddr:     addr,
+		PublicIP:    sql.NullString{String: ip, Valid: true},
+		BlockNumber: sql.NullInt64{Int64: block, Valid: true},
+	}
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, migration := range Migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %s has version %d, expected %d", migration.Name, migration.Version, i+1)
+		}
+		if migration.Name == "" || migration.SQL == "" {
+			t.Errorf("migration %d needs a name and SQL", migration.Version)
+		}
+	}
+}
+
+func TestMemoryStoreNodes(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+
+	inserted, err := store.NodeUpsert(testNode("0xa", "10.0.0.1", 10))
+	assert.Nil(err)
+	assert.True(inserted, "new node should be inserted")
+	inserted, _ = store.NodeUpsert(testNode("0xa", "10.0.0.1", 5))
+	assert.False(inserted, "older stake with the same IP should be ignored")
+	inserted, _ = store.NodeUpsert(testNode("0xa", "10.0.0.2", 5))
+	assert.True(inserted, "IP change should update")
+	node, _ := store.GetNodeByPublicIP("10.0.0.2")
+	assert.Equal("0xa", node.EthAddr)
+
+	deleted, _ := store.NodeDelete(testNode("0xa", "10.0.0.2", 1))
+	assert.False(deleted, "an unstake older than the stake should be ignored")
+	deleted, _ = store.NodeDelete(testNode("0xa", "10.0.0.2", 20))
+	assert.True(deleted)
+	count, _ := store.GetNodeCount()
+	assert.Equal(0, count)
+}
+
+func TestMemoryStoreCoresAndTokens(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	core := types.Core{
+		EthAddr:     "0xc",
+		CoreId:      sql.NullString{String: "core1", Valid: true},
+		PublicIP:    sql.NullString{String: "10.0.1.1", Valid: true},
+		BlockNumber: sql.NullInt64{Int64: 1, Valid: true},
+	}
+	inserted, err := store.CoreUpsert(core)
+	assert.Nil(err)
+	assert.True(inserted)
+	other := core
+	other.EthAddr = "0xd"
+	_, err = store.CoreUpsert(other)
+	assert.NotNil(err, "cores may not share a public IP")
+	found, _ := store.GetCoreByID("core1")
+	assert.Equal("0xc", found.EthAddr)
+
+	_, err = store.TokenHashUpsert("malformed")
+	assert.NotNil(err)
+	inserted, _ = store.TokenHashUpsert("10.0.0.9|abcd")
+	assert.False(inserted, "tokens are only stored for known nodes")
+	store.NodeUpsert(testNode("0xa", "10.0.0.9", 1))
+	inserted, _ = store.TokenHashUpsert("10.0.0.9|abcd")
+	assert.True(inserted)
+	hash, exists := store.GetTokenHash("10.0.0.9")
+	assert.True(exists)
+	assert.Equal("abcd", hash)
+}
+
+func TestMemoryStoreStakingLedger(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	events := []types.StakingEvent{
+		{Kind: types.StakingKindNode, EventType: types.StakingEventStaked, EthAddr: "0xa", PublicIP: "10.0.0.1", BlockNumber: 10, TxHash: "0x01", LogIndex: 0},
+		{Kind: types.StakingKindNode, EventType: types.StakingEventStaked, EthAddr: "0xb", PublicIP: "10.0.0.2", BlockNumber: 10, TxHash: "0x01", LogIndex: 1},
+		{Kind: types.StakingKindNode, EventType: types.StakingEventUpdated, EthAddr: "0xa", PublicIP: "10.0.0.3", BlockNumber: 20, TxHash: "0x02", LogIndex: 0},
+		{Kind: types.StakingKindNode, EventType: types.StakingEventUnstaked, EthAddr: "0xb", PublicIP: "10.0.0.2", BlockNumber: 30, TxHash: "0x03", LogIndex: 4},
+		{Kind: types.StakingKindCore, EventType: types.StakingEventStaked, EthAddr: "0xc", PublicIP: "10.0.1.1", CoreID: "abcd", BlockNumber: 15, TxHash: "0x04", LogIndex: 0},
+	}
+	// record out of order to show that chain order, not arrival order, decides current state
+	for i := len(events) - 1; i >= 0; i-- {
+		recorded, err := store.RecordStakingEvent(events[i])
+		assert.Nil(err)
+		assert.True(recorded)
+	}
+	recorded, _ := store.RecordStakingEvent(events[0])
+	assert.False(recorded, "the same log should only be recorded once")
+
+	nodes, _ := store.GetStakedNodesAtBlock(9)
+	assert.Equal(0, len(nodes))
+	nodes, _ = store.GetStakedNodesAtBlock(10)
+	assert.Equal(2, len(nodes))
+	nodes, _ = store.GetStakedNodesAtBlock(25)
+	assert.Equal(2, len(nodes))
+	assert.Equal("10.0.0.3", nodes[0].PublicIP.String, "stake updates should change the IP")
+	nodes, _ = store.GetStakedNodesAtBlock(30)
+	assert.Equal(1, len(nodes), "unstaked nodes should drop out")
+	assert.Equal("0xa", nodes[0].EthAddr)
+
+	cores, _ := store.GetStakedCoresAtBlock(100)
+	assert.Equal(1, len(cores))
+	assert.Equal("abcd", cores[0].CoreId.String)
+
+	history, _ := store.GetStakingHistory(types.StakingKindNode, "0xb")
+	assert.Equal(2, len(history))
+	assert.Equal(types.StakingEventUnstaked, history[1].EventType)
+}
+
+func TestMemoryStoreReorgRollback(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	staked := types.StakingEvent{Kind: types.StakingKindNode, EventType: types.StakingEventStaked, EthAddr: "0xa", PublicIP: "10.0.0.1", BlockNumber: 10, BlockHash: "0xb10", TxHash: "0x01"}
+	updated := types.StakingEvent{Kind: types.StakingKindNode, EventType: types.StakingEventUpdated, EthAddr: "0xa", PublicIP: "10.0.0.2", BlockNumber: 20, BlockHash: "0xb20", TxHash: "0x02"}
+	store.RecordStakingEvent(staked)
+	store.NodeUpsert(testNode("0xa", "10.0.0.1", 10))
+	store.RecordStakingEvent(updated)
+	store.NodeUpsert(testNode("0xa", "10.0.0.2", 20))
+
+	removed, err := store.RemoveStakingEvent(updated)
+	assert.Nil(err)
+	assert.True(removed)
+	removed, _ = store.RemoveStakingEvent(updated)
+	assert.False(removed, "a removal should only be recorded once")
+	assert.Nil(store.ReconcileStaking(types.StakingKindNode, "0xa"))
+	node, _ := store.GetNodeByEthAddr("0xa")
+	assert.Equal("10.0.0.1", node.PublicIP.String, "the removed update should be rolled back")
+	assert.Equal(int64(10), node.BlockNumber.Int64)
+
+	// the same transaction mined into another block is a new event
+	remined := updated
+	remined.BlockNumber, remined.BlockHash = 21, "0xb21"
+	recorded, _ := store.RecordStakingEvent(remined)
+	assert.True(recorded)
+	history, _ := store.GetStakingHistory(types.StakingKindNode, "0xa")
+	assert.Equal(2, len(history))
+	assert.Equal(int64(21), history[1].BlockNumber)
+
+	store.RemoveStakingEvent(staked)
+	store.RemoveStakingEvent(remined)
+	store.ReconcileStaking(types.StakingKindNode, "0xa")
+	count, _ := store.GetNodeCount()
+	assert.Equal(0, count, "a node with no live stake should be removed")
+
+	_, found, _ := store.GetIngestionCursor("node_registry")
+	assert.False(found)
+	store.SetIngestionCursor("node_registry", 42)
+	block, found, _ := store.GetIngestionCursor("node_registry")
+	assert.True(found)
+	assert.Equal(int64(42), block)
+}
+
+func TestMemoryStoreNodeAudits(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	audit := func(core string, round int64, passed bool, proofPassed bool) types.NodeAuditRecord {
+		return types.NodeAuditRecord{EthAddr: "0xAB", CoreID: core, Round: round, Passed: passed, AuditedAt: round,
+			Checks: []types.NodeAuditCheck{{Check: "reputation", Passed: true, Millis: 10}, {Check: "proof", Passed: proofPassed, Millis: 30}}}
+	}
+	inserted, err := store.RecordNodeAudit(audit("core1", 1, true, true))
+	assert.NoError(err)
+	assert.True(inserted)
+	inserted, _ = store.RecordNodeAudit(audit("core1", 1, false, false))
+	assert.False(inserted, "a Core's audit of a node is recorded once per round")
+	store.RecordNodeAudit(audit("core2", 1, false, false))
+	store.RecordNodeAudit(audit("core1", 2, false, false))
+	store.RecordNodeAudit(types.NodeAuditRecord{EthAddr: "0xcd", CoreID: "core1", Round: 2, Passed: true, AuditedAt: 2})
+
+	history, err := store.GetNodeAudits("0xab", 2)
+	assert.NoError(err)
+	assert.Len(history, 2)
+	assert.Equal(int64(2), history[0].Round, "newest first")
+
+	store.RecordNodeAudit(types.NodeAuditRecord{PublicIP: "10.0.0.1", CoreID: "core1", Round: 2, Reachable: true, AuditedAt: 2})
+	inserted, _ = store.RecordNodeAudit(types.NodeAuditRecord{PublicIP: "10.0.0.2", CoreID: "core1", Round: 2, AuditedAt: 2})
+	assert.True(inserted, "private network nodes are told apart by IP")
+	own, err := store.GetCoreNodeAudits("core1", "0xAb", "", 10)
+	assert.NoError(err)
+	assert.Len(own, 2, "only the Core's own audits of the node")
+	private, _ := store.GetCoreNodeAudits("core1", "", "10.0.0.1", 10)
+	assert.Len(private, 1)
+	assert.True(private[0].Reachable)
+
+	stats, err := store.GetNodeAuditStats("0xAb", 0)
+	assert.NoError(err)
+	assert.Equal(int64(3), stats.Audits)
+	assert.InDelta(1.0/3, stats.PassRate, 0.001)
+	assert.Equal("proof", stats.Checks[0].Check)
+	assert.InDelta(1.0/3, stats.Checks[0].PassRate, 0.001)
+	assert.Equal(float64(30), stats.Checks[0].AvgMillis)
+
+	all, _ := store.GetNodeAuditStats("", 2)
+	assert.Equal(int64(4), all.Audits, "every node's audits since the given time")
+	assert.Equal(0.25, all.PassRate)
+}
@@ -0,0 +1,278 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Result : tells a Consumer what to do with a delivery once its Handler returns
+type Result int
+
+const (
+	// Ack : the message was handled and can be removed
+	Ack Result = iota
+	// Retry : the message should be redelivered after the consumer's RetryDelay
+	Retry
+	// DeadLetter : the message can never be handled and is moved to the dead-letter queue
+	DeadLetter
+)
+
+// RetryCountHeader : header counting how many times a message has been sent back for retry
+const RetryCountHeader = "x-retry-count"
+
+// Handler : processes a single delivery. Handlers must not ack or nack the delivery themselves
+type Handler func(msg amqp.Delivery) Result
+
+// Consumer : Consumes Queue with a bounded prefetch, running Handler on each delivery.
+// Retried messages wait in <Queue>.retry for RetryDelay before returning to Queue. Dead-lettered messages,
+// and messages retried more than MaxRetries times (when non-zero), are moved to <Queue>.dlq.
+// Copies are republished on a confirm-mode channel, and the original is only acked once the broker confirms its copy
+type Consumer struct {
+	URI            string
+	Queue          string
+	Prefetch       int
+	RetryDelay     time.Duration
+	MaxRetries     int
+	ConfirmTimeout time.Duration
+	Handler        Handler
+	stop           chan struct{}
+	stopOnce       sync.Once
+	wg             sync.WaitGroup
+	republishMux   sync.Mutex
+}
+
+// NewConsumer : Returns a consumer for queue with default retry settings
+func NewConsumer(uri string, queue string, prefetch int, handler Handler) *Consumer {
+	return &Consumer{
+		URI:            uri,
+		Queue:          queue,
+		Prefetch:       prefetch,
+		RetryDelay:     30 * time.Second,
+		MaxRetries:     10,
+		ConfirmTimeout: 10 * time.Second,
+		Handler:        handler,
+		stop:           make(chan struct{}),
+	}
+}
+
+// RetryExchange : exchange that delays messages before returning them to the queue
+func (c *Consumer) RetryExchange() string {
+	return c.Queue + ".retry"
+}
+
+// DeadLetterExchange : exchange that routes messages to the dead-letter queue
+func (c *Consumer) DeadLetterExchange() string {
+	return c.Queue + ".dlx"
+}
+
+// DeadLetterQueue : queue holding messages that could not be handled
+func (c *Consumer) DeadLetterQueue() string {
+	return c.Queue + ".dlq"
+}
+
+// Run : Consumes until Stop is called, reconnecting with a delay whenever the connection drops
+func (c *Consumer) Run() error {
+	for {
+		session, republishCh, err := c.connect()
+		if LogError(err, fmt.Sprintf("failed to consume %s", c.Queue)) != nil {
+			select {
+			case <-c.stop:
+				return nil
+			case <-time.After(5 * time.Second):
+				continue
+			}
+		}
+		stopped := c.consume(session, republishCh)
+		c.wg.Wait()
+		session.End()
+		if stopped {
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Stop : Ends consumption. In-flight handlers finish before Run returns
+func (c *Consumer) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+// consume : dispatches deliveries until the connection or republishing channel drops (false) or Stop is called (true)
+func (c *Consumer) consume(session Session, republishCh *confirmChannel) bool {
+	for {
+		select {
+		case <-c.stop:
+			return true
+		case err := <-session.Notify:
+			LogError(err, fmt.Sprintf("connection to %s closed", c.Queue))
+			return false
+		case err := <-republishCh.closed:
+			LogError(err, fmt.Sprintf("republishing channel for %s closed", c.Queue))
+			return false
+		case msg, ok := <-session.Msgs:
+			if !ok {
+				return false
+			}
+			c.wg.Add(1)
+			go func(msg amqp.Delivery) {
+				defer c.wg.Done()
+				c.settle(republishCh, msg, c.handle(msg))
+			}(msg)
+		}
+	}
+}
+
+// handle : runs the handler, treating a panic as a retry
+func (c *Consumer) handle(msg amqp.Delivery) (result Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			LogError(fmt.Errorf("%v", r), fmt.Sprintf("handler for %s panicked", c.Queue))
+			result = Retry
+		}
+	}()
+	return c.Handler(msg)
+}
+
+// settle : carries out a handler result. Messages are only acked once their retry or dead-letter copy is confirmed
+func (c *Consumer) settle(republishCh *confirmChannel, msg amqp.Delivery, result Result) {
+	var err error
+	switch result {
+	case Ack:
+		LogError(msg.Ack(false), "error acking queue item")
+		return
+	case Retry:
+		retries := retryCount(msg) + 1
+		if c.MaxRetries > 0 && retries > c.MaxRetries {
+			err = c.republish(republishCh, c.DeadLetterExchange(), msg, retries-1, "")
+		} else {
+			err = c.republish(republishCh, c.RetryExchange(), msg, retries, strconv.FormatInt(int64(c.RetryDelay/time.Millisecond), 10))
+		}
+	case DeadLetter:
+		err = c.republish(republishCh, c.DeadLetterExchange(), msg, retryCount(msg), "")
+	default:
+		err = errors.New("unknown handler result")
+	}
+	if LogError(err, fmt.Sprintf("failed to settle %s message", c.Queue)) != nil {
+		msg.Nack(false, true)
+		return
+	}
+	LogError(msg.Ack(false), "error acking queue item")
+}
+
+// republish : copies a delivery to exchange, routed by the queue name, with an updated retry count, and waits for the
+// broker to confirm the copy. Republishes are serialized, so the next confirmation on the channel is always ours
+func (c *Consumer) republish(cc *confirmChannel, exchange string, msg amqp.Delivery, retries int, expiration string) error {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[RetryCountHeader] = int32(retries)
+	c.republishMux.Lock()
+	defer c.republishMux.Unlock()
+	err := cc.ch.Publish(exchange, c.Queue, false, false, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		Type:         msg.Type,
+		Body:         msg.Body,
+		DeliveryMode: amqp.Persistent,
+		Expiration:   expiration,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case confirm, ok := <-cc.confirms:
+		if !ok {
+			return errors.New("channel closed before confirmation")
+		}
+		if !confirm.Ack {
+			return errors.New(ReasonNacked)
+		}
+		return nil
+	case <-cc.closed:
+		return errors.New("channel closed before confirmation")
+	case <-time.After(c.ConfirmTimeout):
+		// a late confirmation would be mistaken for the next copy's, so the channel can't be reused
+		cc.ch.Close()
+		return errors.New(ReasonConfirmTimeout)
+	}
+}
+
+// connect : dials, declares the queue topology, opens a confirm-mode channel for republishing, applies prefetch and starts consuming
+func (c *Consumer) connect() (Session, *confirmChannel, error) {
+	session, err := Dial(c.URI, c.Queue)
+	if err != nil {
+		return Session{}, nil, err
+	}
+	if err := c.declareTopology(session.Ch); err != nil {
+		session.End()
+		return Session{}, nil, err
+	}
+	republishCh, err := newConfirmChannel(session.Conn)
+	if err != nil {
+		session.End()
+		return Session{}, nil, err
+	}
+	if err := session.Ch.Qos(c.Prefetch, 0, false); err != nil {
+		session.End()
+		return Session{}, nil, err
+	}
+	session.Msgs, err = session.Ch.Consume(
+		session.Queue.Name, // queue
+		"",                 // consumer
+		false,              // auto-ack
+		false,              // exclusive
+		false,              // no-local
+		false,              // no-wait
+		nil,                // args
+	)
+	if err != nil {
+		session.End()
+		return Session{}, nil, err
+	}
+	return session, republishCh, nil
+}
+
+// declareTopology : declares the retry and dead-letter exchanges and queues for the consumer's queue.
+// The retry queue has no consumers; expired messages are dead-lettered back onto the work queue
+func (c *Consumer) declareTopology(ch *amqp.Channel) error {
+	for _, exchange := range []string{c.RetryExchange(), c.DeadLetterExchange()} {
+		if err := ch.ExchangeDeclare(exchange, "direct", true, false, false, false, nil); err != nil {
+			return err
+		}
+	}
+	retryArgs := amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": c.Queue,
+	}
+	if _, err := ch.QueueDeclare(c.RetryExchange(), true, false, false, false, retryArgs); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(c.RetryExchange(), c.Queue, c.RetryExchange(), false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(c.DeadLetterQueue(), true, false, false, false, nil); err != nil {
+		return err
+	}
+	return ch.QueueBind(c.DeadLetterQueue(), c.Queue, c.DeadLetterExchange(), false, nil)
+}
+
+// retryCount : reads the retry count header of a delivery
+func retryCount(msg amqp.Delivery) int {
+	switch v := msg.Headers[RetryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
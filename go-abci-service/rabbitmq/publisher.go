@@ -0,0 +1,333 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Reasons a PublishError may carry
+const (
+	ReasonConnection     = "connection unavailable"
+	ReasonChannel        = "channel failure"
+	ReasonNacked         = "nacked by broker"
+	ReasonConfirmTimeout = "confirmation timed out"
+	ReasonClosed         = "publisher closed"
+)
+
+// PublishError : Returned by Publisher when the broker hasn't confirmed delivery of a message
+type PublishError struct {
+	Queue  string
+	Reason string
+	Err    error
+}
+
+func (e *PublishError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("RabbitMQ publish to %s not confirmed: %s: %s", e.Queue, e.Reason, e.Err.Error())
+	}
+	return fmt.Sprintf("RabbitMQ publish to %s not confirmed: %s", e.Queue, e.Reason)
+}
+
+// Publisher : Long-lived publisher holding a persistent connection and a pool of confirm-mode channels.
+// The connection is re-dialed with exponential backoff when it drops
+type Publisher struct {
+	URI            string
+	PoolSize       int
+	ConfirmTimeout time.Duration
+	MaxRetries     int
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	mux            sync.Mutex
+	conn           *amqp.Connection
+	connClosed     chan *amqp.Error
+	declared       map[string]bool
+	channels       chan *confirmChannel
+	closed         bool
+}
+
+// confirmChannel : a pooled channel in confirm mode, bound to the connection it was opened on
+type confirmChannel struct {
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	closed   chan *amqp.Error
+}
+
+var (
+	publishers   = map[string]*Publisher{}
+	publishersMu sync.Mutex
+)
+
+// NewPublisher : Returns a publisher for uri with a pool of poolSize channels. The connection is dialed lazily
+func NewPublisher(uri string, poolSize int) *Publisher {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	return &Publisher{
+		URI:            uri,
+		PoolSize:       poolSize,
+		ConfirmTimeout: 10 * time.Second,
+		MaxRetries:     5,
+		MinBackoff:     500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		declared:       map[string]bool{},
+		channels:       make(chan *confirmChannel, poolSize),
+	}
+}
+
+// GetPublisher : Returns the shared publisher for uri, creating it on first use
+func GetPublisher(uri string) *Publisher {
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+	publisher, exists := publishers[uri]
+	if !exists {
+		publisher = NewPublisher(uri, 4)
+		publishers[uri] = publisher
+	}
+	return publisher
+}
+
+// Publish : Sends a persistent message to queue and waits for the broker to confirm it.
+// Any failure to obtain a confirmation is returned as a *PublishError
+func (p *Publisher) Publish(queue string, msgType string, msg []byte) error {
+	return p.PublishWithHeaders(queue, msgType, nil, msg)
+}
+
+// PublishWithHeaders : Publish, with AMQP headers attached to the message
+func (p *Publisher) PublishWithHeaders(queue string, msgType string, headers amqp.Table, msg []byte) error {
+	cc, err := p.getChannel()
+	if err != nil {
+		return &PublishError{Queue: queue, Reason: ReasonConnection, Err: err}
+	}
+	if err := p.declare(cc, queue); err != nil {
+		p.discardChannel(cc)
+		return &PublishError{Queue: queue, Reason: ReasonChannel, Err: err}
+	}
+	publish := amqp.Publishing{
+		Headers:      headers,
+		Type:         msgType,
+		Body:         msg,
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+	}
+	if err := cc.ch.Publish("", queue, false, false, publish); err != nil {
+		p.discardChannel(cc)
+		return &PublishError{Queue: queue, Reason: ReasonChannel, Err: err}
+	}
+	// each pooled channel carries one message at a time, so the next confirmation is ours
+	select {
+	case confirm, ok := <-cc.confirms:
+		if !ok {
+			p.discardChannel(cc)
+			return &PublishError{Queue: queue, Reason: ReasonChannel, Err: errors.New("channel closed before confirmation")}
+		}
+		p.releaseChannel(cc)
+		if !confirm.Ack {
+			return &PublishError{Queue: queue, Reason: ReasonNacked}
+		}
+		return nil
+	case err := <-cc.closed:
+		p.discardChannel(cc)
+		return &PublishError{Queue: queue, Reason: ReasonChannel, Err: err}
+	case <-time.After(p.ConfirmTimeout):
+		// a late confirmation would be mistaken for the next message's, so this channel can't be reused
+		p.discardChannel(cc)
+		return &PublishError{Queue: queue, Reason: ReasonConfirmTimeout}
+	}
+}
+
+// Close : Closes pooled channels and the connection. Subsequent publishes fail with ReasonClosed
+func (p *Publisher) Close() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.closed = true
+	for {
+		select {
+		case cc := <-p.channels:
+			cc.ch.Close()
+		default:
+			if p.conn != nil {
+				err := p.conn.Close()
+				p.conn = nil
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+// getChannel : takes a live channel from the pool, or opens a new one on the current connection
+func (p *Publisher) getChannel() (*confirmChannel, error) {
+	for {
+		select {
+		case cc := <-p.channels:
+			if p.isCurrent(cc) {
+				return cc, nil
+			}
+			cc.ch.Close()
+		default:
+			return p.openChannel()
+		}
+	}
+}
+
+// openChannel : opens a confirm-mode channel, (re)connecting first if needed
+func (p *Publisher) openChannel() (*confirmChannel, error) {
+	conn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		p.mux.Lock()
+		if p.conn == conn {
+			p.conn = nil
+		}
+		p.mux.Unlock()
+		return nil, err
+	}
+	return confirmMode(conn, ch)
+}
+
+// newConfirmChannel : opens a confirm-mode channel on conn
+func newConfirmChannel(conn *amqp.Connection) (*confirmChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return confirmMode(conn, ch)
+}
+
+// confirmMode : puts a newly opened channel in confirm mode, closing it if that fails
+func confirmMode(conn *amqp.Connection, ch *amqp.Channel) (*confirmChannel, error) {
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return &confirmChannel{
+		conn:     conn,
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		closed:   ch.NotifyClose(make(chan *amqp.Error, 1)),
+	}, nil
+}
+
+// connect : returns the current connection, dialing the broker with exponential backoff if it has dropped.
+// mux is released while dialing, so a reconnect doesn't block publishers that already hold a channel
+func (p *Publisher) connect() (*amqp.Connection, error) {
+	p.mux.Lock()
+	conn, err := p.currentConn()
+	p.mux.Unlock()
+	if conn != nil || err != nil {
+		return conn, err
+	}
+	backoff := p.MinBackoff
+	for attempt := 0; attempt <= p.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+			p.mux.Lock()
+			closed := p.closed
+			p.mux.Unlock()
+			if closed {
+				return nil, errors.New(ReasonClosed)
+			}
+		}
+		conn, err = amqp.Dial(p.URI)
+		if LogError(err, fmt.Sprintf("publisher dial attempt %d failed", attempt+1)) != nil {
+			continue
+		}
+		return p.setConn(conn)
+	}
+	return nil, err
+}
+
+// currentConn : the open connection, if any. Caller holds mux
+func (p *Publisher) currentConn() (*amqp.Connection, error) {
+	if p.closed {
+		return nil, errors.New(ReasonClosed)
+	}
+	if p.conn != nil {
+		select {
+		case <-p.connClosed:
+			p.conn = nil
+		default:
+			return p.conn, nil
+		}
+	}
+	return nil, nil
+}
+
+// setConn : makes a newly dialed connection current, unless the publisher was closed or another caller reconnected
+// first while it was dialing, in which case it's closed
+func (p *Publisher) setConn(conn *amqp.Connection) (*amqp.Connection, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	current, err := p.currentConn()
+	if current != nil || err != nil {
+		conn.Close()
+		return current, err
+	}
+	p.conn = conn
+	p.connClosed = conn.NotifyClose(make(chan *amqp.Error, 1))
+	p.declared = map[string]bool{}
+	return conn, nil
+}
+
+// declare : declares queue once per connection
+func (p *Publisher) declare(cc *confirmChannel, queue string) error {
+	p.mux.Lock()
+	declared := p.declared[queue] && cc.conn == p.conn
+	p.mux.Unlock()
+	if declared {
+		return nil
+	}
+	_, err := cc.ch.QueueDeclare(
+		queue, // name
+		true,  // durable
+		false, // delete when usused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return err
+	}
+	p.mux.Lock()
+	if cc.conn == p.conn {
+		p.declared[queue] = true
+	}
+	p.mux.Unlock()
+	return nil
+}
+
+// isCurrent : reports whether a pooled channel still belongs to the live connection
+func (p *Publisher) isCurrent(cc *confirmChannel) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return !p.closed && cc.conn == p.conn
+}
+
+// releaseChannel : returns a channel to the pool, or closes it if the pool is full
+func (p *Publisher) releaseChannel(cc *confirmChannel) {
+	if !p.isCurrent(cc) {
+		cc.ch.Close()
+		return
+	}
+	select {
+	case p.channels <- cc:
+	default:
+		cc.ch.Close()
+	}
+}
+
+// discardChannel : closes a channel that may be in an unknown state
+func (p *Publisher) discardChannel(cc *confirmChannel) {
+	cc.ch.Close()
+}
@@ -90,38 +90,12 @@ func ConnectAndConsume(rabbitmqConnectURI string, queue string) (session Session
 	return
 }
 
-// Publish sends messages to a particular queue
+// Publish sends messages to a particular queue using the shared Publisher for rabbitmqConnectURI.
+// Returns a *PublishError unless the broker confirms the message
 func Publish(rabbitmqConnectURI string, queue string, msgType string, msg []byte) error {
-	pubSession, err := Dial(rabbitmqConnectURI, queue)
+	err := GetPublisher(rabbitmqConnectURI).Publish(queue, msgType, msg)
 	if err != nil {
-		LogError(err, "failed to dial for publishing")
-		return err
-	}
-	defer pubSession.Conn.Close()
-	defer pubSession.Ch.Close()
-	var publish amqp.Publishing
-	if msgType != "" {
-		publish = amqp.Publishing{
-			Type:         msgType,
-			Body:         msg,
-			DeliveryMode: 2, //persistent
-			ContentType:  "application/json",
-		}
-	} else {
-		publish = amqp.Publishing{
-			Body:         msg,
-			DeliveryMode: 2, //persistent
-			ContentType:  "application/json",
-		}
-	}
-	err = pubSession.Ch.Publish(
-		"",
-		pubSession.Queue.Name,
-		false,
-		false,
-		publish)
-	if err != nil {
-		LogError(err, "rmq dial failure, is rmq connected?")
+		LogError(err, "rmq publish failure, is rmq connected?")
 		return err
 	}
 	return nil
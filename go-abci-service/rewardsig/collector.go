@@ -0,0 +1,175 @@
+package rewardsig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Errors returned when a signature is rejected
+var (
+	ErrMalformed = errors.New("malformed reward signature")
+	ErrWrongHash = errors.New("reward signature doesn't cover the current reward hash from its signer")
+	ErrDuplicate = errors.New("signer already submitted a reward signature")
+)
+
+// Collector : Gathers mint signatures for one reward hash, keeping one verified signature per signer.
+// Signatures that arrive before the local Core has computed the reward hash are held and verified once Reset supplies it
+type Collector struct {
+	mux    sync.Mutex
+	hash   []byte
+	sigs   map[common.Address][]byte
+	early  map[common.Address][]byte
+	notify chan struct{}
+}
+
+// NewCollector : Returns a collector that has no reward hash yet
+func NewCollector() *Collector {
+	return &Collector{
+		sigs:   map[common.Address][]byte{},
+		early:  map[common.Address][]byte{},
+		notify: make(chan struct{}),
+	}
+}
+
+// Reset : starts collecting signatures over hash, the Ethereum-prefixed reward hash. Held signatures that cover it are kept
+func (c *Collector) Reset(hash []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.hash = hash
+	c.sigs = map[common.Address][]byte{}
+	for signer, sig := range c.early {
+		if Verify(hash, signer, sig) == nil {
+			c.sigs[signer] = sig
+		}
+	}
+	c.early = map[common.Address][]byte{}
+	c.wake()
+}
+
+// Clear : drops the reward hash and every signature
+func (c *Collector) Clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.hash = nil
+	c.sigs = map[common.Address][]byte{}
+	c.early = map[common.Address][]byte{}
+}
+
+// Add : accepts sig from signer, the registered Ethereum address of the staked Core that submitted it.
+// Without a reward hash, the latest signature from each signer is held until Reset
+func (c *Collector) Add(signer common.Address, sig []byte) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.hash == nil {
+		if len(sig) != 65 {
+			return ErrMalformed
+		}
+		c.early[signer] = sig
+		return nil
+	}
+	if _, exists := c.sigs[signer]; exists {
+		return ErrDuplicate
+	}
+	if err := Verify(c.hash, signer, sig); err != nil {
+		return err
+	}
+	c.sigs[signer] = sig
+	c.wake()
+	return nil
+}
+
+// Len : the number of verified signatures
+func (c *Collector) Len() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.sigs)
+}
+
+// Wait : blocks until threshold verified signatures are collected or timeout passes. Returns whether the threshold was reached
+func (c *Collector) Wait(threshold int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		c.mux.Lock()
+		count, notify := len(c.sigs), c.notify
+		c.mux.Unlock()
+		if count >= threshold {
+			return true
+		}
+		select {
+		case <-notify:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// Signatures : the verified signatures, ordered by signer address so that every Core submits the same array
+func (c *Collector) Signatures() [][]byte {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	signers := make([]common.Address, 0, len(c.sigs))
+	for signer := range c.sigs {
+		signers = append(signers, signer)
+	}
+	sort.Slice(signers, func(i, j int) bool {
+		return bytes.Compare(signers[i].Bytes(), signers[j].Bytes()) < 0
+	})
+	sigs := make([][]byte, len(signers))
+	for i, signer := range signers {
+		sigs[i] = c.sigs[signer]
+	}
+	return sigs
+}
+
+// wake : notifies every Wait in progress. Callers hold the lock
+func (c *Collector) wake() {
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+// Verify : checks that sig is signer's signature over hash, with a recovery id of 27 or 28 as the mint contracts expect
+func Verify(hash []byte, signer common.Address, sig []byte) error {
+	if len(sig) != 65 || (sig[64] != 27 && sig[64] != 28) {
+		return ErrMalformed
+	}
+	recoverable := make([]byte, 65)
+	copy(recoverable, sig)
+	recoverable[64] -= 27
+	pubKey, err := crypto.SigToPub(hash, recoverable)
+	if err != nil {
+		return ErrMalformed
+	}
+	if crypto.PubkeyToAddress(*pubKey) != signer {
+		return ErrWrongHash
+	}
+	return nil
+}
+
+// Encode : arranges signatures for a mint function's signature parameter. A slots of 0 encodes a variable-length bytes[] array;
+// otherwise a fixed bytes[slots] array is returned, padded with empty signatures
+func Encode(sigs [][]byte, slots int) (interface{}, error) {
+	if slots <= 0 {
+		return sigs, nil
+	}
+	if len(sigs) > slots {
+		return nil, fmt.Errorf("%d reward signatures don't fit a fixed array of %d", len(sigs), slots)
+	}
+	fixed := reflect.New(reflect.ArrayOf(slots, reflect.TypeOf([]byte{}))).Elem()
+	for i := 0; i < slots; i++ {
+		sig := []byte{}
+		if i < len(sigs) {
+			sig = sigs[i]
+		}
+		fixed.Index(i).Set(reflect.ValueOf(sig))
+	}
+	return fixed.Interface(), nil
+}
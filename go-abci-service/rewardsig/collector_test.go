@@ -0,0 +1,112 @@
+package rewardsig
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(t *testing.T, hash []byte) (common.Address, []byte) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signWith(t, key, hash)
+}
+
+func signWith(t *testing.T, key *ecdsa.PrivateKey, hash []byte) (common.Address, []byte) {
+	sig, err := crypto.Sign(hash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[64] += 27
+	return crypto.PubkeyToAddress(key.PublicKey), sig
+}
+
+func TestCollectorVerifiesAndDedupes(t *testing.T) {
+	assert := assert.New(t)
+	hash := crypto.Keccak256([]byte("epoch 1"))
+	other := crypto.Keccak256([]byte("epoch 2"))
+	c := NewCollector()
+	c.Reset(hash)
+
+	key, _ := crypto.GenerateKey()
+	signer, sig := signWith(t, key, hash)
+	assert.NoError(c.Add(signer, sig))
+	_, again := signWith(t, key, hash)
+	assert.Equal(ErrDuplicate, c.Add(signer, again), "a second signature from the same signer is rejected")
+
+	_, stale := signWith(t, key, other)
+	stranger, _ := sign(t, hash)
+	assert.Equal(ErrWrongHash, c.Add(stranger, stale), "a signature over another hash is rejected")
+	impostor, _ := sign(t, hash)
+	assert.Equal(ErrWrongHash, c.Add(impostor, sig), "a signature must come from the submitting Core's address")
+	assert.Equal(ErrMalformed, c.Add(stranger, sig[:64]))
+	assert.Equal(1, c.Len())
+}
+
+func TestCollectorHoldsEarlySignatures(t *testing.T) {
+	assert := assert.New(t)
+	hash := crypto.Keccak256([]byte("epoch 1"))
+	c := NewCollector()
+	signer, sig := sign(t, hash)
+	staleSigner, stale := sign(t, crypto.Keccak256([]byte("epoch 0")))
+	assert.NoError(c.Add(signer, sig))
+	assert.NoError(c.Add(staleSigner, stale))
+	assert.Equal(0, c.Len())
+	c.Reset(hash)
+	assert.Equal(1, c.Len(), "only held signatures over the reward hash survive")
+}
+
+func TestCollectorWaitWakesOnThreshold(t *testing.T) {
+	hash := crypto.Keccak256([]byte("epoch 1"))
+	c := NewCollector()
+	c.Reset(hash)
+	done := make(chan bool)
+	go func() { done <- c.Wait(3, time.Second) }()
+	for i := 0; i < 3; i++ {
+		signer, sig := sign(t, hash)
+		c.Add(signer, sig)
+	}
+	select {
+	case reached := <-done:
+		assert.True(t, reached)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Wait didn't wake once the threshold was reached")
+	}
+	assert.False(t, c.Wait(4, 10*time.Millisecond))
+}
+
+func TestSignaturesAreOrderedAndEncoded(t *testing.T) {
+	assert := assert.New(t)
+	hash := crypto.Keccak256([]byte("epoch 1"))
+	c := NewCollector()
+	c.Reset(hash)
+	signers := map[string][]byte{}
+	for i := 0; i < 4; i++ {
+		signer, sig := sign(t, hash)
+		c.Add(signer, sig)
+		signers[string(sig)] = signer.Bytes()
+	}
+	sigs := c.Signatures()
+	for i := 1; i < len(sigs); i++ {
+		assert.Equal(-1, bytes.Compare(signers[string(sigs[i-1])], signers[string(sigs[i])]))
+	}
+
+	dynamic, err := Encode(sigs, 0)
+	assert.NoError(err)
+	assert.Len(dynamic.([][]byte), 4)
+	fixed, err := Encode(sigs, 126)
+	assert.NoError(err)
+	array, ok := fixed.([126][]byte)
+	assert.True(ok, "fixed encoding should produce a [126][]byte")
+	assert.Equal(sigs[3], array[3])
+	assert.Len(array[4], 0)
+	_, err = Encode(sigs, 2)
+	assert.Error(err)
+}
@@ -0,0 +1,78 @@
+// Package sampling draws reproducible, optionally weighted samples from a set of candidates. Every draw is derived
+// from sha256 over a shared seed, so any party holding the seed and the same candidates computes the same sample,
+// regardless of the order the candidates were loaded in, the platform, or the Go version.
+package sampling
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// MaxWeight : weights are capped so that summing them can't overflow
+const MaxWeight = uint64(1) << 32
+
+// Candidate : an item that can be drawn, identified by a key that's unique and stable across parties. A candidate is drawn
+// in proportion to its Weight; candidates with no weight are never drawn
+type Candidate struct {
+	Key    string
+	Weight uint64
+}
+
+// Draw : the pseudo-random value for the nth draw from seed
+func Draw(seed []byte, n uint64) uint64 {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, n)
+	sum := sha256.Sum256(append(append([]byte{}, seed...), counter...))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Sample : draws up to size distinct candidates without replacement, each draw picking a remaining candidate with
+// probability proportional to its weight. The result is in draw order
+func Sample(seed []byte, candidates []Candidate, size int) []Candidate {
+	pool := make([]Candidate, 0, len(candidates))
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if candidate.Weight == 0 || seen[candidate.Key] {
+			continue
+		}
+		seen[candidate.Key] = true
+		if candidate.Weight > MaxWeight {
+			candidate.Weight = MaxWeight
+		}
+		pool = append(pool, candidate)
+	}
+	sort.Slice(pool, func(i, j int) bool {
+		return pool[i].Key < pool[j].Key
+	})
+	sample := make([]Candidate, 0, size)
+	for n := uint64(0); len(sample) < size && len(pool) > 0; n++ {
+		var total uint64
+		for _, candidate := range pool {
+			total += candidate.Weight
+		}
+		target := Draw(seed, n) % total
+		for i, candidate := range pool {
+			if target < candidate.Weight {
+				sample = append(sample, candidate)
+				pool = append(pool[:i], pool[i+1:]...)
+				break
+			}
+			target -= candidate.Weight
+		}
+	}
+	return sample
+}
+
+// Shuffle : every distinct key, in a uniformly random order drawn from seed
+func Shuffle(seed []byte, keys []string) []string {
+	candidates := make([]Candidate, len(keys))
+	for i, key := range keys {
+		candidates[i] = Candidate{Key: key, Weight: 1}
+	}
+	shuffled := make([]string, 0, len(keys))
+	for _, candidate := range Sample(seed, candidates, len(candidates)) {
+		shuffled = append(shuffled, candidate.Key)
+	}
+	return shuffled
+}
@@ -0,0 +1,45 @@
+package sampling
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleIsReproducible(t *testing.T) {
+	assert := assert.New(t)
+	seed := []byte("3719ADA3EEE198F3A7A33616EA60ED6D72D94D31A2B2422FA12E2BCDDCABD4D4")
+	candidates := make([]Candidate, 0)
+	for i := 0; i < 20; i++ {
+		candidates = append(candidates, Candidate{Key: fmt.Sprintf("node%d", i), Weight: uint64(i%3 + 1)})
+	}
+	reversed := make([]Candidate, len(candidates))
+	for i, candidate := range candidates {
+		reversed[len(candidates)-1-i] = candidate
+	}
+	sample := Sample(seed, candidates, 5)
+	assert.Len(sample, 5)
+	assert.Equal(sample, Sample(seed, reversed, 5), "load order shouldn't change the sample")
+	assert.NotEqual(sample, Sample([]byte("another seed"), candidates, 5))
+
+	drawn := map[string]bool{}
+	for _, candidate := range Sample(seed, append(candidates, candidates...), 30) {
+		assert.False(drawn[candidate.Key], "candidates are drawn without replacement")
+		drawn[candidate.Key] = true
+	}
+	assert.Len(drawn, 20)
+	assert.Len(Shuffle(seed, []string{"a", "b", "c"}), 3)
+}
+
+func TestSampleFollowsWeights(t *testing.T) {
+	assert := assert.New(t)
+	candidates := []Candidate{{Key: "heavy", Weight: 9}, {Key: "light", Weight: 1}, {Key: "none", Weight: 0}}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		counts[Sample([]byte(fmt.Sprintf("seed%d", i)), candidates, 1)[0].Key]++
+	}
+	assert.Zero(counts["none"], "unweighted candidates are never drawn")
+	assert.InDelta(900, counts["heavy"], 60)
+	assert.Len(Sample([]byte("seed"), candidates, 3), 2)
+}
@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
+)
+
+// ValidatingBus : Wraps a Bus so that messages on topics covered by Registry are stamped with their schema version
+// and validated when published, and validated again when consumed. Invalid messages are refused on publish
+// and dead-lettered on consume
+type ValidatingBus struct {
+	Bus      bus.Bus
+	Registry *Registry
+}
+
+// NewValidatingBus : Returns b wrapped with validation against registry
+func NewValidatingBus(b bus.Bus, registry *Registry) *ValidatingBus {
+	return &ValidatingBus{Bus: b, Registry: registry}
+}
+
+// Publish : Stamps and validates msg before passing it to the wrapped bus
+func (v *ValidatingBus) Publish(msg bus.Message) error {
+	stamped, err := v.Registry.Stamp(msg)
+	if err != nil {
+		return err
+	}
+	return v.Bus.Publish(stamped)
+}
+
+// Subscribe : Subscribes to the wrapped bus, dead-lettering messages that fail validation before they reach handler
+func (v *ValidatingBus) Subscribe(topic string, prefetch int, handler bus.Handler) (bus.Subscription, error) {
+	return v.Bus.Subscribe(topic, prefetch, func(msg bus.Message) bus.Result {
+		if err := v.Registry.Validate(msg); err != nil {
+			return bus.DeadLetter
+		}
+		return handler(msg)
+	})
+}
+
+// Close : Closes the wrapped bus
+func (v *ValidatingBus) Close() error {
+	return v.Bus.Close()
+}
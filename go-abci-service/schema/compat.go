@@ -0,0 +1,101 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CheckCompatible : Lists the changes in next that would break consumers written against prev, i.e. ways a
+// message valid under next could be rejected or misread under prev. An empty result means next may ship under
+// the same version number; anything else needs a new version
+func CheckCompatible(prev *Schema, next *Schema) []string {
+	breaks := make([]string, 0)
+	compareNodes(prev, prev.root, next, next.root, "$", &breaks)
+	return breaks
+}
+
+func compareNodes(prev *Schema, p *node, next *Schema, n *node, path string, breaks *[]string) {
+	p = prev.resolve(p)
+	n = next.resolve(n)
+	report := func(format string, args ...interface{}) {
+		*breaks = append(*breaks, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	prevTypes, nextTypes := p.types(), n.types()
+	if len(prevTypes) > 0 {
+		if len(nextTypes) == 0 {
+			report("type constraint %s removed", strings.Join(prevTypes, "|"))
+		}
+		for _, t := range nextTypes {
+			if !contains(prevTypes, t) {
+				report("type %s not allowed by %s", t, strings.Join(prevTypes, "|"))
+			}
+		}
+	}
+
+	if len(p.Enum) > 0 {
+		if len(n.Enum) == 0 {
+			report("enum removed")
+		}
+		for _, value := range n.Enum {
+			if !inEnum(p.Enum, value) {
+				report("enum value %v added", value)
+			}
+		}
+	}
+
+	if p.MinLength != nil && (n.MinLength == nil || *n.MinLength < *p.MinLength) {
+		report("minLength relaxed")
+	}
+
+	for _, name := range p.Required {
+		if !contains(n.Required, name) {
+			report("property %s no longer required", name)
+		}
+	}
+
+	prevClosed := p.AdditionalProperties != nil && !*p.AdditionalProperties
+	for _, name := range sortedNodeKeys(p.Properties) {
+		nextProp, exists := n.Properties[name]
+		if !exists {
+			report("property %s removed", name)
+			continue
+		}
+		compareNodes(prev, p.Properties[name], next, nextProp, path+"."+name, breaks)
+	}
+	for _, name := range sortedNodeKeys(n.Properties) {
+		if _, exists := p.Properties[name]; !exists && prevClosed {
+			report("property %s added to a closed object", name)
+		}
+	}
+	if prevClosed && (n.AdditionalProperties == nil || *n.AdditionalProperties) {
+		report("additional properties allowed")
+	}
+
+	if p.Items != nil {
+		if n.Items == nil {
+			report("items constraint removed")
+		} else {
+			compareNodes(prev, p.Items, next, n.Items, path+"[]", breaks)
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNodeKeys(m map[string]*node) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,176 @@
+package schema
+
+import (
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
+)
+
+// Message types published to work.proofstate and read by the proof state service
+const (
+	TypeAggregator  = "aggregator"
+	TypeCalBatch    = "cal_batch"
+	TypeBtcAggBatch = "anchor_btc_agg_batch"
+	TypeBtcTx       = "btctx"
+	TypeBtcMon      = "btcmon"
+)
+
+// definitions shared by several schemas
+const (
+	proofLineItemDef = `"proofLineItem": {
+				"type": "object",
+				"properties": {
+					"l": {"type": "string"},
+					"r": {"type": "string"},
+					"op": {"type": "string"}
+				}
+			}`
+	anchorDef = `"anchor": {
+				"type": "object",
+				"required": ["anchor_id", "uris"],
+				"properties": {
+					"anchor_id": {"type": "string", "minLength": 1},
+					"uris": {"type": "array", "items": {"type": "string"}}
+				}
+			}`
+)
+
+// aggregatorV1 : types.Aggregation
+const aggregatorV1 = `{
+	"type": "object",
+	"required": ["agg_id", "agg_root", "proofData"],
+	"properties": {
+		"agg_id": {"type": "string", "minLength": 1},
+		"agg_root": {"type": "string", "minLength": 1},
+		"proofData": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["hash_id", "hash", "proof"],
+				"properties": {
+					"hash_id": {"type": "string"},
+					"hash": {"type": "string"},
+					"proof": {"type": "array", "items": {"$ref": "#/definitions/proofLineItem"}}
+				}
+			}
+		}
+	},
+	"definitions": {
+		` + proofLineItemDef + `
+	}
+}`
+
+// calBatchV1 : types.CalState
+const calBatchV1 = `{
+	"type": "object",
+	"required": ["cal_id", "anchor", "proofData"],
+	"properties": {
+		"cal_id": {"type": "string", "minLength": 1},
+		"anchor": {"$ref": "#/definitions/anchor"},
+		"proofData": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["agg_id", "proof"],
+				"properties": {
+					"agg_id": {"type": "string"},
+					"proof": {"type": "array", "items": {"$ref": "#/definitions/proofLineItem"}}
+				}
+			}
+		}
+	},
+	"definitions": {
+		` + proofLineItemDef + `,
+		` + anchorDef + `
+	}
+}`
+
+// btcAggBatchV1 : types.BtcAgg
+const btcAggBatchV1 = `{
+	"type": "object",
+	"required": ["anchor_btc_agg_id", "anchor_btc_agg_root", "proofData"],
+	"properties": {
+		"anchor_btc_agg_id": {"type": "string", "minLength": 1},
+		"anchor_btc_agg_root": {"type": "string", "minLength": 1},
+		"proofData": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["cal_id", "proof"],
+				"properties": {
+					"cal_id": {"type": "string"},
+					"proof": {"type": "array", "items": {"$ref": "#/definitions/proofLineItem"}}
+				}
+			}
+		}
+	},
+	"definitions": {
+		` + proofLineItemDef + `
+	}
+}`
+
+// btcTxV1 : types.BtcTxProofState
+const btcTxV1 = `{
+	"type": "object",
+	"required": ["anchor_btc_agg_id", "btctx_id", "btctx_state"],
+	"properties": {
+		"anchor_btc_agg_id": {"type": "string", "minLength": 1},
+		"btctx_id": {"type": "string", "minLength": 1},
+		"btctx_state": {
+			"type": "object",
+			"required": ["ops"],
+			"properties": {
+				"ops": {"type": "array", "items": {"$ref": "#/definitions/proofLineItem"}}
+			}
+		}
+	},
+	"definitions": {
+		` + proofLineItemDef + `
+	}
+}`
+
+// btcMonV1 : types.BtccStateObj
+const btcMonV1 = `{
+	"type": "object",
+	"required": ["btctx_id", "btchead_height", "btchead_state"],
+	"properties": {
+		"btctx_id": {"type": "string", "minLength": 1},
+		"btchead_height": {"type": "integer"},
+		"btchead_state": {
+			"type": "object",
+			"required": ["ops", "anchor"],
+			"properties": {
+				"ops": {"type": "array", "items": {"$ref": "#/definitions/proofLineItem"}},
+				"anchor": {"$ref": "#/definitions/anchor"}
+			}
+		}
+	},
+	"definitions": {
+		` + proofLineItemDef + `,
+		` + anchorDef + `
+	}
+}`
+
+// NewProofStateRegistry : Returns a registry holding every version of the work.proofstate message schemas.
+// A change a consumer can't read needs a new version added here, alongside the old one
+func NewProofStateRegistry() *Registry {
+	registry := NewRegistry()
+	for _, s := range []struct {
+		msgType  string
+		version  int
+		document string
+	}{
+		{TypeAggregator, 1, aggregatorV1},
+		{TypeCalBatch, 1, calBatchV1},
+		{TypeBtcAggBatch, 1, btcAggBatchV1},
+		{TypeBtcTx, 1, btcTxV1},
+		{TypeBtcMon, 1, btcMonV1},
+	} {
+		parsed, err := NewSchema(bus.TopicProofState, s.msgType, s.version, s.document)
+		if err != nil {
+			panic(err)
+		}
+		if err := registry.Register(parsed); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
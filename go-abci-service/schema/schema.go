@@ -0,0 +1,353 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
+)
+
+// VersionHeader : message header naming the schema version a message body was written against
+const VersionHeader = "schema_version"
+
+// ValidationError : Returned when a message doesn't match its schema, or no schema covers it
+type ValidationError struct {
+	Topic   string
+	Type    string
+	Version int
+	Path    string
+	Reason  string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s %s v%d: %s", e.Topic, e.Type, e.Version, e.Reason)
+	}
+	return fmt.Sprintf("%s %s v%d: %s: %s", e.Topic, e.Type, e.Version, e.Path, e.Reason)
+}
+
+// Schema : A versioned JSON Schema document for one message type on one topic.
+// Only the subset of JSON Schema used by Core's messages is understood: type, required, properties,
+// additionalProperties, items, enum, minLength, definitions and local $ref
+type Schema struct {
+	Topic    string
+	Type     string
+	Version  int
+	Document string
+	root     *node
+}
+
+// node : a parsed JSON Schema keyword set
+type node struct {
+	Type                 interface{}      `json:"type"`
+	Required             []string         `json:"required"`
+	Properties           map[string]*node `json:"properties"`
+	AdditionalProperties *bool            `json:"additionalProperties"`
+	Items                *node            `json:"items"`
+	Enum                 []interface{}    `json:"enum"`
+	MinLength            *int             `json:"minLength"`
+	Ref                  string           `json:"$ref"`
+	Definitions          map[string]*node `json:"definitions"`
+}
+
+// NewSchema : Parses document as the schema for msgType on topic at version
+func NewSchema(topic string, msgType string, version int, document string) (*Schema, error) {
+	var root node
+	if err := json.Unmarshal([]byte(document), &root); err != nil {
+		return nil, err
+	}
+	s := &Schema{Topic: topic, Type: msgType, Version: version, Document: document, root: &root}
+	if err := s.checkRefs(&root, "$"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate : Checks a JSON message body against the schema
+func (s *Schema) Validate(body []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return s.fail("", "body is not valid JSON: "+err.Error())
+	}
+	return s.validate(s.root, value, "$")
+}
+
+func (s *Schema) validate(n *node, value interface{}, path string) error {
+	n = s.resolve(n)
+	if types := n.types(); len(types) > 0 && !matchesAny(types, value) {
+		return s.fail(path, fmt.Sprintf("expected %s", strings.Join(types, " or ")))
+	}
+	if len(n.Enum) > 0 && !inEnum(n.Enum, value) {
+		return s.fail(path, fmt.Sprintf("%v is not an allowed value", value))
+	}
+	switch v := value.(type) {
+	case string:
+		if n.MinLength != nil && len(v) < *n.MinLength {
+			return s.fail(path, fmt.Sprintf("shorter than %d characters", *n.MinLength))
+		}
+	case []interface{}:
+		if n.Items != nil {
+			for i, item := range v {
+				if err := s.validate(n.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range n.Required {
+			if _, exists := v[name]; !exists {
+				return s.fail(path, fmt.Sprintf("missing required property %s", name))
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			prop, known := n.Properties[name]
+			if !known {
+				if n.AdditionalProperties != nil && !*n.AdditionalProperties {
+					return s.fail(path, fmt.Sprintf("unexpected property %s", name))
+				}
+				continue
+			}
+			if err := s.validate(prop, v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve : follows a local $ref to its definition
+func (s *Schema) resolve(n *node) *node {
+	if n.Ref == "" {
+		return n
+	}
+	return s.root.Definitions[strings.TrimPrefix(n.Ref, "#/definitions/")]
+}
+
+// checkRefs : makes sure every $ref points at a definition, so validation never has to
+func (s *Schema) checkRefs(n *node, path string) error {
+	if n.Ref != "" {
+		if !strings.HasPrefix(n.Ref, "#/definitions/") || s.resolve(n) == nil {
+			return s.fail(path, fmt.Sprintf("unresolvable $ref %s", n.Ref))
+		}
+		return nil
+	}
+	for name, prop := range n.Properties {
+		if err := s.checkRefs(prop, path+"."+name); err != nil {
+			return err
+		}
+	}
+	if n.Items != nil {
+		if err := s.checkRefs(n.Items, path+"[]"); err != nil {
+			return err
+		}
+	}
+	for name, def := range n.Definitions {
+		if err := s.checkRefs(def, "#/definitions/"+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) fail(path string, reason string) error {
+	return &ValidationError{Topic: s.Topic, Type: s.Type, Version: s.Version, Path: path, Reason: reason}
+}
+
+// types : the JSON types a node allows, which may be given as a string or a list
+func (n *node) types() []string {
+	switch t := n.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, name := range t {
+			if str, ok := name.(string); ok {
+				types = append(types, str)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesAny(types []string, value interface{}) bool {
+	for _, t := range types {
+		if matchesType(t, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(t string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		_, err := v.Int64()
+		return t == "integer" && err == nil
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprintf("%v", allowed) == fmt.Sprintf("%v", value) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Registry : Every known version of every message schema, keyed by topic and message type
+type Registry struct {
+	mux     sync.RWMutex
+	schemas map[string]map[int]*Schema
+	topics  map[string]bool
+}
+
+// NewRegistry : Returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		schemas: map[string]map[int]*Schema{},
+		topics:  map[string]bool{},
+	}
+}
+
+// Register : Adds a schema. Registering the same topic, type and version twice is an error
+func (r *Registry) Register(s *Schema) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	key := registryKey(s.Topic, s.Type)
+	if r.schemas[key] == nil {
+		r.schemas[key] = map[int]*Schema{}
+	}
+	if _, exists := r.schemas[key][s.Version]; exists {
+		return fmt.Errorf("schema %s %s v%d is already registered", s.Topic, s.Type, s.Version)
+	}
+	r.schemas[key][s.Version] = s
+	r.topics[s.Topic] = true
+	return nil
+}
+
+// Get : Returns the schema for msgType on topic at version, or nil
+func (r *Registry) Get(topic string, msgType string, version int) *Schema {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.schemas[registryKey(topic, msgType)][version]
+}
+
+// Latest : Returns the highest registered version of the schema for msgType on topic, or nil
+func (r *Registry) Latest(topic string, msgType string) *Schema {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	var latest *Schema
+	for _, s := range r.schemas[registryKey(topic, msgType)] {
+		if latest == nil || s.Version > latest.Version {
+			latest = s
+		}
+	}
+	return latest
+}
+
+// Covers : Reports whether any schema is registered for topic. Messages on other topics aren't validated
+func (r *Registry) Covers(topic string) bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.topics[topic]
+}
+
+// Schemas : Returns every registered schema ordered by topic, type and version
+func (r *Registry) Schemas() []*Schema {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	all := make([]*Schema, 0)
+	for _, versions := range r.schemas {
+		for _, s := range versions {
+			all = append(all, s)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Topic != all[j].Topic {
+			return all[i].Topic < all[j].Topic
+		}
+		if all[i].Type != all[j].Type {
+			return all[i].Type < all[j].Type
+		}
+		return all[i].Version < all[j].Version
+	})
+	return all
+}
+
+// Validate : Checks msg against the schema version named in its header. Messages without the header
+// predate versioning and are checked against version 1
+func (r *Registry) Validate(msg bus.Message) error {
+	if !r.Covers(msg.Topic) {
+		return nil
+	}
+	version := 1
+	if header, exists := msg.Headers[VersionHeader]; exists {
+		parsed, err := strconv.Atoi(header)
+		if err != nil {
+			return &ValidationError{Topic: msg.Topic, Type: msg.Type, Reason: fmt.Sprintf("invalid %s header %q", VersionHeader, header)}
+		}
+		version = parsed
+	}
+	s := r.Get(msg.Topic, msg.Type, version)
+	if s == nil {
+		return &ValidationError{Topic: msg.Topic, Type: msg.Type, Version: version, Reason: "no schema registered"}
+	}
+	return s.Validate(msg.Body)
+}
+
+// Stamp : Sets the schema version header to the latest version for msg's type, unless the producer
+// pinned an older one, then validates msg against it
+func (r *Registry) Stamp(msg bus.Message) (bus.Message, error) {
+	if !r.Covers(msg.Topic) {
+		return msg, nil
+	}
+	headers := map[string]string{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	if _, pinned := headers[VersionHeader]; !pinned {
+		latest := r.Latest(msg.Topic, msg.Type)
+		if latest == nil {
+			return msg, &ValidationError{Topic: msg.Topic, Type: msg.Type, Reason: "no schema registered"}
+		}
+		headers[VersionHeader] = strconv.Itoa(latest.Version)
+	}
+	msg.Headers = headers
+	return msg, r.Validate(msg)
+}
+
+func registryKey(topic string, msgType string) string {
+	return topic + "|" + msgType
+}
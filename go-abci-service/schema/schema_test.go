@@ -0,0 +1,170 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/bus"
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProofStateSchemasCompatible : each schema must stay readable by consumers built against its frozen copy in
+// testdata. A failure here means the change needs a new schema version rather than an edit to an existing one
+func TestProofStateSchemasCompatible(t *testing.T) {
+	for _, current := range NewProofStateRegistry().Schemas() {
+		path := filepath.Join("testdata", "proofstate", fmt.Sprintf("%s.v%d.json", current.Type, current.Version))
+		document, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("%s v%d has no frozen copy at %s", current.Type, current.Version, path)
+			continue
+		}
+		frozen, err := NewSchema(current.Topic, current.Type, current.Version, string(document))
+		if err != nil {
+			t.Errorf("frozen schema %s doesn't parse: %s", path, err.Error())
+			continue
+		}
+		for _, change := range CheckCompatible(frozen, current) {
+			t.Errorf("%s v%d: breaking change %s", current.Type, current.Version, change)
+		}
+	}
+}
+
+// TestProofStateMessagesValidate : the structs Core publishes must satisfy the latest schema for their type
+func TestProofStateMessagesValidate(t *testing.T) {
+	registry := NewProofStateRegistry()
+	proof := []types.ProofLineItem{{Left: "aa"}, {Op: "sha-256"}, {Right: "bb"}, {Op: "sha-256"}}
+	anchor := types.AnchorObj{AnchorID: "1000", Uris: []string{"https://tendermint.chainpoint.org/calendar/abcd/data"}}
+	samples := map[string]interface{}{
+		TypeAggregator: types.Aggregation{
+			AggID:     "f4c5445a-49ca-11e9-b3cf-0242ac190005",
+			AggRoot:   "58f42246b9c6d303e33206d461e05f3e2292d8eddfce92b7434f1d8be9f0e2c1",
+			ProofData: []types.ProofData{{HashID: "6d627180-1883-11e7-a8f9-edb8c212ef23", Hash: "ed10", Proof: proof}},
+		},
+		TypeCalBatch: types.CalState{
+			CalID:     "b52e3f769921b60352f750bff7998a1d6e3159494c32c639e0431439d418dbba",
+			Anchor:    anchor,
+			ProofData: []types.CalProofData{{AggID: "f4c5445a-49ca-11e9-b3cf-0242ac190005", Proof: proof}},
+		},
+		TypeBtcAggBatch: types.BtcAgg{
+			AnchorBtcAggID:   "cb8196f7-6d6f-b0a2-b542-9355f762acb3",
+			AnchorBtcAggRoot: "cb8196f76d6fb0a2b5429355f762acb32c919402575135315d418875255929f5",
+			ProofData:        []types.BtcProofData{{CalID: "ab79", Proof: proof}},
+		},
+		TypeBtcTx: types.BtcTxProofState{
+			AnchorBtcAggID: "cb8196f7-6d6f-b0a2-b542-9355f762acb3",
+			BtcTxID:        "c1d117a7bad78af98ce4fba7243789b5fca1f084f6191f181e9bba0c102d2e7f",
+			BtcTxState:     types.BtcTxOpsState{Ops: proof},
+		},
+		TypeBtcMon: types.BtccStateObj{
+			BtcTxID:       "c1d117a7bad78af98ce4fba7243789b5fca1f084f6191f181e9bba0c102d2e7f",
+			BtcHeadHeight: 570000,
+			BtcHeadState:  types.BtccOpsState{Ops: proof, Anchor: anchor},
+		},
+	}
+	for _, s := range registry.Schemas() {
+		if _, exists := samples[s.Type]; !exists {
+			t.Errorf("no sample message for %s", s.Type)
+		}
+	}
+	for msgType, sample := range samples {
+		body, err := json.Marshal(sample)
+		assert.Nil(t, err)
+		msg, err := registry.Stamp(bus.Message{Topic: bus.TopicProofState, Type: msgType, Body: body})
+		assert.Nil(t, err, "%s sample should validate", msgType)
+		assert.Equal(t, "1", msg.Headers[VersionHeader])
+	}
+}
+
+func TestValidationRejects(t *testing.T) {
+	registry := NewProofStateRegistry()
+	cases := map[string]bus.Message{
+		"missing property": {Type: TypeBtcTx, Body: []byte(`{"anchor_btc_agg_id":"a","btctx_id":"b"}`)},
+		"wrong type":       {Type: TypeBtcMon, Body: []byte(`{"btctx_id":"a","btchead_height":"1","btchead_state":{"ops":[],"anchor":{"anchor_id":"1","uris":[]}}}`)},
+		"fractional int":   {Type: TypeBtcMon, Body: []byte(`{"btctx_id":"a","btchead_height":1.5,"btchead_state":{"ops":[],"anchor":{"anchor_id":"1","uris":[]}}}`)},
+		"nested ref":       {Type: TypeCalBatch, Body: []byte(`{"cal_id":"a","anchor":{"anchor_id":"1","uris":[]},"proofData":[{"agg_id":"x","proof":[{"l":1}]}]}`)},
+		"empty string":     {Type: TypeAggregator, Body: []byte(`{"agg_id":"","agg_root":"a","proofData":[]}`)},
+		"not json":         {Type: TypeAggregator, Body: []byte(`{`)},
+		"unknown type":     {Type: "cal", Body: []byte(`{}`)},
+		"unknown version":  {Type: TypeAggregator, Headers: map[string]string{VersionHeader: "99"}, Body: []byte(`{"agg_id":"a","agg_root":"a","proofData":[]}`)},
+	}
+	for name, msg := range cases {
+		msg.Topic = bus.TopicProofState
+		err := registry.Validate(msg)
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("%s: expected a ValidationError, got %v", name, err)
+		}
+	}
+	// Legacy messages without a version header are read as version 1
+	err := registry.Validate(bus.Message{Topic: bus.TopicProofState, Type: TypeAggregator, Body: []byte(`{"agg_id":"a","agg_root":"a","proofData":[]}`)})
+	assert.Nil(t, err)
+	// Topics without schemas pass through
+	assert.Nil(t, registry.Validate(bus.Message{Topic: bus.TopicBtcMon, Body: []byte(`not json`)}))
+}
+
+func TestCheckCompatible(t *testing.T) {
+	base := `{"type":"object","required":["a"],"properties":{"a":{"type":"string"},"b":{"type":"integer"},"c":{"type":"string","enum":["x","y"]}}}`
+	cases := map[string]struct {
+		document string
+		breaking bool
+	}{
+		"unchanged":         {base, false},
+		"optional added":    {`{"type":"object","required":["a"],"properties":{"a":{"type":"string"},"b":{"type":"integer"},"c":{"type":"string","enum":["x","y"]},"d":{"type":"string"}}}`, false},
+		"required added":    {`{"type":"object","required":["a","b"],"properties":{"a":{"type":"string"},"b":{"type":"integer"},"c":{"type":"string","enum":["x","y"]}}}`, false},
+		"required dropped":  {`{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"integer"},"c":{"type":"string","enum":["x","y"]}}}`, true},
+		"property removed":  {`{"type":"object","required":["a"],"properties":{"a":{"type":"string"},"c":{"type":"string","enum":["x","y"]}}}`, true},
+		"type changed":      {`{"type":"object","required":["a"],"properties":{"a":{"type":"string"},"b":{"type":"string"},"c":{"type":"string","enum":["x","y"]}}}`, true},
+		"type widened":      {`{"type":"object","required":["a"],"properties":{"a":{"type":["string","null"]},"b":{"type":"integer"},"c":{"type":"string","enum":["x","y"]}}}`, true},
+		"enum value added":  {`{"type":"object","required":["a"],"properties":{"a":{"type":"string"},"b":{"type":"integer"},"c":{"type":"string","enum":["x","y","z"]}}}`, true},
+		"enum value pruned": {`{"type":"object","required":["a"],"properties":{"a":{"type":"string"},"b":{"type":"integer"},"c":{"type":"string","enum":["x"]}}}`, false},
+	}
+	prev, err := NewSchema("t", "m", 1, base)
+	assert.Nil(t, err)
+	for name, c := range cases {
+		next, err := NewSchema("t", "m", 1, c.document)
+		assert.Nil(t, err, name)
+		breaks := CheckCompatible(prev, next)
+		if c.breaking != (len(breaks) > 0) {
+			t.Errorf("%s: expected breaking=%t, got %v", name, c.breaking, breaks)
+		}
+	}
+}
+
+func TestValidatingBus(t *testing.T) {
+	memBus := bus.NewMemoryBus()
+	validating := NewValidatingBus(memBus, NewProofStateRegistry())
+
+	err := validating.Publish(bus.Message{Topic: bus.TopicProofState, Type: TypeBtcTx, Body: []byte(`{}`)})
+	assert.NotNil(t, err, "invalid messages should be refused")
+	err = validating.Publish(bus.Message{Topic: bus.TopicProofState, Type: TypeAggregator, Body: []byte(`{"agg_id":"a","agg_root":"a","proofData":[]}`)})
+	assert.Nil(t, err)
+	pending := memBus.Pending(bus.TopicProofState)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, "1", pending[0].Headers[VersionHeader])
+
+	// a message published around the validating bus is dead-lettered before reaching the handler
+	assert.Nil(t, memBus.Publish(bus.Message{Topic: bus.TopicProofState, Type: TypeBtcTx, Body: []byte(`{}`)}))
+	handled := make(chan bus.Message, 2)
+	sub, err := validating.Subscribe(bus.TopicProofState, 1, func(msg bus.Message) bus.Result {
+		handled <- msg
+		return bus.Ack
+	})
+	assert.Nil(t, err)
+	defer sub.Unsubscribe()
+	select {
+	case msg := <-handled:
+		assert.Equal(t, TypeAggregator, msg.Type)
+	case <-time.After(time.Second):
+		t.Fatal("valid message was not delivered")
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(memBus.DeadLetters(bus.TopicProofState)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, len(memBus.DeadLetters(bus.TopicProofState)))
+	assert.Equal(t, 0, len(handled))
+}
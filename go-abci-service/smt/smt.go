@@ -0,0 +1,215 @@
+/* Copyright 2019 Tierion
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*     http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/merkletools"
+)
+
+// Depth : every key is hashed to a 256 bit path through the tree
+const Depth = 256
+
+// emptyHashes[d] is the root of an empty subtree whose top is at depth d. emptyHashes[Depth] is the empty leaf
+var emptyHashes = func() [Depth + 1][]byte {
+	var hashes [Depth + 1][]byte
+	hashes[Depth] = make([]byte, sha256.Size)
+	for d := Depth - 1; d >= 0; d-- {
+		hashes[d] = merkletools.HashNode(hashes[d+1], hashes[d+1])
+	}
+	return hashes
+}()
+
+// SparseMerkleTree : A fixed depth Merkle tree over sha256(key), supporting membership and non-membership proofs.
+// Internal nodes and values are stored content-addressed, so Root is owned by the caller and persisted with the rest of its state
+type SparseMerkleTree struct {
+	Store  merkletools.KVStore
+	Prefix string
+	Root   []byte
+}
+
+// Proof : Siblings along the path of a key, root first. Empty siblings are omitted and flagged as unset in Bitmap
+type Proof struct {
+	Bitmap   []byte   `json:"bitmap"`
+	Siblings [][]byte `json:"siblings"`
+}
+
+// EmptyRoot : Returns the root of a tree with no keys
+func EmptyRoot() []byte {
+	return emptyHashes[0]
+}
+
+// NewSparseMerkleTree : Returns a tree over store with the given root. A nil root starts an empty tree
+func NewSparseMerkleTree(store merkletools.KVStore, prefix string, root []byte) *SparseMerkleTree {
+	if len(root) == 0 {
+		root = EmptyRoot()
+	}
+	return &SparseMerkleTree{
+		Store:  store,
+		Prefix: prefix,
+		Root:   root,
+	}
+}
+
+// At : Returns a read-only view of the tree as of an earlier root. Nodes and values are both content-addressed,
+// so updates made since root don't disturb it
+func (smt *SparseMerkleTree) At(root []byte) *SparseMerkleTree {
+	return NewSparseMerkleTree(smt.Store, smt.Prefix, root)
+}
+
+// Get : Returns the value stored for key, or nil if the key is absent
+func (smt *SparseMerkleTree) Get(key []byte) ([]byte, error) {
+	path := keyPath(key)
+	leaf, _, err := smt.walk(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(leaf, emptyHashes[Depth]) {
+		return nil, nil
+	}
+	value := smt.Store.Get(smt.valueKey(leaf))
+	if !bytes.Equal(leafHash(path, value), leaf) {
+		return nil, fmt.Errorf("stored value for key %x does not match tree", key)
+	}
+	return value, nil
+}
+
+// Update : Sets key to value and returns the new root. A nil or empty value removes the key
+func (smt *SparseMerkleTree) Update(key []byte, value []byte) ([]byte, error) {
+	path := keyPath(key)
+	_, siblings, err := smt.walk(path)
+	if err != nil {
+		return nil, err
+	}
+	current := emptyHashes[Depth]
+	if len(value) > 0 {
+		current = leafHash(path, value)
+		smt.Store.Set(smt.valueKey(current), value)
+	}
+	for d := Depth - 1; d >= 0; d-- {
+		var left, right []byte
+		if bitAt(path, d) == 0 {
+			left, right = current, siblings[d]
+		} else {
+			left, right = siblings[d], current
+		}
+		current = merkletools.HashNode(left, right)
+		if !bytes.Equal(current, emptyHashes[d]) {
+			smt.Store.Set(smt.nodeKey(current), append(append(make([]byte, 0, 2*sha256.Size), left...), right...))
+		}
+	}
+	smt.Root = current
+	return current, nil
+}
+
+// Delete : Removes key from the tree and returns the new root
+func (smt *SparseMerkleTree) Delete(key []byte) ([]byte, error) {
+	return smt.Update(key, nil)
+}
+
+// Prove : Returns a proof for key. It proves membership if the key is present and non-membership otherwise
+func (smt *SparseMerkleTree) Prove(key []byte) (Proof, error) {
+	_, siblings, err := smt.walk(keyPath(key))
+	if err != nil {
+		return Proof{}, err
+	}
+	proof := Proof{
+		Bitmap:   make([]byte, Depth/8),
+		Siblings: make([][]byte, 0),
+	}
+	for d := 0; d < Depth; d++ {
+		if !bytes.Equal(siblings[d], emptyHashes[d+1]) {
+			proof.Bitmap[d/8] |= 1 << uint(7-d%8)
+			proof.Siblings = append(proof.Siblings, siblings[d])
+		}
+	}
+	return proof, nil
+}
+
+// VerifyProof : Checks that key maps to value under root. A nil value checks that key is absent
+func VerifyProof(proof Proof, root []byte, key []byte, value []byte) bool {
+	if len(proof.Bitmap) != Depth/8 {
+		return false
+	}
+	path := keyPath(key)
+	current := emptyHashes[Depth]
+	if len(value) > 0 {
+		current = leafHash(path, value)
+	}
+	next := len(proof.Siblings) - 1
+	for d := Depth - 1; d >= 0; d-- {
+		sibling := emptyHashes[d+1]
+		if bitAt(proof.Bitmap, d) == 1 {
+			if next < 0 {
+				return false
+			}
+			sibling = proof.Siblings[next]
+			next--
+		}
+		if bitAt(path, d) == 0 {
+			current = merkletools.HashNode(current, sibling)
+		} else {
+			current = merkletools.HashNode(sibling, current)
+		}
+	}
+	return next == -1 && bytes.Equal(current, root)
+}
+
+// walk : descends from the root along path, returning the leaf hash and the sibling at each depth
+func (smt *SparseMerkleTree) walk(path []byte) ([]byte, [][]byte, error) {
+	siblings := make([][]byte, Depth)
+	current := smt.Root
+	for d := 0; d < Depth; d++ {
+		if bytes.Equal(current, emptyHashes[d]) {
+			for i := d; i < Depth; i++ {
+				siblings[i] = emptyHashes[i+1]
+			}
+			return emptyHashes[Depth], siblings, nil
+		}
+		children := smt.Store.Get(smt.nodeKey(current))
+		if len(children) != 2*sha256.Size {
+			return nil, nil, errors.New(fmt.Sprintf("missing sparse merkle tree node %x at depth %d", current, d))
+		}
+		if bitAt(path, d) == 0 {
+			current, siblings[d] = children[:sha256.Size], children[sha256.Size:]
+		} else {
+			current, siblings[d] = children[sha256.Size:], children[:sha256.Size]
+		}
+	}
+	return current, siblings, nil
+}
+
+func (smt *SparseMerkleTree) nodeKey(hash []byte) []byte {
+	return append([]byte(smt.Prefix+"n:"), hash...)
+}
+
+func (smt *SparseMerkleTree) valueKey(leaf []byte) []byte {
+	return append([]byte(smt.Prefix+"v:"), leaf...)
+}
+
+func keyPath(key []byte) []byte {
+	path := sha256.Sum256(key)
+	return path[:]
+}
+
+func leafHash(path []byte, value []byte) []byte {
+	return merkletools.HashLeaf(append(append(make([]byte, 0, len(path)+len(value)), path...), value...))
+}
+
+func bitAt(b []byte, i int) byte {
+	return (b[i/8] >> uint(7-i%8)) & 1
+}
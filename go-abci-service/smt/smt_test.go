@@ -0,0 +1,135 @@
+/* Copyright 2019 Tierion
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*     http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package smt
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/merkletools"
+)
+
+func TestEmptyTree(t *testing.T) {
+	tree := NewSparseMerkleTree(&merkletools.MemStore{}, "smt:", nil)
+	if !bytes.Equal(tree.Root, EmptyRoot()) {
+		t.Errorf("new tree should have the empty root, got: %x, want: %x.", tree.Root, EmptyRoot())
+	}
+	value, err := tree.Get([]byte("missing"))
+	if err != nil || value != nil {
+		t.Errorf("missing key should return nil, got: %x, %v", value, err)
+	}
+	proof, err := tree.Prove([]byte("missing"))
+	if err != nil {
+		t.Fatalf("Prove failed: %s", err)
+	}
+	if !VerifyProof(proof, tree.Root, []byte("missing"), nil) {
+		t.Errorf("non-membership proof in an empty tree should be valid")
+	}
+}
+
+func TestUpdateGetProve(t *testing.T) {
+	tree := NewSparseMerkleTree(&merkletools.MemStore{}, "smt:", nil)
+	for i := 0; i < 20; i++ {
+		if _, err := tree.Update([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("Update failed: %s", err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		value, err := tree.Get(key)
+		if err != nil || !bytes.Equal(value, []byte(fmt.Sprintf("value%d", i))) {
+			t.Errorf("Get(%s) returned %s, %v", key, value, err)
+		}
+		proof, err := tree.Prove(key)
+		if err != nil {
+			t.Fatalf("Prove failed: %s", err)
+		}
+		if !VerifyProof(proof, tree.Root, key, value) {
+			t.Errorf("membership proof for %s should be valid", key)
+		}
+		if VerifyProof(proof, tree.Root, key, []byte("wrong")) {
+			t.Errorf("membership proof for %s should not verify a different value", key)
+		}
+		if VerifyProof(proof, tree.Root, key, nil) {
+			t.Errorf("membership proof for %s should not verify absence", key)
+		}
+	}
+	proof, _ := tree.Prove([]byte("missing"))
+	if !VerifyProof(proof, tree.Root, []byte("missing"), nil) {
+		t.Errorf("non-membership proof should be valid")
+	}
+}
+
+func TestDeleteRestoresRoot(t *testing.T) {
+	tree := NewSparseMerkleTree(&merkletools.MemStore{}, "smt:", nil)
+	tree.Update([]byte("a"), []byte("1"))
+	rootWithA := append([]byte{}, tree.Root...)
+	tree.Update([]byte("b"), []byte("2"))
+	tree.Delete([]byte("b"))
+	if !bytes.Equal(tree.Root, rootWithA) {
+		t.Errorf("deleting a key should restore the previous root, got: %x, want: %x.", tree.Root, rootWithA)
+	}
+	tree.Delete([]byte("a"))
+	if !bytes.Equal(tree.Root, EmptyRoot()) {
+		t.Errorf("deleting every key should restore the empty root, got: %x, want: %x.", tree.Root, EmptyRoot())
+	}
+	proof, _ := tree.Prove([]byte("a"))
+	if !VerifyProof(proof, tree.Root, []byte("a"), nil) {
+		t.Errorf("deleted key should be provably absent")
+	}
+}
+
+func TestOrderIndependentRoot(t *testing.T) {
+	first := NewSparseMerkleTree(&merkletools.MemStore{}, "smt:", nil)
+	second := NewSparseMerkleTree(&merkletools.MemStore{}, "smt:", nil)
+	for i := 0; i < 10; i++ {
+		first.Update([]byte(fmt.Sprintf("key%d", i)), []byte("v"))
+		second.Update([]byte(fmt.Sprintf("key%d", 9-i)), []byte("v"))
+	}
+	if !bytes.Equal(first.Root, second.Root) {
+		t.Errorf("root should not depend on insertion order, got: %x and %x.", first.Root, second.Root)
+	}
+}
+
+func TestReload(t *testing.T) {
+	store := &merkletools.MemStore{}
+	tree := NewSparseMerkleTree(store, "smt:", nil)
+	tree.Update([]byte("a"), []byte("1"))
+	reloaded := NewSparseMerkleTree(store, "smt:", tree.Root)
+	value, err := reloaded.Get([]byte("a"))
+	if err != nil || !bytes.Equal(value, []byte("1")) {
+		t.Errorf("reloaded tree should return stored value, got: %s, %v", value, err)
+	}
+}
+
+func TestAtEarlierRoot(t *testing.T) {
+	tree := NewSparseMerkleTree(&merkletools.MemStore{}, "smt:", nil)
+	tree.Update([]byte("a"), []byte("1"))
+	committed := tree.Root
+	tree.Update([]byte("a"), []byte("2"))
+	tree.Update([]byte("b"), []byte("3"))
+	view := tree.At(committed)
+	value, err := view.Get([]byte("a"))
+	if err != nil || !bytes.Equal(value, []byte("1")) {
+		t.Errorf("view should return the value as of its root, got: %s, %v", value, err)
+	}
+	value, err = view.Get([]byte("b"))
+	if err != nil || value != nil {
+		t.Errorf("view should not see keys added after its root, got: %s, %v", value, err)
+	}
+	value, _ = tree.Get([]byte("a"))
+	if !bytes.Equal(value, []byte("2")) {
+		t.Errorf("tree should return the latest value, got: %s", value)
+	}
+}
@@ -27,7 +27,9 @@ type TendermintConfig struct {
 //AnchorConfig represents values to configure all connections within the ABCI anchor app
 type AnchorConfig struct {
 	DBType           string
+	MessageBus       string
 	RabbitmqURI      string
+	NatsURI          string
 	TendermintConfig TendermintConfig
 	PostgresURI      string
 	RedisURI         string
@@ -36,6 +38,9 @@ type AnchorConfig struct {
 	ECPrivateKey     ecdsa.PrivateKey
 	DoNodeManagement bool
 	DoNodeAudit      bool
+	AuditChecks      string
+	AuditPassScore   float64
+	AuditMinVersion  string
 	DoPrivateNetwork bool
 	PrivateNodeIPs   []string
 	PrivateCoreIPs   []string
@@ -46,12 +51,20 @@ type AnchorConfig struct {
 	FilePV           privval.FilePV
 }
 
-//EthConfig holds contract addresses and eth node URI
+//EthConfig holds contract addresses, eth node URI, and registry event ingestion settings
 type EthConfig struct {
 	EthereumURL          string
+	EthereumWSURL        string
 	EthPrivateKey        string
 	TokenContractAddr    string
+	TokenContractABI     string
 	RegistryContractAddr string
+	Confirmations        int64
+	LogPageSize          int64
+	MaxGasPriceGwei      int64
+	NodeMintSigSlots     int
+	CoreMintSigSlots     int
+	RegistryStartBlock   int64
 }
 
 // AnchorState holds Tendermint/ABCI application state. Persisted by ABCI app
@@ -76,11 +89,41 @@ type AnchorState struct {
 	LastNodeMintedAtBlock int64 `json:"node_last_mint_block"`
 	PrevNodeMintedAtBlock int64 `json:"node_prev_mint_block"`
 	CoreMintPending       bool
-	LastCoreMintedAtBlock int64  `json:"core_last_mint_block"`
-	PrevCoreMintedAtBlock int64  `json:"core_prev_mint_block"`
-	LastAnchorCoreID      string `json:"last_anchor_core_id"`
-	LastMintCoreID        string `json:"last_mint_core_id"`
-	LastAuditCoreID       string `json:"last_audit_core_id"`
+	LastCoreMintedAtBlock int64       `json:"core_last_mint_block"`
+	PrevCoreMintedAtBlock int64       `json:"core_prev_mint_block"`
+	LastAnchorCoreID      string      `json:"last_anchor_core_id"`
+	LastMintCoreID        string      `json:"last_mint_core_id"`
+	LastAuditCoreID       string      `json:"last_audit_core_id"`
+	CalMMRLeafCount       uint64      `json:"cal_mmr_leaf_count"`
+	CalMMRRoot            []byte      `json:"cal_mmr_root"`
+	RegistryRoot          []byte      `json:"registry_root"`
+	ChainParams           ChainParams `json:"chain_params"`
+}
+
+//ChainParams are consensus rules fixed by the genesis app_state. An activation height of 0 leaves its feature off
+type ChainParams struct {
+	HardenedCalTreeHeight int64  `json:"hardened_cal_tree_height"`
+	RegistryHeight        int64  `json:"registry_height"`
+	CalMMRHeight          int64  `json:"cal_mmr_height"`
+	AuditStakingBlock     int64  `json:"audit_staking_block"`
+	AuditWeighting        string `json:"audit_weighting"`
+	CoreWorkWeights       string `json:"core_work_weights"`
+	CoreRewardShares      int    `json:"core_reward_shares"`
+}
+
+//GenesisChainParams : the chain params written to a newly generated genesis file, with every feature active from the first block
+//and Node audits weighted by auditWeighting, drawn from the staking state as of auditStakingBlock until the first mint. Core mints
+//split coreRewardShares shares by work scored with coreWorkWeights
+func GenesisChainParams(auditStakingBlock int64, auditWeighting string, coreWorkWeights string, coreRewardShares int) ChainParams {
+	return ChainParams{
+		HardenedCalTreeHeight: 1,
+		RegistryHeight:        1,
+		CalMMRHeight:          1,
+		AuditStakingBlock:     auditStakingBlock,
+		AuditWeighting:        auditWeighting,
+		CoreWorkWeights:       coreWorkWeights,
+		CoreRewardShares:      coreRewardShares,
+	}
 }
 
 // Tx holds custom transaction data and metadata for the Chainpoint Calendar
@@ -184,8 +227,23 @@ type TxID struct {
 
 // CalAgg : An RMQ message representing an intermediate aggregation object to be fed into the Cal anchor tree
 type CalAgg struct {
-	CalRoot   string         `json:"cal_root"`
-	ProofData []CalProofData `json:"proofData"`
+	CalRoot   string                `json:"cal_root"`
+	ProofData []CalProofData        `json:"proofData"`
+	Excluded  []ExcludedAggregation `json:"excluded,omitempty"`
+}
+
+// PendingCal : A CAL submission recorded in the outbox until the proof state service has acknowledged it.
+// TxHash is empty until the CAL tx has been committed
+type PendingCal struct {
+	CalRoot string `json:"cal_root"`
+	CalAgg  CalAgg `json:"cal_agg"`
+	TxHash  string `json:"tx_hash"`
+}
+
+// ExcludedAggregation : An aggregation left out of a calendar tree, along with the reason it was rejected
+type ExcludedAggregation struct {
+	Aggregation Aggregation `json:"aggregation"`
+	Reason      string      `json:"reason"`
 }
 
 // CalState : An RMQ message confirming a CAL anchor, sent to the proof service to generate/store the proof
@@ -215,6 +273,42 @@ type CalProofData struct {
 	Proof []ProofLineItem `json:"proof"`
 }
 
+// CalMMRQuery : Parameters for calendar MMR proof queries
+type CalMMRQuery struct {
+	LeafIndex    uint64 `json:"leaf_index"`
+	LeafCount    uint64 `json:"leaf_count"`
+	OldLeafCount uint64 `json:"old_leaf_count"`
+}
+
+// CalMMRInclusion : Proof that a CAL root is included in the calendar MMR with LeafCount leaves
+type CalMMRInclusion struct {
+	LeafIndex uint64          `json:"leaf_index"`
+	LeafCount uint64          `json:"leaf_count"`
+	Root      string          `json:"root"`
+	Proof     []ProofLineItem `json:"proof"`
+}
+
+// CalMMRConsistency : Proof that the calendar MMR with OldLeafCount leaves is a prefix of the one with NewLeafCount leaves
+type CalMMRConsistency struct {
+	OldLeafCount uint64            `json:"old_leaf_count"`
+	NewLeafCount uint64            `json:"new_leaf_count"`
+	OldRoot      string            `json:"old_root"`
+	NewRoot      string            `json:"new_root"`
+	OldPeaks     []string          `json:"old_peaks"`
+	NewPeaks     []string          `json:"new_peaks"`
+	Paths        [][]ProofLineItem `json:"paths"`
+}
+
+// RegistryProof : Sparse Merkle proof that a Core key or node token is present in, or absent from, the registry
+type RegistryProof struct {
+	Key      string   `json:"key"`
+	Value    string   `json:"value"`
+	Present  bool     `json:"present"`
+	Root     string   `json:"root"`
+	Bitmap   string   `json:"bitmap"`
+	Siblings []string `json:"siblings"`
+}
+
 // ProofLineItem : A step in a Chainpoint proof
 type ProofLineItem struct {
 	Left  string `json:"l,omitempty"`
@@ -246,12 +340,157 @@ type Core struct {
 	BlockNumber sql.NullInt64
 }
 
+// Staking event kinds and types recorded in the staking_events ledger
+const (
+	StakingKindNode      = "node"
+	StakingKindCore      = "core"
+	StakingEventStaked   = "staked"
+	StakingEventUpdated  = "stake_updated"
+	StakingEventUnstaked = "unstaked"
+)
+
+// StakingEvent : A registry contract event as recorded in the append-only staking_events ledger.
+// BlockHash, TxHash and LogIndex identify the log on chain, so recording the same event twice has no effect,
+// while the same transaction mined into a different block after a reorg is a distinct event
+type StakingEvent struct {
+	Kind        string `json:"kind"`
+	EventType   string `json:"event_type"`
+	EthAddr     string `json:"eth_addr"`
+	PublicIP    string `json:"public_ip"`
+	CoreID      string `json:"core_id,omitempty"`
+	BlockNumber int64  `json:"block_number"`
+	BlockHash   string `json:"block_hash"`
+	TxHash      string `json:"tx_hash"`
+	LogIndex    int64  `json:"log_index"`
+}
+
 //NodeJSON : Used to write to chain
 type NodeJSON struct {
 	EthAddr  string `json:"eth_address"`
 	PublicIP string `json:"node_ip"`
 }
 
+//NodeAuditResult : One node's outcome within a NODE-AUDIT tx
+type NodeAuditResult struct {
+	EthAddr  string   `json:"eth_address"`
+	PublicIP string   `json:"node_ip"`
+	Passed   bool     `json:"passed"`
+	Reason   string   `json:"reason,omitempty"`
+	Flags    []string `json:"flags,omitempty"`
+}
+
+//NodeAudit : Written to chain as a NODE-AUDIT tx by each Core assigned to audit a round. Round is the Tendermint height the
+//assignment was derived from, and Epoch the last node minted-at block whose staking state it was drawn from
+type NodeAudit struct {
+	Round   int64             `json:"round"`
+	Epoch   int64             `json:"epoch"`
+	Results []NodeAuditResult `json:"results"`
+}
+
+//NodeAuditCheck : The outcome of one check within a stored node audit
+type NodeAuditCheck struct {
+	Check  string  `json:"check"`
+	Score  float64 `json:"score"`
+	Passed bool    `json:"passed"`
+	Detail string  `json:"detail,omitempty"`
+	Millis int64   `json:"millis"`
+}
+
+//NodeAuditRecord : One Core's audit of a node, as kept in the audits table. Reachable, Score, Checks and Millis are only
+//known for the Core's own audits; other Cores' outcomes are recorded from their NODE-AUDIT txs
+type NodeAuditRecord struct {
+	EthAddr   string           `json:"eth_address"`
+	PublicIP  string           `json:"node_ip"`
+	CoreID    string           `json:"core_id"`
+	Round     int64            `json:"round"`
+	Passed    bool             `json:"passed"`
+	Reachable bool             `json:"reachable"`
+	Score     float64          `json:"score"`
+	Error     string           `json:"error,omitempty"`
+	Flags     []string         `json:"flags,omitempty"`
+	Checks    []NodeAuditCheck `json:"checks"`
+	Millis    int64            `json:"millis"`
+	AuditedAt int64            `json:"audited_at"`
+}
+
+//NodeAuditCheckStats : How often one audit check passed
+type NodeAuditCheckStats struct {
+	Check     string  `json:"check"`
+	Runs      int64   `json:"runs"`
+	Passed    int64   `json:"passed"`
+	PassRate  float64 `json:"pass_rate"`
+	AvgMillis float64 `json:"avg_millis"`
+}
+
+//NodeAuditStats : Pass rates across the stored audits of one node, or of every node when EthAddr is empty
+type NodeAuditStats struct {
+	EthAddr  string                `json:"eth_address,omitempty"`
+	Since    int64                 `json:"since"`
+	Audits   int64                 `json:"audits"`
+	Passed   int64                 `json:"passed"`
+	PassRate float64               `json:"pass_rate"`
+	Checks   []NodeAuditCheckStats `json:"checks"`
+}
+
+//NodeAuditQuery : ABCI query data for the /audits/node and /audits/stats paths
+type NodeAuditQuery struct {
+	EthAddr string `json:"eth_address"`
+	Limit   int    `json:"limit"`
+	Since   int64  `json:"since"`
+}
+
+//RewardEvidence : One tx that qualified a reward candidate: a NODE-RC audit result for nodes, or a BTC-C anchor for Cores
+type RewardEvidence struct {
+	TxType string `json:"tx_type"`
+	TxHash string `json:"tx_hash"`
+	Time   int64  `json:"time"`
+	CoreID string `json:"core_id"`
+}
+
+//RewardCandidate : An address rewarded in a mint epoch, with every tx that qualified it
+type RewardCandidate struct {
+	EthAddr  string           `json:"eth_address"`
+	Evidence []RewardEvidence `json:"evidence,omitempty"`
+	Work     map[string]int64 `json:"work,omitempty"`
+	Score    int64            `json:"score,omitempty"`
+	Shares   int              `json:"shares,omitempty"`
+}
+
+//CoreWork : The work a Core was credited with in one Core mint epoch, counted by kind, from the first Tendermint height it
+//was credited at
+type CoreWork struct {
+	CoreID      string           `json:"core_id"`
+	Work        map[string]int64 `json:"work"`
+	FirstHeight int64            `json:"first_height"`
+}
+
+//RewardEpoch : Written to chain as a REWARD-EPOCH tx for each mint. Candidates are in the order their addresses are hashed,
+//so RewardHash can be recomputed from the record alone. Epoch is the last minted-at block the candidates were collected from
+type RewardEpoch struct {
+	Kind       string            `json:"kind"`
+	Epoch      int64             `json:"epoch"`
+	Candidates []RewardCandidate `json:"candidates"`
+	RewardHash string            `json:"reward_hash"`
+}
+
+//Misbehavior : Evidence that a Core misbehaved, committed as a MISBEHAVIOR tx. Txs are the offending Core's signed txs,
+//base64-encoded as they were broadcast
+type Misbehavior struct {
+	Offense string   `json:"offense"`
+	CoreID  string   `json:"core_id"`
+	Txs     []string `json:"txs"`
+}
+
+//CorePenalty : A Core's entry in the penalty ledger. The Core is excluded from leader election and Core rewards from Height,
+//the Tendermint height its first unexpired offense was committed at, until Until
+type CorePenalty struct {
+	CoreID   string   `json:"core_id"`
+	Offense  string   `json:"offense"`
+	Height   int64    `json:"height"`
+	Until    int64    `json:"until"`
+	Evidence []string `json:"evidence"`
+}
+
 //RepChain : Array of repchain items
 type RepChain []RepChainItem
 
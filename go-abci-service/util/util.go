@@ -114,8 +114,11 @@ func DecodePubKey(tx types.Tx) (*ecdsa.PublicKey, error) {
 		if LogError(err) != nil {
 			continue
 		}
-		pubKey := pubKeyInterface.(*ecdsa.PublicKey)
-		return pubKey, err
+		pubKey, ok := pubKeyInterface.(*ecdsa.PublicKey)
+		if !ok {
+			continue
+		}
+		return pubKey, nil
 	}
 	return &ecdsa.PublicKey{}, errors.New("unable to create public key from JWK")
 }
@@ -152,28 +155,33 @@ func DecodeVerifyTx(incoming []byte, CoreKeys map[string]ecdsa.PublicKey) (types
 	} else {
 		return types.Tx{}, errors.New(fmt.Sprintf("Can't find corresponding key for message from Core: %s", calendar.CoreID))
 	}
-	oldSig := calendar.Sig
-	der, err := base64.StdEncoding.DecodeString(calendar.Sig)
-	if LogError(err) != nil {
+	if err := VerifyTxSig(calendar, pubKey); err != nil {
 		return types.Tx{}, err
 	}
+	return calendar, nil
+}
+
+// VerifyTxSig : checks a tx's sig, an ASN.1 ECDSA signature over the sha256 of the tx's JSON without its sig, against a public key
+func VerifyTxSig(tx types.Tx, pubKey *ecdsa.PublicKey) error {
+	der, err := base64.StdEncoding.DecodeString(tx.Sig)
+	if LogError(err) != nil {
+		return err
+	}
 	sig := &types.EcdsaSignature{}
 	_, err = asn1.Unmarshal(der, sig)
 	if LogError(err) != nil {
-		return types.Tx{}, err
+		return err
 	}
-	calendar.Sig = ""
-	txNoSig, err := json.Marshal(calendar)
+	tx.Sig = ""
+	txNoSig, err := json.Marshal(tx)
 	if LogError(err) != nil {
-		return types.Tx{}, err
+		return err
 	}
 	hash := sha256.Sum256(txNoSig)
 	if !ecdsa.Verify(pubKey, hash[:], sig.R, sig.S) {
-		err := LogError(errors.New("Can't validate signature of Tx"))
-		return types.Tx{}, err
+		return LogError(errors.New("Can't validate signature of Tx"))
 	}
-	calendar.Sig = oldSig
-	return calendar, nil
+	return nil
 }
 
 // EncodeTxWithKey : Encodes a Tendermint transaction to base64
@@ -368,6 +376,21 @@ func ReadContractJSON(file string, testnet bool) string {
 	return jsonMap["networks"].(map[string]interface{})["1"].(map[string]interface{})["address"].(string)
 }
 
+//ReadContractABI: returns the ABI held in a contract's JSON artifact
+func ReadContractABI(file string) string {
+	byteValue, err := ioutil.ReadFile(file)
+	if LogError(err) != nil {
+		return ""
+	}
+	var artifact struct {
+		ABI json.RawMessage `json:"abi"`
+	}
+	if LogError(json.Unmarshal(byteValue, &artifact)) != nil {
+		return ""
+	}
+	return string(artifact.ABI)
+}
+
 //UniquifyAddresses: make unique array of addresses
 func UniquifyAddresses(s []common.Address) []common.Address {
 	seen := make(map[common.Address]struct{}, len(s))
@@ -1,6 +1,9 @@
 package util
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"testing"
@@ -69,6 +72,22 @@ func TestDecodeTx(t *testing.T) {
 	assert.NotEqual(err, nil, "Error from DecodeVerifyTx([]byte{}) should be non-nil")
 }
 
+func TestVerifyTxSig(t *testing.T) {
+	assert := assert.New(t)
+	// a TOKEN tx signed the way node-api-service signs it, with Node's crypto over the JSON of the unsigned tx
+	jsTokenTx := "eyJ0eXBlIjoiVE9LRU4iLCJkYXRhIjoiMTI3LjAuMC4xfGFiY2RlZiIsInZlcnNpb24iOjIsInRpbWUiOjE1NzEyNzA0MDAsImNvcmVfaWQiOiJjb3JlMSIsInNpZyI6Ik1FUUNJRTNGVlpGcmdGelNlMENVL3g3T0NKVkdaNC9BNGIrUW9IV2g3UkRtd2NOWUFpQWp1Z2ZtQUhVV3g2YXJaQWFpdVUvbW5vRFhMbVoxYmc5ZmlMZlFFTmtTZVE9PSJ9"
+	point, _ := hex.DecodeString("04c7ea88ec41aa71425df2fc8eebd01a85edb0376574aa8891366aef6e1fa6e2019daa18eedde42dc43c45615a9c4233b3731162b09b523450dbaf683035a28245")
+	x, y := elliptic.Unmarshal(elliptic.P256(), point)
+	pubKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	tx, err := DecodeTx([]byte(jsTokenTx))
+	assert.Nil(err)
+	assert.Nil(VerifyTxSig(tx, pubKey), "a TOKEN tx signed by node-api-service should verify")
+	tx.Data = "127.0.0.1|fedcba"
+	assert.NotNil(VerifyTxSig(tx, pubKey), "a tampered tx should not verify")
+	tx.Sig = ""
+	assert.NotNil(VerifyTxSig(tx, pubKey), "an unsigned tx should not verify")
+}
+
 func TestDecodeIP(t *testing.T) {
 	ipStr := DecodeIP("AAAAAAAAAAAAAP//I7zuug==")
 	assert.Equal(t, ipStr, "35.188.238.186", "DecodeIP mismatch, please check initial IP encoding")
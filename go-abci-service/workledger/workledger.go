@@ -0,0 +1,211 @@
+// Package workledger counts the work each Core contributes to the network during a Core mint epoch: the CAL, BTC-A,
+// BTC-C and NIST txs it issued and the Node audit rounds it took part in. The caller credits work only from committed txs
+// whose signatures were checked against keys committed to the chain, and each tx or audit round only once, so every
+// Core replaying the chain keeps the same ledger. A configurable set of integer weights turns each Core's work into a
+// score that its share of the epoch's Core rewards follows.
+package workledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	dbm "github.com/chainpoint/tendermint/libs/db"
+
+	"github.com/chp-project/chainpoint-core/go-abci-service/types"
+)
+
+// Kinds of work credited to a Core
+const (
+	WorkCal   = "cal"
+	WorkBtcA  = "btc_a"
+	WorkBtcC  = "btc_c"
+	WorkNist  = "nist"
+	WorkAudit = "audit"
+)
+
+// Kinds : every kind of work, in the order they're reported
+var Kinds = []string{WorkCal, WorkBtcA, WorkBtcC, WorkNist, WorkAudit}
+
+// ledger key prefixes within the app database
+const (
+	workPrefix = "work:"
+	refPrefix  = "workref:"
+)
+
+// Weights : what one unit of each kind of work is worth. Integers, so every Core computes the same scores
+type Weights map[string]int64
+
+// DefaultWeights : anchoring to Bitcoin is worth the most, then confirming anchors and auditing Nodes. CAL and NIST
+// txs are frequent, so each is worth the least
+func DefaultWeights() Weights {
+	return Weights{WorkCal: 1, WorkBtcA: 20, WorkBtcC: 10, WorkNist: 1, WorkAudit: 5}
+}
+
+// ParseWeights : overrides defaults with a comma-delimited list of kind=weight entries, e.g. "cal=2,audit=0"
+func ParseWeights(spec string, defaults Weights) (Weights, error) {
+	weights := make(Weights)
+	for kind, weight := range defaults {
+		weights[kind] = weight
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return defaults, fmt.Errorf("core work weight %s isn't of the form kind=weight", entry)
+		}
+		kind := strings.TrimSpace(parts[0])
+		if !knownKind(kind) {
+			return defaults, fmt.Errorf("unknown kind of core work %s", kind)
+		}
+		weight, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil || weight < 0 {
+			return defaults, fmt.Errorf("invalid weight for core work %s", kind)
+		}
+		weights[kind] = weight
+	}
+	return weights, nil
+}
+
+// Score : the weighted sum of a Core's work
+func (w Weights) Score(work map[string]int64) int64 {
+	score := int64(0)
+	for kind, count := range work {
+		score += w[kind] * count
+	}
+	return score
+}
+
+// Shares : splits total reward shares between scores. Every positive score gets at least one share and the rest are
+// split in proportion to the scores, with leftovers going to the largest remainders, ties to the earliest score.
+// Scores of zero get none. total is raised to the number of positive scores if it's smaller
+func Shares(scores []int64, total int) []int {
+	shares := make([]int, len(scores))
+	positive, sum := 0, int64(0)
+	for _, score := range scores {
+		if score > 0 {
+			positive++
+			sum += score
+		}
+	}
+	if positive == 0 {
+		return shares
+	}
+	if total < positive {
+		total = positive
+	}
+	remaining := int64(total - positive)
+	remainders := make([]int, 0, positive)
+	allocated := int64(0)
+	for i, score := range scores {
+		if score <= 0 {
+			continue
+		}
+		proportional := score * remaining / sum
+		shares[i] = 1 + int(proportional)
+		allocated += proportional
+		remainders = append(remainders, i)
+	}
+	sort.SliceStable(remainders, func(a, b int) bool {
+		i, j := remainders[a], remainders[b]
+		return scores[i]*remaining%sum > scores[j]*remaining%sum
+	})
+	for k := 0; int64(k) < remaining-allocated; k++ {
+		shares[remainders[k]]++
+	}
+	return shares
+}
+
+// Ledger : persists each Core's work per mint epoch in the app database
+type Ledger struct {
+	Db  dbm.DB
+	mux sync.Mutex
+}
+
+// NewLedger : creates a work ledger backed by db
+func NewLedger(db dbm.DB) *Ledger {
+	return &Ledger{Db: db}
+}
+
+// Add : credits a Core with one unit of work of a kind in an epoch, delivered at a Tendermint height
+func (l *Ledger) Add(epoch int64, coreID string, kind string, height int64) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.add(epoch, coreID, kind, height)
+}
+
+// AddOnce : like Add, but credits a Core only once per reference, such as the hash of the tx that did the work or the
+// Node audit round it took part in. A reference is only credited once across all epochs. Returns whether the work was credited
+func (l *Ledger) AddOnce(epoch int64, coreID string, kind string, ref string, height int64) (bool, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	key := []byte(fmt.Sprintf("%s%s:%s:%s", refPrefix, coreID, kind, ref))
+	if l.Db.Get(key) != nil {
+		return false, nil
+	}
+	if err := l.add(epoch, coreID, kind, height); err != nil {
+		return false, err
+	}
+	l.Db.Set(key, []byte{1})
+	return true, nil
+}
+
+// Epoch : the work credited to every Core in an epoch, ordered by Core ID
+func (l *Ledger) Epoch(epoch int64) ([]types.CoreWork, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	itr := dbm.IteratePrefix(l.Db, epochPrefix(epoch))
+	defer itr.Close()
+	work := make([]types.CoreWork, 0)
+	for ; itr.Valid(); itr.Next() {
+		var coreWork types.CoreWork
+		if err := json.Unmarshal(itr.Value(), &coreWork); err != nil {
+			return nil, err
+		}
+		work = append(work, coreWork)
+	}
+	sort.Slice(work, func(i, j int) bool {
+		return work[i].CoreID < work[j].CoreID
+	})
+	return work, nil
+}
+
+func (l *Ledger) add(epoch int64, coreID string, kind string, height int64) error {
+	if !knownKind(kind) {
+		return fmt.Errorf("unknown kind of core work %s", kind)
+	}
+	key := append(epochPrefix(epoch), []byte(coreID)...)
+	coreWork := types.CoreWork{CoreID: coreID, Work: make(map[string]int64), FirstHeight: height}
+	if workJSON := l.Db.Get(key); workJSON != nil {
+		if err := json.Unmarshal(workJSON, &coreWork); err != nil {
+			return err
+		}
+	}
+	coreWork.Work[kind]++
+	workJSON, err := json.Marshal(coreWork)
+	if err != nil {
+		return err
+	}
+	l.Db.Set(key, workJSON)
+	return nil
+}
+
+// epochPrefix : zero-padded, so an epoch's keys don't share a prefix with a longer epoch's
+func epochPrefix(epoch int64) []byte {
+	return []byte(fmt.Sprintf("%s%020d:", workPrefix, epoch))
+}
+
+func knownKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
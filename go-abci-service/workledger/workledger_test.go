@@ -0,0 +1,66 @@
+package workledger
+
+import (
+	"testing"
+
+	dbm "github.com/chainpoint/tendermint/libs/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerCountsWorkPerEpoch(t *testing.T) {
+	assert := assert.New(t)
+	ledger := NewLedger(dbm.NewMemDB())
+	assert.NoError(ledger.Add(100, "coreB", WorkCal, 5))
+	assert.NoError(ledger.Add(100, "coreB", WorkCal, 6))
+	assert.NoError(ledger.Add(100, "coreA", WorkBtcA, 7))
+	assert.NoError(ledger.Add(1000, "coreA", WorkBtcC, 8))
+	assert.Error(ledger.Add(100, "coreA", "mining", 9))
+
+	credited, err := ledger.AddOnce(100, "coreB", WorkAudit, "3", 9)
+	assert.NoError(err)
+	assert.True(credited)
+	credited, _ = ledger.AddOnce(100, "coreB", WorkAudit, "3", 10)
+	assert.False(credited, "a Core is credited once per audit round")
+	credited, _ = ledger.AddOnce(1000, "coreB", WorkAudit, "3", 11)
+	assert.False(credited, "a reference isn't credited again in a later epoch")
+
+	work, err := ledger.Epoch(100)
+	assert.NoError(err)
+	assert.Len(work, 2, "work from other epochs isn't included")
+	assert.Equal("coreA", work[0].CoreID)
+	assert.Equal(int64(7), work[0].FirstHeight)
+	assert.Equal("coreB", work[1].CoreID)
+	assert.Equal(map[string]int64{WorkCal: 2, WorkAudit: 1}, work[1].Work)
+	assert.Equal(int64(5), work[1].FirstHeight)
+}
+
+func TestWeightsScoreWork(t *testing.T) {
+	assert := assert.New(t)
+	weights, err := ParseWeights("cal=2, audit=0", DefaultWeights())
+	assert.NoError(err)
+	assert.Equal(int64(2), weights[WorkCal])
+	assert.Equal(DefaultWeights()[WorkBtcA], weights[WorkBtcA])
+	assert.Equal(int64(2*3+20), weights.Score(map[string]int64{WorkCal: 3, WorkBtcA: 1, WorkAudit: 4}))
+
+	_, err = ParseWeights("cal=-1", DefaultWeights())
+	assert.Error(err)
+	_, err = ParseWeights("mining=1", DefaultWeights())
+	assert.Error(err)
+	_, err = ParseWeights("cal", DefaultWeights())
+	assert.Error(err)
+}
+
+func TestSharesFollowScores(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]int{15, 5}, Shares([]int64{30, 10}, 20))
+	assert.Equal([]int{4, 3, 3}, Shares([]int64{1, 1, 1}, 10), "leftover shares go to the earliest of equal remainders")
+	assert.Equal([]int{1, 0, 1}, Shares([]int64{1, 0, 1000}, 1), "every Core that did work gets a share")
+	assert.Equal([]int{0, 0}, Shares([]int64{0, 0}, 20))
+
+	shares := Shares([]int64{7, 13, 29, 1}, 20)
+	total := 0
+	for _, share := range shares {
+		total += share
+	}
+	assert.Equal(20, total)
+}
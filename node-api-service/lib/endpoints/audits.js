@@ -0,0 +1,86 @@
+/* Copyright (C) 2019 Tierion
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+const errors = require('restify-errors')
+let tmRpc = require('../tendermint-rpc.js')
+const logger = require('../logger.js')
+
+// the most audits returned for one node, matching the ABCI app's own limit
+const MAX_AUDITS = 100
+
+function queryError(queryResponse, message, next) {
+  switch (queryResponse.error.responseCode) {
+    case 404:
+      return next(new errors.NotFoundError(`Resource not found`))
+    case 409:
+      return next(new errors.InvalidArgumentError(queryResponse.error.message))
+    default:
+      logger.error(`RPC error communicating with Tendermint : ${queryResponse.error.message}`)
+      return next(new errors.InternalServerError(message))
+  }
+}
+
+async function getNodeAuditsAsync(req, res, next) {
+  const ethAddress = req.params.addr
+  // ensure that addr represents a valid, well formatted ETH address
+  if (!/^0x[0-9a-fA-F]{40}$/i.test(ethAddress)) {
+    return next(new errors.InvalidArgumentError('invalid request, invalid ethereum address supplied'))
+  }
+  let limit = MAX_AUDITS
+  if (req.query.limit !== undefined) {
+    limit = parseInt(req.query.limit, 10)
+    if (isNaN(limit) || limit < 1 || limit > MAX_AUDITS) {
+      return next(new errors.InvalidArgumentError(`invalid request, limit must be between 1 and ${MAX_AUDITS}`))
+    }
+  }
+
+  let queryResponse = await tmRpc.abciQueryAsync('/audits/node', { eth_address: ethAddress, limit: limit })
+  if (queryResponse.error) return queryError(queryResponse, 'Could not query for node audits', next)
+
+  res.contentType = 'application/json'
+  res.send(queryResponse.result)
+  return next()
+}
+
+async function getAuditStatsAsync(req, res, next) {
+  const ethAddress = req.query.eth_address || ''
+  if (ethAddress !== '' && !/^0x[0-9a-fA-F]{40}$/i.test(ethAddress)) {
+    return next(new errors.InvalidArgumentError('invalid request, invalid ethereum address supplied'))
+  }
+  let since = 0
+  if (req.query.since !== undefined) {
+    since = parseInt(req.query.since, 10)
+    if (isNaN(since) || since < 0) {
+      return next(new errors.InvalidArgumentError('invalid request, since must be a unix timestamp'))
+    }
+  }
+
+  let queryResponse = await tmRpc.abciQueryAsync('/audits/stats', { eth_address: ethAddress, since: since })
+  if (queryResponse.error) return queryError(queryResponse, 'Could not query for audit stats', next)
+
+  res.contentType = 'application/json'
+  res.send(queryResponse.result)
+  return next()
+}
+
+module.exports = {
+  getNodeAuditsAsync: getNodeAuditsAsync,
+  getAuditStatsAsync: getAuditStatsAsync,
+  // additional functions for testing purposes
+  setTmRpc: rpc => {
+    tmRpc = rpc
+  }
+}
@@ -257,7 +257,7 @@ async function postHashV1Async(req, res, next) {
       // broadcast Node IP and new token hash for Cores to update their local active token table
       try {
         let coreId = await tokenUtils.getCachedCoreIDAsync()
-        await tokenUtils.broadcastCoreTxAsync(coreId, submittingNodeIP, submittedTokenHash)
+        await tokenUtils.broadcastCoreTxAsync(coreId, submittingNodeIP, submittedTokenHash, env.ECDSA_PKPEM)
       } catch (error) {
         return next(new errors.InternalServerError(`server error, ${error.message}`))
       }
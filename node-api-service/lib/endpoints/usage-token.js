@@ -336,7 +336,7 @@ async function postTokenCreditAsync(req, res, next) {
   // broadcast Node IP and new token hash for Cores to update their local active token table
   try {
     let coreId = await tokenUtils.getCachedCoreIDAsync()
-    await tokenUtils.broadcastCoreTxAsync(coreId, submittingNodeIP, newTokenHash)
+    await tokenUtils.broadcastCoreTxAsync(coreId, submittingNodeIP, newTokenHash, env.ECDSA_PKPEM)
   } catch (error) {
     return next(new errors.InternalServerError(`server error, ${error.message}`))
   }
@@ -493,7 +493,7 @@ async function postTokenAudienceUpdateAsync(req, res, next) {
   // broadcast Node IP and new token hash for Cores to update their local active token table
   try {
     let coreId = await tokenUtils.getCachedCoreIDAsync()
-    await tokenUtils.broadcastCoreTxAsync(coreId, submittingNodeIP, updatedTokenHash)
+    await tokenUtils.broadcastCoreTxAsync(coreId, submittingNodeIP, updatedTokenHash, env.ECDSA_PKPEM)
   } catch (error) {
     return next(new errors.InternalServerError(`server error, ${error.message}`))
   }
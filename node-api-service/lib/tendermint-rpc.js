@@ -93,6 +93,23 @@ async function getAbciInfo() {
   return { result: abciInfo, error: null }
 }
 
+async function abciQueryAsync(path, data) {
+  let value
+  try {
+    let dataHex = Buffer.from(JSON.stringify(data)).toString('hex')
+    let query = await rpcClient.abciQuery({ path: `"${path}"`, data: `0x${dataHex}` })
+    if (query.response.code) {
+      // the ABCI app reports malformed query data with code 1, and anything else it can't answer with other codes
+      let responseCode = query.response.code === 1 ? 409 : 500
+      return { result: null, error: { responseCode: responseCode, message: query.response.log } }
+    }
+    value = JSON.parse(Buffer.from(query.response.value, 'base64').toString('utf8'))
+  } catch (error) {
+    return parseRpcError(error)
+  }
+  return { result: value, error: null }
+}
+
 async function broadcastTxAsync(tx) {
   try {
     await rpcClient.broadcastTxAsync({ tx: `"${tx}"` }) // API requires double quotes to be explicitly added
@@ -109,5 +126,6 @@ module.exports = {
   getStatusAsync: getStatusAsync,
   broadcastTxAsync: broadcastTxAsync,
   getAbciInfo: getAbciInfo,
-  getTxSearch: getTxSearch
+  getTxSearch: getTxSearch,
+  abciQueryAsync: abciQueryAsync
 }
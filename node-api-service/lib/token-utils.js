@@ -14,6 +14,7 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
+const crypto = require('crypto')
 const errors = require('restify-errors')
 const jwt = require('jsonwebtoken')
 const jose = require('node-jose')
@@ -242,7 +243,10 @@ async function coreStatusRequestAsync(coreURI, retryCount = 3) {
   return response.body
 }
 
-async function broadcastCoreTxAsync(coreId, submittingNodeIP, tokenHash) {
+// TOKEN txs commit to the Core registry, so Cores only accept them signed with the key the issuing Core
+// registered in its JWK tx. The signature is made the way Go's EncodeTxWithKey makes it: an ECDSA signature
+// over the sha256 of the tx's JSON, without the sig, in the field order of Go's types.Tx
+async function broadcastCoreTxAsync(coreId, submittingNodeIP, tokenHash, privateKeyPEM) {
   let tokenTx = {
     type: 'TOKEN',
     data: `${submittingNodeIP}|${tokenHash}`,
@@ -250,9 +254,12 @@ async function broadcastCoreTxAsync(coreId, submittingNodeIP, tokenHash) {
     time: Math.ceil(Date.now() / 1000),
     core_id: coreId
   }
-  let tokenTxString = JSON.stringify(tokenTx)
-  let tokenTxB64 = Buffer.from(tokenTxString).toString('base64')
   try {
+    tokenTx.sig = crypto
+      .createSign('SHA256')
+      .update(JSON.stringify(tokenTx))
+      .sign(privateKeyPEM, 'base64')
+    let tokenTxB64 = Buffer.from(JSON.stringify(tokenTx)).toString('base64')
     let txResponse = await tmRpc.broadcastTxAsync(tokenTxB64)
     if (txResponse.error) {
       switch (txResponse.error.responseCode) {
@@ -27,6 +27,7 @@ const proofs = require('./lib/endpoints/proofs.js')
 const status = require('./lib/endpoints/status.js')
 const root = require('./lib/endpoints/root.js')
 const nodes = require('./lib/endpoints/nodes.js')
+const audits = require('./lib/endpoints/audits.js')
 const eth = require('./lib/endpoints/eth.js')
 const usageToken = require('./lib/endpoints/usage-token.js')
 const connections = require('./lib/connections.js')
@@ -141,6 +142,14 @@ function setupRestifyConfigAndRoutes(server, privateMode) {
   }
   // get nodes from core
   server.get({ path: '/nodes/random', version: '1.0.0' }, ...applyMiddleware([throttle(15, 3)]), nodes.getNodesAsync)
+  // get a node's recent audits by any core
+  server.get(
+    { path: '/nodes/:addr/audits', version: '1.0.0' },
+    ...applyMiddleware([throttle(15, 3)]),
+    audits.getNodeAuditsAsync
+  )
+  // get audit pass rates for one node or all nodes
+  server.get({ path: '/audits/stats', version: '1.0.0' }, ...applyMiddleware([throttle(15, 3)]), audits.getAuditStatsAsync)
   // get random core peers
   server.get({ path: '/peers', version: '1.0.0' }, ...applyMiddleware([throttle(15, 3)]), peers.getPeersAsync)
   // get status
@@ -0,0 +1,179 @@
+/* global describe, it, before, beforeEach, afterEach */
+
+process.env.NODE_ENV = 'test'
+
+// test related packages
+const expect = require('chai').expect
+const request = require('supertest')
+
+const app = require('../server.js')
+const audits = require('../lib/endpoints/audits.js')
+
+describe('Audits Controller', () => {
+  let insecureServer = null
+  beforeEach(async () => {
+    app.setThrottle(() => (req, res, next) => next())
+    insecureServer = await app.startInsecureRestifyServerAsync(false)
+  })
+  afterEach(() => {
+    insecureServer.close()
+  })
+
+  describe('GET /nodes/:addr/audits with invalid address', () => {
+    it('should return proper error with invalid address', done => {
+      request(insecureServer)
+        .get('/nodes/0xbad/audits')
+        .expect('Content-type', /json/)
+        .expect(409)
+        .end((err, res) => {
+          expect(err).to.equal(null)
+          expect(res.body)
+            .to.have.property('code')
+            .and.to.be.a('string')
+            .and.to.equal('InvalidArgument')
+          expect(res.body)
+            .to.have.property('message')
+            .and.to.be.a('string')
+            .and.to.equal('invalid request, invalid ethereum address supplied')
+          done()
+        })
+    })
+  })
+
+  describe('GET /nodes/:addr/audits with invalid limit', () => {
+    it('should return proper error with invalid limit', done => {
+      request(insecureServer)
+        .get('/nodes/0x9b0e4e6e4b1b2a1dc4a9d1e8d2b1c5f0a3e4d5c6/audits?limit=0')
+        .expect('Content-type', /json/)
+        .expect(409)
+        .end((err, res) => {
+          expect(err).to.equal(null)
+          expect(res.body)
+            .to.have.property('message')
+            .and.to.be.a('string')
+            .and.to.equal('invalid request, limit must be between 1 and 100')
+          done()
+        })
+    })
+  })
+
+  describe('GET /nodes/:addr/audits with bad TM connection', () => {
+    before(() => {
+      audits.setTmRpc({
+        abciQueryAsync: async () => {
+          return { error: { responseCode: 500, message: 'connection refused' } }
+        }
+      })
+    })
+    it('should return proper error with TM communication error', done => {
+      request(insecureServer)
+        .get('/nodes/0x9b0e4e6e4b1b2a1dc4a9d1e8d2b1c5f0a3e4d5c6/audits')
+        .expect('Content-type', /json/)
+        .expect(500)
+        .end((err, res) => {
+          expect(err).to.equal(null)
+          expect(res.body)
+            .to.have.property('code')
+            .and.to.be.a('string')
+            .and.to.equal('InternalServer')
+          expect(res.body)
+            .to.have.property('message')
+            .and.to.be.a('string')
+            .and.to.equal('Could not query for node audits')
+          done()
+        })
+    })
+  })
+
+  describe('GET /nodes/:addr/audits', () => {
+    let query = null
+    let auditData = [
+      {
+        eth_address: '0x9b0e4e6e4b1b2a1dc4a9d1e8d2b1c5f0a3e4d5c6',
+        node_ip: '65.125.23.1',
+        core_id: 'ABCD',
+        round: 1200,
+        passed: true,
+        score: 0.95,
+        checks: [{ check: 'proof', score: 1, passed: true, millis: 61000 }],
+        millis: 61000,
+        audited_at: 1571400000
+      }
+    ]
+    before(() => {
+      audits.setTmRpc({
+        abciQueryAsync: async (path, data) => {
+          query = { path, data }
+          return { result: auditData, error: null }
+        }
+      })
+    })
+    it('should return the node audits', done => {
+      request(insecureServer)
+        .get('/nodes/0x9b0e4e6e4b1b2a1dc4a9d1e8d2b1c5f0a3e4d5c6/audits?limit=10')
+        .expect('Content-type', /json/)
+        .expect(200)
+        .end((err, res) => {
+          expect(err).to.equal(null)
+          expect(query).to.deep.equal({
+            path: '/audits/node',
+            data: { eth_address: '0x9b0e4e6e4b1b2a1dc4a9d1e8d2b1c5f0a3e4d5c6', limit: 10 }
+          })
+          expect(res.body)
+            .to.be.a('array')
+            .and.to.deep.equal(auditData)
+          done()
+        })
+    })
+  })
+
+  describe('GET /audits/stats with invalid since', () => {
+    it('should return proper error with invalid since', done => {
+      request(insecureServer)
+        .get('/audits/stats?since=yesterday')
+        .expect('Content-type', /json/)
+        .expect(409)
+        .end((err, res) => {
+          expect(err).to.equal(null)
+          expect(res.body)
+            .to.have.property('message')
+            .and.to.be.a('string')
+            .and.to.equal('invalid request, since must be a unix timestamp')
+          done()
+        })
+    })
+  })
+
+  describe('GET /audits/stats', () => {
+    let query = null
+    let statsData = {
+      since: 1571400000,
+      audits: 4,
+      passed: 3,
+      pass_rate: 0.75,
+      checks: [{ check: 'proof', runs: 4, passed: 3, pass_rate: 0.75, avg_millis: 60000 }]
+    }
+    before(() => {
+      audits.setTmRpc({
+        abciQueryAsync: async (path, data) => {
+          query = { path, data }
+          return { result: statsData, error: null }
+        }
+      })
+    })
+    it('should return audit stats for all nodes', done => {
+      request(insecureServer)
+        .get('/audits/stats?since=1571400000')
+        .expect('Content-type', /json/)
+        .expect(200)
+        .end((err, res) => {
+          expect(err).to.equal(null)
+          expect(query).to.deep.equal({ path: '/audits/stats', data: { eth_address: '', since: 1571400000 } })
+          expect(res.body)
+            .to.be.a('object')
+            .and.to.deep.equal(statsData)
+          done()
+        })
+    })
+  })
+})
@@ -0,0 +1,58 @@
+/* global describe, it */
+
+process.env.NODE_ENV = 'test'
+
+// test related packages
+const expect = require('chai').expect
+const crypto = require('crypto')
+
+const utils = require('../lib/utils.js')
+
+function sha256(...buffers) {
+  return crypto
+    .createHash('sha256')
+    .update(Buffer.concat(buffers))
+    .digest()
+}
+
+describe('Hardened Calendar proof ops', () => {
+  let leftRoot = Buffer.from('89b4f6c13d489cd5e5bd557234cf00d4daeedc2dd50a1f18e525296e5abd1399', 'hex')
+  let rightRoot = Buffer.from('e05d2a67a74278dd4bca5866f4c5a5e66ba7a2217205959bfb27fc0e727aa7d0', 'hex')
+  let hashedRight = sha256(Buffer.from([0x00]), rightRoot)
+  let calRoot = sha256(Buffer.from([0x01]), sha256(Buffer.from([0x00]), leftRoot), hashedRight)
+  let proof = {
+    hash: leftRoot.toString('hex'),
+    branches: [
+      {
+        label: 'cal_anchor_branch',
+        ops: [
+          { op: 'sha-256-leaf' },
+          { r: hashedRight.toString('hex') },
+          { op: 'sha-256-node' },
+          { anchors: [{ type: 'cal', anchor_id: '1', uris: [] }] }
+        ]
+      }
+    ]
+  }
+
+  it('should evaluate a hardened calendar branch to the calendar root', done => {
+    let anchors = utils.parseChainpointProofAnchors(proof)
+    expect(anchors).to.have.length(1)
+    expect(anchors[0].type).to.equal('cal')
+    expect(anchors[0].expected_value).to.equal(calRoot.toString('hex'))
+    done()
+  })
+
+  it('should reject unknown ops', done => {
+    expect(() => utils.applyChainpointOp(leftRoot, 'md5')).to.throw()
+    done()
+  })
+
+  it('should swap hardened ops for sha-256 when checking a proof against the v3 schema', done => {
+    let compatible = utils.withSchemaCompatibleOps(proof)
+    expect(compatible.branches[0].ops[0].op).to.equal('sha-256')
+    expect(compatible.branches[0].ops[2].op).to.equal('sha-256')
+    expect(proof.branches[0].ops[0].op).to.equal('sha-256-leaf')
+    done()
+  })
+})
@@ -14,6 +14,15 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
+const crypto = require('crypto')
+
+// Hardened Calendar trees prefix what they hash so an internal node can never pass as a leaf. Both ops
+// hash the running proof value, which a preceding l/r step has already made left||right
+const HARDENED_OP_PREFIXES = {
+  'sha-256-leaf': Buffer.from([0x00]),
+  'sha-256-node': Buffer.from([0x01])
+}
+
 /**
  * Sleep for a specified number of milliseconds
  *
@@ -102,6 +111,106 @@ function formatAsChainpointV3Ops(proof, op) {
   return ChainpointV3Ops
 }
 
+/**
+ * Applies a Chainpoint v3 hash op to a proof value, including the hardened
+ * sha-256-leaf and sha-256-node ops used by Calendar trees past their activation height
+ *
+ * @param {Buffer} value - The running proof value
+ * @param {string} op - The op to apply
+ * @returns {Buffer} The new proof value
+ */
+function applyChainpointOp(value, op) {
+  if (HARDENED_OP_PREFIXES[op]) {
+    return crypto
+      .createHash('sha256')
+      .update(Buffer.concat([HARDENED_OP_PREFIXES[op], value]))
+      .digest()
+  }
+  switch (op) {
+    case 'sha-224':
+    case 'sha-256':
+    case 'sha-384':
+    case 'sha-512':
+      return crypto
+        .createHash(op.replace('-', ''))
+        .update(value)
+        .digest()
+    case 'sha3-224':
+    case 'sha3-256':
+    case 'sha3-384':
+    case 'sha3-512':
+      return crypto
+        .createHash(op)
+        .update(value)
+        .digest()
+    case 'sha-256-x2': {
+      let hash = crypto
+        .createHash('sha256')
+        .update(value)
+        .digest()
+      return crypto
+        .createHash('sha256')
+        .update(hash)
+        .digest()
+    }
+    default:
+      throw new Error(`Unknown proof op ${op}`)
+  }
+}
+
+/**
+ * Evaluates the ops of a Chainpoint v3 proof, returning every anchor it reaches along with the value
+ * that anchor must commit to. This is the chainpoint-parse evaluation, extended with the hardened Calendar ops
+ *
+ * @param {object} proof - A Chainpoint v3 JSON proof
+ * @returns {object array} The anchors reached, each with its branch label, type, anchor_id, uris and expected_value
+ */
+function parseChainpointProofAnchors(proof) {
+  let anchors = []
+  let toBuffer = operand => (isHex(operand) ? Buffer.from(operand, 'hex') : Buffer.from(operand, 'utf8'))
+  let evaluateBranches = (branches, startValue) => {
+    for (let branch of branches) {
+      let value = startValue
+      for (let op of branch.ops) {
+        if (op.l !== undefined) {
+          value = Buffer.concat([toBuffer(op.l), value])
+        } else if (op.r !== undefined) {
+          value = Buffer.concat([value, toBuffer(op.r)])
+        } else if (op.op !== undefined) {
+          value = applyChainpointOp(value, op.op)
+        } else if (op.anchors !== undefined) {
+          for (let anchor of op.anchors) {
+            // Bitcoin displays merkle roots byte-reversed
+            let expectedValue = ['btc', 'tbtc'].includes(anchor.type) ? Buffer.from(value).reverse() : value
+            anchors.push({
+              branch: branch.label,
+              type: anchor.type,
+              anchor_id: anchor.anchor_id,
+              uris: anchor.uris,
+              expected_value: expectedValue.toString('hex')
+            })
+          }
+        }
+      }
+      if (branch.branches) evaluateBranches(branch.branches, value)
+    }
+  }
+  evaluateBranches(proof.branches || [], Buffer.from(proof.hash, 'hex'))
+  return anchors
+}
+
+/**
+ * The Chainpoint v3 JSON schema predates the hardened Calendar ops. Returns a copy of a proof with
+ * them swapped for sha-256, so the rest of the proof can still be checked against the schema
+ *
+ * @param {object} proof - A Chainpoint v3 JSON proof
+ * @returns {object} A copy of the proof using only ops known to the schema
+ */
+function withSchemaCompatibleOps(proof) {
+  let replacer = (key, value) => (key === 'op' && HARDENED_OP_PREFIXES[value] ? 'sha-256' : value)
+  return JSON.parse(JSON.stringify(proof, replacer))
+}
+
 /**
  * Extracts the IP address from a Restify request object
  *
@@ -140,5 +249,8 @@ module.exports = {
   isHex: isHex,
   isIP: isIP,
   formatAsChainpointV3Ops: formatAsChainpointV3Ops,
+  applyChainpointOp: applyChainpointOp,
+  parseChainpointProofAnchors: parseChainpointProofAnchors,
+  withSchemaCompatibleOps: withSchemaCompatibleOps,
   getClientIP: getClientIP
 }
@@ -122,7 +122,8 @@ async function consumeProofReadyMessageAsync(msg) {
             )
 
             // ensure the proof is valid according to the defined Chainpoint v3 JSON schema
-            let isValidSchema = chainpointProofSchema.validate(proof).valid
+            // the schema predates the hardened Calendar ops, so they're checked as sha-256
+            let isValidSchema = chainpointProofSchema.validate(utils.withSchemaCompatibleOps(proof)).valid
             if (!isValidSchema) {
               logger.error(`Proof ${aggStateRow.hash_id} has an invalid JSON schema`)
               return null
@@ -200,7 +201,8 @@ async function consumeProofReadyMessageAsync(msg) {
             )
 
             // ensure the proof is valid according to the defined Chainpoint v3 JSON schema
-            let isValidSchema = chainpointProofSchema.validate(proof).valid
+            // the schema predates the hardened Calendar ops, so they're checked as sha-256
+            let isValidSchema = chainpointProofSchema.validate(utils.withSchemaCompatibleOps(proof)).valid
             if (!isValidSchema) {
               logger.error(`Proof ${aggStateRow.hash_id} has an invalid JSON schema`)
               return null